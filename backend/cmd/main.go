@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
 	"internet_provider/config"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"internet_provider/internal/app"
 	"internet_provider/internal/entity"
 	"internet_provider/internal/handler"
 	"internet_provider/internal/middleware"
+	"internet_provider/internal/mockpay"
 	"internet_provider/internal/repository"
 	"internet_provider/internal/service"
 	"internet_provider/internal/storage"
@@ -38,33 +46,173 @@ func main() {
 		log.Fatal("Failed to create default admin:", err)
 	}
 
+	if err := seedTariffs(db); err != nil {
+		log.Fatal("Failed to seed tariffs:", err)
+	}
+
+	if err := seedAddons(db); err != nil {
+		log.Fatal("Failed to seed addons:", err)
+	}
+
+	promoRepo := repository.NewPromoRepository(db)
+	promoService := service.NewPromoService(promoRepo)
+
+	userRepo := repository.NewUserRepository(db)
+	if added, err := userRepo.BackfillReferralCodes(); err != nil {
+		log.Fatal("Failed to issue referral codes:", err)
+	} else if added > 0 {
+		log.Printf("Referral codes issued to %d existing customers", added)
+	}
+	referralService := service.NewReferralService(repository.NewReferralRepository(db), repository.NewSettingsRepository(db))
+
 	appRepo := repository.NewGormApplicationRepository(db)
-	appService := service.NewApplicationService(appRepo)
+	appService := service.NewApplicationService(appRepo, promoService, referralService)
 	pdfService := service.NewPDFService()
 	appHandler := handler.NewApplicationHandler(appService, pdfService)
 
 	paymentRepo := repository.NewPaymentRepository(db)
+	auditService := service.NewAuditService(repository.NewAuditRepository(db))
+	promoHandler := handler.NewPromoHandler(promoService, auditService)
 
-	userRepo := repository.NewUserRepository(db)
-	authHandler := handler.NewAuthHandler(userRepo)
+	accessTTL, err := time.ParseDuration(cfg.Auth.AccessTokenTTL)
+	if err != nil {
+		log.Fatal("Invalid access_token_ttl:", err)
+	}
+	refreshTTL, err := time.ParseDuration(cfg.Auth.RefreshTokenTTL)
+	if err != nil {
+		log.Fatal("Invalid refresh_token_ttl:", err)
+	}
+
+	adminKeys, err := newKeyRing(cfg.Auth.AdminJWT)
+	if err != nil {
+		log.Fatal("Invalid admin_jwt keys:", err)
+	}
+	userKeys, err := newKeyRing(cfg.Auth.UserJWT)
+	if err != nil {
+		log.Fatal("Invalid user_jwt keys:", err)
+	}
+
+	loginGuard := service.NewLoginGuard(repository.NewLoginThrottleRepository(db))
+
+	mailer, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatal("Failed to configure mailer:", err)
+	}
+
+	tariffRepo := repository.NewTariffRepository(db)
+	tariffService := service.NewTariffService(tariffRepo)
+
+	subscriptionRepo := repository.NewSubscriptionRepository(db)
+	if added, err := subscriptionRepo.BackfillLegacy(time.Now()); err != nil {
+		log.Fatal("Failed to create subscriptions for existing tariffs:", err)
+	} else if added > 0 {
+		log.Printf("Created %d subscriptions for customers with a tariff activated before billing", added)
+	}
 
-	paymenthandler := handler.NewPaymentHandler(paymentRepo)
+	ledgerRepo := repository.NewLedgerRepository(db)
+	if opened, err := ledgerRepo.OpenBalances(time.Now()); err != nil {
+		log.Fatal("Failed to move balances into the ledger:", err)
+	} else if opened > 0 {
+		log.Printf("Opening ledger balances recorded for %d customers", opened)
+	}
+	if ids, err := ledgerRepo.Unbalanced(); err != nil {
+		log.Printf("Failed to reconcile balances with the ledger: %v", err)
+	} else if len(ids) > 0 {
+		log.Printf("WARNING: balance does not match the ledger for customers %v", ids)
+	}
+	ledgerHandler := handler.NewLedgerHandler(service.NewLedgerService(ledgerRepo))
+
+	billingInterval, err := time.ParseDuration(cfg.Billing.Interval)
+	if err != nil {
+		log.Fatal("Invalid billing interval:", err)
+	}
+	billingService := service.NewBillingService(subscriptionRepo, tariffRepo, promoRepo, auditService)
+	billingHandler := handler.NewBillingHandler(billingService, auditService)
+	go billingService.Run(context.Background(), billingInterval)
+	tariffHandler := handler.NewTariffHandler(tariffService, auditService)
+	addonService := service.NewAddonService(repository.NewAddonRepository(db))
+	addonHandler := handler.NewAddonHandler(addonService, auditService)
+	promiseService := service.NewPromiseService(repository.NewPromiseRepository(db), repository.NewSettingsRepository(db), billingService)
+	promiseHandler := handler.NewPromiseHandler(promiseService, auditService)
+	freezeService := service.NewFreezeService(repository.NewFreezeRepository(db), repository.NewSettingsRepository(db), auditService)
+	freezeHandler := handler.NewFreezeHandler(freezeService, auditService)
+	go freezeService.Run(context.Background(), billingInterval)
+
+	documentService := service.NewDocumentService(repository.NewDocumentRepository(db), ledgerRepo, userRepo, pdfService)
+	documentHandler := handler.NewDocumentHandler(documentService)
+	go documentService.Run(context.Background(), billingInterval)
+	userTokenRepo := repository.NewUserTokenRepository(db)
+	userSessionRepo := repository.NewUserSessionRepository(db)
+	userAuthService := service.NewUserAuthService(userRepo, userSessionRepo, userKeys, accessTTL, refreshTTL)
+	accountService := service.NewAccountService(userRepo, userTokenRepo, mailer, cfg.Mail.PublicURL)
+	authHandler := handler.NewAuthHandler(userRepo, userAuthService, accountService, loginGuard, tariffService, billingService, referralService, addonService, auditService)
+	referralHandler := handler.NewReferralHandler(referralService, userRepo, auditService)
+	userAuth := middleware.UserAuthMiddleware(userAuthService)
+
+	provider, err := newPaymentProvider(cfg.Payments)
+	if err != nil {
+		log.Fatal("Failed to configure payment provider:", err)
+	}
+	cashRegister, err := newCashRegister(cfg.Fiscal)
+	if err != nil {
+		log.Fatal("Failed to configure cash register:", err)
+	}
+	receiptInterval, err := time.ParseDuration(cfg.Fiscal.RetryInterval)
+	if err != nil {
+		log.Fatal("Invalid fiscal retry_interval:", err)
+	}
+	receiptService := service.NewReceiptService(repository.NewReceiptRepository(db), cashRegister, service.FiscalSettings{
+		CompanyINN:  cfg.Fiscal.CompanyINN,
+		Taxation:    cfg.Fiscal.Taxation,
+		VAT:         cfg.Fiscal.VAT,
+		MaxAttempts: cfg.Fiscal.MaxAttempts,
+	})
+	receiptHandler := handler.NewReceiptHandler(receiptService, auditService)
+	go receiptService.Run(context.Background(), receiptInterval)
+
+	autopayRepo := repository.NewAutopayRepository(db)
+	paymentService := service.NewPaymentService(paymentRepo, autopayRepo, provider, cfg.Payments.ReturnURL, billingService, auditService)
+	paymenthandler := handler.NewPaymentHandler(paymentRepo, paymentService, auditService)
+	refundRetryInterval, err := time.ParseDuration(cfg.Payments.RefundRetryInterval)
+	if err != nil {
+		log.Fatal("Invalid refund_retry_interval:", err)
+	}
+	go paymentService.RunRefunds(context.Background(), refundRetryInterval)
+
+	autopayInterval, err := time.ParseDuration(cfg.Payments.Autopay.Interval)
+	if err != nil {
+		log.Fatal("Invalid autopay interval:", err)
+	}
+	autopayRetryDelay, err := time.ParseDuration(cfg.Payments.Autopay.RetryDelay)
+	if err != nil {
+		log.Fatal("Invalid autopay retry_delay:", err)
+	}
+	if cfg.Payments.Autopay.MaxAttempts < 1 {
+		log.Fatal("Autopay max_attempts must be at least 1")
+	}
+	autopayService := service.NewAutopayService(autopayRepo, paymentService, userRepo, mailer, auditService, service.AutopaySettings{
+		RetryDelay:  autopayRetryDelay,
+		MaxAttempts: cfg.Payments.Autopay.MaxAttempts,
+	})
+	autopayHandler := handler.NewAutopayHandler(autopayService, auditService)
+	go autopayService.Run(context.Background(), autopayInterval)
 
 	adminRepo := repository.NewGormAdminRepository(db)
-	adminSevice := service.NewAdminService(adminRepo, "zGuui2cfiVi9yLCMiilfIEbCAVzkFkWA7OiShxxi4ML")
-	adminHandler := handler.NewAdminHandler(adminSevice)
+	adminSevice := service.NewAdminService(adminRepo, repository.NewSettingsRepository(db), adminKeys)
+	adminHandler := handler.NewAdminHandler(adminSevice, loginGuard, auditService)
 	adminAuth := middleware.AdminAuthMiddleware(adminSevice)
 
 	router := gin.Default()
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://127.0.0.1:5500", "http://localhost:5500"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", middleware.RequestIDHeader, entity.IdempotencyKeyHeader},
+		ExposeHeaders:    []string{"Content-Length", "Content-Disposition", middleware.RequestIDHeader, "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
-	setupRouters(router, appHandler, authHandler, paymenthandler, adminHandler, adminAuth)
+	router.Use(middleware.RequestID())
+	setupRouters(router, appHandler, authHandler, paymenthandler, adminHandler, tariffHandler, billingHandler, ledgerHandler, documentHandler, promoHandler, promiseHandler, receiptHandler, autopayHandler, referralHandler, addonHandler, freezeHandler, adminAuth, userAuth)
 
 	log.Printf("Server starting on port %s", cfg.Server.Port)
 	if err := router.Run(":" + cfg.Server.Port); err != nil {
@@ -72,15 +220,166 @@ func main() {
 	}
 }
 
-func autoMigrate(db *gorm.DB) error {
-	if db.Migrator().HasTable(&entity.User{}) {
-		if !db.Migrator().HasColumn(&entity.User{}, "TariffID") {
-			err := db.Migrator().AddColumn(&entity.User{}, "TariffID")
+func newMailer(cfg config.MailConfig) (service.Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return service.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPassword, cfg.From), nil
+	case "file", "":
+		log.Printf("Mail is written to %s instead of being sent", cfg.OutboxDir)
+		return service.NewFileMailer(cfg.OutboxDir, cfg.From)
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+func newCashRegister(cfg config.FiscalConfig) (service.CashRegister, error) {
+	if !entity.IsValidVAT(cfg.VAT) {
+		return nil, fmt.Errorf("unknown VAT rate %q", cfg.VAT)
+	}
+	if cfg.MaxAttempts < 1 {
+		return nil, fmt.Errorf("max_attempts must be at least 1")
+	}
+
+	switch cfg.CashRegister {
+	case "local", "":
+		log.Printf("Receipts are written to %s by the local cash register", cfg.Local.Dir)
+		return service.NewLocalCashRegister(cfg.Local.Dir, cfg.Local.CheckURL)
+	default:
+		return nil, fmt.Errorf("unknown cash register %q", cfg.CashRegister)
+	}
+}
+
+func newPaymentProvider(cfg config.PaymentsConfig) (service.PaymentProvider, error) {
+	switch cfg.Provider {
+	case "mock", "":
+		if cfg.Mock.Secret == "" {
+			return nil, fmt.Errorf("mock provider needs a secret in NETLINK_MOCKPAY_SECRET")
+		}
+		if cfg.Mock.Listen != "" {
+			server := mockpay.NewServer(cfg.Mock.Secret, cfg.Mock.URL)
+			go func() {
+				log.Printf("Mock payment gateway listening on %s", cfg.Mock.Listen)
+				if err := http.ListenAndServe(cfg.Mock.Listen, server.Handler()); err != nil {
+					log.Printf("Mock payment gateway stopped: %v", err)
+				}
+			}()
+		}
+		return service.NewMockProvider(cfg.Mock.URL, cfg.Mock.Secret, cfg.WebhookURL), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", cfg.Provider)
+	}
+}
+
+func newKeyRing(cfg config.KeySetConfig) (*service.KeyRing, error) {
+	keys := make([]service.SigningKey, 0, len(cfg.Keys))
+
+	for _, key := range cfg.Keys {
+		signingKey := service.SigningKey{
+			ID:     key.KID,
+			Secret: []byte(key.Secret),
+			Legacy: key.Legacy,
+		}
+
+		if key.VerifyUntil != "" {
+			verifyUntil, err := time.Parse(time.RFC3339, key.VerifyUntil)
 			if err != nil {
-				log.Printf("Ошибка добавления tariff_id: %v", err)
-				return err
+				return nil, fmt.Errorf("key %q: invalid verify_until: %w", key.KID, err)
 			}
-			log.Println("Добавлен столбец tariff_id в таблицу users")
+			signingKey.VerifyUntil = verifyUntil
+		}
+
+		keys = append(keys, signingKey)
+	}
+
+	return service.NewKeyRing(cfg.ActiveKID, keys)
+}
+
+func autoMigrate(db *gorm.DB) error {
+	// CreateTable не трогает существующие таблицы, поэтому новые столбцы добавляем отдельно
+	columns := []struct {
+		model interface{}
+		field string
+	}{
+		{&entity.User{}, "TariffID"},
+		{&entity.Admin{}, "MustChangePassword"},
+		{&entity.User{}, "EmailVerified"},
+		{&entity.User{}, "PasswordChangedAt"},
+		{&entity.Admin{}, "TOTPSecret"},
+		{&entity.Admin{}, "TOTPEnabled"},
+		{&entity.Admin{}, "TOTPLastStep"},
+		{&entity.Tariff{}, "Description"},
+		{&entity.Tariff{}, "Features"},
+		{&entity.Tariff{}, "IsVisible"},
+		{&entity.Tariff{}, "SortOrder"},
+		{&entity.Tariff{}, "ArchivedAt"},
+		{&entity.Tariff{}, "CreatedAt"},
+		{&entity.Tariff{}, "UpdatedAt"},
+		{&entity.Tariff{}, "BillingPeriod"},
+		{&entity.Subscription{}, "NextTariffID"},
+		{&entity.Payment{}, "IdempotencyKey"},
+		{&entity.Payment{}, "RequestHash"},
+		{&entity.Payment{}, "Provider"},
+		{&entity.Payment{}, "ExternalID"},
+		{&entity.Payment{}, "ConfirmationURL"},
+		{&entity.Payment{}, "CompletedAt"},
+		{&entity.Payment{}, "RefundedAmount"},
+		{&entity.Payment{}, "ParentID"},
+		{&entity.Payment{}, "ChargeID"},
+		{&entity.Payment{}, "Reason"},
+		{&entity.Payment{}, "AdminID"},
+		{&entity.SubscriptionCharge{}, "ReversedAt"},
+		{&entity.SubscriptionCharge{}, "Discount"},
+		{&entity.SubscriptionCharge{}, "PromoRedemptionID"},
+		{&entity.Subscription{}, "PeriodDiscount"},
+		{&app.Application{}, "PromoCode"},
+		{&entity.User{}, "ReferralCode"},
+		{&entity.User{}, "ReferredBy"},
+		{&app.Application{}, "ReferralCode"},
+	}
+
+	for _, column := range columns {
+		if _, err := addColumnIfMissing(db, column.model, column.field); err != nil {
+			return err
+		}
+	}
+
+	added, err := addColumnIfMissing(db, &entity.Subscription{}, "AnchorDay")
+	if err != nil {
+		return err
+	}
+	if added {
+		if err := backfillAnchorDay(db); err != nil {
+			return err
+		}
+	}
+
+	// Деньги хранятся в копейках целым числом. Старые столбцы в рублях переводятся один раз.
+	money := []struct {
+		model interface{}
+		field string
+	}{
+		{&entity.User{}, "Balance"},
+		{&entity.Tariff{}, "Price"},
+		{&entity.Payment{}, "Amount"},
+		{&entity.SubscriptionCharge{}, "Amount"},
+		{&entity.TariffHistory{}, "Credit"},
+		{&entity.TariffHistory{}, "Charge"},
+	}
+
+	for _, column := range money {
+		if err := convertToKopecks(db, column.model, column.field); err != nil {
+			return err
+		}
+	}
+
+	added, err = addColumnIfMissing(db, &entity.Admin{}, "Role")
+	if err != nil {
+		return err
+	}
+	if added {
+		// До появления ролей любой админ мог все, сохраняем им этот доступ
+		if err := db.Model(&entity.Admin{}).Where("1 = 1").Update("role", entity.RoleSuperadmin).Error; err != nil {
+			return err
 		}
 	}
 
@@ -90,6 +389,32 @@ func autoMigrate(db *gorm.DB) error {
 		&entity.Payment{},
 		&entity.Tariff{},
 		&entity.Admin{},
+		&entity.AdminSession{},
+		&entity.UserToken{},
+		&entity.UserSession{},
+		&entity.LoginThrottle{},
+		&entity.LockoutEvent{},
+		&entity.AdminRecoveryCode{},
+		&entity.Setting{},
+		&entity.AuditEvent{},
+		&entity.Subscription{},
+		&entity.SubscriptionCharge{},
+		&entity.TariffHistory{},
+		&entity.LedgerTransaction{},
+		&entity.LedgerEntry{},
+		&entity.Document{},
+		&entity.DocumentCounter{},
+		&entity.PromoCode{},
+		&entity.PromoRedemption{},
+		&entity.PromisedPayment{},
+		&entity.Receipt{},
+		&entity.PaymentCard{},
+		&entity.Autopay{},
+		&entity.Referral{},
+		&entity.Addon{},
+		&entity.UserAddon{},
+		&entity.AddonCharge{},
+		&entity.AccountFreeze{},
 	}
 
 	for _, table := range tables {
@@ -101,11 +426,118 @@ func autoMigrate(db *gorm.DB) error {
 		}
 	}
 
+	indexes := []struct {
+		model interface{}
+		name  string
+	}{
+		{&entity.Payment{}, "idx_payment_idempotency"},
+		{&entity.Payment{}, "idx_payment_external"},
+		{&entity.User{}, "ReferralCode"},
+		{&entity.User{}, "ReferredBy"},
+	}
+
+	for _, index := range indexes {
+		if !db.Migrator().HasIndex(index.model, index.name) {
+			if err := db.Migrator().CreateIndex(index.model, index.name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return protectLedger(db)
+}
+
+// backfillAnchorDay заполняет день отсчета у подписок, открытых до его появления. Обычно это день
+// начала текущего периода. Если период начался в последний день месяца, он мог быть урезан
+// коротким месяцем, поэтому берется больший из дней начала текущего и первого списанного периода.
+func backfillAnchorDay(db *gorm.DB) error {
+	result := db.Exec(`UPDATE subscriptions s SET anchor_day = CASE
+		WHEN EXTRACT(MONTH FROM s.period_start + INTERVAL '1 day') <> EXTRACT(MONTH FROM s.period_start)
+		THEN GREATEST(EXTRACT(DAY FROM s.period_start), COALESCE((
+			SELECT EXTRACT(DAY FROM c.period_start) FROM subscription_charges c
+			WHERE c.subscription_id = s.id ORDER BY c.id LIMIT 1), 0))
+		ELSE EXTRACT(DAY FROM s.period_start)
+	END`)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	log.Printf("Anchor day filled for %d subscriptions", result.RowsAffected)
 	return nil
 }
 
+// convertToKopecks переводит денежный столбец из рублей (numeric) в копейки (bigint)
+func convertToKopecks(db *gorm.DB, model interface{}, field string) error {
+	if !db.Migrator().HasTable(model) {
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	column := stmt.Schema.LookUpField(field).DBName
+
+	columnTypes, err := db.Migrator().ColumnTypes(model)
+	if err != nil {
+		return err
+	}
+	for _, columnType := range columnTypes {
+		if columnType.Name() != column || strings.EqualFold(columnType.DatabaseTypeName(), "int8") {
+			continue
+		}
+
+		err := db.Exec(fmt.Sprintf(`ALTER TABLE %[1]s
+			ALTER COLUMN %[2]s DROP DEFAULT,
+			ALTER COLUMN %[2]s TYPE bigint USING ROUND(COALESCE(%[2]s, 0) * 100)::bigint,
+			ALTER COLUMN %[2]s SET DEFAULT 0`, stmt.Schema.Table, column)).Error
+		if err != nil {
+			return err
+		}
+		log.Printf("Column %s.%s converted to kopecks", stmt.Schema.Table, column)
+	}
+
+	return nil
+}
+
+// protectLedger запрещает менять и удалять записи журнала на уровне базы
+func protectLedger(db *gorm.DB) error {
+	return db.Exec(`
+		CREATE OR REPLACE FUNCTION ledger_immutable() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'ledger records are immutable';
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS ledger_transactions_immutable ON ledger_transactions;
+		CREATE TRIGGER ledger_transactions_immutable BEFORE UPDATE OR DELETE ON ledger_transactions
+			FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+		DROP TRIGGER IF EXISTS ledger_entries_immutable ON ledger_entries;
+		CREATE TRIGGER ledger_entries_immutable BEFORE UPDATE OR DELETE ON ledger_entries
+			FOR EACH ROW EXECUTE FUNCTION ledger_immutable();`).Error
+}
+
+func addColumnIfMissing(db *gorm.DB, model interface{}, field string) (bool, error) {
+	if !db.Migrator().HasTable(model) || db.Migrator().HasColumn(model, field) {
+		return false, nil
+	}
+
+	if err := db.Migrator().AddColumn(model, field); err != nil {
+		log.Printf("Ошибка добавления столбца %s в %T: %v", field, model, err)
+		return false, err
+	}
+
+	log.Printf("Добавлен столбец %s в %T", field, model)
+	return true, nil
+}
+
 func setupRouters(router *gin.Engine, handler *handler.ApplicationHandler, authHandler *handler.AuthHandler,
-	payHandler *handler.PaymentHandler, adminHandler *handler.AdminHandler, adminAuth gin.HandlerFunc) {
+	payHandler *handler.PaymentHandler, adminHandler *handler.AdminHandler, tariffHandler *handler.TariffHandler,
+	billingHandler *handler.BillingHandler, ledgerHandler *handler.LedgerHandler, documentHandler *handler.DocumentHandler,
+	promoHandler *handler.PromoHandler, promiseHandler *handler.PromiseHandler, receiptHandler *handler.ReceiptHandler, autopayHandler *handler.AutopayHandler,
+	referralHandler *handler.ReferralHandler, addonHandler *handler.AddonHandler,
+	freezeHandler *handler.FreezeHandler, adminAuth, userAuth gin.HandlerFunc) {
 	api := router.Group("/api/v1")
 	{
 		applications := api.Group("/applications")
@@ -116,69 +548,333 @@ func setupRouters(router *gin.Engine, handler *handler.ApplicationHandler, authH
 			applications.GET("/:id/pdf", handler.DownloadPDF)
 		}
 
+		tariffs := api.Group("/tariffs")
+		{
+			tariffs.GET("", tariffHandler.ListTariffs)
+			tariffs.GET("/:id", tariffHandler.GetTariff)
+		}
+
+		api.GET("/addons", addonHandler.ListAddons)
+
 		auth := api.Group("/auth")
 		{
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
-			auth.POST("/activate-tariff", authHandler.ActivateTarrif)
-			auth.GET("/:id", authHandler.GetUserProfile)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/verify-email", authHandler.VerifyEmail)
+			auth.POST("/forgot-password", authHandler.ForgotPassword)
+			auth.POST("/reset-password", authHandler.ResetPassword)
+
+			// ID в пути оставлен для совместимости, профиль берется из токена
+			customer := auth.Group("")
+			customer.Use(userAuth)
+			{
+				customer.POST("/logout", authHandler.Logout)
+				customer.POST("/activate-tariff", authHandler.ActivateTarrif)
+				customer.POST("/activate-tariff/preview", authHandler.PreviewTariffChange)
+				customer.GET("/tariff-history", billingHandler.GetTariffHistory)
+				customer.GET("/statement", ledgerHandler.GetStatement)
+				customer.GET("/statement/pdf", documentHandler.DownloadStatement)
+				customer.GET("/invoices/:month", documentHandler.DownloadInvoice)
+				customer.GET("/documents", documentHandler.ListDocuments)
+				customer.GET("/documents/:document_id", documentHandler.DownloadDocument)
+				customer.POST("/resend-verification", authHandler.ResendVerification)
+				customer.GET("/subscription", billingHandler.GetSubscription)
+				customer.GET("/receipts/:id", receiptHandler.GetReceipt)
+				customer.GET("/promised-payment", promiseHandler.GetPromise)
+				customer.POST("/promised-payment", promiseHandler.TakePromise)
+				customer.GET("/autopay", autopayHandler.GetAutopay)
+				customer.PUT("/autopay", autopayHandler.UpdateAutopay)
+				customer.DELETE("/autopay", autopayHandler.DisableAutopay)
+				customer.DELETE("/cards/:id", autopayHandler.DeleteCard)
+				customer.GET("/referral", referralHandler.GetReferral)
+				customer.GET("/addons", addonHandler.GetUserAddons)
+				customer.POST("/addons", addonHandler.AttachAddon)
+				customer.DELETE("/addons/:id", addonHandler.DetachAddon)
+				customer.GET("/freeze", freezeHandler.GetFreeze)
+				customer.POST("/freeze", freezeHandler.Freeze)
+				customer.DELETE("/freeze", freezeHandler.Unfreeze)
+				customer.GET("/me", authHandler.GetUserProfile)
+				customer.GET("/:id", authHandler.GetUserProfile)
+			}
 		}
 
 		pay := api.Group("/pay")
+		pay.Use(userAuth)
 		{
+			pay.POST("", payHandler.ToUpBalance)
 			pay.POST("/:id", payHandler.ToUpBalance)
+			pay.GET("/history", payHandler.GetPaymentHistory)
+			pay.GET("/:id", payHandler.GetPayment)
 		}
 
+		// Уведомления шлюза без токена клиента, подлинность проверяется подписью
+		api.POST("/webhooks/payments/:provider", payHandler.ProviderWebhook)
+
 		admin := api.Group("/admin")
 		{
 			admin.POST("/login", adminHandler.Login)
+			admin.POST("/login/2fa", adminHandler.LoginTwoFactor)
+			admin.POST("/refresh", adminHandler.Refresh)
 			admin.GET("/verify", adminHandler.VerifyToken)
-			admin.POST("/debug-token", adminHandler.DebugToken)
 
 			authorized := admin.Group("")
 			authorized.Use(adminAuth)
 			{
-				authorized.GET("/dashboard", adminHandler.GetDashboard)
-				authorized.GET("/users", adminHandler.GetUsers)
-				authorized.GET("/payments", payHandler.GetPayments)
+				authorized.POST("/logout", adminHandler.Logout)
+				authorized.GET("/sessions", adminHandler.ListSessions)
+				authorized.DELETE("/sessions/:id", adminHandler.RevokeSession)
+				authorized.PUT("/me/password", adminHandler.ChangePassword)
+
+				twoFactor := authorized.Group("/2fa")
+				{
+					twoFactor.GET("", adminHandler.GetTwoFactorStatus)
+					twoFactor.POST("/setup", adminHandler.SetupTwoFactor)
+					twoFactor.POST("/enable", adminHandler.EnableTwoFactor)
+					twoFactor.POST("/disable", adminHandler.DisableTwoFactor)
+					twoFactor.POST("/recovery-codes", adminHandler.RegenerateRecoveryCodes)
+				}
+
+				// Все остальное недоступно, пока не сменен временный пароль и не подключена обязательная 2FA
+				active := authorized.Group("")
+				active.Use(middleware.RequirePasswordChanged(), middleware.RequireTwoFactor())
+				{
+					active.GET("/dashboard", middleware.RequirePermission(entity.PermDashboardView), adminHandler.GetDashboard)
+
+					users := active.Group("/users")
+					users.Use(middleware.RequirePermission(entity.PermUsersView))
+					{
+						users.GET("", adminHandler.GetUsers)
+						users.GET("/:id", adminHandler.GetUser)
+						users.GET("/:id/documents", documentHandler.AdminListDocuments)
+						users.GET("/:id/documents/:document_id", documentHandler.AdminDownloadDocument)
+						users.GET("/:id/invoices/:month", documentHandler.AdminDownloadInvoice)
+						users.GET("/:id/statement/pdf", documentHandler.AdminDownloadStatement)
+						users.POST("/:id/adjustments", middleware.RequirePermission(entity.PermUsersBalance), payHandler.AdjustBalance)
+						users.POST("/:id/freeze", middleware.RequirePermission(entity.PermUsersBalance), freezeHandler.AdminFreeze)
+						users.PUT("/:id/freeze", middleware.RequirePermission(entity.PermUsersBalance), freezeHandler.UpdateFreeze)
+						users.DELETE("/:id/freeze", middleware.RequirePermission(entity.PermUsersBalance), freezeHandler.AdminUnfreeze)
+					}
+
+					payments := active.Group("/payments")
+					payments.Use(middleware.RequirePermission(entity.PermPaymentsView))
+					{
+						payments.GET("", payHandler.GetPayments)
+						payments.POST("/:id/refund", middleware.RequirePermission(entity.PermPaymentsManage), payHandler.RefundPayment)
+					}
+
+					active.POST("/charges/:id/reverse", middleware.RequirePermission(entity.PermPaymentsManage), payHandler.ReverseCharge)
+
+					active.POST("/billing/run", middleware.RequirePermission(entity.PermPaymentsManage), billingHandler.RunBilling)
+
+					adminTariffs := active.Group("/tariffs")
+					adminTariffs.Use(middleware.RequirePermission(entity.PermTariffsManage))
+					{
+						adminTariffs.GET("", tariffHandler.AdminListTariffs)
+						adminTariffs.POST("", tariffHandler.CreateTariff)
+						adminTariffs.PUT("/order", tariffHandler.ReorderTariffs)
+						adminTariffs.PUT("/:id", tariffHandler.UpdateTariff)
+						adminTariffs.DELETE("/:id", tariffHandler.ArchiveTariff)
+						adminTariffs.POST("/:id/restore", tariffHandler.RestoreTariff)
+					}
+
+					adminAddons := active.Group("/addons")
+					adminAddons.Use(middleware.RequirePermission(entity.PermTariffsManage))
+					{
+						adminAddons.GET("", addonHandler.AdminListAddons)
+						adminAddons.POST("", addonHandler.CreateAddon)
+						adminAddons.PUT("/:id", addonHandler.UpdateAddon)
+						adminAddons.DELETE("/:id", addonHandler.ArchiveAddon)
+						adminAddons.POST("/:id/restore", addonHandler.RestoreAddon)
+					}
+
+					active.GET("/receipts", middleware.RequirePermission(entity.PermPaymentsView), receiptHandler.ListReceipts)
+					active.POST("/receipts/:id/retry", middleware.RequirePermission(entity.PermPaymentsManage), receiptHandler.RetryReceipt)
+
+					promises := active.Group("/promised-payments")
+					{
+						promises.GET("", middleware.RequirePermission(entity.PermPaymentsView), promiseHandler.ListOutstanding)
+						promises.GET("/settings", middleware.RequirePermission(entity.PermPaymentsView), promiseHandler.GetSettings)
+						promises.PUT("/settings", middleware.RequirePermission(entity.PermPaymentsManage), promiseHandler.UpdateSettings)
+					}
+
+					promoCodes := active.Group("/promo-codes")
+					promoCodes.Use(middleware.RequirePermission(entity.PermPromoManage))
+					{
+						promoCodes.GET("", promoHandler.ListPromoCodes)
+						promoCodes.POST("", promoHandler.CreatePromoCode)
+						promoCodes.PUT("/:id", promoHandler.UpdatePromoCode)
+						promoCodes.GET("/:id/redemptions", promoHandler.PromoRedemptions)
+					}
+
+					freezes := active.Group("/freezes")
+					{
+						freezes.GET("", middleware.RequirePermission(entity.PermUsersView), freezeHandler.ListFreezes)
+						freezes.GET("/settings", middleware.RequirePermission(entity.PermUsersView), freezeHandler.GetSettings)
+						freezes.PUT("/settings", middleware.RequirePermission(entity.PermPaymentsManage), freezeHandler.UpdateSettings)
+					}
+
+					referrals := active.Group("/referrals")
+					{
+						referrals.GET("", middleware.RequirePermission(entity.PermPaymentsView), referralHandler.ListReferrals)
+						referrals.GET("/settings", middleware.RequirePermission(entity.PermPaymentsView), referralHandler.GetSettings)
+						referrals.PUT("/settings", middleware.RequirePermission(entity.PermPromoManage), referralHandler.UpdateSettings)
+					}
+
+					lockouts := active.Group("/lockouts")
+					lockouts.Use(middleware.RequirePermission(entity.PermLockoutsManage))
+					{
+						lockouts.GET("", adminHandler.GetLockouts)
+						lockouts.POST("/unlock", adminHandler.UnlockLogin)
+					}
+
+					admins := active.Group("/admins")
+					admins.Use(middleware.RequirePermission(entity.PermAdminsManage))
+					{
+						admins.GET("", adminHandler.ListAdmins)
+						admins.POST("", adminHandler.CreateAdmin)
+						admins.PUT("/:id", adminHandler.UpdateAdmin)
+						admins.DELETE("/:id", adminHandler.DeactivateAdmin)
+						admins.DELETE("/:id/2fa", adminHandler.ResetAdminTwoFactor)
+					}
+
+					settings := active.Group("/settings")
+					settings.Use(middleware.RequirePermission(entity.PermAdminsManage))
+					{
+						settings.GET("/2fa", adminHandler.GetTwoFactorPolicy)
+						settings.PUT("/2fa", adminHandler.SetTwoFactorPolicy)
+					}
+
+					audit := active.Group("/audit")
+					audit.Use(middleware.RequirePermission(entity.PermAuditView))
+					{
+						audit.GET("", adminHandler.GetAudit)
+						audit.GET("/verify", adminHandler.VerifyAudit)
+					}
+				}
 			}
 		}
 	}
 }
 
+// seedTariffs переносит в пустую таблицу тарифы, которые раньше были зашиты в код.
+// ID сохраняются, потому что на них уже ссылаются users.tariff_id.
+func seedTariffs(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&entity.Tariff{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	tariffs := []entity.Tariff{
+		{ID: 1, Name: "Базовый 50 Мбит/с", Price: 30000, Speed: 50, SortOrder: 1, IsVisible: true,
+			Features: entity.StringList{"Безлимитный трафик", "До 3 устройств", "Техподдержка 24/7", "Базовая защита"}},
+		{ID: 2, Name: "Оптимальный 100 Мбит/с", Price: 50000, Speed: 100, SortOrder: 2, IsVisible: true,
+			Features: entity.StringList{"Безлимитный трафик", "До 5 устройств", "Приоритетная поддержка", "Расширенная защита", "Статический IP"}},
+		{ID: 3, Name: "Премиум 200 Мбит/с", Price: 80000, Speed: 200, SortOrder: 3, IsVisible: true,
+			Features: entity.StringList{"Безлимитный трафик", "До 10 устройств", "Персональный менеджер", "Максимальная защита", "Статический IP", "Резервный канал"}},
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&tariffs).Error; err != nil {
+			return err
+		}
+
+		// ID заданы явно, поэтому последовательность нужно сдвинуть вручную
+		if err := tx.Exec("SELECT setval(pg_get_serial_sequence('tariffs', 'id'), (SELECT MAX(id) FROM tariffs))").Error; err != nil {
+			return err
+		}
+
+		log.Printf("Seeded %d tariffs", len(tariffs))
+		return nil
+	})
+}
+
+// seedAddons заполняет пустой каталог дополнительных услуг
+func seedAddons(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&entity.Addon{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	addons := []entity.Addon{
+		{Name: "Статический IP", Description: "Постоянный внешний IP-адрес", SetupFee: 10000, Price: 15000, IsVisible: true},
+		{Name: "Аренда роутера", Description: "Wi-Fi роутер на время договора", SetupFee: 50000, Price: 10000, IsVisible: true},
+		{Name: "IPTV", Description: "Более 150 телеканалов на ТВ и смартфоне", Price: 25000, IsVisible: true},
+		{Name: "Антивирус", Description: "Защита до 3 устройств", Price: 9900, IsVisible: true},
+	}
+
+	if err := db.Create(&addons).Error; err != nil {
+		return err
+	}
+
+	log.Printf("Seeded %d addons", len(addons))
+	return nil
+}
+
+const bootstrapPasswordFile = "bootstrap_admin_password.txt"
+
+// createDefaultAdmin создает первого суперадмина, если в базе нет ни одного администратора.
+// Пароль берется из ADMIN_BOOTSTRAP_PASSWORD или генерируется и записывается в файл,
+// доступный только владельцу процесса. В лог пароль не попадает.
 func createDefaultAdmin(db *gorm.DB) error {
 	log.Println("🔄 Checking for default admin...")
 
 	var count int64
-	db.Model(&entity.Admin{}).Count(&count)
+	if err := db.Model(&entity.Admin{}).Count(&count).Error; err != nil {
+		return err
+	}
 	log.Printf("Current admins in DB: %d", count)
 
-	if count == 0 {
-		log.Println("📝 Creating default admin...")
+	if count > 0 {
+		log.Println("✅ Admin already exists in database")
+		return nil
+	}
+
+	log.Println("📝 Creating bootstrap admin...")
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte("admin123"), bcrypt.DefaultCost)
-		if err != nil {
+	password := os.Getenv("ADMIN_BOOTSTRAP_PASSWORD")
+	generated := password == ""
+	if generated {
+		buf := make([]byte, 12)
+		if _, err := rand.Read(buf); err != nil {
 			return err
 		}
+		password = base64.RawURLEncoding.EncodeToString(buf)
+	}
 
-		admin := &entity.Admin{
-			Username: "admin",
-			Password: string(hashedPassword),
-			Email:    "admin@netlink.ru",
-			IsActive: true,
-		}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
 
-		if err := db.Create(admin).Error; err != nil {
+	admin := &entity.Admin{
+		Username:           "admin",
+		Password:           string(hashedPassword),
+		Email:              "admin@netlink.ru",
+		Role:               entity.RoleSuperadmin,
+		IsActive:           true,
+		MustChangePassword: true,
+	}
+
+	if err := db.Create(admin).Error; err != nil {
+		return err
+	}
+
+	if generated {
+		if err := os.WriteFile(bootstrapPasswordFile, []byte(password+"\n"), 0600); err != nil {
 			return err
 		}
-
-		log.Printf("✅ Default admin created successfully!")
-		log.Printf("👤 Username: admin")
-		log.Printf("🔑 Password: admin123")
-		log.Printf("📧 Email: %s", admin.Email)
+		log.Printf("✅ Bootstrap admin created. One-time password saved to %s", bootstrapPasswordFile)
 	} else {
-		log.Println("✅ Admin already exists in database")
+		log.Printf("✅ Bootstrap admin created with password from ADMIN_BOOTSTRAP_PASSWORD")
 	}
+	log.Printf("👤 Username: %s (password must be changed at first login)", admin.Username)
 
 	return nil
 }
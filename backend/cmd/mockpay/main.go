@@ -0,0 +1,29 @@
+// Команда mockpay запускает учебный платежный шлюз отдельно от сервера.
+// Секрет магазина берется из NETLINK_MOCKPAY_SECRET и должен совпадать с секретом сервера.
+package main
+
+import (
+	"flag"
+	"internet_provider/internal/mockpay"
+	"log"
+	"net/http"
+	"os"
+)
+
+func main() {
+	listen := flag.String("listen", ":8090", "address to listen on")
+	publicURL := flag.String("public-url", "http://localhost:8090", "URL customers use to open the checkout page")
+	flag.Parse()
+
+	secret := os.Getenv("NETLINK_MOCKPAY_SECRET")
+	if secret == "" {
+		log.Fatal("NETLINK_MOCKPAY_SECRET is not set")
+	}
+
+	server := mockpay.NewServer(secret, *publicURL)
+
+	log.Printf("Mock payment gateway listening on %s", *listen)
+	if err := http.ListenAndServe(*listen, server.Handler()); err != nil {
+		log.Fatal(err)
+	}
+}
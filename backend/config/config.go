@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -9,6 +11,11 @@ import (
 type Config struct {
 	Server   ServerConfig   `yaml:"server"`
 	Database DatabaseConfig `yaml:"database"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Mail     MailConfig     `yaml:"mail"`
+	Billing  BillingConfig  `yaml:"billing"`
+	Payments PaymentsConfig `yaml:"payments"`
+	Fiscal   FiscalConfig   `yaml:"fiscal"`
 }
 
 type ServerConfig struct {
@@ -25,6 +32,98 @@ type DatabaseConfig struct {
 	TimeZone string `yaml:"timezone"`
 }
 
+type AuthConfig struct {
+	AdminJWT        KeySetConfig `yaml:"admin_jwt"`
+	UserJWT         KeySetConfig `yaml:"user_jwt"`
+	AccessTokenTTL  string       `yaml:"access_token_ttl"`
+	RefreshTokenTTL string       `yaml:"refresh_token_ttl"`
+}
+
+// KeySetConfig - набор ключей подписи JWT. Новые токены подписываются ключом active_kid,
+// остальные ключи только проверяют уже выданные токены до verify_until.
+type KeySetConfig struct {
+	ActiveKID string             `yaml:"active_kid"`
+	Keys      []SigningKeyConfig `yaml:"keys"`
+}
+
+// SigningKeyConfig - секрет берется из переменной окружения secret_env (или файла из <secret_env>_FILE),
+// либо из файла secret_file. Legacy-ключом проверяются токены без kid, выпущенные до ротации.
+type SigningKeyConfig struct {
+	KID         string `yaml:"kid"`
+	Secret      string `yaml:"-"`
+	SecretEnv   string `yaml:"secret_env"`
+	SecretFile  string `yaml:"secret_file"`
+	VerifyUntil string `yaml:"verify_until"`
+	Legacy      bool   `yaml:"legacy"`
+}
+
+// MailConfig - driver "smtp" отправляет письма через SMTP сервер,
+// driver "file" складывает их в outbox_dir (для разработки и тестов).
+type MailConfig struct {
+	Driver       string `yaml:"driver"`
+	From         string `yaml:"from"`
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     string `yaml:"smtp_port"`
+	SMTPUser     string `yaml:"smtp_user"`
+	SMTPPassword string `yaml:"smtp_password"`
+	OutboxDir    string `yaml:"outbox_dir"`
+	PublicURL    string `yaml:"public_url"`
+}
+
+// BillingConfig - interval задает, как часто фоновая задача продлевает подписки
+type BillingConfig struct {
+	Interval string `yaml:"interval"`
+}
+
+// PaymentsConfig - provider выбирает платежный шлюз. return_url - страница, куда шлюз вернет
+// клиента после оплаты, webhook_url - адрес, на который шлюз шлет уведомления о статусе.
+// refund_retry_interval - как часто повторяются возвраты, на которые шлюз не ответил.
+type PaymentsConfig struct {
+	Provider            string        `yaml:"provider"`
+	ReturnURL           string        `yaml:"return_url"`
+	WebhookURL          string        `yaml:"webhook_url"`
+	RefundRetryInterval string        `yaml:"refund_retry_interval"`
+	Mock                MockPayConfig `yaml:"mock"`
+	Autopay             AutopayConfig `yaml:"autopay"`
+}
+
+// AutopayConfig - interval задает, как часто проверяются условия автоплатежей. После неудачного
+// списания следующая попытка ждет retry_delay, пауза удваивается с каждой неудачей, а после
+// max_attempts неудач подряд автоплатеж отключается.
+type AutopayConfig struct {
+	Interval    string `yaml:"interval"`
+	RetryDelay  string `yaml:"retry_delay"`
+	MaxAttempts int    `yaml:"max_attempts"`
+}
+
+// MockPayConfig - учебный шлюз. Если задан listen, шлюз запускается вместе с сервером,
+// иначе его поднимают отдельно командой cmd/mockpay. Секрет - из NETLINK_MOCKPAY_SECRET.
+type MockPayConfig struct {
+	URL    string `yaml:"url"`
+	Listen string `yaml:"listen"`
+	Secret string `yaml:"-"`
+}
+
+// FiscalConfig - фискализация платежей по 54-ФЗ. cash_register выбирает онлайн-кассу,
+// vat - ставка НДС продавца (в чеках на аванс ставится расчетная, 22/122 для vat22),
+// taxation - система налогообложения продавца. Чек, который касса не приняла,
+// отправляется повторно до max_attempts раз, очередь проверяется каждые retry_interval.
+type FiscalConfig struct {
+	CashRegister  string            `yaml:"cash_register"`
+	CompanyINN    string            `yaml:"company_inn"`
+	Taxation      string            `yaml:"taxation"`
+	VAT           string            `yaml:"vat"`
+	RetryInterval string            `yaml:"retry_interval"`
+	MaxAttempts   int               `yaml:"max_attempts"`
+	Local         LocalFiscalConfig `yaml:"local"`
+}
+
+// LocalFiscalConfig - касса-заглушка: чеки пишутся в dir, ссылка на чек строится по check_url
+type LocalFiscalConfig struct {
+	Dir      string `yaml:"dir"`
+	CheckURL string `yaml:"check_url"`
+}
+
 func LoadConf(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -37,5 +136,86 @@ func LoadConf(filename string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := config.loadSecrets(); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
+
+// loadSecrets подставляет секреты из окружения. Значение из переменной важнее значения в yaml,
+// так что в репозитории секретов нет, а на сервере их передают через env или смонтированные файлы.
+func (c *Config) loadSecrets() error {
+	secrets := []struct {
+		target *string
+		env    string
+	}{
+		{&c.Database.Password, "NETLINK_DB_PASSWORD"},
+		{&c.Mail.SMTPPassword, "NETLINK_SMTP_PASSWORD"},
+		{&c.Payments.Mock.Secret, "NETLINK_MOCKPAY_SECRET"},
+	}
+
+	for _, secret := range secrets {
+		if err := resolveSecret(secret.target, secret.env); err != nil {
+			return err
+		}
+	}
+
+	for _, set := range []*KeySetConfig{&c.Auth.AdminJWT, &c.Auth.UserJWT} {
+		for i := range set.Keys {
+			if err := set.Keys[i].loadSecret(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (k *SigningKeyConfig) loadSecret() error {
+	if k.SecretFile != "" {
+		secret, err := readSecretFile(k.SecretFile)
+		if err != nil {
+			return err
+		}
+		k.Secret = secret
+	}
+
+	if k.SecretEnv != "" {
+		if err := resolveSecret(&k.Secret, k.SecretEnv); err != nil {
+			return err
+		}
+	}
+
+	if k.Secret == "" {
+		return fmt.Errorf("signing key %q has no secret: set %s or secret_file", k.KID, k.SecretEnv)
+	}
+	return nil
+}
+
+// resolveSecret берет значение из переменной env, а если ее нет - из файла, путь к которому в env_FILE
+func resolveSecret(target *string, env string) error {
+	if value, ok := os.LookupEnv(env); ok {
+		*target = value
+		return nil
+	}
+
+	if path := os.Getenv(env + "_FILE"); path != "" {
+		secret, err := readSecretFile(path)
+		if err != nil {
+			return err
+		}
+		*target = secret
+	}
+
+	return nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -16,6 +16,8 @@ type Application struct {
 	Address      string    `gorm:"not null" json:"address"`
 	Phone        string    `gorm:"not null" json:"phone"`
 	Plan         string    `json:"plan"`
+	PromoCode    string    `gorm:"size:50" json:"promo_code,omitempty"`
+	ReferralCode string    `gorm:"size:16" json:"referral_code,omitempty"`
 	Status       string    `gorm:"default:'new'" json:"status"`
 	CreatedAt    time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt    time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP;autoUpdateTime" json:"updated_at"`
@@ -0,0 +1,89 @@
+package entity
+
+import "time"
+
+// Addon - дополнительная услуга из каталога: статический IP, аренда роутера, IPTV, антивирус.
+// SetupFee списывается один раз при подключении, Price - за месяц вместе с периодом тарифа.
+type Addon struct {
+	ID          int64      `gorm:"primaryKey" json:"id"`
+	Name        string     `gorm:"size:100;not null" json:"name"`
+	Description string     `gorm:"type:text" json:"description"`
+	SetupFee    Kopecks    `gorm:"not null;default:0" json:"setup_fee"`
+	Price       Kopecks    `gorm:"not null;default:0" json:"price"`
+	IsVisible   bool       `gorm:"not null;default:true" json:"is_visible"`
+	ArchivedAt  *time.Time `json:"archived_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Available - услугу можно подключить: она не в архиве и показывается клиентам
+func (a *Addon) Available() bool {
+	return a.ArchivedAt == nil && a.IsVisible
+}
+
+// PeriodPrice - сумма за период тарифа tariff, начавшийся в start. При посуточной оплате
+// месячная цена делится по дням так же, как цена тарифа.
+func (a *Addon) PeriodPrice(tariff *Tariff, start time.Time) Kopecks {
+	return periodPrice(a.Price, tariff.BillingPeriod, start)
+}
+
+// UserAddon - услуга, подключенная клиенту. Оплачивается вместе с подпиской по тот же PaidUntil.
+// После отключения запись остается для истории, второй раз ту же услугу можно подключить заново.
+type UserAddon struct {
+	ID         int64      `gorm:"primaryKey" json:"id"`
+	UserID     int        `gorm:"not null;uniqueIndex:idx_user_addon_active,where:detached_at IS NULL" json:"user_id"`
+	AddonID    int64      `gorm:"not null;uniqueIndex:idx_user_addon_active" json:"addon_id"`
+	Addon      *Addon     `gorm:"foreignKey:AddonID" json:"addon,omitempty"`
+	AttachedAt time.Time  `gorm:"not null" json:"attached_at"`
+	DetachedAt *time.Time `json:"detached_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Виды списаний за услугу
+const (
+	AddonChargeSetup  = "setup"
+	AddonChargePeriod = "period"
+	AddonChargeRefund = "refund"
+)
+
+// AddonCharge - списание за услугу: разовый платеж при подключении, оплата периода или
+// возврат части периода при смене периодичности тарифа (Amount < 0). ChargeID - списание
+// за тариф, вместе с которым оплачен период. Уникальность не дает списать период дважды.
+type AddonCharge struct {
+	ID          int64     `gorm:"primaryKey" json:"id"`
+	UserAddonID int64     `gorm:"not null;uniqueIndex:idx_addon_charge_period" json:"user_addon_id"`
+	UserID      int       `gorm:"not null;index" json:"user_id"`
+	AddonID     int64     `gorm:"not null" json:"addon_id"`
+	ChargeID    *int64    `gorm:"index" json:"charge_id,omitempty"`
+	Kind        string    `gorm:"size:10;not null;uniqueIndex:idx_addon_charge_period" json:"kind"`
+	Amount      Kopecks   `gorm:"not null" json:"amount"`
+	PeriodStart time.Time `gorm:"not null;uniqueIndex:idx_addon_charge_period" json:"period_start"`
+	PeriodEnd   time.Time `gorm:"not null" json:"period_end"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AddonAttachment - итог подключения услуги
+type AddonAttachment struct {
+	UserAddon    *UserAddon `json:"user_addon"`
+	SetupFee     Kopecks    `json:"setup_fee"`
+	PeriodCharge Kopecks    `json:"period_charge"`
+	PaidUntil    time.Time  `json:"paid_until"`
+	BalanceAfter Kopecks    `json:"balance_after"`
+}
+
+type CreateAddonRequest struct {
+	Name        string  `json:"name" binding:"required,max=100"`
+	Description string  `json:"description"`
+	SetupFee    float64 `json:"setup_fee" binding:"min=0"`
+	Price       float64 `json:"price" binding:"min=0"`
+	IsVisible   *bool   `json:"is_visible"`
+}
+
+// UpdateAddonRequest - меняются только переданные поля
+type UpdateAddonRequest struct {
+	Name        *string  `json:"name" binding:"omitempty,min=1,max=100"`
+	Description *string  `json:"description"`
+	SetupFee    *float64 `json:"setup_fee" binding:"omitempty,min=0"`
+	Price       *float64 `json:"price" binding:"omitempty,min=0"`
+	IsVisible   *bool    `json:"is_visible"`
+}
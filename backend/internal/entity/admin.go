@@ -3,13 +3,18 @@ package entity
 import "time"
 
 type Admin struct {
-	ID        int64     `json:"id" gorm:"primaryKey"`
-	Username  string    `json:"username" gorm:"uniqueIndex;size:50;not null"`
-	Password  string    `json:"-" gorm:"size:255;not null"`
-	Email     string    `json:"email" gorm:"size:100"`
-	IsActive  bool      `json:"is_active" gorm:"default:true"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                 int64     `json:"id" gorm:"primaryKey"`
+	Username           string    `json:"username" gorm:"uniqueIndex;size:50;not null"`
+	Password           string    `json:"-" gorm:"size:255;not null"`
+	Email              string    `json:"email" gorm:"size:100"`
+	Role               string    `json:"role" gorm:"size:20;not null;default:'support'"`
+	IsActive           bool      `json:"is_active" gorm:"default:true"`
+	MustChangePassword bool      `json:"must_change_password" gorm:"default:false"`
+	TOTPSecret         string    `json:"-" gorm:"column:totp_secret;size:64"`
+	TOTPEnabled        bool      `json:"totp_enabled" gorm:"column:totp_enabled;default:false"`
+	TOTPLastStep       int64     `json:"-" gorm:"column:totp_last_step;default:0"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
 }
 
 type AdminLoginRequest struct {
@@ -17,20 +22,101 @@ type AdminLoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type AdminSession struct {
+	ID               int64      `json:"id" gorm:"primaryKey"`
+	AdminID          int64      `json:"admin_id" gorm:"index;not null"`
+	RefreshTokenHash string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	UserAgent        string     `json:"user_agent" gorm:"size:255"`
+	IP               string     `json:"ip" gorm:"size:45"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	LastUsedAt       time.Time  `json:"last_used_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type CreateAdminRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=50"`
+	Password string `json:"password" binding:"required,min=8"`
+	Email    string `json:"email" binding:"omitempty,email"`
+	Role     string `json:"role" binding:"required"`
+}
+
+// UpdateAdminRequest - меняются только переданные поля.
+// Новый пароль, заданный суперадмином, считается временным.
+type UpdateAdminRequest struct {
+	Email    *string `json:"email" binding:"omitempty,email"`
+	Role     *string `json:"role"`
+	IsActive *bool   `json:"is_active"`
+	Password *string `json:"password" binding:"omitempty,min=8"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+}
+
+type AdminRecoveryCode struct {
+	ID        int64      `json:"id" gorm:"primaryKey"`
+	AdminID   int64      `json:"admin_id" gorm:"index;not null"`
+	CodeHash  string     `json:"-" gorm:"size:64;not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+	QRCode     string `json:"qr_code"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type TwoFactorPolicyRequest struct {
+	Required *bool `json:"required" binding:"required"`
+}
+
+type AdminRefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// AdminLoginResponse - при включенной 2FA вместо токенов возвращается ChallengeToken
+// для второго шага входа
 type AdminLoginResponse struct {
-	Token string `json:"token"`
-	Admin struct {
-		ID       int64  `json:"id"`
-		Username string `json:"username"`
-		Email    string `json:"email"`
+	Token             string `json:"token,omitempty"`
+	RefreshToken      string `json:"refresh_token,omitempty"`
+	ExpiresIn         int64  `json:"expires_in,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+	Admin             struct {
+		ID                 int64    `json:"id"`
+		Username           string   `json:"username"`
+		Email              string   `json:"email"`
+		Role               string   `json:"role"`
+		Permissions        []string `json:"permissions"`
+		MustChangePassword bool     `json:"must_change_password"`
+		TwoFactorSetup     bool     `json:"two_factor_setup_required"`
 	} `json:"admin"`
 }
 
 type DashboardList struct {
 	TotalUsers    int64   `json:"total_users"`
 	TotalPayments int64   `json:"total_payments"`
+	TotalRevenue  Kopecks `json:"total_revenue"`
 	NewUsersToday int64   `json:"new_users_today"`
-	RevenuToday   float64 `json:"revenu_today"`
+	RevenuToday   Kopecks `json:"revenu_today"`
 }
 
 type AdminUserList struct {
@@ -39,7 +125,7 @@ type AdminUserList struct {
 	Email         string    `json:"email"`
 	Phone         string    `json:"phone"`
 	AccountNumber string    `json:"account_number"`
-	Balance       float64   `json:"balance"`
+	Balance       Kopecks   `json:"balance"`
 	TariffID      *int64    `json:"tariff_id"`
 	TariffName    *string   `json:"tariff_name"`
 	CreatedAt     time.Time `json:"created_at,omitempty"`
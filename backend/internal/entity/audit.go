@@ -0,0 +1,140 @@
+package entity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ActorAdmin    = "admin"
+	ActorCustomer = "customer"
+	ActorSystem   = "system"
+)
+
+// Действия, которые попадают в журнал аудита
+const (
+	AuditUserRegister        = "user.register"
+	AuditUserEmailVerified   = "user.email_verified"
+	AuditUserPasswordReset   = "user.password_reset"
+	AuditBalanceTopUp        = "balance.top_up"
+	AuditBalanceAdjust       = "balance.adjust"
+	AuditPaymentRefund       = "payment.refund"
+	AuditChargeReverse       = "charge.reverse"
+	AuditReceiptRetry        = "receipt.retry"
+	AuditPromiseTake         = "promise.take"
+	AuditFreezeStart         = "freeze.start"
+	AuditFreezeUpdate        = "freeze.update"
+	AuditFreezeEnd           = "freeze.end"
+	AuditAutopayEnable       = "autopay.enable"
+	AuditAutopayDisable      = "autopay.disable"
+	AuditCardDelete          = "payment_card.delete"
+	AuditTariffActivate      = "tariff.activate"
+	AuditTariffChange        = "tariff.change"
+	AuditTariffCreate        = "tariff.create"
+	AuditTariffUpdate        = "tariff.update"
+	AuditTariffArchive       = "tariff.archive"
+	AuditTariffRestore       = "tariff.restore"
+	AuditTariffReorder       = "tariff.reorder"
+	AuditPromoCreate         = "promo.create"
+	AuditPromoUpdate         = "promo.update"
+	AuditAddonCreate         = "addon.create"
+	AuditAddonUpdate         = "addon.update"
+	AuditAddonArchive        = "addon.archive"
+	AuditAddonRestore        = "addon.restore"
+	AuditAddonAttach         = "addon.attach"
+	AuditAddonDetach         = "addon.detach"
+	AuditSubscriptionCharge  = "subscription.charge"
+	AuditSubscriptionSuspend = "subscription.suspend"
+	AuditSubscriptionResume  = "subscription.resume"
+	AuditBillingRun          = "billing.run"
+	AuditAdminCreate         = "admin.create"
+	AuditAdminUpdate         = "admin.update"
+	AuditAdminDeactivate     = "admin.deactivate"
+	AuditAdminPassword       = "admin.password_change"
+	AuditAdmin2FAEnable      = "admin.2fa_enable"
+	AuditAdmin2FADisable     = "admin.2fa_disable"
+	AuditAdmin2FAReset       = "admin.2fa_reset"
+	AuditAdminRecoveryCodes  = "admin.recovery_codes"
+	AuditAdminSessionRevoke  = "admin.session_revoke"
+	AuditLoginUnlock         = "login.unlock"
+	AuditSettingUpdate       = "setting.update"
+)
+
+// Типы объектов, над которыми выполняются действия
+const (
+	AuditEntityUser         = "user"
+	AuditEntityPayment      = "payment"
+	AuditEntityCharge       = "charge"
+	AuditEntityReceipt      = "receipt"
+	AuditEntityTariff       = "tariff"
+	AuditEntityPromo        = "promo_code"
+	AuditEntityAddon        = "addon"
+	AuditEntityAdmin        = "admin"
+	AuditEntityAdminSession = "admin_session"
+	AuditEntityLoginLock    = "login_lock"
+	AuditEntitySetting      = "setting"
+)
+
+// AuditEvent - запись журнала действий. Каждая запись содержит хеш предыдущей,
+// поэтому изменение или удаление любой записи ломает цепочку.
+type AuditEvent struct {
+	ID         int64     `gorm:"primaryKey" json:"id"`
+	ActorType  string    `gorm:"size:20;not null;index:idx_audit_actor" json:"actor_type"`
+	ActorID    *int64    `gorm:"index:idx_audit_actor" json:"actor_id"`
+	Action     string    `gorm:"size:50;not null;index" json:"action"`
+	EntityType string    `gorm:"size:50;index:idx_audit_entity" json:"entity_type"`
+	EntityID   string    `gorm:"size:50;index:idx_audit_entity" json:"entity_id"`
+	Before     string    `gorm:"type:text" json:"before,omitempty"`
+	After      string    `gorm:"type:text" json:"after,omitempty"`
+	IP         string    `gorm:"size:45" json:"ip"`
+	RequestID  string    `gorm:"size:64" json:"request_id"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+	PrevHash   string    `gorm:"size:64;not null" json:"prev_hash"`
+	Hash       string    `gorm:"size:64;not null;uniqueIndex" json:"hash"`
+}
+
+// AuditMeta - кто и откуда выполняет действие
+type AuditMeta struct {
+	ActorType string
+	ActorID   *int64
+	IP        string
+	RequestID string
+}
+
+type AuditFilter struct {
+	ActorType  string
+	ActorID    *int64
+	Action     string
+	EntityType string
+	EntityID   string
+	From       *time.Time
+	To         *time.Time
+}
+
+// ComputeHash считает хеш записи вместе с хешем предыдущей записи цепочки
+func (e *AuditEvent) ComputeHash(prevHash string) string {
+	actorID := ""
+	if e.ActorID != nil {
+		actorID = strconv.FormatInt(*e.ActorID, 10)
+	}
+
+	fields := []string{
+		prevHash,
+		e.ActorType,
+		actorID,
+		e.Action,
+		e.EntityType,
+		e.EntityID,
+		e.Before,
+		e.After,
+		e.IP,
+		e.RequestID,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,58 @@
+package entity
+
+import "time"
+
+// Условие автоплатежа: баланс опустился ниже порога или до списания за тариф осталось
+// DaysBefore дней, а на балансе не хватает на следующий период
+const (
+	AutopayThreshold    = "threshold"
+	AutopayBeforeCharge = "before_charge"
+)
+
+// PaymentMethodAutopay - способ оплаты пополнений, списанных автоплатежом с сохраненной карты
+const PaymentMethodAutopay = "autopay"
+
+// MinAutopayAmount - автоплатеж не списывает меньше минимального пополнения
+const MinAutopayAmount Kopecks = 10000
+
+// PaymentCard - карта, сохраненная в платежном шлюзе. Номер карты хранит только шлюз,
+// у нас - его токен и маска для клиента.
+type PaymentCard struct {
+	ID        int64     `gorm:"primaryKey" json:"id"`
+	UserID    int       `gorm:"not null;index" json:"user_id"`
+	Provider  string    `gorm:"size:30;not null;uniqueIndex:idx_card_token,priority:1" json:"provider"`
+	Token     string    `gorm:"size:100;not null;uniqueIndex:idx_card_token,priority:2" json:"-"`
+	Title     string    `gorm:"size:100;not null" json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Autopay - настройки автоплатежа клиента. PaymentID - списание, итог которого еще не учтен.
+// После неудачи следующая попытка ждет до NextAttemptAt, а после MaxAttempts неудач подряд
+// автоплатеж отключается.
+type Autopay struct {
+	ID            int64      `gorm:"primaryKey" json:"id"`
+	UserID        int        `gorm:"not null;uniqueIndex" json:"user_id"`
+	Enabled       bool       `gorm:"not null;default:false;index" json:"enabled"`
+	CardID        int64      `gorm:"not null" json:"card_id"`
+	Trigger       string     `gorm:"size:20;not null" json:"trigger"`
+	Threshold     Kopecks    `gorm:"not null;default:0" json:"threshold"`
+	DaysBefore    int        `gorm:"not null;default:0" json:"days_before"`
+	Amount        Kopecks    `gorm:"not null;default:0" json:"amount"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	PaymentID     *int       `json:"payment_id,omitempty"`
+	LastError     string     `gorm:"size:500" json:"last_error,omitempty"`
+	LastChargedAt *time.Time `json:"last_charged_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// AutopayRequest - включение или изменение автоплатежа. Amount - сумма пополнения; для
+// before_charge 0 значит пополнить ровно на недостающую до списания сумму.
+type AutopayRequest struct {
+	CardID     int64   `json:"card_id" binding:"required"`
+	Trigger    string  `json:"trigger" binding:"required,oneof=threshold before_charge"`
+	Threshold  float64 `json:"threshold" binding:"min=0"`
+	DaysBefore int     `json:"days_before" binding:"min=0,max=30"`
+	Amount     float64 `json:"amount" binding:"min=0"`
+}
@@ -0,0 +1,35 @@
+package entity
+
+import "time"
+
+// Виды документов клиента
+const (
+	DocumentInvoice   = "invoice"
+	DocumentStatement = "statement"
+)
+
+// Document - PDF, выданный клиенту. Файл сохраняется при первой выдаче и дальше отдается
+// как есть, поэтому повторная загрузка возвращает тот же документ, даже если шаблон изменился.
+// Number есть только у счетов: номера идут подряд без пропусков.
+type Document struct {
+	ID             int64     `gorm:"primaryKey" json:"id"`
+	Type           string    `gorm:"size:20;not null;uniqueIndex:idx_document_period,priority:1" json:"type"`
+	UserID         int       `gorm:"not null;uniqueIndex:idx_document_period,priority:2" json:"user_id"`
+	PeriodStart    time.Time `gorm:"not null;uniqueIndex:idx_document_period,priority:3" json:"period_start"`
+	PeriodEnd      time.Time `gorm:"not null;uniqueIndex:idx_document_period,priority:4" json:"period_end"`
+	Number         *string   `gorm:"size:30;uniqueIndex" json:"number,omitempty"`
+	OpeningBalance Kopecks   `gorm:"not null" json:"opening_balance"`
+	Charges        Kopecks   `gorm:"not null" json:"charges"`
+	Payments       Kopecks   `gorm:"not null" json:"payments"`
+	ClosingBalance Kopecks   `gorm:"not null" json:"closing_balance"`
+	Content        []byte    `gorm:"type:bytea;not null" json:"-"`
+	Checksum       string    `gorm:"size:64;not null" json:"checksum"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// DocumentCounter - счетчик номеров документов. Номер берется под блокировкой строки
+// в транзакции создания документа, поэтому откат не оставляет пропусков.
+type DocumentCounter struct {
+	Name  string `gorm:"primaryKey;size:30"`
+	Value int64  `gorm:"not null;default:0"`
+}
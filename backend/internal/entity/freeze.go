@@ -0,0 +1,72 @@
+package entity
+
+import "time"
+
+// Статусы заморозки
+const (
+	FreezeActive   = "active"
+	FreezeFinished = "finished"
+)
+
+// Условия заморозки по умолчанию, пока администратор их не поменял
+const (
+	DefaultFreezeMinDays          = 7
+	DefaultFreezeMaxDays          = 90
+	DefaultFreezeDailyFee Kopecks = 0
+)
+
+// AccountFreeze - добровольная заморозка аккаунта (режим отпуска). Пока она идет, биллинг
+// не трогает подписку, а при разморозке оплаченный период сдвигается на время заморозки,
+// так что оплаченные дни не пропадают. Плата DailyFee списывается сразу за FeeDays дней,
+// при досрочной разморозке неиспользованные полные дни возвращаются.
+type AccountFreeze struct {
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	UserID         int        `gorm:"not null;index;uniqueIndex:idx_freeze_active,where:status = 'active'" json:"user_id"`
+	SubscriptionID int64      `gorm:"not null" json:"subscription_id"`
+	Status         string     `gorm:"size:20;not null;index" json:"status"`
+	StartAt        time.Time  `gorm:"not null" json:"start_at"`
+	EndAt          time.Time  `gorm:"not null;index" json:"end_at"`
+	EndedAt        *time.Time `json:"ended_at,omitempty"`
+	DailyFee       Kopecks    `gorm:"not null;default:0" json:"daily_fee"`
+	FeeDays        int        `gorm:"not null;default:0" json:"fee_days"`
+	FeeRefunded    Kopecks    `gorm:"not null;default:0" json:"fee_refunded"`
+	AdminID        *int64     `json:"admin_id,omitempty"`
+	Reason         string     `gorm:"size:255" json:"reason,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// FreezeSettings - ограничения срока заморозки для клиентов и плата за день, 0 - бесплатно
+type FreezeSettings struct {
+	MinDays  int     `json:"min_days" binding:"required,min=1,max=365"`
+	MaxDays  int     `json:"max_days" binding:"required,min=1,max=365"`
+	DailyFee Kopecks `json:"daily_fee" binding:"min=0"`
+}
+
+// FreezeOffer - текущая заморозка клиента и условия новой
+type FreezeOffer struct {
+	Active   *AccountFreeze  `json:"active,omitempty"`
+	Settings *FreezeSettings `json:"settings"`
+}
+
+// FreezeRequest - заморозка на Days дней с текущего момента
+type FreezeRequest struct {
+	Days int `json:"days" binding:"required,min=1,max=365"`
+}
+
+// AdminFreezeRequest - заморозка администратором без ограничений срока и без платы
+type AdminFreezeRequest struct {
+	Days   int    `json:"days" binding:"required,min=1,max=365"`
+	Reason string `json:"reason" binding:"max=255"`
+}
+
+// UpdateFreezeRequest - новая дата автоматической разморозки
+type UpdateFreezeRequest struct {
+	EndAt time.Time `json:"end_at" binding:"required"`
+}
+
+// FreezeReport - заморозка с данными клиента для админ-панели
+type FreezeReport struct {
+	AccountFreeze
+	UserName  string `json:"user_name"`
+	UserEmail string `json:"user_email"`
+}
@@ -0,0 +1,126 @@
+package entity
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Kopecks - денежная сумма в копейках. В базе хранится целым числом, чтобы не копить
+// ошибки округления float, а в JSON отдается в рублях, как и раньше.
+type Kopecks int64
+
+// ToKopecks переводит рубли из запросов и цен тарифов в копейки
+func ToKopecks(rubles float64) Kopecks {
+	return Kopecks(math.Round(rubles * 100))
+}
+
+func (k Kopecks) Rubles() float64 {
+	return float64(k) / 100
+}
+
+// String - сумма в рублях с двумя знаками, "-12.05"
+func (k Kopecks) String() string {
+	sign := ""
+	value := int64(k)
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, value/100, value%100)
+}
+
+func (k Kopecks) MarshalJSON() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *Kopecks) UnmarshalJSON(data []byte) error {
+	rubles, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("invalid money amount %s", data)
+	}
+	*k = ToKopecks(rubles)
+	return nil
+}
+
+// Типы операций в журнале
+const (
+	LedgerTopUp        = "top_up"
+	LedgerTariffCharge = "tariff_charge"
+	LedgerAddonCharge  = "addon_charge"
+	LedgerRefund       = "refund"
+	LedgerAdjustment   = "adjustment"
+	LedgerPromise      = "promised_payment"
+	LedgerPromiseRepay = "promise_repayment"
+	LedgerReferral     = "referral_reward"
+	LedgerFreezeFee    = "freeze_fee"
+)
+
+// Служебные счета, с которыми корреспондируют счета клиентов
+const (
+	AccountExternal    = "external"
+	AccountRevenue     = "revenue"
+	AccountAdjustments = "adjustments"
+	AccountPromises    = "promised_payments"
+	AccountReferrals   = "referral_rewards"
+)
+
+// CustomerAccount - счет клиента в журнале
+func CustomerAccount(userID int) string {
+	return "customer:" + strconv.Itoa(userID)
+}
+
+// LedgerTransaction - одна денежная операция. Проводки операции в сумме дают ноль.
+// Записи журнала не меняются и не удаляются, исправления делаются новыми операциями.
+type LedgerTransaction struct {
+	ID          int64     `gorm:"primaryKey" json:"id"`
+	Type        string    `gorm:"size:20;not null;index" json:"type"`
+	UserID      int       `gorm:"not null;index" json:"user_id"`
+	Reference   string    `gorm:"size:50;index" json:"reference"`
+	Description string    `gorm:"size:255" json:"description"`
+	CreatedAt   time.Time `gorm:"not null;index" json:"created_at"`
+}
+
+// LedgerEntry - проводка по одному счету. Amount больше нуля увеличивает остаток счета.
+// BalanceAfter заполняется для счетов клиентов и нужен для выписки.
+type LedgerEntry struct {
+	ID            int64     `gorm:"primaryKey" json:"id"`
+	TransactionID int64     `gorm:"not null;index" json:"transaction_id"`
+	Account       string    `gorm:"size:50;not null;index:idx_ledger_account,priority:1" json:"account"`
+	Amount        Kopecks   `gorm:"not null" json:"amount"`
+	BalanceAfter  *Kopecks  `json:"balance_after,omitempty"`
+	CreatedAt     time.Time `gorm:"not null;index:idx_ledger_account,priority:2" json:"created_at"`
+}
+
+// LedgerPosting - операция по счету клиента и встречному служебному счету
+type LedgerPosting struct {
+	Type        string
+	UserID      int
+	Amount      Kopecks
+	Counter     string
+	Reference   string
+	Description string
+}
+
+// StatementLine - строка выписки клиента
+type StatementLine struct {
+	TransactionID int64     `json:"transaction_id"`
+	Type          string    `json:"type"`
+	Reference     string    `json:"reference"`
+	Description   string    `json:"description"`
+	Amount        Kopecks   `json:"amount"`
+	BalanceAfter  Kopecks   `json:"balance_after"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Statement - выписка по счету клиента за период [From, To)
+type Statement struct {
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance Kopecks         `json:"opening_balance"`
+	ClosingBalance Kopecks         `json:"closing_balance"`
+	Credits        Kopecks         `json:"credits"`
+	Debits         Kopecks         `json:"debits"`
+	Lines          []StatementLine `json:"lines"`
+}
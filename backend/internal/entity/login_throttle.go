@@ -0,0 +1,36 @@
+package entity
+
+import "time"
+
+const (
+	ThrottleScopeCustomer = "customer"
+	ThrottleScopeAdmin    = "admin"
+	ThrottleScopeIP       = "ip"
+)
+
+// LoginThrottle - счетчик неудачных входов по аккаунту или IP.
+// Хранится в Postgres, чтобы лимиты действовали на все экземпляры API сразу.
+type LoginThrottle struct {
+	ThrottleKey   string     `gorm:"primaryKey;size:150" json:"key"`
+	Scope         string     `gorm:"size:20;not null;index" json:"scope"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LockCount     int        `gorm:"not null;default:0" json:"lock_count"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `gorm:"index" json:"locked_until"`
+}
+
+type LockoutEvent struct {
+	ID          int64      `gorm:"primaryKey" json:"id"`
+	ThrottleKey string     `gorm:"size:150;not null;index" json:"key"`
+	Scope       string     `gorm:"size:20;not null" json:"scope"`
+	IP          string     `gorm:"size:45" json:"ip"`
+	Failures    int        `json:"failures"`
+	LockedUntil time.Time  `json:"locked_until"`
+	UnlockedBy  *int64     `json:"unlocked_by"`
+	UnlockedAt  *time.Time `json:"unlocked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type UnlockRequest struct {
+	Key string `json:"key" binding:"required"`
+}
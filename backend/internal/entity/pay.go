@@ -1,13 +1,85 @@
 package entity
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Статусы платежа. Деньги зачисляются только при переходе pending -> completed по webhook шлюза,
+// failed и cancelled - конечные статусы без движения денег.
+const (
+	PaymentPending   = "pending"
+	PaymentCompleted = "completed"
+	PaymentFailed    = "failed"
+	PaymentCancelled = "cancelled"
+)
+
+// Статусы пополнения после возврата
+const (
+	PaymentRefunded          = "refunded"
+	PaymentPartiallyRefunded = "partially_refunded"
+)
+
+// Ручные операции администратора. Хранятся в payments рядом с пополнениями, чтобы попадать
+// в историю платежей, и сразу проводятся через журнал. Сумма со знаком: минус - списание с баланса.
+const (
+	PaymentRefund         = "refund"
+	PaymentChargeReversal = "charge_reversal"
+	PaymentAdjustment     = "adjustment"
+)
+
+// Возврат через шлюз сначала сохраняется как refund_pending со списанием с баланса, и только
+// потом уходит запрос в шлюз. После ответа шлюза он становится refund или refund_failed,
+// при refund_failed деньги возвращаются на баланс.
+const (
+	PaymentRefundPending = "refund_pending"
+	PaymentRefundFailed  = "refund_failed"
+)
+
+// Способы оплаты служебных операций, которые не проходят через шлюз
+const (
+	PaymentMethodBalance = "balance"
+	PaymentMethodManual  = "manual"
+)
+
+// IdempotencyKeyHeader - заголовок, по которому повтор запроса на оплату узнается как тот же платеж
+const IdempotencyKeyHeader = "Idempotency-Key"
 
 type Payment struct {
-	ID            int       `gorm:"primaryKey" json:"id"`
-	UserID        int       `gorm:"not null;index" json:"user_id"`
-	Amount        float64   `gorm:"not null" json:"amount"`
-	Status        string    `gorm:"type:varchar(20);not null;default:'pending'" json:"status"`
-	PaymentMethod string    `gorm:"type:varchar(50);not null" json:"payment_method"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID              int        `gorm:"primaryKey" json:"id"`
+	UserID          int        `gorm:"not null;index;uniqueIndex:idx_payment_idempotency,priority:1" json:"user_id"`
+	Amount          Kopecks    `gorm:"not null" json:"amount"`
+	Status          string     `gorm:"type:varchar(20);not null;default:'pending'" json:"status"`
+	PaymentMethod   string     `gorm:"type:varchar(50);not null" json:"payment_method"`
+	Provider        string     `gorm:"size:30;uniqueIndex:idx_payment_external,priority:1" json:"provider,omitempty"`
+	ExternalID      *string    `gorm:"size:100;uniqueIndex:idx_payment_external,priority:2" json:"external_id,omitempty"`
+	ConfirmationURL string     `gorm:"size:500" json:"confirmation_url,omitempty"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+	IdempotencyKey  *string    `gorm:"size:100;uniqueIndex:idx_payment_idempotency,priority:2" json:"-"`
+	RequestHash     string     `gorm:"size:64" json:"-"`
+	RefundedAmount  Kopecks    `gorm:"not null;default:0" json:"refunded_amount"`
+	ParentID        *int       `gorm:"index" json:"parent_id,omitempty"`
+	ChargeID        *int64     `gorm:"index" json:"charge_id,omitempty"`
+	Reason          string     `gorm:"size:500" json:"reason,omitempty"`
+	AdminID         *int64     `json:"admin_id,omitempty"`
+	Receipt         *Receipt   `gorm:"foreignKey:PaymentID" json:"receipt,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// Refundable - сколько еще можно вернуть по пополнению
+func (p *Payment) Refundable() Kopecks {
+	if p.Status != PaymentCompleted && p.Status != PaymentPartiallyRefunded {
+		return 0
+	}
+	return p.Amount - p.RefundedAmount
+}
+
+// PaymentRequestHash - отпечаток параметров платежа. Повтор с тем же ключом, но другим
+// отпечатком - это другой платеж, и его нельзя выдавать за повтор.
+func PaymentRequestHash(amount Kopecks, paymentMethod string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s", amount, paymentMethod)))
+	return hex.EncodeToString(sum[:])
 }
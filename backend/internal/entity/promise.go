@@ -0,0 +1,63 @@
+package entity
+
+import "time"
+
+// Статусы обещанного платежа
+const (
+	PromiseActive = "active"
+	PromiseRepaid = "repaid"
+)
+
+// Условия обещанного платежа по умолчанию, пока администратор их не поменял
+const (
+	DefaultPromiseLimitPercent = 100
+	DefaultPromiseDays         = 3
+)
+
+// PromisedPayment - временный кредит, который клиент берет сам, чтобы услуга не отключалась.
+// Outstanding гасится из следующих пополнений. Если к DueAt долг не погашен, доступ
+// приостанавливается до погашения.
+type PromisedPayment struct {
+	ID          int64      `gorm:"primaryKey" json:"id"`
+	UserID      int        `gorm:"not null;index" json:"user_id"`
+	Amount      Kopecks    `gorm:"not null" json:"amount"`
+	Outstanding Kopecks    `gorm:"not null" json:"outstanding"`
+	Status      string     `gorm:"size:20;not null;index" json:"status"`
+	DueAt       time.Time  `gorm:"not null;index" json:"due_at"`
+	RepaidAt    *time.Time `json:"repaid_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Overdue - срок вышел, а долг не погашен
+func (p *PromisedPayment) Overdue(now time.Time) bool {
+	return p.Status == PromiseActive && !now.Before(p.DueAt)
+}
+
+// PromiseSettings - условия обещанного платежа. LimitPercent - доля цены периода тарифа,
+// 0 выключает обещанный платеж.
+type PromiseSettings struct {
+	LimitPercent int `json:"limit_percent" binding:"min=0,max=100"`
+	Days         int `json:"days" binding:"required,min=1,max=30"`
+}
+
+// PromiseOffer - можно ли клиенту сейчас взять обещанный платеж и на какую сумму
+type PromiseOffer struct {
+	Available bool             `json:"available"`
+	Reason    string           `json:"reason,omitempty"`
+	Limit     Kopecks          `json:"limit"`
+	Days      int              `json:"days"`
+	Active    *PromisedPayment `json:"active,omitempty"`
+}
+
+// PromisedPaymentReport - непогашенный обещанный платеж с данными клиента для админ-панели
+type PromisedPaymentReport struct {
+	PromisedPayment
+	UserName  string `json:"user_name"`
+	UserEmail string `json:"user_email"`
+	Overdue   bool   `json:"overdue"`
+}
+
+// PromiseRequest - сумма в рублях, без суммы берется весь доступный лимит
+type PromiseRequest struct {
+	Amount float64 `json:"amount" binding:"omitempty,gt=0"`
+}
@@ -0,0 +1,192 @@
+package entity
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Виды скидки по промокоду
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// Статусы применения промокода клиентом
+const (
+	PromoRedemptionActive    = "active"
+	PromoRedemptionCompleted = "completed"
+)
+
+// Откуда пришел промокод
+const (
+	PromoSourceActivation  = "activation"
+	PromoSourceApplication = "application"
+)
+
+// PromoCode - промокод маркетинговой акции. Сроки, лимит применений и отключение
+// ограничивают только новые применения: уже примененный код действует, пока у клиента
+// не закончатся периоды со скидкой.
+type PromoCode struct {
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	Code           string     `gorm:"size:50;not null;uniqueIndex" json:"code"`
+	Description    string     `gorm:"size:255" json:"description"`
+	DiscountType   string     `gorm:"size:10;not null" json:"discount_type"`
+	Percent        int        `gorm:"not null;default:0" json:"percent,omitempty"`
+	Amount         Kopecks    `gorm:"not null;default:0" json:"amount,omitempty"`
+	Periods        int        `gorm:"not null;default:1" json:"periods"`
+	TariffIDs      Int64List  `gorm:"type:text" json:"tariff_ids"`
+	MaxRedemptions int        `gorm:"not null;default:0" json:"max_redemptions"`
+	Redeemed       int        `gorm:"not null;default:0" json:"redeemed"`
+	StartsAt       *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// NormalizePromoCode - коды сравниваются без учета регистра и пробелов по краям
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Redeemable - код можно применить сейчас: он включен и действует по датам
+func (p *PromoCode) Redeemable(now time.Time) bool {
+	return p.DisabledAt == nil &&
+		(p.StartsAt == nil || !now.Before(*p.StartsAt)) &&
+		(p.ExpiresAt == nil || now.Before(*p.ExpiresAt))
+}
+
+// LimitReached - код применили столько раз, сколько разрешено. MaxRedemptions = 0 - без ограничения.
+func (p *PromoCode) LimitReached() bool {
+	return p.MaxRedemptions > 0 && p.Redeemed >= p.MaxRedemptions
+}
+
+// AppliesTo - скидка действует на тариф. Пустой список - на все тарифы.
+func (p *PromoCode) AppliesTo(tariffID int64) bool {
+	if len(p.TariffIDs) == 0 {
+		return true
+	}
+	for _, id := range p.TariffIDs {
+		if id == tariffID {
+			return true
+		}
+	}
+	return false
+}
+
+// Discount - скидка с суммы одного периода. Больше самой суммы скидка не бывает.
+func (p *PromoCode) Discount(amount Kopecks) Kopecks {
+	var discount Kopecks
+	switch p.DiscountType {
+	case DiscountPercent:
+		discount = Kopecks(math.Round(float64(amount) * float64(p.Percent) / 100))
+	case DiscountFixed:
+		discount = p.Amount
+	}
+
+	if discount > amount {
+		return amount
+	}
+	return discount
+}
+
+// PromoRedemption - промокод, примененный клиентом. Скидка дается на списания,
+// пока не израсходованы Periods периодов кода (Periods = 0 - на все списания).
+type PromoRedemption struct {
+	ID            int64     `gorm:"primaryKey" json:"id"`
+	PromoCodeID   int64     `gorm:"not null;uniqueIndex:idx_promo_redemption,priority:1" json:"promo_code_id"`
+	UserID        int       `gorm:"not null;index;uniqueIndex:idx_promo_redemption,priority:2" json:"user_id"`
+	Status        string    `gorm:"size:20;not null;index" json:"status"`
+	Source        string    `gorm:"size:20;not null" json:"source"`
+	ApplicationID *uint     `json:"application_id,omitempty"`
+	PeriodsUsed   int       `gorm:"not null;default:0" json:"periods_used"`
+	TotalDiscount Kopecks   `gorm:"not null;default:0" json:"total_discount"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// PromoClaim - промокод, который нужно применить при подключении тарифа
+type PromoClaim struct {
+	Code          string
+	Source        string
+	ApplicationID *uint
+}
+
+// PromoCodeReport - промокод с итогами применений для админ-панели
+type PromoCodeReport struct {
+	PromoCode
+	ActiveRedemptions int     `json:"active_redemptions"`
+	TotalDiscount     Kopecks `json:"total_discount"`
+}
+
+// PromoRedemptionReport - применение промокода с данными клиента
+type PromoRedemptionReport struct {
+	PromoRedemption
+	UserName  string `json:"user_name"`
+	UserEmail string `json:"user_email"`
+}
+
+type CreatePromoCodeRequest struct {
+	Code           string     `json:"code" binding:"required,alphanum,max=50"`
+	Description    string     `json:"description" binding:"max=255"`
+	DiscountType   string     `json:"discount_type" binding:"required,oneof=percent fixed"`
+	Percent        int        `json:"percent" binding:"omitempty,min=1,max=100"`
+	Amount         float64    `json:"amount" binding:"omitempty,gt=0"`
+	Periods        *int       `json:"periods" binding:"omitempty,min=0"`
+	TariffIDs      []int64    `json:"tariff_ids"`
+	MaxRedemptions int        `json:"max_redemptions" binding:"min=0"`
+	StartsAt       *time.Time `json:"starts_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+}
+
+// UpdatePromoCodeRequest - меняются только переданные поля. Размер скидки не меняется,
+// чтобы у клиентов, уже применивших код, не менялись условия.
+type UpdatePromoCodeRequest struct {
+	Description    *string    `json:"description" binding:"omitempty,max=255"`
+	Periods        *int       `json:"periods" binding:"omitempty,min=0"`
+	TariffIDs      *[]int64   `json:"tariff_ids"`
+	MaxRedemptions *int       `json:"max_redemptions" binding:"omitempty,min=0"`
+	StartsAt       *time.Time `json:"starts_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	Disabled       *bool      `json:"disabled"`
+}
+
+// Int64List хранит список чисел в столбце как JSON массив
+type Int64List []int64
+
+func (l Int64List) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+
+	data, err := json.Marshal([]int64(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (l *Int64List) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = Int64List{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T into Int64List", value)
+	}
+
+	if len(data) == 0 {
+		*l = Int64List{}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]int64)(l))
+}
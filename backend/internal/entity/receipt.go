@@ -0,0 +1,125 @@
+package entity
+
+import (
+	"math"
+	"time"
+)
+
+// Вид чека: приход при пополнении, возврат прихода при возврате денег
+const (
+	ReceiptIncome       = "income"
+	ReceiptIncomeReturn = "income_return"
+)
+
+// Статусы чека. pending - в очереди на отправку в кассу, failed - попытки исчерпаны
+// и чек ждет повторной отправки администратором.
+const (
+	ReceiptPending    = "pending"
+	ReceiptRegistered = "registered"
+	ReceiptFailed     = "failed"
+)
+
+// Ставки НДС в чеке. Расчетные ставки (22/122 и т.п.) ставятся в чеках на аванс,
+// где сумма уже включает налог.
+const (
+	VATNone = "none"
+	VAT0    = "vat0"
+	VAT5    = "vat5"
+	VAT7    = "vat7"
+	VAT10   = "vat10"
+	VAT20   = "vat20"
+	VAT22   = "vat22"
+	VAT105  = "vat105"
+	VAT107  = "vat107"
+	VAT110  = "vat110"
+	VAT120  = "vat120"
+	VAT122  = "vat122"
+)
+
+var vatRates = map[string]int{
+	VATNone: 0,
+	VAT0:    0,
+	VAT5:    5,
+	VAT7:    7,
+	VAT10:   10,
+	VAT20:   20,
+	VAT22:   22,
+	VAT105:  5,
+	VAT107:  7,
+	VAT110:  10,
+	VAT120:  20,
+	VAT122:  22,
+}
+
+// calculatedVAT - расчетная ставка для каждой обычной ставки
+var calculatedVAT = map[string]string{
+	VAT5:  VAT105,
+	VAT7:  VAT107,
+	VAT10: VAT110,
+	VAT20: VAT120,
+	VAT22: VAT122,
+}
+
+// Реквизиты расчета за пополнение лицевого счета: это аванс за будущие услуги,
+// поэтому предмет расчета - платеж, а способ расчета - аванс
+const (
+	ReceiptItemTopUp         = "Пополнение лицевого счета"
+	ReceiptMethodAdvance     = "advance"
+	ReceiptSubjectPayment    = "payment"
+	ReceiptPaymentElectronic = "electronic"
+)
+
+func IsValidVAT(vat string) bool {
+	_, ok := vatRates[vat]
+	return ok
+}
+
+// ReceiptVAT - ставка для предмета расчета со способом method при ставке продавца vat.
+// По 54-ФЗ в чеке на аванс указывается расчетная ставка, например 22/122 вместо 22%.
+func ReceiptVAT(vat, method string) string {
+	if method == ReceiptMethodAdvance {
+		if calculated, ok := calculatedVAT[vat]; ok {
+			return calculated
+		}
+	}
+	return vat
+}
+
+// IncludedVAT - НДС, который входит в сумму amount, по ставке vat
+func IncludedVAT(amount Kopecks, vat string) Kopecks {
+	rate := vatRates[vat]
+	if rate == 0 {
+		return 0
+	}
+	return Kopecks(math.Round(float64(amount) * float64(rate) / float64(100+rate)))
+}
+
+// Receipt - фискальный чек по платежу. Чек ставится в очередь в той же транзакции, что и
+// движение денег, поэтому платеж без чека не остается, а в кассу он уходит уже из очереди.
+type Receipt struct {
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	PaymentID      int        `gorm:"not null;uniqueIndex" json:"payment_id"`
+	UserID         int        `gorm:"not null;index" json:"user_id"`
+	Operation      string     `gorm:"size:20;not null" json:"operation"`
+	Item           string     `gorm:"size:128;not null" json:"item"`
+	Amount         Kopecks    `gorm:"not null" json:"amount"`
+	VAT            string     `gorm:"size:10" json:"vat"`
+	VATAmount      Kopecks    `gorm:"not null;default:0" json:"vat_amount"`
+	Method         string     `gorm:"size:20;not null" json:"method"`
+	Subject        string     `gorm:"size:20;not null" json:"subject"`
+	PaymentType    string     `gorm:"size:20;not null" json:"payment_type"`
+	Contact        string     `gorm:"size:100;not null" json:"contact"`
+	Status         string     `gorm:"size:20;not null;index:idx_receipt_queue,priority:1" json:"status"`
+	Attempts       int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"not null;index:idx_receipt_queue,priority:2" json:"next_attempt_at"`
+	LastError      string     `gorm:"size:500" json:"last_error,omitempty"`
+	CashRegister   string     `gorm:"size:30" json:"cash_register,omitempty"`
+	ExternalID     string     `gorm:"size:100" json:"external_id,omitempty"`
+	FiscalNumber   string     `gorm:"size:20" json:"fiscal_number,omitempty"`
+	FiscalDocument string     `gorm:"size:20" json:"fiscal_document,omitempty"`
+	FiscalSign     string     `gorm:"size:20" json:"fiscal_sign,omitempty"`
+	URL            string     `gorm:"size:500" json:"url,omitempty"`
+	RegisteredAt   *time.Time `json:"registered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
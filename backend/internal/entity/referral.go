@@ -0,0 +1,129 @@
+package entity
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Откуда пришел приглашенный клиент: код введен при регистрации или в заявке на подключение
+const (
+	ReferralSourceRegister    = "register"
+	ReferralSourceApplication = "application"
+)
+
+// Статусы приглашения. pending ждет первой оплаты тарифа приглашенным клиентом.
+const (
+	ReferralPending  = "pending"
+	ReferralRewarded = "rewarded"
+	ReferralRejected = "rejected"
+)
+
+// Почему приглашение не засчитано
+const (
+	ReferralSelf             = "self_referral"
+	ReferralDuplicatePhone   = "duplicate_phone"
+	ReferralDuplicateAddress = "duplicate_address"
+	ReferralExistingCustomer = "existing_customer"
+)
+
+// Бонусы по умолчанию в копейках, пока администратор их не поменял
+const (
+	DefaultReferrerReward Kopecks = 30000
+	DefaultReferredReward Kopecks = 30000
+)
+
+// Referral - приглашение клиента по реферальному коду. Из заявки приглашение приходит без
+// ReferredID и привязывается к клиенту, который зарегистрируется с тем же телефоном.
+// Бонус получают обе стороны, когда приглашенный впервые оплатит период тарифа.
+type Referral struct {
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	ReferrerID     int        `gorm:"not null;index" json:"referrer_id"`
+	ReferredID     *int       `gorm:"index" json:"referred_id,omitempty"`
+	ApplicationID  *uint      `gorm:"index" json:"application_id,omitempty"`
+	Source         string     `gorm:"size:20;not null" json:"source"`
+	Code           string     `gorm:"size:16;not null" json:"code"`
+	Phone          string     `gorm:"size:20;not null;index" json:"phone"`
+	Address        string     `gorm:"size:255;index" json:"address,omitempty"`
+	Status         string     `gorm:"size:20;not null;index" json:"status"`
+	RejectReason   string     `gorm:"size:30" json:"reject_reason,omitempty"`
+	ReferrerReward Kopecks    `gorm:"not null;default:0" json:"referrer_reward"`
+	ReferredReward Kopecks    `gorm:"not null;default:0" json:"referred_reward"`
+	RewardedAt     *time.Time `json:"rewarded_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// ReferralReport - приглашение в отчете для админ-панели
+type ReferralReport struct {
+	Referral
+	ReferrerName  string `json:"referrer_name"`
+	ReferrerEmail string `json:"referrer_email"`
+	ReferredName  string `json:"referred_name,omitempty"`
+	ReferredEmail string `json:"referred_email,omitempty"`
+}
+
+// ReferralSummary - итоги программы: сколько приглашений в каждом статусе и сколько выплачено
+type ReferralSummary struct {
+	Total    int64   `json:"total"`
+	Pending  int64   `json:"pending"`
+	Rewarded int64   `json:"rewarded"`
+	Rejected int64   `json:"rejected"`
+	PaidOut  Kopecks `json:"paid_out"`
+}
+
+// ReferralStats - реферальная программа глазами клиента
+type ReferralStats struct {
+	Code           string  `json:"code"`
+	Invited        int64   `json:"invited"`
+	Rewarded       int64   `json:"rewarded"`
+	Earned         Kopecks `json:"earned"`
+	ReferrerReward Kopecks `json:"referrer_reward"`
+	ReferredReward Kopecks `json:"referred_reward"`
+}
+
+// ReferralSettings - бонусы пригласившему и приглашенному, 0 - бонус не начисляется
+type ReferralSettings struct {
+	ReferrerReward Kopecks `json:"referrer_reward" binding:"min=0"`
+	ReferredReward Kopecks `json:"referred_reward" binding:"min=0"`
+}
+
+func NormalizeReferralCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// NormalizePhone - последние 10 цифр номера, чтобы +7, 8 и номер без кода страны совпадали
+func NormalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) > 10 {
+		digits = digits[len(digits)-10:]
+	}
+	return digits
+}
+
+// addressNoise - сокращения, которые пишут по-разному и которые не отличают один адрес от другого
+var addressNoise = map[string]bool{
+	"г": true, "город": true, "ул": true, "улица": true, "пр": true, "пр-т": true, "проспект": true,
+	"пер": true, "переулок": true, "д": true, "дом": true, "кв": true, "квартира": true,
+	"корп": true, "к": true, "стр": true,
+}
+
+// NormalizeAddress приводит адрес к виду для сравнения: нижний регистр, без знаков препинания
+// и сокращений вроде "ул." и "д."
+func NormalizeAddress(address string) string {
+	words := strings.FieldsFunc(strings.ToLower(address), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	kept := words[:0]
+	for _, word := range words {
+		if !addressNoise[word] {
+			kept = append(kept, word)
+		}
+	}
+	return strings.Join(kept, " ")
+}
@@ -0,0 +1,81 @@
+package entity
+
+const (
+	RoleSuperadmin = "superadmin"
+	RoleSupport    = "support"
+	RoleAccountant = "accountant"
+	RoleInstaller  = "installer"
+)
+
+const (
+	PermDashboardView  = "dashboard.view"
+	PermUsersView      = "users.view"
+	PermUsersBalance   = "users.balance"
+	PermPaymentsView   = "payments.view"
+	PermPaymentsManage = "payments.manage"
+	PermTariffsManage  = "tariffs.manage"
+	PermPromoManage    = "promo.manage"
+	PermAdminsManage   = "admins.manage"
+	PermLockoutsManage = "lockouts.manage"
+	PermAuditView      = "audit.view"
+)
+
+// RolePermissions - матрица прав админ-панели. Суперадмину разрешено все.
+var RolePermissions = map[string][]string{
+	RoleSupport: {
+		PermDashboardView,
+		PermUsersView,
+		PermLockoutsManage,
+	},
+	RoleAccountant: {
+		PermDashboardView,
+		PermUsersView,
+		PermUsersBalance,
+		PermPaymentsView,
+		PermPaymentsManage,
+		PermPromoManage,
+		PermAuditView,
+	},
+	RoleInstaller: {
+		PermUsersView,
+	},
+}
+
+func IsValidRole(role string) bool {
+	if role == RoleSuperadmin {
+		return true
+	}
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+func HasPermission(role, permission string) bool {
+	if role == RoleSuperadmin {
+		return true
+	}
+
+	for _, p := range RolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func PermissionsForRole(role string) []string {
+	if role == RoleSuperadmin {
+		return []string{
+			PermDashboardView,
+			PermUsersView,
+			PermUsersBalance,
+			PermPaymentsView,
+			PermPaymentsManage,
+			PermTariffsManage,
+			PermPromoManage,
+			PermAdminsManage,
+			PermLockoutsManage,
+			PermAuditView,
+		}
+	}
+	return RolePermissions[role]
+}
@@ -0,0 +1,22 @@
+package entity
+
+import "time"
+
+const (
+	SettingAdmin2FARequired    = "admin_2fa_required"
+	SettingPromiseLimitPercent = "promised_payment_limit_percent"
+	SettingPromiseDays         = "promised_payment_days"
+	SettingReferrerReward      = "referral_referrer_reward"
+	SettingReferredReward      = "referral_referred_reward"
+	SettingFreezeMinDays       = "freeze_min_days"
+	SettingFreezeMaxDays       = "freeze_max_days"
+	SettingFreezeDailyFee      = "freeze_daily_fee"
+)
+
+// Setting - системная настройка, которую администраторы меняют без перезапуска сервера
+type Setting struct {
+	Key       string    `gorm:"primaryKey;size:100" json:"key"`
+	Value     string    `gorm:"type:text;not null" json:"value"`
+	UpdatedBy *int64    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
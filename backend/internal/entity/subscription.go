@@ -0,0 +1,128 @@
+package entity
+
+import "time"
+
+const (
+	BillingMonthly = "monthly"
+	BillingDaily   = "daily"
+)
+
+const (
+	SubscriptionActive    = "active"
+	SubscriptionSuspended = "suspended"
+	SubscriptionFrozen    = "frozen"
+)
+
+// Subscription - подключенный тариф клиента. Услуга оплачена по PaidUntil,
+// после этого биллинг списывает следующий период или приостанавливает доступ.
+// PeriodDiscount - скидка по промокоду за текущий период, при перерасчете ее не возвращают.
+// AnchorDay - день месяца, в который начался первый месячный период: от него считаются
+// концы следующих периодов, чтобы короткий месяц не сдвигал их навсегда.
+type Subscription struct {
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	UserID         int        `gorm:"uniqueIndex;not null" json:"user_id"`
+	TariffID       int64      `gorm:"not null;index" json:"tariff_id"`
+	NextTariffID   *int64     `json:"next_tariff_id,omitempty"`
+	Status         string     `gorm:"size:20;not null;default:'active';index" json:"status"`
+	PeriodStart    time.Time  `gorm:"not null" json:"period_start"`
+	PaidUntil      time.Time  `gorm:"not null;index" json:"paid_until"`
+	AnchorDay      int        `gorm:"not null;default:0" json:"anchor_day"`
+	PeriodDiscount Kopecks    `gorm:"not null;default:0" json:"period_discount"`
+	SuspendedAt    *time.Time `json:"suspended_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// SubscriptionCharge - списание за один период. Уникальность (subscription_id, period_start)
+// не дает списать один и тот же период дважды при повторном запуске биллинга.
+type SubscriptionCharge struct {
+	ID                int64      `gorm:"primaryKey" json:"id"`
+	SubscriptionID    int64      `gorm:"not null;uniqueIndex:idx_charge_period" json:"subscription_id"`
+	UserID            int        `gorm:"not null;index" json:"user_id"`
+	TariffID          int64      `gorm:"not null" json:"tariff_id"`
+	Amount            Kopecks    `gorm:"not null" json:"amount"`
+	Discount          Kopecks    `gorm:"not null;default:0" json:"discount"`
+	PromoRedemptionID *int64     `json:"promo_redemption_id,omitempty"`
+	PeriodStart       time.Time  `gorm:"not null;uniqueIndex:idx_charge_period" json:"period_start"`
+	PeriodEnd         time.Time  `gorm:"not null" json:"period_end"`
+	ReversedAt        *time.Time `json:"reversed_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// Виды изменения тарифа в истории
+const (
+	TariffChangeActivation         = "activation"
+	TariffChangeUpgrade            = "upgrade"
+	TariffChangeDowngrade          = "downgrade"
+	TariffChangeDowngradeCancelled = "downgrade_cancelled"
+	TariffChangeDowngradeApplied   = "downgrade_applied"
+	TariffChangeFreeze             = "freeze"
+	TariffChangeUnfreeze           = "unfreeze"
+)
+
+// TariffHistory - запись о каждом подключении и смене тарифа, а также о заморозке аккаунта
+type TariffHistory struct {
+	ID             int64     `gorm:"primaryKey" json:"id"`
+	UserID         int       `gorm:"not null;index" json:"user_id"`
+	SubscriptionID int64     `gorm:"not null;index" json:"subscription_id"`
+	FromTariffID   *int64    `json:"from_tariff_id"`
+	ToTariffID     int64     `gorm:"not null" json:"to_tariff_id"`
+	Kind           string    `gorm:"size:30;not null" json:"kind"`
+	Credit         Kopecks   `gorm:"not null;default:0" json:"credit"`
+	Charge         Kopecks   `gorm:"not null;default:0" json:"charge"`
+	EffectiveAt    time.Time `gorm:"not null" json:"effective_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// TariffActivation - итог подключения тарифа клиенту без оплаченного периода
+type TariffActivation struct {
+	Subscription     *Subscription       `json:"subscription"`
+	Charge           *SubscriptionCharge `json:"charge"`
+	Price            Kopecks             `json:"price"`
+	Discount         Kopecks             `json:"discount"`
+	Addons           Kopecks             `json:"addons"`
+	PreviousTariffID *int                `json:"previous_tariff_id"`
+	BalanceBefore    Kopecks             `json:"balance_before"`
+	BalanceAfter     Kopecks             `json:"balance_after"`
+}
+
+// TariffChangeQuote - расчет смены тарифа. Один и тот же расчет показывается клиенту
+// в предпросмотре и применяется при подтверждении.
+type TariffChangeQuote struct {
+	Kind             string    `json:"kind"`
+	CurrentTariffID  *int64    `json:"current_tariff_id"`
+	NewTariffID      int64     `json:"new_tariff_id"`
+	Credit           Kopecks   `json:"credit"`
+	Charge           Kopecks   `json:"charge"`
+	AmountDue        Kopecks   `json:"amount_due"`
+	Discount         Kopecks   `json:"discount"`
+	PromoCode        string    `json:"promo_code,omitempty"`
+	NextPeriodCharge Kopecks   `json:"next_period_charge"`
+	EffectiveAt      time.Time `json:"effective_at"`
+	PeriodStart      time.Time `json:"period_start"`
+	PaidUntil        time.Time `json:"paid_until"`
+	Balance          Kopecks   `json:"balance"`
+	BalanceAfter     Kopecks   `json:"balance_after"`
+	EnoughFunds      bool      `json:"enough_funds"`
+}
+
+type TariffChangeRequest struct {
+	TariffID  int64  `json:"tariff_id" binding:"required"`
+	PromoCode string `json:"promo_code" binding:"max=50"`
+}
+
+// BillingRunResult - итог одного прохода биллинга
+type BillingRunResult struct {
+	Charged   int `json:"charged"`
+	Suspended int `json:"suspended"`
+	Resumed   int `json:"resumed"`
+	Failed    int `json:"failed"`
+}
+
+// Итог биллинга одной подписки
+const (
+	BillingSkipped   = "skipped"
+	BillingCharged   = "charged"
+	BillingSuspended = "suspended"
+	BillingResumed   = "resumed"
+)
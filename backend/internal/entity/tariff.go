@@ -1,8 +1,132 @@
 package entity
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
 type Tariff struct {
-	ID    int64   `json:"id"`
-	Name  string  `json:"name"`
-	Price float64 `json:"price"`
-	Speed int     `json:"speed"`
+	ID            int64      `gorm:"primaryKey" json:"id"`
+	Name          string     `gorm:"size:100;not null" json:"name"`
+	Description   string     `gorm:"type:text" json:"description"`
+	Price         Kopecks    `gorm:"not null" json:"price"`
+	Speed         int        `json:"speed"`
+	Features      StringList `gorm:"type:text" json:"features"`
+	BillingPeriod string     `gorm:"size:10;not null;default:'monthly'" json:"billing_period"`
+	IsVisible     bool       `gorm:"not null;default:true" json:"is_visible"`
+	SortOrder     int        `gorm:"not null;default:0" json:"sort_order"`
+	ArchivedAt    *time.Time `json:"archived_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Available - тариф можно подключить: он не в архиве и показывается клиентам
+func (t *Tariff) Available() bool {
+	return t.ArchivedAt == nil && t.IsVisible
+}
+
+// PeriodEnd - конец первого оплачиваемого периода, начавшегося в start
+func (t *Tariff) PeriodEnd(start time.Time) time.Time {
+	return t.PeriodEndOn(start, start.Day())
+}
+
+// PeriodEndOn - конец периода, начавшегося в start, у подписки с днем отсчета anchorDay.
+// Месячный период заканчивается в день anchorDay следующего месяца, а если в нем столько
+// дней нет - в последний день месяца. Так подписка от 31 января оплачена до 28 февраля,
+// а следующий период идет до 31 марта, а не до 28-го.
+func (t *Tariff) PeriodEndOn(start time.Time, anchorDay int) time.Time {
+	if t.BillingPeriod == BillingDaily {
+		return start.AddDate(0, 0, 1)
+	}
+	if anchorDay <= 0 {
+		anchorDay = start.Day()
+	}
+
+	year, month, _ := start.Date()
+	days := time.Date(year, month+2, 0, 0, 0, 0, 0, start.Location()).Day()
+	if anchorDay > days {
+		anchorDay = days
+	}
+	return time.Date(year, month+1, anchorDay, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+}
+
+// PeriodPrice - сумма за период. Price всегда указывается за месяц, при посуточной оплате
+// она делится на число дней в месяце, на который приходится начало периода.
+func (t *Tariff) PeriodPrice(start time.Time) Kopecks {
+	return periodPrice(t.Price, t.BillingPeriod, start)
+}
+
+// periodPrice - доля месячной цены price за период billingPeriod, начавшийся в start
+func periodPrice(price Kopecks, billingPeriod string, start time.Time) Kopecks {
+	if billingPeriod != BillingDaily {
+		return price
+	}
+
+	days := time.Date(start.Year(), start.Month()+1, 0, 0, 0, 0, 0, start.Location()).Day()
+	return Kopecks(math.Round(float64(price) / float64(days)))
+}
+
+// StringList хранит список строк в столбце как JSON массив
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+
+	data, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = StringList{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", value)
+	}
+
+	if len(data) == 0 {
+		*l = StringList{}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+type CreateTariffRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Description   string   `json:"description"`
+	Price         float64  `json:"price" binding:"required,gt=0"`
+	Speed         int      `json:"speed" binding:"required,gt=0"`
+	Features      []string `json:"features"`
+	IsVisible     *bool    `json:"is_visible"`
+	BillingPeriod string   `json:"billing_period" binding:"omitempty,oneof=monthly daily"`
+}
+
+// UpdateTariffRequest - меняются только переданные поля
+type UpdateTariffRequest struct {
+	Name          *string   `json:"name" binding:"omitempty,min=1,max=100"`
+	Description   *string   `json:"description"`
+	Price         *float64  `json:"price" binding:"omitempty,gt=0"`
+	Speed         *int      `json:"speed" binding:"omitempty,gt=0"`
+	Features      *[]string `json:"features"`
+	IsVisible     *bool     `json:"is_visible"`
+	BillingPeriod *string   `json:"billing_period" binding:"omitempty,oneof=monthly daily"`
+}
+
+// ReorderTariffsRequest - ID тарифов в том порядке, в котором их показывать
+type ReorderTariffsRequest struct {
+	IDs []int64 `json:"ids" binding:"required,min=1"`
 }
@@ -1,24 +1,85 @@
 package entity
 
+import "time"
+
 type User struct {
-	Id            int     `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name          string  `gorm:"size:100;not null" json:"name"`
-	Email         string  `gorm:"size:100;uniqueIndex;not null" json:"email"`
-	Phone         string  `gorm:"size:20;not null" json:"phone"`
-	PasswordHash  string  `gorm:"size:255;not null" json:"-"`
-	AccountNumber string  `gorm:"size:20;uniqueIndex;not null" json:"accountn"`
-	Balance       float64 `gorm:"type:decimal(10,2);default:0.00" json:"balance"`
-	TariffID      *int    `gorm:"default:null" json:"tariff_id"`
+	Id                int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name              string     `gorm:"size:100;not null" json:"name"`
+	Email             string     `gorm:"size:100;uniqueIndex;not null" json:"email"`
+	Phone             string     `gorm:"size:20;not null" json:"phone"`
+	PasswordHash      string     `gorm:"size:255;not null" json:"-"`
+	AccountNumber     string     `gorm:"size:20;uniqueIndex;not null" json:"accountn"`
+	Balance           Kopecks    `gorm:"not null;default:0" json:"balance"`
+	TariffID          *int       `gorm:"default:null" json:"tariff_id"`
+	EmailVerified     bool       `gorm:"default:false" json:"email_verified"`
+	PasswordChangedAt *time.Time `json:"-"`
+	ReferralCode      string     `gorm:"size:16;uniqueIndex" json:"referral_code"`
+	ReferredBy        *int       `gorm:"index" json:"referred_by,omitempty"`
+}
+
+// UserSession - вход клиента с одного устройства. Refresh токен хранится только в виде хеша,
+// поэтому сессию можно отозвать при выходе или смене пароля.
+type UserSession struct {
+	ID               int64      `gorm:"primaryKey" json:"id"`
+	UserID           int        `gorm:"index;not null" json:"user_id"`
+	RefreshTokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	UserAgent        string     `gorm:"size:255" json:"user_agent"`
+	IP               string     `gorm:"size:45" json:"ip"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	LastUsedAt       time.Time  `json:"last_used_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+)
+
+// UserToken - одноразовый токен из письма. В базе хранится только его хеш.
+type UserToken struct {
+	ID        int64      `gorm:"primaryKey" json:"id"`
+	UserID    int        `gorm:"not null;index" json:"user_id"`
+	Purpose   string     `gorm:"size:20;not null" json:"purpose"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
 }
 
 type RegisterRequest struct {
-	Name     string `json:"name" binding:"required"`
-	Email    string `json:"email" binding:"required,email"`
-	Phone    string `json:"phone" binding:"required"`
-	Password string `json:"password" binding:"required,min=6"`
+	Name         string `json:"name" binding:"required"`
+	Email        string `json:"email" binding:"required,email"`
+	Phone        string `json:"phone" binding:"required"`
+	Password     string `json:"password" binding:"required,min=6"`
+	ReferralCode string `json:"referral_code" binding:"max=16"`
 }
 
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
 }
+
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
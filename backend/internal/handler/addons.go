@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AddonHandler struct {
+	addons *service.AddonService
+	audit  *service.AuditService
+}
+
+func NewAddonHandler(addons *service.AddonService, audit *service.AuditService) *AddonHandler {
+	return &AddonHandler{
+		addons: addons,
+		audit:  audit,
+	}
+}
+
+// ListAddons - каталог услуг, которые можно подключить к тарифу
+func (h *AddonHandler) ListAddons(c *gin.Context) {
+	addons, err := h.addons.ListPublic()
+	if err != nil {
+		log.Printf("Ошибка получения услуг: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения услуг"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"addons": addons})
+}
+
+// GetUserAddons - подключенные клиенту услуги и последние списания за них
+func (h *AddonHandler) GetUserAddons(c *gin.Context) {
+	userID := currentUserID(c)
+
+	addons, err := h.addons.ForUser(userID)
+	if err != nil {
+		log.Printf("Ошибка получения услуг клиента: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	charges, err := h.addons.Charges(userID)
+	if err != nil {
+		log.Printf("Ошибка получения списаний за услуги: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"addons":  addons,
+		"charges": charges,
+	})
+}
+
+// AttachAddon подключает услугу к подписке клиента
+func (h *AddonHandler) AttachAddon(c *gin.Context) {
+	userID := currentUserID(c)
+
+	var req struct {
+		AddonID int64 `json:"addon_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	attachment, err := h.addons.Attach(userID, req.AddonID)
+	if errors.Is(err, service.ErrInsufficientFunds) {
+		required := attachment.SetupFee + attachment.PeriodCharge
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Недостаточно средств для подключения услуги",
+			"required": required,
+			"current":  attachment.BalanceAfter,
+			"missing":  required - attachment.BalanceAfter,
+		})
+		return
+	}
+	if err != nil {
+		h.customerError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAddonAttach, entity.AuditEntityUser, strconv.Itoa(userID), nil, attachment)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "Услуга подключена",
+		"addon":       attachment.UserAddon,
+		"charged":     attachment.SetupFee + attachment.PeriodCharge,
+		"new_balance": attachment.BalanceAfter,
+		"paid_until":  attachment.PaidUntil,
+	})
+}
+
+// DetachAddon отключает услугу. Оплаченный период не возвращается.
+func (h *AddonHandler) DetachAddon(c *gin.Context) {
+	userID := currentUserID(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный номер услуги"})
+		return
+	}
+
+	userAddon, err := h.addons.Detach(userID, id)
+	if err != nil {
+		h.customerError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAddonDetach, entity.AuditEntityUser, strconv.Itoa(userID), nil, userAddon)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Услуга отключена",
+		"addon":   userAddon,
+	})
+}
+
+func (h *AddonHandler) AdminListAddons(c *gin.Context) {
+	includeArchived := c.Query("archived") == "true"
+
+	addons, err := h.addons.ListAll(includeArchived)
+	if err != nil {
+		log.Printf("Error listing addons: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get addons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"addons": addons})
+}
+
+func (h *AddonHandler) CreateAddon(c *gin.Context) {
+	var req entity.CreateAddonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	addon, err := h.addons.Create(&req)
+	if err != nil {
+		h.adminError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAddonCreate, entity.AuditEntityAddon, strconv.FormatInt(addon.ID, 10), nil, addon)
+
+	c.JSON(http.StatusCreated, gin.H{"addon": addon})
+}
+
+func (h *AddonHandler) UpdateAddon(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid addon ID"})
+		return
+	}
+
+	var req entity.UpdateAddonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := h.addons.Get(id)
+	if err != nil {
+		h.adminError(c, err)
+		return
+	}
+
+	addon, err := h.addons.Update(id, &req)
+	if err != nil {
+		h.adminError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAddonUpdate, entity.AuditEntityAddon, strconv.FormatInt(id, 10), before, addon)
+
+	c.JSON(http.StatusOK, gin.H{"addon": addon})
+}
+
+func (h *AddonHandler) ArchiveAddon(c *gin.Context) {
+	h.setArchived(c, true)
+}
+
+func (h *AddonHandler) RestoreAddon(c *gin.Context) {
+	h.setArchived(c, false)
+}
+
+func (h *AddonHandler) setArchived(c *gin.Context, archived bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid addon ID"})
+		return
+	}
+
+	addon, err := h.addons.SetArchived(id, archived)
+	if err != nil {
+		h.adminError(c, err)
+		return
+	}
+
+	action := entity.AuditAddonRestore
+	if archived {
+		action = entity.AuditAddonArchive
+	}
+	recordAudit(h.audit, auditMeta(c), action, entity.AuditEntityAddon, strconv.FormatInt(id, 10), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"addon": addon})
+}
+
+func (h *AddonHandler) customerError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrAddonNotFound), errors.Is(err, service.ErrAddonUnavailable):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Услуга не найдена или недоступна для подключения"})
+	case errors.Is(err, service.ErrAddonNotAttached):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Услуга не подключена"})
+	case errors.Is(err, service.ErrAddonAttached):
+		c.JSON(http.StatusConflict, gin.H{"error": "Услуга уже подключена"})
+	case errors.Is(err, service.ErrAddonNoSubscription):
+		c.JSON(http.StatusConflict, gin.H{"error": "Услуги подключаются к оплаченному тарифу"})
+	default:
+		log.Printf("Ошибка операции с услугой: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+	}
+}
+
+func (h *AddonHandler) adminError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrAddonNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidAddonPrice):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Addon operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func (h *AdminHandler) LoginTwoFactor(c *gin.Context) {
+	var req entity.TwoFactorLoginRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	username, err := h.adminService.ChallengeUsername(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login challenge"})
+		return
+	}
+
+	// Коды 2FA перебираются так же, как пароли, поэтому лимиты общие со входом
+	throttleKey := service.AdminThrottleKey(username)
+	if err := h.loginGuard.Check(throttleKey, entity.ThrottleScopeAdmin, c.ClientIP()); err != nil {
+		if !loginBlocked(c, err, "Account temporarily locked", "Too many attempts, try again later") {
+			log.Printf("Login guard check failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	response, err := h.adminService.CompleteTwoFactorLogin(&req, c.Request.UserAgent(), c.ClientIP())
+	if errors.Is(err, service.ErrTwoFactorCodeNeeded) {
+		h.loginGuard.Release(throttleKey, c.ClientIP())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Admin 2FA login failed for %s: %v", username, err)
+		if err := h.loginGuard.RegisterFailure(throttleKey, c.ClientIP()); err != nil {
+			log.Printf("Failed to register login failure: %v", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	if err := h.loginGuard.RegisterSuccess(throttleKey, c.ClientIP()); err != nil {
+		log.Printf("Failed to reset login throttle: %v", err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *AdminHandler) GetTwoFactorStatus(c *gin.Context) {
+	adminID := c.GetInt64("admin_id")
+
+	admin, err := h.adminService.GetAdmin(adminID)
+	if err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	required, err := h.adminService.TwoFactorRequired()
+	if err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	codesLeft, err := h.adminService.RecoveryCodesLeft(adminID)
+	if err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":             admin.TOTPEnabled,
+		"required":            required,
+		"recovery_codes_left": codesLeft,
+	})
+}
+
+func (h *AdminHandler) SetupTwoFactor(c *gin.Context) {
+	setup, err := h.adminService.SetupTwoFactor(c.GetInt64("admin_id"))
+	if err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+func (h *AdminHandler) EnableTwoFactor(c *gin.Context) {
+	var req entity.TwoFactorCodeRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID := c.GetInt64("admin_id")
+	codes, err := h.adminService.EnableTwoFactor(adminID, req.Code)
+	if err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAdmin2FAEnable, entity.AuditEntityAdmin, strconv.FormatInt(adminID, 10), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *AdminHandler) DisableTwoFactor(c *gin.Context) {
+	var req entity.TwoFactorDisableRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID := c.GetInt64("admin_id")
+	if err := h.adminService.DisableTwoFactor(adminID, req.Password, req.Code); err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAdmin2FADisable, entity.AuditEntityAdmin, strconv.FormatInt(adminID, 10), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func (h *AdminHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req entity.TwoFactorCodeRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID := c.GetInt64("admin_id")
+	codes, err := h.adminService.RegenerateRecoveryCodes(adminID, req.Code)
+	if err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAdminRecoveryCodes, entity.AuditEntityAdmin, strconv.FormatInt(adminID, 10), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *AdminHandler) ResetAdminTwoFactor(c *gin.Context) {
+	adminID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin ID"})
+		return
+	}
+
+	if err := h.adminService.ResetTwoFactor(c.GetInt64("admin_id"), adminID); err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAdmin2FAReset, entity.AuditEntityAdmin, strconv.FormatInt(adminID, 10), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset"})
+}
+
+func (h *AdminHandler) GetTwoFactorPolicy(c *gin.Context) {
+	required, err := h.adminService.TwoFactorRequired()
+	if err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"required": required})
+}
+
+func (h *AdminHandler) SetTwoFactorPolicy(c *gin.Context) {
+	var req entity.TwoFactorPolicyRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := h.adminService.TwoFactorRequired()
+	if err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	if err := h.adminService.SetTwoFactorRequired(c.GetInt64("admin_id"), *req.Required); err != nil {
+		h.twoFactorError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditSettingUpdate, entity.AuditEntitySetting, entity.SettingAdmin2FARequired,
+		gin.H{"value": before}, gin.H{"value": *req.Required})
+
+	c.JSON(http.StatusOK, gin.H{"required": *req.Required})
+}
+
+func (h *AdminHandler) twoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Admin not found"})
+	case errors.Is(err, service.ErrTwoFactorEnabled),
+		errors.Is(err, service.ErrTwoFactorNotSetUp),
+		errors.Is(err, service.ErrTwoFactorMandatory):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidTwoFactor),
+		errors.Is(err, service.ErrWrongPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		log.Printf("2FA operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func (h *AdminHandler) CreateAdmin(c *gin.Context) {
+	var req entity.CreateAdminRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	admin, err := h.adminService.CreateAdmin(&req)
+	if err != nil {
+		h.adminAccountError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAdminCreate, entity.AuditEntityAdmin, strconv.FormatInt(admin.ID, 10), nil, admin)
+
+	c.JSON(http.StatusCreated, gin.H{"admin": admin})
+}
+
+func (h *AdminHandler) ListAdmins(c *gin.Context) {
+	admins, err := h.adminService.ListAdmins()
+	if err != nil {
+		log.Printf("Error listing admins: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get admins"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"admins": admins})
+}
+
+func (h *AdminHandler) UpdateAdmin(c *gin.Context) {
+	adminID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin ID"})
+		return
+	}
+
+	var req entity.UpdateAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := h.adminService.GetAdmin(adminID)
+	if err != nil {
+		h.adminAccountError(c, err)
+		return
+	}
+
+	admin, err := h.adminService.UpdateAdmin(c.GetInt64("admin_id"), adminID, &req)
+	if err != nil {
+		h.adminAccountError(c, err)
+		return
+	}
+
+	// Сам пароль в журнал не попадает, только факт его сброса
+	after := gin.H{"admin": admin, "password_reset": req.Password != nil}
+	recordAudit(h.audit, auditMeta(c), entity.AuditAdminUpdate, entity.AuditEntityAdmin, strconv.FormatInt(adminID, 10), before, after)
+
+	c.JSON(http.StatusOK, gin.H{"admin": admin})
+}
+
+func (h *AdminHandler) DeactivateAdmin(c *gin.Context) {
+	adminID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin ID"})
+		return
+	}
+
+	before, err := h.adminService.GetAdmin(adminID)
+	if err != nil {
+		h.adminAccountError(c, err)
+		return
+	}
+
+	admin, err := h.adminService.DeactivateAdmin(c.GetInt64("admin_id"), adminID)
+	if err != nil {
+		h.adminAccountError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAdminDeactivate, entity.AuditEntityAdmin, strconv.FormatInt(adminID, 10), before, admin)
+
+	c.JSON(http.StatusOK, gin.H{"admin": admin})
+}
+
+func (h *AdminHandler) ChangePassword(c *gin.Context) {
+	var req entity.ChangePasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.adminService.ChangePassword(c.GetInt64("admin_id"), c.GetInt64("admin_session_id"), &req)
+	if err != nil {
+		h.adminAccountError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAdminPassword, entity.AuditEntityAdmin, strconv.FormatInt(c.GetInt64("admin_id"), 10), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+func (h *AdminHandler) adminAccountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Admin not found"})
+	case errors.Is(err, service.ErrAdminExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidRole),
+		errors.Is(err, service.ErrSamePassword),
+		errors.Is(err, service.ErrSelfDeactivation),
+		errors.Is(err, service.ErrLastSuperadmin):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrWrongPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Admin account operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
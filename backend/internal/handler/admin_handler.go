@@ -1,9 +1,7 @@
 package handler
 
 import (
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"internet_provider/internal/entity"
 	"internet_provider/internal/service"
 	"log"
@@ -16,11 +14,15 @@ import (
 
 type AdminHandler struct {
 	adminService *service.AdminService
+	loginGuard   *service.LoginGuard
+	audit        *service.AuditService
 }
 
-func NewAdminHandler(ah *service.AdminService) *AdminHandler {
+func NewAdminHandler(ah *service.AdminService, loginGuard *service.LoginGuard, audit *service.AuditService) *AdminHandler {
 	return &AdminHandler{
 		adminService: ah,
+		loginGuard:   loginGuard,
+		audit:        audit,
 	}
 }
 
@@ -32,17 +34,102 @@ func (h *AdminHandler) Login(c *gin.Context) {
 		return
 	}
 
-	responce, err := h.adminService.Authenticate(req.Username, req.Password)
+	throttleKey := service.AdminThrottleKey(req.Username)
+	if err := h.loginGuard.Check(throttleKey, entity.ThrottleScopeAdmin, c.ClientIP()); err != nil {
+		if !loginBlocked(c, err, "Account temporarily locked", "Too many attempts, try again later") {
+			log.Printf("Login guard check failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	responce, err := h.adminService.Authenticate(req.Username, req.Password, c.Request.UserAgent(), c.ClientIP())
 
 	if err != nil {
 		log.Printf("Admin login failed for %s: %v", req.Username, err)
+		if err := h.loginGuard.RegisterFailure(throttleKey, c.ClientIP()); err != nil {
+			log.Printf("Failed to register login failure: %v", err)
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	// При включенной 2FA счетчик сбросится только после второго шага, а второй шаг
+	// засчитает свою попытку сам
+	if responce.TwoFactorRequired {
+		h.loginGuard.Release(throttleKey, c.ClientIP())
+	} else if err := h.loginGuard.RegisterSuccess(throttleKey, c.ClientIP()); err != nil {
+		log.Printf("Failed to reset login throttle: %v", err)
+	}
+
 	c.JSON(http.StatusOK, responce)
 }
 
+func (h *AdminHandler) Refresh(c *gin.Context) {
+	var req entity.AdminRefreshRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	response, err := h.adminService.Refresh(req.RefreshToken)
+	if err != nil {
+		log.Printf("Admin token refresh failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *AdminHandler) Logout(c *gin.Context) {
+	if err := h.adminService.Logout(c.GetInt64("admin_id"), c.GetInt64("admin_session_id")); err != nil {
+		log.Printf("Admin logout failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+func (h *AdminHandler) ListSessions(c *gin.Context) {
+	sessions, err := h.adminService.ListSessions(c.GetInt64("admin_id"))
+	if err != nil {
+		log.Printf("Error listing admin sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions":        sessions,
+		"current_session": c.GetInt64("admin_session_id"),
+	})
+}
+
+func (h *AdminHandler) RevokeSession(c *gin.Context) {
+	sessionID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	err = h.adminService.RevokeSession(c.GetInt64("admin_id"), sessionID)
+	if errors.Is(err, service.ErrSessionUnknown) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking admin session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAdminSessionRevoke, entity.AuditEntityAdminSession, strconv.FormatInt(sessionID, 10), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
 func (h *AdminHandler) GetDashboard(c *gin.Context) {
 	stats, err := h.adminService.GetDashboardStats()
 
@@ -78,108 +165,49 @@ func (h *AdminHandler) GetUsers(c *gin.Context) {
 	})
 }
 
-func (h *AdminHandler) VerifyToken(c *gin.Context) {
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+func (h *AdminHandler) GetUser(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
-	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-		authHeader = strings.TrimSpace(authHeader[7:])
-	}
-
-	claims, err := h.adminService.VerifyJWT(authHeader)
+	user, err := h.adminService.GetUser(userID)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"admin_id": claims["admin_id"],
-		"username": claims["username"],
-	})
+	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
-// DebugToken - полная диагностика токена
-func (h *AdminHandler) DebugToken(c *gin.Context) {
-	var request struct {
-		Token string `json:"token"`
-	}
-
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	log.Printf("🔧 [DebugToken] Starting debug for token: %s", request.Token)
-
-	// 1. Проверим структуру токена
-	parts := strings.Split(request.Token, ".")
-	if len(parts) != 3 {
-		c.JSON(http.StatusOK, gin.H{
-			"error": fmt.Sprintf("Invalid token structure: expected 3 parts, got %d", len(parts)),
-			"parts": len(parts),
-		})
-		return
-	}
-
-	log.Printf("🔧 [DebugToken] Token parts: %d/%d/%d", len(parts[0]), len(parts[1]), len(parts[2]))
-
-	// 2. Попробуем декодировать header
-	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
-	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"error":  "Header decode failed: " + err.Error(),
-			"header": parts[0],
-		})
+func (h *AdminHandler) VerifyToken(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 		return
 	}
 
-	var header map[string]interface{}
-	if err := json.Unmarshal(headerBytes, &header); err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"error":      "Header JSON parse failed: " + err.Error(),
-			"header_raw": string(headerBytes),
-		})
-		return
+	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
+		authHeader = strings.TrimSpace(authHeader[7:])
 	}
 
-	// 3. Попробуем декодировать payload
-	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	claims, err := h.adminService.VerifyJWT(authHeader)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"error":   "Payload decode failed: " + err.Error(),
-			"payload": parts[1],
-		})
-		return
-	}
-
-	var payload map[string]interface{}
-	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"error":       "Payload JSON parse failed: " + err.Error(),
-			"payload_raw": string(payloadBytes),
-		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 		return
 	}
 
-	// 4. Пробуем верифицировать
-	claims, err := h.adminService.VerifyJWT(request.Token)
+	admin, _, err := h.adminService.ValidateSession(claims)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"error":            "Verification failed: " + err.Error(),
-			"header":           header,
-			"payload":          payload,
-			"signature_length": len(parts[2]),
-		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is no longer valid"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"status":  "valid",
-		"claims":  claims,
-		"header":  header,
-		"payload": payload,
+		"admin_id":    admin.ID,
+		"username":    admin.Username,
+		"role":        admin.Role,
+		"permissions": entity.PermissionsForRole(admin.Role),
 	})
 }
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditMeta определяет автора действия по данным, которые положили middleware
+func auditMeta(c *gin.Context) entity.AuditMeta {
+	meta := entity.AuditMeta{
+		ActorType: entity.ActorSystem,
+		IP:        c.ClientIP(),
+		RequestID: c.GetString("request_id"),
+	}
+
+	if adminID := c.GetInt64("admin_id"); adminID != 0 {
+		meta.ActorType = entity.ActorAdmin
+		meta.ActorID = &adminID
+	} else if userID := c.GetInt("user_id"); userID != 0 {
+		id := int64(userID)
+		meta.ActorType = entity.ActorCustomer
+		meta.ActorID = &id
+	}
+
+	return meta
+}
+
+// customerAuditMeta - для публичных ручек, где клиент становится известен только по ходу запроса
+func customerAuditMeta(c *gin.Context, userID int) entity.AuditMeta {
+	meta := auditMeta(c)
+	id := int64(userID)
+	meta.ActorType = entity.ActorCustomer
+	meta.ActorID = &id
+	return meta
+}
+
+// recordAudit пишет событие после успешной операции. Операция уже выполнена,
+// поэтому ошибка журнала не меняет ответ клиенту, но попадает в лог.
+func recordAudit(audit *service.AuditService, meta entity.AuditMeta, action, entityType, entityID string, before, after interface{}) {
+	if err := audit.Record(meta, action, entityType, entityID, before, after); err != nil {
+		log.Printf("Failed to record audit event %s for %s %s: %v", action, entityType, entityID, err)
+	}
+}
+
+func (h *AdminHandler) GetAudit(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	filter := entity.AuditFilter{
+		ActorType:  c.Query("actor_type"),
+		Action:     c.Query("action"),
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+	}
+
+	if value := c.Query("actor_id"); value != "" {
+		actorID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actor_id"})
+			return
+		}
+		filter.ActorID = &actorID
+	}
+
+	var err error
+	if filter.From, err = parseAuditDate(c.Query("from"), false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, use YYYY-MM-DD or RFC3339"})
+		return
+	}
+	if filter.To, err = parseAuditDate(c.Query("to"), true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, use YYYY-MM-DD or RFC3339"})
+		return
+	}
+
+	events, total, err := h.audit.List(&filter, page, limit)
+	if err != nil {
+		log.Printf("Error listing audit events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
+
+func (h *AdminHandler) VerifyAudit(c *gin.Context) {
+	result, err := h.audit.Verify()
+	if err != nil {
+		log.Printf("Error verifying audit chain: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit chain"})
+		return
+	}
+
+	if !result.Valid {
+		log.Printf("Audit chain is broken at event %d", *result.BrokenAt)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// parseAuditDate принимает дату или RFC3339. Дата в to включается целиком.
+func parseAuditDate(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
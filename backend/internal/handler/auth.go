@@ -1,22 +1,73 @@
 package handler
 
 import (
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"internet_provider/internal/entity"
 	"internet_provider/internal/repository"
+	"internet_provider/internal/service"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
-	userRepo *repository.UserRepository
+	userRepo       *repository.UserRepository
+	authService    *service.UserAuthService
+	accountService *service.AccountService
+	loginGuard     *service.LoginGuard
+	tariffService  *service.TariffService
+	billing        *service.BillingService
+	referrals      *service.ReferralService
+	addons         *service.AddonService
+	audit          *service.AuditService
 }
 
-func NewAuthHandler(userRepo *repository.UserRepository) *AuthHandler {
-	return &AuthHandler{userRepo: userRepo}
+func NewAuthHandler(userRepo *repository.UserRepository, authService *service.UserAuthService, accountService *service.AccountService,
+	loginGuard *service.LoginGuard, tariffService *service.TariffService, billing *service.BillingService,
+	referrals *service.ReferralService, addons *service.AddonService, audit *service.AuditService) *AuthHandler {
+	return &AuthHandler{
+		userRepo:       userRepo,
+		authService:    authService,
+		accountService: accountService,
+		loginGuard:     loginGuard,
+		tariffService:  tariffService,
+		billing:        billing,
+		referrals:      referrals,
+		addons:         addons,
+		audit:          audit,
+	}
+}
+
+// loginBlocked отвечает 429, если LoginGuard запретил вход. Возвращает false для прочих ошибок.
+func loginBlocked(c *gin.Context, err error, lockedMessage, delayMessage string) bool {
+	var blocked *service.LoginBlockedError
+	if !errors.As(err, &blocked) {
+		return false
+	}
+
+	seconds := int(math.Ceil(blocked.RetryAfter.Seconds()))
+	message := delayMessage
+	if blocked.Locked {
+		message = lockedMessage
+	}
+
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       message,
+		"locked":      blocked.Locked,
+		"retry_after": seconds,
+	})
+	return true
+}
+
+// currentUserID возвращает ID клиента, положенный в контекст UserAuthMiddleware
+func currentUserID(c *gin.Context) int {
+	return c.GetInt("user_id")
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -38,6 +89,17 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	referrer, err := h.referrals.Referrer(req.ReferralCode)
+	if errors.Is(err, service.ErrReferralCodeNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Реферальный код не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка проверки реферального кода: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
@@ -59,6 +121,18 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	recordAudit(h.audit, customerAuditMeta(c, user.Id), entity.AuditUserRegister, entity.AuditEntityUser, strconv.Itoa(user.Id), nil, user)
+
+	// Клиент уже зарегистрирован, поэтому сбой записи приглашения не отменяет регистрацию
+	if err := h.referrals.AttachRegistration(user, referrer); err != nil {
+		log.Printf("Ошибка записи приглашения: %v", err)
+	}
+
+	// Регистрация не должна падать из-за почты: письмо можно запросить повторно
+	if err := h.accountService.SendVerification(user); err != nil {
+		log.Printf("Ошибка отправки письма подтверждения: %v", err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Регистрация успешна",
 		"user_id": user.Id,
@@ -68,6 +142,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 			"email":          user.Email,
 			"account_number": user.AccountNumber,
 			"balance":        user.Balance,
+			"referral_code":  user.ReferralCode,
 		},
 	})
 }
@@ -80,22 +155,42 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Ищем пользователя
+	throttleKey := service.CustomerThrottleKey(req.Email)
+	if err := h.loginGuard.Check(throttleKey, entity.ThrottleScopeCustomer, c.ClientIP()); err != nil {
+		if !loginBlocked(c, err, "Вход временно заблокирован из-за большого числа неудачных попыток", "Слишком много попыток, попробуйте позже") {
+			log.Printf("Ошибка проверки блокировки входа: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		}
+		return
+	}
+
+	// Ищем пользователя и проверяем пароль
 	user, err := h.userRepo.FindByEmail(req.Email)
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
+	}
 	if err != nil {
+		if err := h.loginGuard.RegisterFailure(throttleKey, c.ClientIP()); err != nil {
+			log.Printf("Ошибка учета неудачного входа: %v", err)
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный email или пароль"})
 		return
 	}
 
-	// Проверяем пароль
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
+	if err := h.loginGuard.RegisterSuccess(throttleKey, c.ClientIP()); err != nil {
+		log.Printf("Ошибка сброса счетчика входов: %v", err)
+	}
+
+	tokens, err := h.authService.IssueTokens(user, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный email или пароль"})
+		log.Printf("Ошибка выдачи токенов: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Вход успешен",
+		"tokens":  tokens,
 		"user": gin.H{
 			"id":             user.Id,
 			"name":           user.Name,
@@ -106,10 +201,118 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	})
 }
 
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req entity.RefreshRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.authService.Refresh(req.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный refresh токен"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	if err := h.authService.Logout(c.GetInt("user_id"), c.GetInt64("user_session_id")); err != nil {
+		log.Printf("Ошибка выхода клиента: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Вы вышли из аккаунта"})
+}
+
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req entity.VerifyEmailRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := h.accountService.VerifyEmail(req.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ссылка недействительна или устарела"})
+		return
+	}
+
+	recordAudit(h.audit, customerAuditMeta(c, userID), entity.AuditUserEmailVerified, entity.AuditEntityUser, strconv.Itoa(userID), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email подтвержден"})
+}
+
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	user, err := h.userRepo.GetUserByID(int64(currentUserID(c)))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	if user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email уже подтвержден"})
+		return
+	}
+
+	if err := h.accountService.SendVerification(user); err != nil {
+		log.Printf("Ошибка отправки письма подтверждения: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось отправить письмо"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Письмо отправлено"})
+}
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req entity.ForgotPasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.accountService.RequestPasswordReset(req.Email); err != nil {
+		log.Printf("Ошибка отправки письма восстановления: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось отправить письмо"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Если такой email зарегистрирован, мы отправили на него ссылку для смены пароля"})
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req entity.ResetPasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := h.accountService.ResetPassword(req.Token, req.Password)
+	if errors.Is(err, service.ErrTokenInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ссылка недействительна или устарела"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка смены пароля: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	recordAudit(h.audit, customerAuditMeta(c, userID), entity.AuditUserPasswordReset, entity.AuditEntityUser, strconv.Itoa(userID), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Пароль изменен"})
+}
+
 func (h *AuthHandler) ActivateTarrif(c *gin.Context) {
 	var request struct {
-		UserID   int `json:"user_id" binding:"required"`
-		TariffID int `json:"tariff_id" binding:"required"`
+		TariffID  int    `json:"tariff_id" binding:"required"`
+		PromoCode string `json:"promo_code" binding:"max=50"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -117,73 +320,197 @@ func (h *AuthHandler) ActivateTarrif(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userRepo.GetUserByID(int64(request.UserID))
+	userID := currentUserID(c)
+
+	user, err := h.userRepo.GetUserByID(int64(userID))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	tariff, err := h.userRepo.GetTariffByID(int64(request.TariffID))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	tariff, ok := h.availableTariff(c, int64(request.TariffID))
+	if !ok {
 		return
 	}
 
-	if user.Balance < tariff.Price {
+	activation, err := h.billing.ActivateTariff(user, tariff, request.PromoCode)
+	switch {
+	case service.IsPromoError(err):
+		c.JSON(promoStatus(err), gin.H{"error": promoMessage(err)})
+		return
+	case errors.Is(err, service.ErrSubscriptionActive):
+		// При оплаченном периоде выбор тарифа - это смена с перерасчетом, а не новое подключение
+		h.changeTariff(c, user, tariff)
+		return
+	case errors.Is(err, service.ErrAccountFrozen):
+		c.JSON(http.StatusConflict, gin.H{"error": "Аккаунт заморожен. Разморозьте его, чтобы сменить тариф"})
+		return
+	case errors.Is(err, service.ErrInsufficientFunds):
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":    "Недостаточно средств для активации тарифа",
-			"required": tariff.Price,
-			"current":  user.Balance,
-			"missing":  tariff.Price - user.Balance,
+			"required": activation.Price + activation.Addons,
+			"current":  activation.BalanceBefore,
+			"missing":  activation.Price + activation.Addons - activation.BalanceBefore,
 		})
 		return
+	case err != nil:
+		log.Printf("Ошибка активации тарифа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при активации тарифа"})
+		return
 	}
 
-	err = h.userRepo.UpdateBalance(int64(request.UserID), -tariff.Price)
+	recordAudit(h.audit, auditMeta(c), entity.AuditTariffActivate, entity.AuditEntityUser, strconv.Itoa(userID),
+		gin.H{"balance": activation.BalanceBefore, "tariff_id": activation.PreviousTariffID},
+		gin.H{"balance": activation.BalanceAfter, "tariff_id": tariff.ID, "charged": activation.Price, "discount": activation.Discount, "addons": activation.Addons, "charge_id": activation.Charge.ID})
+	log.Printf("Тариф активирован успешно. Списано: %s, Новый баланс: %s", activation.Price, activation.BalanceAfter)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Тариф успешно активирован",
+		"tariff": gin.H{
+			"id":    tariff.ID,
+			"name":  tariff.Name,
+			"price": tariff.Price,
+		},
+		"new_balance": activation.BalanceAfter,
+		"charged":     activation.Price,
+		"discount":    activation.Discount,
+		"addons":      activation.Addons,
+		"paid_until":  activation.Subscription.PaidUntil,
+	})
+}
+
+// PreviewTariffChange показывает суммы смены тарифа до подтверждения клиентом
+func (h *AuthHandler) PreviewTariffChange(c *gin.Context) {
+	var req entity.TariffChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(int64(currentUserID(c)))
 	if err != nil {
-		log.Printf("Ошибка списания денег: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при списании средств"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	tariff, ok := h.availableTariff(c, req.TariffID)
+	if !ok {
 		return
 	}
 
-	err = h.userRepo.SetTariff(int64(request.UserID), int64(request.TariffID))
+	quote, err := h.billing.PreviewTariffChange(user, tariff, req.PromoCode)
+	if service.IsPromoError(err) {
+		c.JSON(promoStatus(err), gin.H{"error": promoMessage(err)})
+		return
+	}
+	if errors.Is(err, service.ErrSameTariff) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Этот тариф уже подключен"})
+		return
+	}
+	if errors.Is(err, service.ErrAccountFrozen) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Аккаунт заморожен. Разморозьте его, чтобы сменить тариф"})
+		return
+	}
 	if err != nil {
+		log.Printf("Ошибка расчета смены тарифа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quote":  quote,
+		"tariff": tariff,
+	})
+}
+
+// changeTariff меняет тариф клиента с активной подпиской
+func (h *AuthHandler) changeTariff(c *gin.Context, user *entity.User, tariff *entity.Tariff) {
+	quote, err := h.billing.ChangeTariff(user.Id, tariff)
+	switch {
+	case errors.Is(err, service.ErrSameTariff):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Этот тариф уже подключен"})
+		return
+	case errors.Is(err, service.ErrInsufficientFunds):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Недостаточно средств для смены тарифа",
+			"required": quote.AmountDue,
+			"current":  quote.Balance,
+			"missing":  quote.AmountDue - quote.Balance,
+		})
+		return
+	case errors.Is(err, service.ErrNoActiveSubscription):
+		c.JSON(http.StatusConflict, gin.H{"error": "Подписка приостановлена, повторите подключение тарифа"})
+		return
+	case errors.Is(err, service.ErrAccountFrozen):
+		c.JSON(http.StatusConflict, gin.H{"error": "Аккаунт заморожен. Разморозьте его, чтобы сменить тариф"})
+		return
+	case err != nil:
+		log.Printf("Ошибка смены тарифа: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при обновлении тарифа"})
 		return
 	}
 
-	newBalance := user.Balance - tariff.Price
-	log.Printf("Тариф активирован успешно. Списано: %.2f, Новый баланс: %.2f", tariff.Price, newBalance)
+	recordAudit(h.audit, auditMeta(c), entity.AuditTariffChange, entity.AuditEntityUser, strconv.Itoa(user.Id),
+		gin.H{"balance": user.Balance, "tariff_id": user.TariffID}, quote)
 
-	log.Printf("Тариф активирован успешно. Новый баланс: %.2f", newBalance)
+	message := "Тариф изменен"
+	switch quote.Kind {
+	case entity.TariffChangeDowngrade:
+		message = "Тариф сменится с начала следующего периода"
+	case entity.TariffChangeDowngradeCancelled:
+		message = "Запланированная смена тарифа отменена"
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Тариф успешно активирован",
+		"message": message,
 		"tariff": gin.H{
 			"id":    tariff.ID,
 			"name":  tariff.Name,
 			"price": tariff.Price,
 		},
-		"new_balance": newBalance,
+		"quote":       quote,
+		"new_balance": quote.BalanceAfter,
+		"paid_until":  quote.PaidUntil,
 	})
 }
 
-func (h *AuthHandler) GetUserProfile(c *gin.Context) {
-	userID := c.Param("id")
-	log.Printf("GetUserProfile: ищем пользователя с ID=%s", userID)
+// availableTariff находит тариф, доступный для подключения, или сам отвечает ошибкой
+func (h *AuthHandler) availableTariff(c *gin.Context, id int64) (*entity.Tariff, bool) {
+	tariff, err := h.tariffService.GetAvailable(id)
+	if errors.Is(err, service.ErrTariffNotFound) || errors.Is(err, service.ErrTariffUnavailable) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тариф не найден или недоступен для подключения"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Ошибка получения тарифа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return nil, false
+	}
 
-	var user entity.User
+	return tariff, true
+}
 
-	err := h.userRepo.LoadTariff(&user, userID)
+func (h *AuthHandler) GetUserProfile(c *gin.Context) {
+	userID := currentUserID(c)
+	log.Printf("GetUserProfile: ищем пользователя с ID=%d", userID)
 
+	user, err := h.userRepo.GetUserByID(int64(userID))
 	if err != nil {
-		log.Printf("Пользователь с ID=%s не найден: %v", userID, err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный фрмат данных" + err.Error()})
+		log.Printf("Пользователь с ID=%d не найден: %v", userID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	addons, err := h.addons.ForUser(userID)
+	if err != nil {
+		log.Printf("Ошибка получения услуг клиента %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
 		return
 	}
 
 	log.Printf("Найден пользователь: %+v", user)
 	c.JSON(http.StatusOK, gin.H{
-		"user": user,
+		"user":   user,
+		"addons": addons,
 	})
 }
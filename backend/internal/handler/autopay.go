@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AutopayHandler struct {
+	autopay *service.AutopayService
+	audit   *service.AuditService
+}
+
+func NewAutopayHandler(autopay *service.AutopayService, audit *service.AuditService) *AutopayHandler {
+	return &AutopayHandler{
+		autopay: autopay,
+		audit:   audit,
+	}
+}
+
+// GetAutopay - настройки автоплатежа клиента и сохраненные карты. Карта сохраняется
+// при пополнении с save_card.
+func (h *AutopayHandler) GetAutopay(c *gin.Context) {
+	userID := currentUserID(c)
+
+	autopay, err := h.autopay.Get(userID)
+	if err != nil {
+		log.Printf("Ошибка получения автоплатежа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	cards, err := h.autopay.Cards(userID)
+	if err != nil {
+		log.Printf("Ошибка получения сохраненных карт: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"autopay": autopay,
+		"cards":   cards,
+	})
+}
+
+// UpdateAutopay включает автоплатеж или меняет его настройки
+func (h *AutopayHandler) UpdateAutopay(c *gin.Context) {
+	userID := currentUserID(c)
+
+	var req entity.AutopayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	before, err := h.autopay.Get(userID)
+	if err != nil {
+		log.Printf("Ошибка получения автоплатежа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	autopay, err := h.autopay.Enable(userID, &req)
+	switch {
+	case errors.Is(err, service.ErrCardNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Карта не найдена"})
+		return
+	case errors.Is(err, service.ErrInvalidAutopay):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите порог баланса и сумму пополнения от " +
+			entity.MinAutopayAmount.String() + " ₽ или число дней до списания от 1 до 30"})
+		return
+	case err != nil:
+		log.Printf("Ошибка включения автоплатежа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAutopayEnable, entity.AuditEntityUser, strconv.Itoa(userID), before, autopay)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Автоплатеж включен",
+		"autopay": autopay,
+	})
+}
+
+// DisableAutopay выключает автоплатеж. Настройки сохраняются, чтобы включить его снова.
+func (h *AutopayHandler) DisableAutopay(c *gin.Context) {
+	userID := currentUserID(c)
+
+	autopay, err := h.autopay.Disable(userID)
+	if errors.Is(err, service.ErrAutopayNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Автоплатеж не настроен"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка отключения автоплатежа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditAutopayDisable, entity.AuditEntityUser, strconv.Itoa(userID), nil, autopay)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Автоплатеж отключен",
+		"autopay": autopay,
+	})
+}
+
+// DeleteCard удаляет сохраненную карту. Автоплатеж с нее отключается.
+func (h *AutopayHandler) DeleteCard(c *gin.Context) {
+	userID := currentUserID(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный номер карты"})
+		return
+	}
+
+	err = h.autopay.DeleteCard(userID, id)
+	if errors.Is(err, service.ErrCardNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Карта не найдена"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка удаления карты: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditCardDelete, entity.AuditEntityUser, strconv.Itoa(userID), gin.H{"card_id": id}, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Карта удалена"})
+}
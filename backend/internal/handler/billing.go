@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BillingHandler struct {
+	billing *service.BillingService
+	audit   *service.AuditService
+}
+
+func NewBillingHandler(billing *service.BillingService, audit *service.AuditService) *BillingHandler {
+	return &BillingHandler{
+		billing: billing,
+		audit:   audit,
+	}
+}
+
+// GetSubscription - состояние подписки клиента и последние списания
+func (h *BillingHandler) GetSubscription(c *gin.Context) {
+	userID := currentUserID(c)
+
+	sub, err := h.billing.GetSubscription(userID)
+	if err != nil {
+		log.Printf("Ошибка получения подписки: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+	if sub == nil {
+		c.JSON(http.StatusOK, gin.H{"subscription": nil, "charges": []entity.SubscriptionCharge{}})
+		return
+	}
+
+	charges, err := h.billing.ListCharges(userID)
+	if err != nil {
+		log.Printf("Ошибка получения списаний: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscription": sub,
+		"charges":      charges,
+	})
+}
+
+// GetTariffHistory - подключения и смены тарифа клиента
+func (h *BillingHandler) GetTariffHistory(c *gin.Context) {
+	history, err := h.billing.TariffHistory(currentUserID(c))
+	if err != nil {
+		log.Printf("Ошибка получения истории тарифов: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"history": history})
+}
+
+// RunBilling запускает биллинг вручную. Повторный запуск не списывает уже оплаченные периоды.
+func (h *BillingHandler) RunBilling(c *gin.Context) {
+	result, err := h.billing.RunOnce(time.Now())
+	if err != nil {
+		log.Printf("Manual billing run failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Billing run failed", "result": result})
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditBillingRun, "", "", nil, result)
+
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
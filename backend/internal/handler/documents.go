@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const invoiceMonthLayout = "2006-01"
+
+type DocumentHandler struct {
+	documents *service.DocumentService
+}
+
+func NewDocumentHandler(documents *service.DocumentService) *DocumentHandler {
+	return &DocumentHandler{documents: documents}
+}
+
+// ListDocuments - выданные клиенту счета и выписки, без файлов
+func (h *DocumentHandler) ListDocuments(c *gin.Context) {
+	h.list(c, currentUserID(c))
+}
+
+// DownloadDocument - повторная загрузка уже выданного документа
+func (h *DocumentHandler) DownloadDocument(c *gin.Context) {
+	h.download(c, currentUserID(c))
+}
+
+// DownloadInvoice - счет за месяц, месяц задается в пути как ГГГГ-ММ
+func (h *DocumentHandler) DownloadInvoice(c *gin.Context) {
+	h.invoice(c, currentUserID(c))
+}
+
+// DownloadStatement - выписка в PDF за from и to включительно, как у выписки в JSON
+func (h *DocumentHandler) DownloadStatement(c *gin.Context) {
+	h.statement(c, currentUserID(c))
+}
+
+func (h *DocumentHandler) AdminListDocuments(c *gin.Context) {
+	if userID, ok := pathUserID(c); ok {
+		h.list(c, userID)
+	}
+}
+
+func (h *DocumentHandler) AdminDownloadDocument(c *gin.Context) {
+	if userID, ok := pathUserID(c); ok {
+		h.download(c, userID)
+	}
+}
+
+func (h *DocumentHandler) AdminDownloadInvoice(c *gin.Context) {
+	if userID, ok := pathUserID(c); ok {
+		h.invoice(c, userID)
+	}
+}
+
+func (h *DocumentHandler) AdminDownloadStatement(c *gin.Context) {
+	if userID, ok := pathUserID(c); ok {
+		h.statement(c, userID)
+	}
+}
+
+func pathUserID(c *gin.Context) (int, bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+		return 0, false
+	}
+	return userID, true
+}
+
+func (h *DocumentHandler) list(c *gin.Context, userID int) {
+	docs, err := h.documents.List(userID)
+	if err != nil {
+		log.Printf("Ошибка получения документов: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"documents": docs})
+}
+
+func (h *DocumentHandler) download(c *gin.Context, userID int) {
+	documentID, err := strconv.ParseInt(c.Param("document_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный номер документа"})
+		return
+	}
+
+	doc, err := h.documents.Get(userID, documentID)
+	h.send(c, doc, err)
+}
+
+func (h *DocumentHandler) invoice(c *gin.Context, userID int) {
+	month, err := time.ParseInLocation(invoiceMonthLayout, c.Param("month"), time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Месяц должен быть в формате ГГГГ-ММ"})
+		return
+	}
+
+	doc, err := h.documents.Invoice(userID, month)
+	h.send(c, doc, err)
+}
+
+func (h *DocumentHandler) statement(c *gin.Context, userID int) {
+	from, errFrom := time.ParseInLocation(statementDateLayout, c.Query("from"), time.Local)
+	to, errTo := time.ParseInLocation(statementDateLayout, c.Query("to"), time.Local)
+	if errFrom != nil || errTo != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Даты from и to обязательны, формат ГГГГ-ММ-ДД"})
+		return
+	}
+
+	doc, err := h.documents.Statement(userID, from, to.AddDate(0, 0, 1))
+	h.send(c, doc, err)
+}
+
+// send отдает файл документа. Файл сохранен при первой выдаче, поэтому повторная
+// загрузка возвращает его байт в байт, а ETag позволяет это проверить.
+func (h *DocumentHandler) send(c *gin.Context, doc *entity.Document, err error) {
+	switch {
+	case errors.Is(err, service.ErrDocumentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Документ не найден"})
+		return
+	case errors.Is(err, service.ErrCustomerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	case errors.Is(err, service.ErrInvalidPeriod):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный период: не больше года, начало не позже конца"})
+		return
+	case errors.Is(err, service.ErrPeriodNotClosed):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Документ формируется только за закончившийся период"})
+		return
+	case err != nil:
+		log.Printf("Ошибка формирования документа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка формирования документа"})
+		return
+	}
+
+	filename := "statement_" + doc.PeriodStart.Format(statementDateLayout) + "_" +
+		doc.PeriodEnd.AddDate(0, 0, -1).Format(statementDateLayout) + ".pdf"
+	if doc.Number != nil {
+		filename = "invoice_" + *doc.Number + ".pdf"
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Header("ETag", `"`+doc.Checksum+`"`)
+	c.Header("Cache-Control", "private, no-cache")
+	c.Data(http.StatusOK, "application/pdf", doc.Content)
+}
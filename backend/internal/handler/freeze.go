@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FreezeHandler struct {
+	freezes *service.FreezeService
+	audit   *service.AuditService
+}
+
+func NewFreezeHandler(freezes *service.FreezeService, audit *service.AuditService) *FreezeHandler {
+	return &FreezeHandler{
+		freezes: freezes,
+		audit:   audit,
+	}
+}
+
+// GetFreeze - текущая заморозка клиента, условия новой и прошлые заморозки
+func (h *FreezeHandler) GetFreeze(c *gin.Context) {
+	userID := currentUserID(c)
+
+	offer, err := h.freezes.Offer(userID)
+	if err != nil {
+		log.Printf("Ошибка получения заморозки: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	history, err := h.freezes.History(userID)
+	if err != nil {
+		log.Printf("Ошибка получения истории заморозок: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"freeze":  offer,
+		"history": history,
+	})
+}
+
+// Freeze ставит аккаунт на паузу на указанное число дней
+func (h *FreezeHandler) Freeze(c *gin.Context) {
+	userID := currentUserID(c)
+
+	var req entity.FreezeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите срок заморозки в днях"})
+		return
+	}
+
+	freeze, err := h.freezes.Freeze(userID, req.Days)
+	if errors.Is(err, service.ErrFreezeDuration) {
+		settings, settingsErr := h.freezes.Settings()
+		if settingsErr != nil {
+			log.Printf("Ошибка получения условий заморозки: %v", settingsErr)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Заморозить аккаунт можно на срок от " +
+			strconv.Itoa(settings.MinDays) + " до " + strconv.Itoa(settings.MaxDays) + " дней"})
+		return
+	}
+	if err != nil {
+		h.customerError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditFreezeStart, entity.AuditEntityUser, strconv.Itoa(userID), nil, freeze)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Аккаунт заморожен",
+		"freeze":  freeze,
+	})
+}
+
+// Unfreeze размораживает аккаунт раньше срока
+func (h *FreezeHandler) Unfreeze(c *gin.Context) {
+	userID := currentUserID(c)
+
+	freeze, err := h.freezes.Unfreeze(userID)
+	if err != nil {
+		h.customerError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditFreezeEnd, entity.AuditEntityUser, strconv.Itoa(userID), nil, freeze)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Аккаунт разморожен",
+		"freeze":  freeze,
+	})
+}
+
+// ListFreezes - замороженные сейчас аккаунты
+func (h *FreezeHandler) ListFreezes(c *gin.Context) {
+	freezes, err := h.freezes.ListActive()
+	if err != nil {
+		log.Printf("Error listing account freezes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get account freezes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"freezes": freezes})
+}
+
+// AdminFreeze замораживает аккаунт клиента без ограничений срока и без платы
+func (h *FreezeHandler) AdminFreeze(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req entity.AdminFreezeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	freeze, err := h.freezes.AdminFreeze(userID, req.Days, strings.TrimSpace(req.Reason), c.GetInt64("admin_id"))
+	if err != nil {
+		h.adminError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditFreezeStart, entity.AuditEntityUser, strconv.Itoa(userID), nil, freeze)
+
+	c.JSON(http.StatusCreated, gin.H{"freeze": freeze})
+}
+
+// UpdateFreeze переносит дату автоматической разморозки
+func (h *FreezeHandler) UpdateFreeze(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req entity.UpdateFreezeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, freeze, err := h.freezes.SetEnd(userID, req.EndAt)
+	if err != nil {
+		h.adminError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditFreezeUpdate, entity.AuditEntityUser, strconv.Itoa(userID), before, freeze)
+
+	c.JSON(http.StatusOK, gin.H{"freeze": freeze})
+}
+
+// AdminUnfreeze размораживает аккаунт клиента раньше срока
+func (h *FreezeHandler) AdminUnfreeze(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	freeze, err := h.freezes.Unfreeze(userID)
+	if err != nil {
+		h.adminError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditFreezeEnd, entity.AuditEntityUser, strconv.Itoa(userID), nil, freeze)
+
+	c.JSON(http.StatusOK, gin.H{"freeze": freeze})
+}
+
+func (h *FreezeHandler) GetSettings(c *gin.Context) {
+	settings, err := h.freezes.Settings()
+	if err != nil {
+		log.Printf("Error reading freeze settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"settings": settings})
+}
+
+func (h *FreezeHandler) UpdateSettings(c *gin.Context) {
+	var req entity.FreezeSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := h.freezes.Settings()
+	if err != nil {
+		log.Printf("Error reading freeze settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := h.freezes.UpdateSettings(&req, c.GetInt64("admin_id")); err != nil {
+		h.adminError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditSettingUpdate, entity.AuditEntitySetting, "freeze", before, req)
+
+	c.JSON(http.StatusOK, gin.H{"settings": req})
+}
+
+func (h *FreezeHandler) customerError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrFreezeNoSubscription):
+		c.JSON(http.StatusConflict, gin.H{"error": "Заморозить можно только оплаченный тариф"})
+	case errors.Is(err, service.ErrFreezeActive):
+		c.JSON(http.StatusConflict, gin.H{"error": "Аккаунт уже заморожен"})
+	case errors.Is(err, service.ErrFreezeNotActive):
+		c.JSON(http.StatusConflict, gin.H{"error": "Аккаунт не заморожен"})
+	case errors.Is(err, service.ErrFreezePromise):
+		c.JSON(http.StatusConflict, gin.H{"error": "Сначала погасите обещанный платеж"})
+	case errors.Is(err, service.ErrInsufficientFunds):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Недостаточно средств для оплаты заморозки"})
+	default:
+		log.Printf("Ошибка заморозки аккаунта: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+	}
+}
+
+func (h *FreezeHandler) adminError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrFreezeNoSubscription), errors.Is(err, service.ErrFreezeActive),
+		errors.Is(err, service.ErrFreezeNotActive), errors.Is(err, service.ErrFreezePromise):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrFreezeEnd), errors.Is(err, service.ErrInvalidFreezeSettings):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Account freeze operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"internet_provider/internal/app"
 	"internet_provider/internal/service"
 	"net/http"
@@ -29,6 +30,14 @@ func (h *ApplicationHandler) CreateApplication(c *gin.Context) {
 	}
 
 	if err := h.service.CreateApplication(&app); err != nil {
+		if service.IsPromoError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": promoMessage(err)})
+			return
+		}
+		if errors.Is(err, service.ErrReferralCodeNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Реферальный код не найден"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -83,6 +92,14 @@ func (h *ApplicationHandler) CreateApplicationPDF(c *gin.Context) {
 	}
 
 	if err := h.service.CreateApplication(&application); err != nil {
+		if service.IsPromoError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": promoMessage(err)})
+			return
+		}
+		if errors.Is(err, service.ErrReferralCodeNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Реферальный код не найден"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения: " + err.Error()})
 		return
 	}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const statementDateLayout = "2006-01-02"
+
+type LedgerHandler struct {
+	ledger *service.LedgerService
+}
+
+func NewLedgerHandler(ledger *service.LedgerService) *LedgerHandler {
+	return &LedgerHandler{ledger: ledger}
+}
+
+// GetStatement - выписка по счету клиента. Период задается датами from и to включительно,
+// по умолчанию - с начала текущего месяца по сегодня.
+func (h *LedgerHandler) GetStatement(c *gin.Context) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = time.ParseInLocation(statementDateLayout, value, now.Location()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Дата from должна быть в формате ГГГГ-ММ-ДД"})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = time.ParseInLocation(statementDateLayout, value, now.Location()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Дата to должна быть в формате ГГГГ-ММ-ДД"})
+			return
+		}
+	}
+
+	statement, err := h.ledger.Statement(currentUserID(c), from, to.AddDate(0, 0, 1))
+	if errors.Is(err, service.ErrInvalidPeriod) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный период выписки: не больше года, начало не позже конца"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения выписки: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"statement": statement})
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"internet_provider/internal/entity"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *AdminHandler) GetLockouts(c *gin.Context) {
+	scope := c.Query("scope")
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	locked, err := h.loginGuard.ListLocked()
+	if err != nil {
+		log.Printf("Error listing locked accounts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lockouts"})
+		return
+	}
+
+	events, total, err := h.loginGuard.ListEvents(scope, page, limit)
+	if err != nil {
+		log.Printf("Error listing lockout events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lockouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"locked": locked,
+		"events": events,
+		"total":  total,
+		"page":   page,
+	})
+}
+
+func (h *AdminHandler) UnlockLogin(c *gin.Context) {
+	var req entity.UnlockRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Снять блокировку с учетки администратора может только тот, кто управляет админами
+	if strings.HasPrefix(req.Key, entity.ThrottleScopeAdmin+":") && !entity.HasPermission(c.GetString("admin_role"), entity.PermAdminsManage) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	unlocked, err := h.loginGuard.Unlock(req.Key, c.GetInt64("admin_id"))
+	if err != nil {
+		log.Printf("Error unlocking %s: %v", req.Key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock"})
+		return
+	}
+	if !unlocked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key is not locked"})
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditLoginUnlock, entity.AuditEntityLoginLock, req.Key, nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unlocked", "key": req.Key})
+}
@@ -1,11 +1,16 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"internet_provider/internal/entity"
 	"internet_provider/internal/repository"
+	"internet_provider/internal/service"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,20 +18,26 @@ import (
 
 type PaymentHandler struct {
 	PaymentRepo *repository.PaymentRepository
+	payments    *service.PaymentService
+	audit       *service.AuditService
 }
 
-func NewPaymentHandler(paymentRepo *repository.PaymentRepository) *PaymentHandler {
+func NewPaymentHandler(paymentRepo *repository.PaymentRepository, payments *service.PaymentService, audit *service.AuditService) *PaymentHandler {
 	return &PaymentHandler{
 		PaymentRepo: paymentRepo,
+		payments:    payments,
+		audit:       audit,
 	}
 }
 
+// ToUpBalance создает платеж в шлюзе. Баланс пополнится, когда шлюз подтвердит оплату.
 func (h *PaymentHandler) ToUpBalance(c *gin.Context) {
-	userID := c.Param("id")
+	userID := currentUserID(c)
 
 	var request struct {
 		Amount        float64 `json:"amount" binding:"required,min=100"`
 		PaymentMethod string  `json:"payment_method" binding:"required"`
+		SaveCard      bool    `json:"save_card"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -34,24 +45,116 @@ func (h *PaymentHandler) ToUpBalance(c *gin.Context) {
 		return
 	}
 
-	id, err := strconv.Atoi(userID)
+	// Повтор запроса с тем же ключом (ретрай, двойной клик) не создает второй платеж
+	idempotencyKey := c.GetHeader(entity.IdempotencyKeyHeader)
+	if len(idempotencyKey) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Слишком длинный Idempotency-Key"})
+		return
+	}
+
+	payment, replayed, err := h.payments.TopUp(c.Request.Context(), userID, entity.ToKopecks(request.Amount), request.PaymentMethod, idempotencyKey, request.SaveCard)
+	if errors.Is(err, repository.ErrIdempotencyConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Idempotency-Key уже использован для платежа с другими параметрами"})
+		return
+	}
+	if errors.Is(err, service.ErrProviderUnavailable) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Платежный сервис недоступен, попробуйте позже", "payment_id": payment.ID})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+		log.Printf("Ошибка создания платежа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
 		return
 	}
 
-	err = h.PaymentRepo.ToUpBalance(id, request.Amount, request.PaymentMethod)
+	if replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
+	c.JSON(http.StatusOK, topUpResponse(payment))
+}
+
+// GetPayment - статус платежа клиента, по нему страница возврата из шлюза узнает итог оплаты
+func (h *PaymentHandler) GetPayment(c *gin.Context) {
+	paymentID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		log.Print("Ошибка поплнения")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный номер платежа"})
+		return
+	}
+
+	payment, err := h.payments.Get(currentUserID(c), paymentID)
+	if errors.Is(err, service.ErrPaymentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Платеж не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения платежа: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Оплата прошла успешно",
-		"user_id": userID,
-		"amount":  request.Amount,
-	})
+	c.JSON(http.StatusOK, gin.H{"payment": payment})
+}
+
+// GetPaymentHistory - пополнения клиента вместе с возвратами и ручными операциями
+func (h *PaymentHandler) GetPaymentHistory(c *gin.Context) {
+	payments, err := h.PaymentRepo.GetBalanceHistory(currentUserID(c))
+	if err != nil {
+		log.Printf("Ошибка получения истории платежей: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payments": payments})
+}
+
+// ProviderWebhook принимает уведомление шлюза о статусе платежа. Шлюз повторяет уведомление,
+// пока не получит 2xx, поэтому на временные ошибки отвечаем 500.
+func (h *PaymentHandler) ProviderWebhook(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+		return
+	}
+
+	err = h.payments.HandleWebhook(c.Param("provider"), body, c.Request.Header)
+	switch {
+	case errors.Is(err, service.ErrUnknownProvider), errors.Is(err, service.ErrPaymentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidSignature):
+		log.Printf("Rejected payment webhook with invalid signature from %s", c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrPaymentMismatch):
+		log.Printf("Payment webhook does not match the payment: %s", body)
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		log.Printf("Payment webhook failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// topUpResponse - ответ на пополнение. Строится только из сохраненного платежа,
+// поэтому повтор запроса получает тот же ответ, что и первый.
+func topUpResponse(payment *entity.Payment) gin.H {
+	message := "Перейдите на страницу оплаты"
+	switch payment.Status {
+	case entity.PaymentCompleted:
+		message = "Оплата прошла успешно"
+	case entity.PaymentFailed:
+		message = "Платеж не прошел"
+	case entity.PaymentCancelled:
+		message = "Платеж отменен"
+	}
+
+	return gin.H{
+		"message":          message,
+		"user_id":          payment.UserID,
+		"payment_id":       payment.ID,
+		"amount":           payment.Amount,
+		"status":           payment.Status,
+		"confirmation_url": payment.ConfirmationURL,
+	}
 }
 
 // / В файле handler/payment_handler.go добавьте:
@@ -71,27 +174,46 @@ func (h *PaymentHandler) GetPayments(c *gin.Context) {
 
 	// Можно добавить информацию о пользователях
 	type PaymentResponse struct {
-		ID            int       `json:"id"`
-		UserID        int       `json:"user_id"`
-		UserName      string    `json:"user_name"`
-		Amount        float64   `json:"amount"`
-		Status        string    `json:"status"`
-		PaymentMethod string    `json:"payment_method"`
-		CreatedAt     time.Time `json:"created_at"`
+		ID             int            `json:"id"`
+		UserID         int            `json:"user_id"`
+		UserName       string         `json:"user_name"`
+		Amount         entity.Kopecks `json:"amount"`
+		RefundedAmount entity.Kopecks `json:"refunded_amount"`
+		Status         string         `json:"status"`
+		PaymentMethod  string         `json:"payment_method"`
+		ParentID       *int           `json:"parent_id,omitempty"`
+		ChargeID       *int64         `json:"charge_id,omitempty"`
+		Reason         string         `json:"reason,omitempty"`
+		AdminID        *int64         `json:"admin_id,omitempty"`
+		ReceiptID      *int64         `json:"receipt_id,omitempty"`
+		ReceiptStatus  string         `json:"receipt_status,omitempty"`
+		ReceiptURL     string         `json:"receipt_url,omitempty"`
+		CreatedAt      time.Time      `json:"created_at"`
 	}
 
 	var response []PaymentResponse
 	for _, payment := range payments {
 		// Здесь можно получить имя пользователя, если нужно
-		response = append(response, PaymentResponse{
-			ID:            payment.ID,
-			UserID:        payment.UserID,
-			UserName:      fmt.Sprintf("Пользователь #%d", payment.UserID), // Можно добавить реальное имя
-			Amount:        payment.Amount,
-			Status:        payment.Status,
-			PaymentMethod: payment.PaymentMethod,
-			CreatedAt:     payment.CreatedAt,
-		})
+		item := PaymentResponse{
+			ID:             payment.ID,
+			UserID:         payment.UserID,
+			UserName:       fmt.Sprintf("Пользователь #%d", payment.UserID), // Можно добавить реальное имя
+			Amount:         payment.Amount,
+			RefundedAmount: payment.RefundedAmount,
+			Status:         payment.Status,
+			PaymentMethod:  payment.PaymentMethod,
+			ParentID:       payment.ParentID,
+			ChargeID:       payment.ChargeID,
+			Reason:         payment.Reason,
+			AdminID:        payment.AdminID,
+			CreatedAt:      payment.CreatedAt,
+		}
+		if payment.Receipt != nil {
+			item.ReceiptID = &payment.Receipt.ID
+			item.ReceiptStatus = payment.Receipt.Status
+			item.ReceiptURL = payment.Receipt.URL
+		}
+		response = append(response, item)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -101,3 +223,122 @@ func (h *PaymentHandler) GetPayments(c *gin.Context) {
 		"limit":    limit,
 	})
 }
+
+// operationReason - причина ручной операции обязательна, она попадает в историю платежей и журнал
+type operationReason struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// RefundPayment - полный или частичный возврат пополнения. Без amount возвращается весь остаток.
+func (h *PaymentHandler) RefundPayment(c *gin.Context) {
+	paymentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный номер платежа"})
+		return
+	}
+
+	var request struct {
+		operationReason
+		Amount float64 `json:"amount" binding:"omitempty,gt=0"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || strings.TrimSpace(request.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите причину и сумму возврата больше нуля"})
+		return
+	}
+
+	adminID := c.GetInt64("admin_id")
+	refund, payment, err := h.payments.RefundPayment(c.Request.Context(), adminID, paymentID,
+		entity.ToKopecks(request.Amount), strings.TrimSpace(request.Reason))
+	if h.operationFailed(c, err) {
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditPaymentRefund, entity.AuditEntityPayment, strconv.Itoa(payment.ID),
+		nil, gin.H{"status": payment.Status, "refunded_amount": payment.RefundedAmount, "refund_id": refund.ID,
+			"amount": -refund.Amount, "reason": refund.Reason, "external_id": refund.ExternalID})
+
+	c.JSON(http.StatusOK, gin.H{"refund": refund, "payment": payment})
+}
+
+// ReverseCharge возвращает клиенту списание за тариф
+func (h *PaymentHandler) ReverseCharge(c *gin.Context) {
+	chargeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный номер списания"})
+		return
+	}
+
+	var request operationReason
+	if err := c.ShouldBindJSON(&request); err != nil || strings.TrimSpace(request.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите причину возврата"})
+		return
+	}
+
+	reversal, charge, err := h.payments.ReverseCharge(c.GetInt64("admin_id"), chargeID, strings.TrimSpace(request.Reason))
+	if h.operationFailed(c, err) {
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditChargeReverse, entity.AuditEntityCharge, strconv.FormatInt(charge.ID, 10),
+		nil, gin.H{"payment_id": reversal.ID, "user_id": reversal.UserID, "amount": reversal.Amount, "reason": reversal.Reason})
+
+	c.JSON(http.StatusOK, gin.H{"payment": reversal, "charge": charge})
+}
+
+// AdjustBalance - ручное зачисление (amount > 0) или списание (amount < 0)
+func (h *PaymentHandler) AdjustBalance(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+		return
+	}
+
+	var request struct {
+		operationReason
+		Amount float64 `json:"amount" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || strings.TrimSpace(request.Reason) == "" || entity.ToKopecks(request.Amount) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите причину и ненулевую сумму"})
+		return
+	}
+
+	adjustment, err := h.payments.AdjustBalance(c.GetInt64("admin_id"), userID, entity.ToKopecks(request.Amount), strings.TrimSpace(request.Reason))
+	if h.operationFailed(c, err) {
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditBalanceAdjust, entity.AuditEntityUser, strconv.Itoa(userID),
+		nil, gin.H{"payment_id": adjustment.ID, "amount": adjustment.Amount, "reason": adjustment.Reason})
+
+	c.JSON(http.StatusOK, gin.H{"payment": adjustment})
+}
+
+// operationFailed отвечает на ошибку ручной операции и возвращает true, если ответ уже отправлен
+func (h *PaymentHandler) operationFailed(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, service.ErrPaymentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Платеж не найден"})
+	case errors.Is(err, service.ErrChargeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Списание не найдено"})
+	case errors.Is(err, service.ErrCustomerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+	case errors.Is(err, service.ErrNotRefundable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Платеж не оплачен или уже возвращен полностью"})
+	case errors.Is(err, service.ErrChargeReversed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Списание уже возвращено"})
+	case errors.Is(err, service.ErrRefundTooLarge):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Сумма больше, чем можно вернуть по платежу"})
+	case errors.Is(err, service.ErrInsufficientFunds):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "На балансе клиента недостаточно средств"})
+	case errors.Is(err, service.ErrRefundPending):
+		c.JSON(http.StatusAccepted, gin.H{"message": "Платежный сервис не подтвердил возврат, он будет повторен автоматически"})
+	case errors.Is(err, service.ErrProviderUnavailable), errors.Is(err, service.ErrUnknownProvider):
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Платежный сервис не выполнил возврат, попробуйте позже"})
+	default:
+		log.Printf("Payment operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+	}
+	return true
+}
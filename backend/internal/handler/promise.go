@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PromiseHandler struct {
+	promises *service.PromiseService
+	audit    *service.AuditService
+}
+
+func NewPromiseHandler(promises *service.PromiseService, audit *service.AuditService) *PromiseHandler {
+	return &PromiseHandler{
+		promises: promises,
+		audit:    audit,
+	}
+}
+
+// GetPromise - можно ли взять обещанный платеж, на какую сумму, и прошлые платежи клиента
+func (h *PromiseHandler) GetPromise(c *gin.Context) {
+	userID := currentUserID(c)
+
+	offer, reason, err := h.promises.Offer(userID)
+	if err != nil {
+		log.Printf("Ошибка получения условий обещанного платежа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+	if reason != nil {
+		offer.Reason = promiseMessage(reason)
+	}
+
+	history, err := h.promises.History(userID)
+	if err != nil {
+		log.Printf("Ошибка получения обещанных платежей: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"offer":   offer,
+		"history": history,
+	})
+}
+
+// TakePromise выдает обещанный платеж. Без суммы в запросе берется весь лимит.
+func (h *PromiseHandler) TakePromise(c *gin.Context) {
+	var req entity.PromiseRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	userID := currentUserID(c)
+
+	promise, err := h.promises.Take(userID, entity.ToKopecks(req.Amount))
+	switch {
+	case service.IsPromiseError(err):
+		c.JSON(promiseStatus(err), gin.H{"error": promiseMessage(err)})
+		return
+	case err != nil:
+		log.Printf("Ошибка выдачи обещанного платежа: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditPromiseTake, entity.AuditEntityUser, strconv.Itoa(userID), nil, promise)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Обещанный платеж зачислен на баланс",
+		"promise": promise,
+	})
+}
+
+// ListOutstanding - непогашенные обещанные платежи всех клиентов
+func (h *PromiseHandler) ListOutstanding(c *gin.Context) {
+	promises, err := h.promises.ListOutstanding()
+	if err != nil {
+		log.Printf("Error listing promised payments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get promised payments"})
+		return
+	}
+
+	var total entity.Kopecks
+	overdue := 0
+	for _, promise := range promises {
+		total += promise.Outstanding
+		if promise.Overdue {
+			overdue++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"promises":    promises,
+		"outstanding": total,
+		"overdue":     overdue,
+	})
+}
+
+func (h *PromiseHandler) GetSettings(c *gin.Context) {
+	settings, err := h.promises.Settings()
+	if err != nil {
+		log.Printf("Error reading promised payment settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"settings": settings})
+}
+
+func (h *PromiseHandler) UpdateSettings(c *gin.Context) {
+	var req entity.PromiseSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := h.promises.Settings()
+	if err != nil {
+		log.Printf("Error reading promised payment settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := h.promises.UpdateSettings(&req, c.GetInt64("admin_id")); err != nil {
+		log.Printf("Error updating promised payment settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditSettingUpdate, entity.AuditEntitySetting, "promised_payment", before, req)
+
+	c.JSON(http.StatusOK, gin.H{"settings": req})
+}
+
+func promiseStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrPromiseOutstanding), errors.Is(err, service.ErrPromiseUsed):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrPromiseDisabled), errors.Is(err, service.ErrPromiseNoSubscription):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// promiseMessage - объяснение для клиента, почему обещанный платеж недоступен
+func promiseMessage(err error) string {
+	switch {
+	case errors.Is(err, service.ErrPromiseDisabled):
+		return "Обещанный платеж сейчас недоступен"
+	case errors.Is(err, service.ErrPromiseNoSubscription):
+		return "Обещанный платеж доступен только с подключенным тарифом"
+	case errors.Is(err, service.ErrPromiseOutstanding):
+		return "Сначала погасите предыдущий обещанный платеж"
+	case errors.Is(err, service.ErrPromiseUsed):
+		return "Обещанный платеж можно взять только раз за период тарифа"
+	case errors.Is(err, service.ErrPromiseTooLarge):
+		return "Сумма больше доступного лимита"
+	default:
+		return "Обещанный платеж недоступен"
+	}
+}
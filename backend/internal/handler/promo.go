@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PromoHandler struct {
+	promos *service.PromoService
+	audit  *service.AuditService
+}
+
+func NewPromoHandler(promos *service.PromoService, audit *service.AuditService) *PromoHandler {
+	return &PromoHandler{
+		promos: promos,
+		audit:  audit,
+	}
+}
+
+// ListPromoCodes - промокоды с числом применений и суммой выданных скидок
+func (h *PromoHandler) ListPromoCodes(c *gin.Context) {
+	promos, err := h.promos.List()
+	if err != nil {
+		h.promoError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"promo_codes": promos})
+}
+
+func (h *PromoHandler) CreatePromoCode(c *gin.Context) {
+	var req entity.CreatePromoCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promo, err := h.promos.Create(&req)
+	if err != nil {
+		h.promoError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditPromoCreate, entity.AuditEntityPromo, strconv.FormatInt(promo.ID, 10), nil, promo)
+
+	c.JSON(http.StatusCreated, gin.H{"promo_code": promo})
+}
+
+func (h *PromoHandler) UpdatePromoCode(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	var req entity.UpdatePromoCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := h.promos.Get(id)
+	if err != nil {
+		h.promoError(c, err)
+		return
+	}
+
+	promo, err := h.promos.Update(id, &req, time.Now())
+	if err != nil {
+		h.promoError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditPromoUpdate, entity.AuditEntityPromo, strconv.FormatInt(id, 10), before, promo)
+
+	c.JSON(http.StatusOK, gin.H{"promo_code": promo})
+}
+
+// PromoRedemptions - отчет по применениям кода клиентами
+func (h *PromoHandler) PromoRedemptions(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	redemptions, err := h.promos.Redemptions(id)
+	if err != nil {
+		h.promoError(c, err)
+		return
+	}
+
+	var total entity.Kopecks
+	for _, redemption := range redemptions {
+		total += redemption.TotalDiscount
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"redemptions":    redemptions,
+		"count":          len(redemptions),
+		"total_discount": total,
+	})
+}
+
+func (h *PromoHandler) promoError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrPromoNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrPromoExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidPromo):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Promo code operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+// promoStatus - код ответа клиенту на промокод, который нельзя применить
+func promoStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrPromoAlreadyRedeemed), errors.Is(err, service.ErrPromoActive),
+		errors.Is(err, service.ErrPromoActivationOnly):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// promoMessage - объяснение для клиента, почему промокод не применился
+func promoMessage(err error) string {
+	switch {
+	case errors.Is(err, service.ErrPromoNotFound):
+		return "Промокод не найден"
+	case errors.Is(err, service.ErrPromoUnavailable):
+		return "Срок действия промокода истек или он отключен"
+	case errors.Is(err, service.ErrPromoLimitReached):
+		return "Промокод больше не действует: исчерпан лимит применений"
+	case errors.Is(err, service.ErrPromoNotEligible):
+		return "Промокод не действует на выбранный тариф"
+	case errors.Is(err, service.ErrPromoAlreadyRedeemed):
+		return "Вы уже использовали этот промокод"
+	case errors.Is(err, service.ErrPromoActive):
+		return "У вас уже действует другой промокод"
+	case errors.Is(err, service.ErrPromoActivationOnly):
+		return "Промокод применяется только при подключении тарифа, а не при смене"
+	default:
+		return "Промокод нельзя применить"
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReceiptHandler struct {
+	receipts *service.ReceiptService
+	audit    *service.AuditService
+}
+
+func NewReceiptHandler(receipts *service.ReceiptService, audit *service.AuditService) *ReceiptHandler {
+	return &ReceiptHandler{
+		receipts: receipts,
+		audit:    audit,
+	}
+}
+
+// GetReceipt - чек клиента с фискальными реквизитами
+func (h *ReceiptHandler) GetReceipt(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный номер чека"})
+		return
+	}
+
+	receipt, err := h.receipts.Get(currentUserID(c), id)
+	if errors.Is(err, service.ErrReceiptNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Чек не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения чека: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"receipt": receipt})
+}
+
+// ListReceipts - чеки всех клиентов, ?status=failed показывает те, что не удалось отправить
+func (h *ReceiptHandler) ListReceipts(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	receipts, total, err := h.receipts.List(c.Query("status"), page, limit)
+	if err != nil {
+		log.Printf("Error listing receipts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get receipts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"receipts": receipts,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// RetryReceipt возвращает в очередь чек, по которому исчерпаны попытки отправки
+func (h *ReceiptHandler) RetryReceipt(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid receipt ID"})
+		return
+	}
+
+	receipt, err := h.receipts.Retry(id)
+	switch {
+	case errors.Is(err, service.ErrReceiptNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrReceiptNotFailed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Error retrying receipt %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditReceiptRetry, entity.AuditEntityReceipt, strconv.FormatInt(id, 10), nil,
+		gin.H{"payment_id": receipt.PaymentID, "status": receipt.Status})
+
+	c.JSON(http.StatusOK, gin.H{"receipt": receipt})
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReferralHandler struct {
+	referrals *service.ReferralService
+	users     *repository.UserRepository
+	audit     *service.AuditService
+}
+
+func NewReferralHandler(referrals *service.ReferralService, users *repository.UserRepository, audit *service.AuditService) *ReferralHandler {
+	return &ReferralHandler{
+		referrals: referrals,
+		users:     users,
+		audit:     audit,
+	}
+}
+
+// GetReferral - реферальный код клиента, число приглашенных и полученные бонусы
+func (h *ReferralHandler) GetReferral(c *gin.Context) {
+	user, err := h.users.GetUserByID(int64(currentUserID(c)))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	stats, err := h.referrals.Stats(user)
+	if err != nil {
+		log.Printf("Ошибка получения реферальной статистики: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"referral": stats})
+}
+
+// ListReferrals - приглашения с отклоненными дублями и итогами выплат
+func (h *ReferralHandler) ListReferrals(c *gin.Context) {
+	status := c.Query("status")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	referrals, total, err := h.referrals.Report(status, page, limit)
+	if err != nil {
+		log.Printf("Error listing referrals: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	summary, err := h.referrals.Summary()
+	if err != nil {
+		log.Printf("Error summarizing referrals: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"referrals": referrals,
+		"total":     total,
+		"page":      page,
+		"limit":     limit,
+		"summary":   summary,
+	})
+}
+
+func (h *ReferralHandler) GetSettings(c *gin.Context) {
+	settings, err := h.referrals.Settings()
+	if err != nil {
+		log.Printf("Error reading referral settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"settings": settings})
+}
+
+func (h *ReferralHandler) UpdateSettings(c *gin.Context) {
+	var req entity.ReferralSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := h.referrals.Settings()
+	if err != nil {
+		log.Printf("Error reading referral settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := h.referrals.UpdateSettings(&req, c.GetInt64("admin_id")); err != nil {
+		log.Printf("Error updating referral settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditSettingUpdate, entity.AuditEntitySetting, "referral", before, req)
+
+	c.JSON(http.StatusOK, gin.H{"settings": req})
+}
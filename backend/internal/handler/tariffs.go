@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TariffHandler struct {
+	tariffService *service.TariffService
+	audit         *service.AuditService
+}
+
+func NewTariffHandler(tariffService *service.TariffService, audit *service.AuditService) *TariffHandler {
+	return &TariffHandler{
+		tariffService: tariffService,
+		audit:         audit,
+	}
+}
+
+// ListTariffs - публичный каталог для сайта, формы заявки и личного кабинета
+func (h *TariffHandler) ListTariffs(c *gin.Context) {
+	tariffs, err := h.tariffService.ListPublic()
+	if err != nil {
+		log.Printf("Ошибка получения тарифов: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения тарифов"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tariffs": tariffs})
+}
+
+// GetTariff отдает и архивный тариф: клиент на нем остается и должен видеть его условия
+func (h *TariffHandler) GetTariff(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID тарифа"})
+		return
+	}
+
+	tariff, err := h.tariffService.Get(id)
+	if errors.Is(err, service.ErrTariffNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тариф не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения тарифа %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения тарифа"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tariff": tariff})
+}
+
+func (h *TariffHandler) AdminListTariffs(c *gin.Context) {
+	includeArchived := c.Query("archived") == "true"
+
+	tariffs, err := h.tariffService.ListAll(includeArchived)
+	if err != nil {
+		log.Printf("Error listing tariffs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tariffs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tariffs": tariffs})
+}
+
+func (h *TariffHandler) CreateTariff(c *gin.Context) {
+	var req entity.CreateTariffRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tariff, err := h.tariffService.Create(&req)
+	if err != nil {
+		h.tariffError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditTariffCreate, entity.AuditEntityTariff, strconv.FormatInt(tariff.ID, 10), nil, tariff)
+
+	c.JSON(http.StatusCreated, gin.H{"tariff": tariff})
+}
+
+func (h *TariffHandler) UpdateTariff(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tariff ID"})
+		return
+	}
+
+	var req entity.UpdateTariffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := h.tariffService.Get(id)
+	if err != nil {
+		h.tariffError(c, err)
+		return
+	}
+
+	tariff, err := h.tariffService.Update(id, &req)
+	if err != nil {
+		h.tariffError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditTariffUpdate, entity.AuditEntityTariff, strconv.FormatInt(id, 10), before, tariff)
+
+	c.JSON(http.StatusOK, gin.H{"tariff": tariff})
+}
+
+func (h *TariffHandler) ArchiveTariff(c *gin.Context) {
+	h.setArchived(c, true)
+}
+
+func (h *TariffHandler) RestoreTariff(c *gin.Context) {
+	h.setArchived(c, false)
+}
+
+func (h *TariffHandler) setArchived(c *gin.Context, archived bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tariff ID"})
+		return
+	}
+
+	tariff, err := h.tariffService.SetArchived(id, archived)
+	if err != nil {
+		h.tariffError(c, err)
+		return
+	}
+
+	action := entity.AuditTariffRestore
+	if archived {
+		action = entity.AuditTariffArchive
+	}
+	recordAudit(h.audit, auditMeta(c), action, entity.AuditEntityTariff, strconv.FormatInt(id, 10), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"tariff": tariff})
+}
+
+func (h *TariffHandler) ReorderTariffs(c *gin.Context) {
+	var req entity.ReorderTariffsRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tariffs, err := h.tariffService.Reorder(req.IDs)
+	if err != nil {
+		h.tariffError(c, err)
+		return
+	}
+
+	recordAudit(h.audit, auditMeta(c), entity.AuditTariffReorder, entity.AuditEntityTariff, "", nil, gin.H{"ids": req.IDs})
+
+	c.JSON(http.StatusOK, gin.H{"tariffs": tariffs})
+}
+
+func (h *TariffHandler) tariffError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrTariffNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrDuplicateTariffs):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Tariff operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
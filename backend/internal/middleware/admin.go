@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"internet_provider/internal/entity"
 	"internet_provider/internal/service"
 	"log"
 	"net/http"
@@ -12,8 +13,6 @@ import (
 func AdminAuthMiddleware(adminService *service.AdminService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
-		log.Printf("Auth Header: %s", authHeader) // Добавим логирование
-		log.Printf("Request URL: %s %s", c.Request.Method, c.Request.URL.String())
 
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
@@ -24,14 +23,13 @@ func AdminAuthMiddleware(adminService *service.AdminService) gin.HandlerFunc {
 		// Правильно извлекаем токен
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			log.Printf("Invalid auth format. Parts: %v", parts)
+			log.Printf("Invalid auth format: %s %s", c.Request.Method, c.Request.URL.Path)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bearer token required"})
 			c.Abort()
 			return
 		}
 
 		token := parts[1]
-		log.Printf("Token extracted: %s...", token[:min(len(token), 10)]) // Логируем начало токена
 
 		// Проверяем токен
 		claims, err := adminService.VerifyJWT(token)
@@ -42,20 +40,82 @@ func AdminAuthMiddleware(adminService *service.AdminService) gin.HandlerFunc {
 			return
 		}
 
+		// Токен может быть еще не просрочен, но сессия уже отозвана или админ отключен
+		admin, sessionID, err := adminService.ValidateSession(claims)
+		if err != nil {
+			log.Printf("Admin session rejected: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is no longer valid"})
+			c.Abort()
+			return
+		}
+
 		log.Printf("Token valid for admin: %s (ID: %v)", claims["username"], claims["admin_id"])
 
 		// Сохраняем данные администратора в контекст
-		c.Set("admin_id", claims["admin_id"])
-		c.Set("admin_username", claims["username"])
-		c.Set("admin_role", claims["role"])
+		c.Set("admin_id", admin.ID)
+		c.Set("admin_session_id", sessionID)
+		c.Set("admin_username", admin.Username)
+		c.Set("admin_role", admin.Role)
+		c.Set("admin_must_change_password", admin.MustChangePassword)
+
+		setupRequired, err := adminService.TwoFactorSetupRequired(admin)
+		if err != nil {
+			log.Printf("Failed to check 2FA policy: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			c.Abort()
+			return
+		}
+		c.Set("admin_2fa_setup_required", setupRequired)
+
+		c.Next()
+	}
+}
+
+// RequirePasswordChanged не пускает администратора с временным паролем никуда,
+// кроме смены пароля и выхода.
+func RequirePasswordChanged() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("admin_must_change_password") {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                "Password change required",
+				"must_change_password": true,
+			})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+// RequireTwoFactor не пускает дальше администратора без 2FA, если суперадмин сделал ее обязательной
+func RequireTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("admin_2fa_setup_required") {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                     "Two-factor authentication setup required",
+				"two_factor_setup_required": true,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequirePermission пропускает запрос, только если у роли администратора есть нужное право.
+// Должен стоять после AdminAuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("admin_role")
+		if !entity.HasPermission(role, permission) {
+			log.Printf("Access denied: role=%q permission=%q %s %s", role, permission, c.Request.Method, c.Request.URL.Path)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
 	}
-	return b
 }
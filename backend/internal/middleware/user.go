@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"internet_provider/internal/service"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func UserAuthMiddleware(authService *service.UserAuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+			c.Abort()
+			return
+		}
+
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется Bearer токен"})
+			c.Abort()
+			return
+		}
+
+		userID, sessionID, err := authService.VerifyAccessToken(parts[1])
+		if err != nil {
+			log.Printf("User token verification failed: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный токен"})
+			c.Abort()
+			return
+		}
+
+		// Дальше обработчики работают только с этим ID, а не с ID из запроса
+		c.Set("user_id", userID)
+		c.Set("user_session_id", sessionID)
+
+		c.Next()
+	}
+}
@@ -0,0 +1,471 @@
+// Package mockpay - учебный эквайринг для разработки. Принимает платежи по API, показывает
+// покупателю страницу подтверждения и сообщает магазину результат подписанным webhook,
+// как это делают настоящие платежные шлюзы.
+package mockpay
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignatureHeader - HMAC-SHA256 тела webhook в hex, ключ - секрет магазина
+const SignatureHeader = "X-Mockpay-Signature"
+
+// IdempotencyHeader - ключ возврата. Повтор запроса с тем же ключом не возвращает деньги
+// второй раз, а отдает уже созданный возврат.
+const IdempotencyHeader = "Idempotency-Key"
+
+// Статусы платежа в шлюзе
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+)
+
+// CreateRequest - запрос магазина на создание платежа. Сумма в копейках.
+// SavePaymentMethod просит сохранить карту после оплаты, а платеж с PaymentMethodID
+// списывается с сохраненной карты сразу, без страницы оплаты.
+type CreateRequest struct {
+	Amount            int64  `json:"amount"`
+	Description       string `json:"description"`
+	OrderID           string `json:"order_id"`
+	ReturnURL         string `json:"return_url"`
+	WebhookURL        string `json:"webhook_url"`
+	SavePaymentMethod bool   `json:"save_payment_method,omitempty"`
+	PaymentMethodID   string `json:"payment_method_id,omitempty"`
+}
+
+// PaymentMethod - сохраненная карта. По ID магазин списывает деньги без участия покупателя.
+type PaymentMethod struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Saved bool   `json:"saved"`
+
+	// declines - карта, по которой списания без покупателя не проходят, для проверки отказов
+	declines bool
+}
+
+type Payment struct {
+	ID              string         `json:"id"`
+	Status          string         `json:"status"`
+	Amount          int64          `json:"amount"`
+	Description     string         `json:"description"`
+	OrderID         string         `json:"order_id"`
+	ConfirmationURL string         `json:"confirmation_url"`
+	Refunded        int64          `json:"refunded"`
+	PaymentMethod   *PaymentMethod `json:"payment_method,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+
+	returnURL  string
+	webhookURL string
+	saveMethod bool
+}
+
+// RefundRequest - возврат части или всей суммы оплаченного платежа. Сумма в копейках.
+type RefundRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+type Refund struct {
+	ID        string    `json:"id"`
+	PaymentID string    `json:"payment_id"`
+	Amount    int64     `json:"amount"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Event - тело webhook
+type Event struct {
+	Event   string  `json:"event"`
+	Payment Payment `json:"payment"`
+}
+
+// Sign - подпись тела запроса секретом магазина
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify сравнивает подпись за постоянное время
+func Verify(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Server - шлюз в памяти. После перезапуска платежи теряются, для разработки этого достаточно.
+type Server struct {
+	secret    string
+	publicURL string
+	client    *http.Client
+
+	mu       sync.Mutex
+	payments map[string]*Payment
+	methods  map[string]*PaymentMethod
+	refunds  map[string]*Refund
+}
+
+// NewServer - publicURL нужен, чтобы строить ссылки на страницу оплаты для покупателя
+func NewServer(secret, publicURL string) *Server {
+	return &Server{
+		secret:    secret,
+		publicURL: strings.TrimRight(publicURL, "/"),
+		client:    &http.Client{Timeout: 10 * time.Second},
+		payments:  make(map[string]*Payment),
+		methods:   make(map[string]*PaymentMethod),
+		refunds:   make(map[string]*Refund),
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/payments", s.create)
+	mux.HandleFunc("GET /api/payments/{id}", s.get)
+	mux.HandleFunc("POST /api/payments/{id}/refunds", s.refund)
+	mux.HandleFunc("GET /checkout/{id}", s.checkout)
+	mux.HandleFunc("POST /checkout/{id}", s.complete)
+	return mux
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return hmac.Equal([]byte(token), []byte(s.secret))
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Amount <= 0 || req.WebhookURL == "" {
+		http.Error(w, "invalid payment request", http.StatusBadRequest)
+		return
+	}
+
+	if req.PaymentMethodID != "" {
+		s.charge(w, &req)
+		return
+	}
+
+	id := newID()
+	payment := &Payment{
+		ID:              id,
+		Status:          StatusPending,
+		Amount:          req.Amount,
+		Description:     req.Description,
+		OrderID:         req.OrderID,
+		ConfirmationURL: s.publicURL + "/checkout/" + id,
+		CreatedAt:       time.Now(),
+		returnURL:       req.ReturnURL,
+		webhookURL:      req.WebhookURL,
+		saveMethod:      req.SavePaymentMethod,
+	}
+
+	s.mu.Lock()
+	s.payments[id] = payment
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, payment)
+}
+
+// charge списывает деньги с сохраненной карты. Итог известен сразу и приходит в ответе,
+// а webhook все равно отправляется, как у настоящих шлюзов.
+func (s *Server) charge(w http.ResponseWriter, req *CreateRequest) {
+	s.mu.Lock()
+	method, ok := s.methods[req.PaymentMethodID]
+	if !ok {
+		s.mu.Unlock()
+		http.Error(w, "unknown payment method", http.StatusUnprocessableEntity)
+		return
+	}
+
+	status := StatusSucceeded
+	if method.declines {
+		status = StatusFailed
+	}
+	payment := &Payment{
+		ID:            newID(),
+		Status:        status,
+		Amount:        req.Amount,
+		Description:   req.Description,
+		OrderID:       req.OrderID,
+		PaymentMethod: method,
+		CreatedAt:     time.Now(),
+		webhookURL:    req.WebhookURL,
+	}
+	s.payments[payment.ID] = payment
+	snapshot := *payment
+	s.mu.Unlock()
+
+	go func() {
+		if err := s.notify(&snapshot); err != nil {
+			log.Printf("mockpay: webhook for payment %s failed, will retry: %v", snapshot.ID, err)
+			s.retryNotify(&snapshot)
+		}
+	}()
+
+	writeJSON(w, http.StatusCreated, &snapshot)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	payment, ok := s.find(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, payment)
+}
+
+// refund возвращает деньги сразу: в учебном шлюзе возврат не бывает отложенным
+func (s *Server) refund(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Amount <= 0 {
+		http.Error(w, "invalid refund request", http.StatusBadRequest)
+		return
+	}
+
+	key := r.Header.Get(IdempotencyHeader)
+
+	s.mu.Lock()
+	if done, ok := s.refunds[key]; ok && key != "" {
+		s.mu.Unlock()
+		writeJSON(w, http.StatusCreated, done)
+		return
+	}
+
+	payment, ok := s.payments[r.PathValue("id")]
+	var status int
+	var refund Refund
+	switch {
+	case !ok:
+		status = http.StatusNotFound
+	case payment.Status != StatusSucceeded:
+		status = http.StatusConflict
+	case payment.Refunded+req.Amount > payment.Amount:
+		status = http.StatusUnprocessableEntity
+	default:
+		payment.Refunded += req.Amount
+		refund = Refund{
+			ID:        "rf_" + strings.TrimPrefix(newID(), "mp_"),
+			PaymentID: payment.ID,
+			Amount:    req.Amount,
+			Status:    StatusSucceeded,
+			CreatedAt: time.Now(),
+		}
+		if key != "" {
+			s.refunds[key] = &refund
+		}
+	}
+	s.mu.Unlock()
+
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, &refund)
+}
+
+var checkoutPage = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Оплата</title></head>
+<body style="font-family: sans-serif; max-width: 420px; margin: 60px auto;">
+<h2>Тестовая оплата</h2>
+<p>{{.Description}}</p>
+<p><b>{{.Rubles}} ₽</b></p>
+{{if eq .Status "pending"}}
+{{if .SaveMethod}}<p>Карта будет сохранена для автоплатежей</p>{{end}}
+<form method="post">
+	<button name="result" value="succeeded">Оплатить</button>
+	{{if .SaveMethod}}<button name="result" value="succeeded_declines">Оплатить картой, которая потом откажет</button>{{end}}
+	<button name="result" value="failed">Отказ банка</button>
+	<button name="result" value="canceled">Отменить</button>
+</form>
+{{else}}
+<p>Платеж уже обработан: {{.Status}}</p>
+{{end}}
+</body>
+</html>`))
+
+func (s *Server) checkout(w http.ResponseWriter, r *http.Request) {
+	payment, ok := s.find(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	checkoutPage.Execute(w, struct {
+		Description string
+		Rubles      string
+		Status      string
+		SaveMethod  bool
+	}{payment.Description, fmt.Sprintf("%d.%02d", payment.Amount/100, payment.Amount%100), payment.Status, payment.saveMethod})
+}
+
+// complete - покупатель нажал кнопку на странице оплаты
+func (s *Server) complete(w http.ResponseWriter, r *http.Request) {
+	result := r.FormValue("result")
+	declines := result == "succeeded_declines"
+	if declines {
+		result = StatusSucceeded
+	}
+	if result != StatusSucceeded && result != StatusFailed && result != StatusCanceled {
+		http.Error(w, "unknown result", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	payment, ok := s.payments[r.PathValue("id")]
+	if ok && payment.Status == StatusPending {
+		payment.Status = result
+		if result == StatusSucceeded && payment.saveMethod {
+			payment.PaymentMethod = s.saveMethod(declines)
+		}
+	}
+	var snapshot Payment
+	if ok {
+		snapshot = *payment
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := s.notify(&snapshot); err != nil {
+		log.Printf("mockpay: webhook for payment %s failed, will retry: %v", snapshot.ID, err)
+		go s.retryNotify(&snapshot)
+	}
+
+	if snapshot.returnURL == "" {
+		fmt.Fprintf(w, "Платеж %s: %s", snapshot.ID, snapshot.Status)
+		return
+	}
+	http.Redirect(w, r, withQuery(snapshot.returnURL, "payment_status", snapshot.Status), http.StatusSeeOther)
+}
+
+// notify отправляет магазину подписанный webhook с итогом платежа
+func (s *Server) notify(payment *Payment) error {
+	body, err := json.Marshal(Event{Event: "payment." + payment.Status, Payment: *payment})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, payment.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(s.secret, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// retryNotify повторяет webhook с растущей паузой, как настоящие шлюзы, пока магазин не ответит 2xx
+func (s *Server) retryNotify(payment *Payment) {
+	delay := time.Second
+	for attempt := 0; attempt < 6; attempt++ {
+		time.Sleep(delay)
+		delay *= 2
+
+		err := s.notify(payment)
+		if err == nil {
+			return
+		}
+		log.Printf("mockpay: webhook for payment %s failed: %v", payment.ID, err)
+	}
+}
+
+// saveMethod запоминает карту покупателя. Вызывается под s.mu.
+func (s *Server) saveMethod(declines bool) *PaymentMethod {
+	title := "Тестовая карта •••• 4242"
+	if declines {
+		title = "Тестовая карта •••• 0002"
+	}
+
+	method := &PaymentMethod{
+		ID:       "pm_" + strings.TrimPrefix(newID(), "mp_"),
+		Title:    title,
+		Saved:    true,
+		declines: declines,
+	}
+	s.methods[method.ID] = method
+	return method
+}
+
+func (s *Server) find(id string) (*Payment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payment, ok := s.payments[id]
+	if !ok {
+		return nil, false
+	}
+	snapshot := *payment
+	return &snapshot, true
+}
+
+func newID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "mp_" + hex.EncodeToString(buf)
+}
+
+func withQuery(rawURL, key, value string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := parsed.Query()
+	query.Set(key, value)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
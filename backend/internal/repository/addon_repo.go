@@ -0,0 +1,392 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"internet_provider/internal/entity"
+	"math"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAddonNotFound       = errors.New("addon not found")
+	ErrAddonUnavailable    = errors.New("addon is archived or hidden")
+	ErrAddonAttached       = errors.New("addon is already attached")
+	ErrAddonNotAttached    = errors.New("addon is not attached")
+	ErrAddonNoSubscription = errors.New("no paid subscription to attach the addon to")
+)
+
+type AddonRepository struct {
+	db *gorm.DB
+}
+
+func NewAddonRepository(db *gorm.DB) *AddonRepository {
+	return &AddonRepository{db: db}
+}
+
+// ListPublic - услуги, которые клиент может подключить
+func (r *AddonRepository) ListPublic() ([]entity.Addon, error) {
+	var addons []entity.Addon
+	err := r.db.Where("is_visible = ? AND archived_at IS NULL", true).Order("id ASC").Find(&addons).Error
+
+	return addons, err
+}
+
+func (r *AddonRepository) ListAll(includeArchived bool) ([]entity.Addon, error) {
+	var addons []entity.Addon
+
+	query := r.db.Model(&entity.Addon{})
+	if !includeArchived {
+		query = query.Where("archived_at IS NULL")
+	}
+
+	err := query.Order("id ASC").Find(&addons).Error
+	return addons, err
+}
+
+func (r *AddonRepository) FindByID(id int64) (*entity.Addon, error) {
+	var addon entity.Addon
+	err := r.db.First(&addon, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAddonNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &addon, nil
+}
+
+func (r *AddonRepository) Create(addon *entity.Addon) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// gorm не пишет false в поле с default:true, скрытую услугу сохраняем отдельным запросом
+		visible := addon.IsVisible
+		if err := tx.Create(addon).Error; err != nil {
+			return err
+		}
+
+		if !visible {
+			return tx.Model(addon).Update("is_visible", false).Error
+		}
+		return nil
+	})
+}
+
+func (r *AddonRepository) Update(addon *entity.Addon) error {
+	return r.db.Save(addon).Error
+}
+
+func (r *AddonRepository) SetArchived(id int64, archived bool) (bool, error) {
+	var archivedAt *time.Time
+	if archived {
+		now := time.Now()
+		archivedAt = &now
+	}
+
+	result := r.db.Model(&entity.Addon{}).Where("id = ?", id).Update("archived_at", archivedAt)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListForUser - подключенные клиенту услуги вместе с условиями из каталога
+func (r *AddonRepository) ListForUser(userID int) ([]entity.UserAddon, error) {
+	var addons []entity.UserAddon
+	err := r.db.Preload("Addon").
+		Where("user_id = ? AND detached_at IS NULL", userID).
+		Order("attached_at ASC").
+		Find(&addons).Error
+
+	return addons, err
+}
+
+// ListCharges - последние списания клиента за услуги, новые первыми
+func (r *AddonRepository) ListCharges(userID int, limit int) ([]entity.AddonCharge, error) {
+	var charges []entity.AddonCharge
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Limit(limit).Find(&charges).Error
+
+	return charges, err
+}
+
+// Attach подключает услугу к оплаченной подписке клиента: списывает разовый платеж и цену
+// услуги за оставшиеся дни текущего периода. Дальше услуга продлевается вместе с тарифом.
+// Блокирует сначала клиента, потом подписку - в том же порядке, что и биллинг.
+func (r *AddonRepository) Attach(userID int, addonID int64, now time.Time) (*entity.AddonAttachment, error) {
+	attachment := &entity.AddonAttachment{}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var user entity.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		var sub entity.Subscription
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&sub).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAddonNoSubscription
+		}
+		if err != nil {
+			return err
+		}
+		if sub.Status != entity.SubscriptionActive || !sub.PaidUntil.After(now) {
+			return ErrAddonNoSubscription
+		}
+
+		var addon entity.Addon
+		err = tx.First(&addon, addonID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAddonNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !addon.Available() {
+			return ErrAddonUnavailable
+		}
+
+		var count int64
+		if err := tx.Model(&entity.UserAddon{}).
+			Where("user_id = ? AND addon_id = ? AND detached_at IS NULL", userID, addonID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAddonAttached
+		}
+
+		var tariff entity.Tariff
+		if err := tx.First(&tariff, sub.TariffID).Error; err != nil {
+			return err
+		}
+
+		attachment.SetupFee = addon.SetupFee
+		attachment.PeriodCharge = prorateAddon(addon.PeriodPrice(&tariff, sub.PeriodStart),
+			sub.PaidUntil.Sub(now), sub.PaidUntil.Sub(sub.PeriodStart))
+		attachment.PaidUntil = sub.PaidUntil
+		attachment.BalanceAfter = user.Balance
+		if user.Balance < attachment.SetupFee+attachment.PeriodCharge {
+			return ErrInsufficientBalance
+		}
+
+		userAddon := entity.UserAddon{
+			UserID:     userID,
+			AddonID:    addon.ID,
+			AttachedAt: now,
+		}
+		if err := tx.Create(&userAddon).Error; err != nil {
+			return err
+		}
+		userAddon.Addon = &addon
+		attachment.UserAddon = &userAddon
+
+		charges := []entity.AddonCharge{
+			{Kind: entity.AddonChargeSetup, Amount: attachment.SetupFee},
+			{Kind: entity.AddonChargePeriod, Amount: attachment.PeriodCharge},
+		}
+		for _, charge := range charges {
+			if charge.Amount == 0 {
+				continue
+			}
+			charge.UserAddonID = userAddon.ID
+			charge.UserID = userID
+			charge.AddonID = addon.ID
+			charge.PeriodStart = now
+			charge.PeriodEnd = sub.PaidUntil
+			charge.CreatedAt = now
+			if attachment.BalanceAfter, err = postAddonCharge(tx, &charge, &addon); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return attachment, err
+	}
+
+	return attachment, nil
+}
+
+// Detach отключает услугу с этого момента. Оплаченный период не возвращается, как и при
+// понижении тарифа, следующий период за услугу уже не списывается.
+func (r *AddonRepository) Detach(userID int, userAddonID int64, now time.Time) (*entity.UserAddon, error) {
+	var userAddon entity.UserAddon
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ? AND detached_at IS NULL", userAddonID, userID).
+			First(&userAddon).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAddonNotAttached
+		}
+		if err != nil {
+			return err
+		}
+
+		userAddon.DetachedAt = &now
+		return tx.Model(&userAddon).Update("detached_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &userAddon, nil
+}
+
+// activeAddons - подключенные клиенту услуги для списания в транзакции биллинга
+func activeAddons(tx *gorm.DB, userID int) ([]entity.UserAddon, error) {
+	var addons []entity.UserAddon
+	err := tx.Preload("Addon").Where("user_id = ? AND detached_at IS NULL", userID).Order("id ASC").Find(&addons).Error
+
+	return addons, err
+}
+
+// addonsPrice - сколько стоят услуги за период тарифа, начавшийся в start
+func addonsPrice(addons []entity.UserAddon, tariff *entity.Tariff, start time.Time) entity.Kopecks {
+	var total entity.Kopecks
+	for _, userAddon := range addons {
+		total += userAddon.Addon.PeriodPrice(tariff, start)
+	}
+	return total
+}
+
+// chargeAddons списывает услуги за период тарифа, оплаченный списанием charge, и возвращает
+// списанную сумму. Уже списанный период пропускается.
+func chargeAddons(tx *gorm.DB, addons []entity.UserAddon, tariff *entity.Tariff, charge *entity.SubscriptionCharge) (entity.Kopecks, error) {
+	var total entity.Kopecks
+	for _, userAddon := range addons {
+		addonCharge := entity.AddonCharge{
+			UserAddonID: userAddon.ID,
+			UserID:      userAddon.UserID,
+			AddonID:     userAddon.AddonID,
+			ChargeID:    &charge.ID,
+			Kind:        entity.AddonChargePeriod,
+			Amount:      userAddon.Addon.PeriodPrice(tariff, charge.PeriodStart),
+			PeriodStart: charge.PeriodStart,
+			PeriodEnd:   charge.PeriodEnd,
+			CreatedAt:   charge.CreatedAt,
+		}
+		if addonCharge.Amount == 0 {
+			continue
+		}
+
+		posted, err := postAddonChargeOnce(tx, &addonCharge, userAddon.Addon)
+		if err != nil {
+			return 0, err
+		}
+		if posted {
+			total += addonCharge.Amount
+		}
+	}
+	return total, nil
+}
+
+// resizeAddons пересчитывает услуги, когда при смене периодичности тарифа оплаченный период
+// заканчивается не в oldEnd, а в newEnd: доплата за продление или возврат за лишние дни.
+// Возвращает сумму к списанию, при возврате она отрицательная.
+func resizeAddons(tx *gorm.DB, addons []entity.UserAddon, tariff *entity.Tariff, oldEnd, newStart, newEnd, at time.Time) (entity.Kopecks, error) {
+	var total entity.Kopecks
+	for _, userAddon := range addons {
+		addonCharge := entity.AddonCharge{
+			UserAddonID: userAddon.ID,
+			UserID:      userAddon.UserID,
+			AddonID:     userAddon.AddonID,
+			CreatedAt:   at,
+		}
+
+		switch {
+		case newEnd.After(oldEnd):
+			addonCharge.Kind = entity.AddonChargePeriod
+			addonCharge.Amount = prorateAddon(userAddon.Addon.PeriodPrice(tariff, newStart), newEnd.Sub(oldEnd), newEnd.Sub(newStart))
+			addonCharge.PeriodStart = oldEnd
+			addonCharge.PeriodEnd = newEnd
+		case newEnd.Before(oldEnd):
+			// Возвращается доля того, что клиент заплатил за услугу в последнем периоде
+			var paid entity.AddonCharge
+			err := tx.Where("user_addon_id = ? AND kind = ? AND period_end = ?", userAddon.ID, entity.AddonChargePeriod, oldEnd).
+				Order("period_start DESC").
+				First(&paid).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			addonCharge.Kind = entity.AddonChargeRefund
+			addonCharge.Amount = -prorateAddon(paid.Amount, oldEnd.Sub(newEnd), oldEnd.Sub(paid.PeriodStart))
+			addonCharge.PeriodStart = newEnd
+			addonCharge.PeriodEnd = oldEnd
+		default:
+			return 0, nil
+		}
+		if addonCharge.Amount == 0 {
+			continue
+		}
+
+		posted, err := postAddonChargeOnce(tx, &addonCharge, userAddon.Addon)
+		if err != nil {
+			return 0, err
+		}
+		if posted {
+			total += addonCharge.Amount
+		}
+	}
+	return total, nil
+}
+
+// postAddonChargeOnce сохраняет списание и проводит его через журнал, если такого еще не было
+func postAddonChargeOnce(tx *gorm.DB, charge *entity.AddonCharge, addon *entity.Addon) (bool, error) {
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(charge)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+
+	_, err := postAddonLedger(tx, charge, addon)
+	return err == nil, err
+}
+
+// postAddonCharge сохраняет списание за услугу и проводит его через журнал. Возвращает новый баланс.
+func postAddonCharge(tx *gorm.DB, charge *entity.AddonCharge, addon *entity.Addon) (entity.Kopecks, error) {
+	if err := tx.Create(charge).Error; err != nil {
+		return 0, err
+	}
+
+	return postAddonLedger(tx, charge, addon)
+}
+
+func postAddonLedger(tx *gorm.DB, charge *entity.AddonCharge, addon *entity.Addon) (entity.Kopecks, error) {
+	posting := entity.LedgerPosting{
+		Type:      entity.LedgerAddonCharge,
+		UserID:    charge.UserID,
+		Amount:    -charge.Amount,
+		Counter:   entity.AccountRevenue,
+		Reference: "addon_charge:" + strconv.FormatInt(charge.ID, 10),
+	}
+
+	switch charge.Kind {
+	case entity.AddonChargeSetup:
+		posting.Description = fmt.Sprintf("Подключение услуги «%s»", addon.Name)
+	case entity.AddonChargeRefund:
+		posting.Type = entity.LedgerRefund
+		posting.Description = fmt.Sprintf("Возврат за услугу «%s» с %s по %s", addon.Name,
+			charge.PeriodStart.Format("02.01.2006"), charge.PeriodEnd.Format("02.01.2006"))
+	default:
+		posting.Description = fmt.Sprintf("Услуга «%s» с %s по %s", addon.Name,
+			charge.PeriodStart.Format("02.01.2006"), charge.PeriodEnd.Format("02.01.2006"))
+	}
+
+	_, balance, err := postLedger(tx, posting, charge.CreatedAt)
+	return balance, err
+}
+
+// prorateAddon - доля part от total цены price, округленная до копейки
+func prorateAddon(price entity.Kopecks, part, total time.Duration) entity.Kopecks {
+	if total <= 0 || part <= 0 {
+		return 0
+	}
+	if part >= total {
+		return price
+	}
+	return entity.Kopecks(math.Round(float64(price) * float64(part) / float64(total)))
+}
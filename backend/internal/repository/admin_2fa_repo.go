@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ClaimTOTPStep запоминает шаг принятого кода. Возвращает false, если код этого шага уже использован.
+func (r *GormAdminRepository) ClaimTOTPStep(adminID, step int64) (bool, error) {
+	result := r.db.Model(&entity.Admin{}).
+		Where("id = ? AND totp_last_step < ?", adminID, step).
+		Update("totp_last_step", step)
+
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *GormAdminRepository) ReplaceRecoveryCodes(adminID int64, hashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("admin_id = ?", adminID).Delete(&entity.AdminRecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]entity.AdminRecoveryCode, 0, len(hashes))
+		for _, hash := range hashes {
+			codes = append(codes, entity.AdminRecoveryCode{AdminID: adminID, CodeHash: hash})
+		}
+
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode гасит код восстановления. Возвращает false, если код не найден или уже использован.
+func (r *GormAdminRepository) UseRecoveryCode(adminID int64, hash string) (bool, error) {
+	result := r.db.Model(&entity.AdminRecoveryCode{}).
+		Where("admin_id = ? AND code_hash = ? AND used_at IS NULL", adminID, hash).
+		Update("used_at", time.Now())
+
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *GormAdminRepository) CountRecoveryCodes(adminID int64) (int64, error) {
+	var count int64
+	err := r.db.Model(&entity.AdminRecoveryCode{}).
+		Where("admin_id = ? AND used_at IS NULL", adminID).
+		Count(&count).Error
+
+	return count, err
+}
+
+func (r *GormAdminRepository) DeleteRecoveryCodes(adminID int64) error {
+	return r.db.Where("admin_id = ?", adminID).Delete(&entity.AdminRecoveryCode{}).Error
+}
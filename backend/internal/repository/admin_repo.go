@@ -1,12 +1,16 @@
 package repository
 
 import (
+	"errors"
 	"internet_provider/internal/entity"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrLastSuperadmin = errors.New("at least one active superadmin is required")
+
 type GormAdminRepository struct {
 	db *gorm.DB
 }
@@ -34,11 +38,16 @@ func (r *GormAdminRepository) DashboardStats() (*entity.DashboardList, error) {
 
 	r.db.Model(&entity.Payment{}).Count(&stats.TotalPayments)
 
-	r.db.Model(&entity.Payment{}).Where("amount > 0").Select("COALESCE(SUM(amount), 0)").Scan(&stats.TotalPayments)
+	// Полученные деньги - оплаченные пополнения, в том числе возвращенные, минус возвраты,
+	// включая еще не подтвержденные шлюзом
+	received := []string{entity.PaymentCompleted, entity.PaymentPartiallyRefunded, entity.PaymentRefunded,
+		entity.PaymentRefund, entity.PaymentRefundPending}
+
+	r.db.Model(&entity.Payment{}).Where("status IN ?", received).Select("COALESCE(SUM(amount), 0)").Scan(&stats.TotalRevenue)
 
 	today := time.Now().Format("2006-01-02")
 
-	r.db.Model(&entity.Payment{}).Where("amount > 0 AND DATE(created_at) = ?", today).Select("COALESCE(SUM(amount), 0)").Scan(&stats.RevenuToday)
+	r.db.Model(&entity.Payment{}).Where("status IN ? AND DATE(created_at) = ?", received, today).Select("COALESCE(SUM(amount), 0)").Scan(&stats.RevenuToday)
 
 	return &stats, nil
 }
@@ -73,3 +82,72 @@ func (r *GormAdminRepository) GetUsers(search string, filter string, page, limit
 	}
 	return users, total, nil
 }
+
+func (r *GormAdminRepository) GetUserByID(userID int64) (*entity.AdminUserList, error) {
+	var user entity.AdminUserList
+
+	err := r.db.Model(&entity.User{}).Select("users.id, users.name, users.email, users.phone, users.account_number, users.balance, users.tariff_id, tariffs.name as tariff_name").
+		Joins("LEFT JOIN tariffs ON users.tariff_id = tariffs.id").
+		Where("users.id = ?", userID).
+		First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *GormAdminRepository) CreateAdmin(admin *entity.Admin) error {
+	return r.db.Create(admin).Error
+}
+
+func (r *GormAdminRepository) ListAdmins() ([]entity.Admin, error) {
+	var admins []entity.Admin
+	err := r.db.Order("id").Find(&admins).Error
+	return admins, err
+}
+
+func (r *GormAdminRepository) UpdateAdmin(admin *entity.Admin) error {
+	return r.db.Save(admin).Error
+}
+
+// UpdateAdminKeepingSuperadmin применяет apply к администратору и сохраняет его. Строки активных
+// суперадминов блокируются до конца транзакции, поэтому два параллельных запроса не могут
+// снять права с двух последних суперадминов: второй увидит результат первого и получит ErrLastSuperadmin.
+func (r *GormAdminRepository) UpdateAdminKeepingSuperadmin(adminID int64, apply func(admin *entity.Admin)) (*entity.Admin, error) {
+	var admin entity.Admin
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var superadmins []entity.Admin
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("role = ? AND is_active = ?", entity.RoleSuperadmin, true).
+			Order("id").
+			Find(&superadmins).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&admin, adminID).Error; err != nil {
+			return err
+		}
+
+		wasSuperadmin := admin.Role == entity.RoleSuperadmin && admin.IsActive
+		apply(&admin)
+		if wasSuperadmin && (admin.Role != entity.RoleSuperadmin || !admin.IsActive) && len(superadmins) <= 1 {
+			return ErrLastSuperadmin
+		}
+
+		return tx.Save(&admin).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &admin, nil
+}
+
+func (r *GormAdminRepository) ExistsByUsername(username string) (bool, error) {
+	var count int64
+	err := r.db.Model(&entity.Admin{}).Where("username = ?", username).Count(&count).Error
+	return count > 0, err
+}
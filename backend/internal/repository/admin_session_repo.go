@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"internet_provider/internal/entity"
+	"time"
+)
+
+func (r *GormAdminRepository) FindByID(adminID int64) (*entity.Admin, error) {
+	var admin entity.Admin
+	if err := r.db.First(&admin, adminID).Error; err != nil {
+		return nil, err
+	}
+
+	return &admin, nil
+}
+
+func (r *GormAdminRepository) CreateSession(session *entity.AdminSession) error {
+	return r.db.Create(session).Error
+}
+
+func (r *GormAdminRepository) FindSessionByID(sessionID int64) (*entity.AdminSession, error) {
+	var session entity.AdminSession
+	if err := r.db.First(&session, sessionID).Error; err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *GormAdminRepository) FindActiveSessionByRefreshHash(hash string) (*entity.AdminSession, error) {
+	var session entity.AdminSession
+	err := r.db.Where("refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hash, time.Now()).
+		First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// RotateSessionRefresh заменяет refresh токен сессии, старый токен после этого недействителен.
+// Замена идет только с oldHash, поэтому из двух запросов с одним токеном проходит один,
+// а второй получает false.
+func (r *GormAdminRepository) RotateSessionRefresh(sessionID int64, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	result := r.db.Model(&entity.AdminSession{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", sessionID, oldHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash": newHash,
+			"expires_at":         expiresAt,
+			"last_used_at":       time.Now(),
+		})
+
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *GormAdminRepository) ListActiveSessions(adminID int64) ([]entity.AdminSession, error) {
+	var sessions []entity.AdminSession
+	err := r.db.Where("admin_id = ? AND revoked_at IS NULL AND expires_at > ?", adminID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+
+	return sessions, err
+}
+
+// RevokeSession отзывает сессию администратора; возвращает false, если сессия не найдена
+func (r *GormAdminRepository) RevokeSession(adminID, sessionID int64) (bool, error) {
+	result := r.db.Model(&entity.AdminSession{}).
+		Where("id = ? AND admin_id = ? AND revoked_at IS NULL", sessionID, adminID).
+		Update("revoked_at", time.Now())
+
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *GormAdminRepository) RevokeAllSessions(adminID int64) error {
+	return r.db.Model(&entity.AdminSession{}).
+		Where("admin_id = ? AND revoked_at IS NULL", adminID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *GormAdminRepository) RevokeOtherSessions(adminID, keepSessionID int64) error {
+	return r.db.Model(&entity.AdminSession{}).
+		Where("admin_id = ? AND id <> ? AND revoked_at IS NULL", adminID, keepSessionID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// auditChainLock - ключ advisory-блокировки, под которой дописывается цепочка журнала
+const auditChainLock = 7_411_008
+
+type AuditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// Append дописывает событие в конец цепочки. Запись идет под блокировкой, чтобы параллельные
+// запросы не сослались на один и тот же предыдущий хеш.
+func (r *AuditRepository) Append(event *entity.AuditEvent) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLock).Error; err != nil {
+			return err
+		}
+
+		var last entity.AuditEvent
+		err := tx.Select("hash").Order("id DESC").First(&last).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Postgres хранит время с точностью до микросекунд, хеш должен совпасть после чтения
+		event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		event.PrevHash = last.Hash
+		event.Hash = event.ComputeHash(last.Hash)
+
+		return tx.Create(event).Error
+	})
+}
+
+func (r *AuditRepository) List(filter *entity.AuditFilter, page, limit int) ([]entity.AuditEvent, int64, error) {
+	var events []entity.AuditEvent
+	var total int64
+
+	query := r.db.Model(&entity.AuditEvent{})
+	if filter.ActorType != "" {
+		query = query.Where("actor_type = ?", filter.ActorType)
+	}
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&events).Error
+
+	return events, total, err
+}
+
+// ListAfter отдает записи цепочки по порядку, начиная после afterID
+func (r *AuditRepository) ListAfter(afterID int64, limit int) ([]entity.AuditEvent, error) {
+	var events []entity.AuditEvent
+	err := r.db.Where("id > ?", afterID).Order("id ASC").Limit(limit).Find(&events).Error
+
+	return events, err
+}
@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrCardNotFound = errors.New("payment card not found")
+
+type AutopayRepository struct {
+	db *gorm.DB
+}
+
+func NewAutopayRepository(db *gorm.DB) *AutopayRepository {
+	return &AutopayRepository{db: db}
+}
+
+// SaveCard запоминает карту клиента. Повторный webhook с той же картой ничего не меняет.
+func (r *AutopayRepository) SaveCard(card *entity.PaymentCard) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(card).Error
+}
+
+func (r *AutopayRepository) ListCards(userID int) ([]entity.PaymentCard, error) {
+	var cards []entity.PaymentCard
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&cards).Error
+
+	return cards, err
+}
+
+func (r *AutopayRepository) FindCard(userID int, id int64) (*entity.PaymentCard, error) {
+	var card entity.PaymentCard
+	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&card).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCardNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &card, nil
+}
+
+// DeleteCard удаляет карту и отключает автоплатеж, который с нее списывал
+func (r *AutopayRepository) DeleteCard(userID int, id int64) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&entity.PaymentCard{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrCardNotFound
+		}
+
+		return tx.Model(&entity.Autopay{}).
+			Where("user_id = ? AND card_id = ?", userID, id).
+			Update("enabled", false).Error
+	})
+}
+
+// Get - автоплатеж клиента или nil, если клиент его ни разу не настраивал
+func (r *AutopayRepository) Get(userID int) (*entity.Autopay, error) {
+	var autopay entity.Autopay
+	err := r.db.Where("user_id = ?", userID).First(&autopay).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &autopay, nil
+}
+
+// Enable сохраняет настройки и включает автоплатеж. Счетчик неудач сбрасывается: клиент
+// мог сменить карту, и прошлые отказы к ней не относятся.
+func (r *AutopayRepository) Enable(autopay *entity.Autopay) error {
+	autopay.Enabled = true
+	autopay.Failures = 0
+	autopay.NextAttemptAt = nil
+	autopay.LastError = ""
+
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled", "card_id", "trigger", "threshold", "days_before",
+			"amount", "failures", "next_attempt_at", "last_error", "updated_at"}),
+	}).Create(autopay).Error
+}
+
+// Disable выключает автоплатеж. Списание, которое уже ушло в шлюз, все равно будет учтено.
+func (r *AutopayRepository) Disable(userID int) error {
+	return r.db.Model(&entity.Autopay{}).Where("user_id = ?", userID).Update("enabled", false).Error
+}
+
+// Due - автоплатежи, которые пора проверить: с неучтенным списанием или без паузы после неудачи
+func (r *AutopayRepository) Due(now time.Time) ([]entity.Autopay, error) {
+	var autopays []entity.Autopay
+	err := r.db.Where("payment_id IS NOT NULL OR (enabled AND (next_attempt_at IS NULL OR next_attempt_at <= ?))", now).
+		Order("id ASC").
+		Find(&autopays).Error
+
+	return autopays, err
+}
+
+// Need - сколько списать автоплатежом сейчас, 0 - списывать не нужно. Сумма доводит баланс
+// до порога или до цены следующего периода с услугами, но не меньше суммы из настроек.
+// Пока аккаунт заморожен, автоплатеж не срабатывает: списаний нет, а баланс ниже порога
+// может оказаться из-за платы за саму заморозку.
+func (r *AutopayRepository) Need(autopay *entity.Autopay, now time.Time) (entity.Kopecks, error) {
+	var user entity.User
+	if err := r.db.Select("balance").First(&user, autopay.UserID).Error; err != nil {
+		return 0, err
+	}
+
+	var frozen int64
+	if err := r.db.Model(&entity.Subscription{}).
+		Where("user_id = ? AND status = ?", autopay.UserID, entity.SubscriptionFrozen).
+		Count(&frozen).Error; err != nil {
+		return 0, err
+	}
+	if frozen > 0 {
+		return 0, nil
+	}
+
+	var missing entity.Kopecks
+	switch autopay.Trigger {
+	case entity.AutopayThreshold:
+		if user.Balance >= autopay.Threshold {
+			return 0, nil
+		}
+		missing = autopay.Threshold - user.Balance
+
+	case entity.AutopayBeforeCharge:
+		var sub entity.Subscription
+		err := r.db.Where("user_id = ?", autopay.UserID).First(&sub).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if sub.PaidUntil.After(now.AddDate(0, 0, autopay.DaysBefore)) {
+			return 0, nil
+		}
+
+		tariffID := sub.TariffID
+		if sub.NextTariffID != nil {
+			tariffID = *sub.NextTariffID
+		}
+		var tariff entity.Tariff
+		if err := r.db.First(&tariff, tariffID).Error; err != nil {
+			return 0, err
+		}
+
+		// Биллинг спишет период тарифа вместе с подключенными услугами
+		addons, err := activeAddons(r.db, autopay.UserID)
+		if err != nil {
+			return 0, err
+		}
+		price := tariff.PeriodPrice(sub.PaidUntil) + addonsPrice(addons, &tariff, sub.PaidUntil)
+		if user.Balance >= price {
+			return 0, nil
+		}
+		missing = price - user.Balance
+
+	default:
+		return 0, nil
+	}
+
+	amount := autopay.Amount
+	if amount < missing {
+		amount = missing
+	}
+	if amount < entity.MinAutopayAmount {
+		amount = entity.MinAutopayAmount
+	}
+	return amount, nil
+}
+
+// Claim занимает автоплатеж до until, чтобы два экземпляра сервера не списали деньги дважды.
+// false - автоплатеж уже занят, выключен или ждет итога прошлого списания.
+func (r *AutopayRepository) Claim(id int64, now, until time.Time) (bool, error) {
+	result := r.db.Model(&entity.Autopay{}).
+		Where("id = ? AND enabled AND payment_id IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", id, now).
+		Update("next_attempt_at", until)
+
+	return result.RowsAffected == 1, result.Error
+}
+
+// Attach запоминает списание, итог которого нужно учесть
+func (r *AutopayRepository) Attach(id int64, paymentID int) error {
+	return r.db.Model(&entity.Autopay{}).Where("id = ?", id).Update("payment_id", paymentID).Error
+}
+
+// Succeeded учитывает успешное списание paymentID. false - его уже учел другой экземпляр.
+func (r *AutopayRepository) Succeeded(id int64, paymentID int, at time.Time) (bool, error) {
+	result := r.db.Model(&entity.Autopay{}).
+		Where("id = ? AND payment_id = ?", id, paymentID).
+		Updates(map[string]interface{}{
+			"payment_id":      nil,
+			"failures":        0,
+			"next_attempt_at": nil,
+			"last_error":      "",
+			"last_charged_at": at,
+		})
+
+	return result.RowsAffected == 1, result.Error
+}
+
+// Failed учитывает неудачное списание: следующая попытка не раньше next, а после maxAttempts
+// неудач подряд автоплатеж выключается. Возвращает автоплатеж после изменения или nil,
+// если списание уже учтено.
+func (r *AutopayRepository) Failed(id int64, paymentID int, reason string, next time.Time, maxAttempts int) (*entity.Autopay, error) {
+	var autopay entity.Autopay
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND payment_id = ?", id, paymentID).
+			First(&autopay).Error
+		if err != nil {
+			return err
+		}
+
+		autopay.PaymentID = nil
+		autopay.Failures++
+		autopay.LastError = reason
+		autopay.NextAttemptAt = &next
+		if autopay.Failures >= maxAttempts {
+			autopay.Enabled = false
+		}
+
+		return tx.Model(&autopay).Updates(map[string]interface{}{
+			"payment_id":      nil,
+			"failures":        autopay.Failures,
+			"last_error":      autopay.LastError,
+			"next_attempt_at": autopay.NextAttemptAt,
+			"enabled":         autopay.Enabled,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &autopay, nil
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errDocumentExists = errors.New("document already exists")
+
+type DocumentRepository struct {
+	db *gorm.DB
+}
+
+func NewDocumentRepository(db *gorm.DB) *DocumentRepository {
+	return &DocumentRepository{db: db}
+}
+
+// Find - сохраненный документ за период или nil, если его еще не выдавали
+func (r *DocumentRepository) Find(docType string, userID int, from, to time.Time) (*entity.Document, error) {
+	var doc entity.Document
+	err := r.db.Where("type = ? AND user_id = ? AND period_start = ? AND period_end = ?", docType, userID, from, to).First(&doc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &doc, nil
+}
+
+func (r *DocumentRepository) FindForUser(userID int, documentID int64) (*entity.Document, error) {
+	var doc entity.Document
+	if err := r.db.Where("id = ? AND user_id = ?", documentID, userID).First(&doc).Error; err != nil {
+		return nil, err
+	}
+
+	return &doc, nil
+}
+
+// ListForUser - документы клиента без содержимого файлов
+func (r *DocumentRepository) ListForUser(userID int) ([]entity.Document, error) {
+	var docs []entity.Document
+	err := r.db.Omit("content").Where("user_id = ?", userID).
+		Order("period_start DESC, id DESC").Find(&docs).Error
+
+	return docs, err
+}
+
+// Create сохраняет документ. Для счета берется следующий номер из счетчика counter, и render
+// получает его, чтобы поставить в документ и отрендерить файл в той же транзакции. Если документ
+// за этот период уже создан параллельным запросом, номер не расходуется и возвращается
+// сохраненный документ.
+func (r *DocumentRepository) Create(doc *entity.Document, counter string, render func(number int64) error) (*entity.Document, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var number int64
+		if counter != "" {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entity.DocumentCounter{Name: counter}).Error
+			if err != nil {
+				return err
+			}
+
+			var current entity.DocumentCounter
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, "name = ?", counter).Error; err != nil {
+				return err
+			}
+			number = current.Value + 1
+			if err := tx.Model(&current).Update("value", number).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := render(number); err != nil {
+			return err
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(doc)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errDocumentExists
+		}
+		return nil
+	})
+	if errors.Is(err, errDocumentExists) {
+		existing, err := r.Find(doc.Type, doc.UserID, doc.PeriodStart, doc.PeriodEnd)
+		if err == nil && existing == nil {
+			err = fmt.Errorf("document %s of user %d vanished after conflict", doc.Type, doc.UserID)
+		}
+		return existing, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// UninvoicedCustomers - клиенты, у которых за период были операции по счету,
+// но счет за этот период еще не выставлен
+func (r *DocumentRepository) UninvoicedCustomers(from, to time.Time) ([]int, error) {
+	var ids []int
+	err := r.db.Model(&entity.LedgerTransaction{}).
+		Distinct("user_id").
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Where("NOT EXISTS (SELECT 1 FROM documents WHERE documents.user_id = ledger_transactions.user_id "+
+			"AND documents.type = ? AND documents.period_start = ? AND documents.period_end = ?)", entity.DocumentInvoice, from, to).
+		Order("user_id").
+		Pluck("user_id", &ids).Error
+
+	return ids, err
+}
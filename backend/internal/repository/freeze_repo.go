@@ -0,0 +1,300 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"internet_provider/internal/entity"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrFreezeNoSubscription = errors.New("no paid subscription to freeze")
+	ErrFreezeActive         = errors.New("account is already frozen")
+	ErrFreezeNotActive      = errors.New("account is not frozen")
+	ErrFreezePromise        = errors.New("promised payment is not repaid")
+	ErrFreezeEnd            = errors.New("freeze end must be after its start and in the future")
+)
+
+const freezeDay = 24 * time.Hour
+
+type FreezeRepository struct {
+	db *gorm.DB
+}
+
+func NewFreezeRepository(db *gorm.DB) *FreezeRepository {
+	return &FreezeRepository{db: db}
+}
+
+// Active - текущая заморозка клиента или nil
+func (r *FreezeRepository) Active(userID int) (*entity.AccountFreeze, error) {
+	var freeze entity.AccountFreeze
+	err := r.db.Where("user_id = ? AND status = ?", userID, entity.FreezeActive).First(&freeze).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &freeze, nil
+}
+
+// ListActive - идущие заморозки для админ-панели, ближайшая разморозка первой
+func (r *FreezeRepository) ListActive() ([]entity.FreezeReport, error) {
+	var freezes []entity.FreezeReport
+	err := r.db.Model(&entity.AccountFreeze{}).
+		Select("account_freezes.*, users.name AS user_name, users.email AS user_email").
+		Joins("JOIN users ON users.id = account_freezes.user_id").
+		Where("account_freezes.status = ?", entity.FreezeActive).
+		Order("account_freezes.end_at ASC").
+		Scan(&freezes).Error
+
+	return freezes, err
+}
+
+func (r *FreezeRepository) ListForUser(userID int) ([]entity.AccountFreeze, error) {
+	var freezes []entity.AccountFreeze
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&freezes).Error
+
+	return freezes, err
+}
+
+// ListDue - клиенты, у которых срок заморозки уже закончился
+func (r *FreezeRepository) ListDue(now time.Time) ([]int, error) {
+	var ids []int
+	err := r.db.Model(&entity.AccountFreeze{}).
+		Where("status = ? AND end_at <= ?", entity.FreezeActive, now).
+		Order("end_at ASC").
+		Pluck("user_id", &ids).Error
+
+	return ids, err
+}
+
+// Freeze замораживает оплаченную подписку клиента на days дней и сразу списывает плату
+// за весь срок. Под блокировкой клиента, а затем подписки, в том же порядке, что и биллинг.
+func (r *FreezeRepository) Freeze(userID, days int, dailyFee entity.Kopecks, adminID *int64, reason string, now time.Time) (*entity.AccountFreeze, error) {
+	var freeze entity.AccountFreeze
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var user entity.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		var sub entity.Subscription
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&sub).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrFreezeNoSubscription
+		}
+		if err != nil {
+			return err
+		}
+		if sub.Status == entity.SubscriptionFrozen {
+			return ErrFreezeActive
+		}
+		if sub.Status != entity.SubscriptionActive || !sub.PaidUntil.After(now) {
+			return ErrFreezeNoSubscription
+		}
+
+		// Обещанный платеж просрочится во время заморозки, поэтому сначала его нужно погасить
+		var promises int64
+		if err := tx.Model(&entity.PromisedPayment{}).
+			Where("user_id = ? AND status = ?", userID, entity.PromiseActive).
+			Count(&promises).Error; err != nil {
+			return err
+		}
+		if promises > 0 {
+			return ErrFreezePromise
+		}
+
+		fee := dailyFee * entity.Kopecks(days)
+		if user.Balance < fee {
+			return ErrInsufficientBalance
+		}
+
+		freeze = entity.AccountFreeze{
+			UserID:         userID,
+			SubscriptionID: sub.ID,
+			Status:         entity.FreezeActive,
+			StartAt:        now,
+			EndAt:          now.AddDate(0, 0, days),
+			DailyFee:       dailyFee,
+			FeeDays:        days,
+			AdminID:        adminID,
+			Reason:         reason,
+			CreatedAt:      now,
+		}
+		if err := tx.Create(&freeze).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&sub).Update("status", entity.SubscriptionFrozen).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(&entity.TariffHistory{
+			UserID:         userID,
+			SubscriptionID: sub.ID,
+			FromTariffID:   &sub.TariffID,
+			ToTariffID:     sub.TariffID,
+			Kind:           entity.TariffChangeFreeze,
+			Charge:         fee,
+			EffectiveAt:    now,
+		}).Error; err != nil {
+			return err
+		}
+
+		if fee > 0 {
+			if _, _, err := postLedger(tx, entity.LedgerPosting{
+				Type:      entity.LedgerFreezeFee,
+				UserID:    userID,
+				Amount:    -fee,
+				Counter:   entity.AccountRevenue,
+				Reference: "freeze:" + strconv.FormatInt(freeze.ID, 10),
+				Description: fmt.Sprintf("Заморозка аккаунта с %s по %s", freeze.StartAt.Format("02.01.2006"),
+					freeze.EndAt.Format("02.01.2006")),
+			}, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &freeze, nil
+}
+
+// Unfreeze размораживает аккаунт: оплаченный период сдвигается на время заморозки, а плата
+// за неиспользованные полные дни возвращается. Досрочно по запросу или по сроку из ListDue.
+func (r *FreezeRepository) Unfreeze(userID int, now time.Time) (*entity.AccountFreeze, error) {
+	var freeze entity.AccountFreeze
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Возврат платы меняет баланс, поэтому клиент блокируется раньше подписки, как в биллинге
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&entity.User{}, userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrFreezeNotActive
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND status = ?", userID, entity.FreezeActive).
+			First(&freeze).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrFreezeNotActive
+		}
+		if err != nil {
+			return err
+		}
+
+		var sub entity.Subscription
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sub, freeze.SubscriptionID).Error; err != nil {
+			return err
+		}
+
+		// Фоновая разморозка может запоздать: дни после end_at клиент не замораживал,
+		// поэтому период сдвигается не дальше срока заморозки
+		end := now
+		if freeze.EndAt.Before(end) {
+			end = freeze.EndAt
+		}
+		frozen := end.Sub(freeze.StartAt)
+		if frozen < 0 {
+			frozen = 0
+		}
+		// Сдвинутый период заканчивается в другой день месяца, от него и считаются следующие
+		paidUntil := sub.PaidUntil.Add(frozen)
+		if err := tx.Model(&sub).Updates(map[string]interface{}{
+			"status":       entity.SubscriptionActive,
+			"period_start": sub.PeriodStart.Add(frozen),
+			"paid_until":   paidUntil,
+			"anchor_day":   paidUntil.Day(),
+		}).Error; err != nil {
+			return err
+		}
+
+		// Начатый день заморозки считается использованным
+		used := int((frozen + freezeDay - 1) / freezeDay)
+		if used < freeze.FeeDays {
+			freeze.FeeRefunded = freeze.DailyFee * entity.Kopecks(freeze.FeeDays-used)
+		}
+		freeze.Status = entity.FreezeFinished
+		freeze.EndedAt = &end
+		if err := tx.Model(&freeze).Updates(map[string]interface{}{
+			"status":       freeze.Status,
+			"ended_at":     freeze.EndedAt,
+			"fee_refunded": freeze.FeeRefunded,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(&entity.TariffHistory{
+			UserID:         freeze.UserID,
+			SubscriptionID: sub.ID,
+			FromTariffID:   &sub.TariffID,
+			ToTariffID:     sub.TariffID,
+			Kind:           entity.TariffChangeUnfreeze,
+			Credit:         freeze.FeeRefunded,
+			EffectiveAt:    end,
+		}).Error; err != nil {
+			return err
+		}
+
+		if freeze.FeeRefunded > 0 {
+			if _, _, err := postLedger(tx, entity.LedgerPosting{
+				Type:        entity.LedgerRefund,
+				UserID:      freeze.UserID,
+				Amount:      freeze.FeeRefunded,
+				Counter:     entity.AccountRevenue,
+				Reference:   "freeze:" + strconv.FormatInt(freeze.ID, 10),
+				Description: "Возврат платы за неиспользованные дни заморозки",
+			}, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &freeze, nil
+}
+
+// SetEnd переносит дату автоматической разморозки. Плата за дни не пересчитывается:
+// при разморозке возвращается только то, что было списано.
+func (r *FreezeRepository) SetEnd(userID int, endAt, now time.Time) (*entity.AccountFreeze, *entity.AccountFreeze, error) {
+	var before, freeze entity.AccountFreeze
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND status = ?", userID, entity.FreezeActive).
+			First(&freeze).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrFreezeNotActive
+		}
+		if err != nil {
+			return err
+		}
+		if !endAt.After(now) || !endAt.After(freeze.StartAt) {
+			return ErrFreezeEnd
+		}
+
+		before = freeze
+		freeze.EndAt = endAt
+		return tx.Model(&freeze).Update("end_at", endAt).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &before, &freeze, nil
+}
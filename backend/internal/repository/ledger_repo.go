@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+type LedgerRepository struct {
+	db *gorm.DB
+}
+
+func NewLedgerRepository(db *gorm.DB) *LedgerRepository {
+	return &LedgerRepository{db: db}
+}
+
+// postLedger проводит операцию по счету клиента и встречному счету и в той же транзакции
+// меняет баланс клиента. Баланс в users - это остаток счета клиента в журнале,
+// поэтому менять его можно только через эту функцию. Вызывается внутри транзакции.
+func postLedger(tx *gorm.DB, posting entity.LedgerPosting, at time.Time) (*entity.LedgerTransaction, entity.Kopecks, error) {
+	result := tx.Model(&entity.User{}).Where("id = ?", posting.UserID).
+		Update("balance", gorm.Expr("balance + ?", posting.Amount))
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, 0, ErrUserNotFound
+	}
+
+	var balance entity.Kopecks
+	if err := tx.Model(&entity.User{}).Where("id = ?", posting.UserID).Select("balance").Scan(&balance).Error; err != nil {
+		return nil, 0, err
+	}
+
+	txn := entity.LedgerTransaction{
+		Type:        posting.Type,
+		UserID:      posting.UserID,
+		Reference:   posting.Reference,
+		Description: posting.Description,
+		CreatedAt:   at,
+	}
+	if err := tx.Create(&txn).Error; err != nil {
+		return nil, 0, err
+	}
+
+	entries := []entity.LedgerEntry{
+		{
+			TransactionID: txn.ID,
+			Account:       entity.CustomerAccount(posting.UserID),
+			Amount:        posting.Amount,
+			BalanceAfter:  &balance,
+			CreatedAt:     at,
+		},
+		{
+			TransactionID: txn.ID,
+			Account:       posting.Counter,
+			Amount:        -posting.Amount,
+			CreatedAt:     at,
+		},
+	}
+	if err := tx.Create(&entries).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return &txn, balance, nil
+}
+
+// Statement - проводки по счету клиента за период и остатки на его начало и конец.
+// Остатки в проводках считаются в порядке записи, поэтому строки идут по id, а начальный
+// остаток берется у последней проводки перед первой проводкой периода.
+func (r *LedgerRepository) Statement(userID int, from, to time.Time) (*entity.Statement, error) {
+	account := entity.CustomerAccount(userID)
+	statement := &entity.Statement{From: from, To: to, Lines: []entity.StatementLine{}}
+
+	err := r.db.Table("ledger_entries").
+		Select("ledger_transactions.id AS transaction_id, ledger_transactions.type, ledger_transactions.reference, "+
+			"ledger_transactions.description, ledger_entries.amount, ledger_entries.balance_after, ledger_entries.created_at").
+		Joins("JOIN ledger_transactions ON ledger_transactions.id = ledger_entries.transaction_id").
+		Where("ledger_entries.account = ? AND ledger_entries.created_at >= ? AND ledger_entries.created_at < ?", account, from, to).
+		Order("ledger_entries.id ASC").
+		Scan(&statement.Lines).Error
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.db.Model(&entity.LedgerEntry{}).Where("account = ?", account)
+	if len(statement.Lines) > 0 {
+		first := r.db.Model(&entity.LedgerEntry{}).Select("MIN(id)").
+			Where("account = ? AND created_at >= ? AND created_at < ?", account, from, to)
+		query = query.Where("id < (?)", first)
+	} else {
+		query = query.Where("created_at < ?", from)
+	}
+
+	var opening []entity.Kopecks
+	if err := query.Order("id DESC").Limit(1).Pluck("balance_after", &opening).Error; err != nil {
+		return nil, err
+	}
+	if len(opening) > 0 {
+		statement.OpeningBalance = opening[0]
+	}
+
+	statement.ClosingBalance = statement.OpeningBalance
+	for _, line := range statement.Lines {
+		if line.Amount > 0 {
+			statement.Credits += line.Amount
+		} else {
+			statement.Debits -= line.Amount
+		}
+		statement.ClosingBalance = line.BalanceAfter
+	}
+
+	return statement, nil
+}
+
+// OpenBalances переносит в журнал балансы, накопленные до его появления, одной
+// корректировкой на клиента. Клиентов, у которых уже есть проводки, не трогает.
+// Возвращает, скольким клиентам остаток действительно перенесен.
+func (r *LedgerRepository) OpenBalances(now time.Time) (int, error) {
+	var users []entity.User
+	err := r.db.Where("balance <> 0").
+		Where("NOT EXISTS (SELECT 1 FROM ledger_transactions WHERE ledger_transactions.user_id = users.id)").
+		Find(&users).Error
+	if err != nil {
+		return 0, err
+	}
+
+	opened := 0
+	for _, user := range users {
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			// Клиент мог пополнить счет, пока шел перенос: тогда проводка уже есть
+			var locked entity.User
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, user.Id).Error; err != nil {
+				return err
+			}
+			var posted int64
+			if err := tx.Model(&entity.LedgerTransaction{}).Where("user_id = ?", user.Id).Count(&posted).Error; err != nil {
+				return err
+			}
+			if posted > 0 || locked.Balance == 0 {
+				return nil
+			}
+
+			balance := locked.Balance
+			txn := entity.LedgerTransaction{
+				Type:        entity.LedgerAdjustment,
+				UserID:      user.Id,
+				Reference:   "opening_balance",
+				Description: "Остаток на начало ведения журнала",
+				CreatedAt:   now,
+			}
+			if err := tx.Create(&txn).Error; err != nil {
+				return err
+			}
+
+			entries := []entity.LedgerEntry{
+				{TransactionID: txn.ID, Account: entity.CustomerAccount(user.Id), Amount: balance, BalanceAfter: &balance, CreatedAt: now},
+				{TransactionID: txn.ID, Account: entity.AccountAdjustments, Amount: -balance, CreatedAt: now},
+			}
+			if err := tx.Create(&entries).Error; err != nil {
+				return err
+			}
+
+			opened++
+			return nil
+		})
+		if err != nil {
+			return opened, err
+		}
+	}
+
+	return opened, nil
+}
+
+// Unbalanced - клиенты, у которых баланс расходится с остатком счета в журнале
+func (r *LedgerRepository) Unbalanced() ([]int, error) {
+	var ids []int
+	err := r.db.Raw(`
+		SELECT users.id FROM users
+		LEFT JOIN ledger_entries ON ledger_entries.account = 'customer:' || users.id
+		GROUP BY users.id, users.balance
+		HAVING users.balance <> COALESCE(SUM(ledger_entries.amount), 0)`).
+		Scan(&ids).Error
+
+	return ids, err
+}
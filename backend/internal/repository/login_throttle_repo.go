@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type LoginThrottleRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginThrottleRepository(db *gorm.DB) *LoginThrottleRepository {
+	return &LoginThrottleRepository{db: db}
+}
+
+// Find возвращает nil без ошибки, если по ключу еще не было неудачных попыток
+func (r *LoginThrottleRepository) Find(key string) (*entity.LoginThrottle, error) {
+	var throttle entity.LoginThrottle
+	err := r.db.Where("throttle_key = ?", key).First(&throttle).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &throttle, nil
+}
+
+// RegisterAttempt атомарно засчитывает попытку входа как неудачную и возвращает счетчик
+// после нее. Если прошлая ошибка была раньше windowStart, счет начинается заново.
+func (r *LoginThrottleRepository) RegisterAttempt(key, scope string, windowStart time.Time) (*entity.LoginThrottle, error) {
+	var throttle entity.LoginThrottle
+	now := time.Now()
+
+	err := r.db.Raw(`
+		INSERT INTO login_throttles (throttle_key, scope, failures, lock_count, last_failure_at)
+		VALUES (?, ?, 1, 0, ?)
+		ON CONFLICT (throttle_key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING *`, key, scope, now, windowStart).Scan(&throttle).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &throttle, nil
+}
+
+// Lock блокирует ключ и записывает событие. Если другой экземпляр API уже заблокировал ключ,
+// ничего не делает и возвращает false.
+func (r *LoginThrottleRepository) Lock(throttle *entity.LoginThrottle, until time.Time, ip string) (bool, error) {
+	locked := false
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&entity.LoginThrottle{}).
+			Where("throttle_key = ? AND (locked_until IS NULL OR locked_until < ?)", throttle.ThrottleKey, time.Now()).
+			Updates(map[string]interface{}{
+				"locked_until": until,
+				"lock_count":   gorm.Expr("lock_count + 1"),
+				"failures":     0,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		locked = true
+		return tx.Create(&entity.LockoutEvent{
+			ThrottleKey: throttle.ThrottleKey,
+			Scope:       throttle.Scope,
+			IP:          ip,
+			Failures:    throttle.Failures,
+			LockedUntil: until,
+		}).Error
+	})
+
+	return locked, err
+}
+
+// Reset сбрасывает счетчик ошибок после успешного входа. Строка остается: по lock_count
+// следующая блокировка будет длиннее.
+func (r *LoginThrottleRepository) Reset(key string) error {
+	return r.db.Model(&entity.LoginThrottle{}).Where("throttle_key = ?", key).Update("failures", 0).Error
+}
+
+// Release снимает одну попытку, засчитанную RegisterAttempt, если пароль так и не проверялся
+// или оказался верным
+func (r *LoginThrottleRepository) Release(key string) error {
+	return r.db.Model(&entity.LoginThrottle{}).
+		Where("throttle_key = ?", key).
+		Update("failures", gorm.Expr("GREATEST(failures - 1, 0)")).Error
+}
+
+// Unlock снимает блокировку вручную и отмечает, кто ее снял. Возвращает false, если ключ не заблокирован.
+func (r *LoginThrottleRepository) Unlock(key string, adminID int64) (bool, error) {
+	unlocked := false
+	now := time.Now()
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&entity.LoginThrottle{}).
+			Where("throttle_key = ? AND locked_until > ?", key, now).
+			Updates(map[string]interface{}{
+				"locked_until": nil,
+				"failures":     0,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		unlocked = true
+		return tx.Model(&entity.LockoutEvent{}).
+			Where("throttle_key = ? AND locked_until > ? AND unlocked_at IS NULL", key, now).
+			Updates(map[string]interface{}{
+				"unlocked_by": adminID,
+				"unlocked_at": now,
+			}).Error
+	})
+
+	return unlocked, err
+}
+
+func (r *LoginThrottleRepository) ListLocked() ([]entity.LoginThrottle, error) {
+	var throttles []entity.LoginThrottle
+	err := r.db.Where("locked_until > ?", time.Now()).
+		Order("locked_until DESC").
+		Find(&throttles).Error
+
+	return throttles, err
+}
+
+func (r *LoginThrottleRepository) ListEvents(scope string, page, limit int) ([]entity.LockoutEvent, int64, error) {
+	var events []entity.LockoutEvent
+	var total int64
+
+	query := r.db.Model(&entity.LockoutEvent{})
+	if scope != "" && scope != "all" {
+		query = query.Where("scope = ?", scope)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&events).Error
+
+	return events, total, err
+}
@@ -1,10 +1,24 @@
 package repository
 
 import (
+	"errors"
+	"fmt"
 	"internet_provider/internal/entity"
 	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrIdempotencyConflict = errors.New("idempotency key reused with different parameters")
+	ErrPaymentMismatch     = errors.New("provider event does not match the payment")
+	ErrNotRefundable       = errors.New("payment cannot be refunded")
+	ErrRefundTooLarge      = errors.New("refund exceeds the refundable amount")
+	ErrChargeReversed      = errors.New("charge is already reversed")
+	ErrRefundNotPending    = errors.New("refund is not pending")
 )
 
 type PaymentRepository struct {
@@ -15,33 +29,410 @@ func NewPaymentRepository(db *gorm.DB) *PaymentRepository {
 	return &PaymentRepository{db: db}
 }
 
-func (h *PaymentRepository) ToUpBalance(UserID int, amount float64, paymentMethod string) error {
+// CreatePending заводит платеж в статусе pending. Если платеж с таким idempotencyKey
+// уже был, возвращает его без создания нового и replayed = true.
+func (h *PaymentRepository) CreatePending(UserID int, amount entity.Kopecks, paymentMethod, provider, idempotencyKey string) (*entity.Payment, bool, error) {
+	operation := entity.Payment{
+		UserID:        UserID,
+		PaymentMethod: paymentMethod,
+		Amount:        amount,
+		Status:        entity.PaymentPending,
+		Provider:      provider,
+		RequestHash:   entity.PaymentRequestHash(amount, paymentMethod),
+	}
+	if idempotencyKey != "" {
+		operation.IdempotencyKey = &idempotencyKey
+	}
+
+	// Параллельный запрос с тем же ключом ждет на уникальном индексе, пока первый не завершится
+	result := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&operation)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return &operation, false, nil
+	}
+
+	requestHash := operation.RequestHash
+	if err := h.db.Where("user_id = ? AND idempotency_key = ?", UserID, idempotencyKey).First(&operation).Error; err != nil {
+		return nil, false, err
+	}
+	if operation.RequestHash != requestHash {
+		return nil, false, ErrIdempotencyConflict
+	}
+
+	return &operation, true, nil
+}
+
+// AttachProvider запоминает платеж шлюза и ссылку на страницу оплаты
+func (h *PaymentRepository) AttachProvider(payment *entity.Payment, externalID, confirmationURL string) error {
+	payment.ExternalID = &externalID
+	payment.ConfirmationURL = confirmationURL
+
+	return h.db.Model(payment).Updates(map[string]interface{}{
+		"external_id":      externalID,
+		"confirmation_url": confirmationURL,
+	}).Error
+}
+
+// MarkFailed закрывает платеж, который не удалось создать в шлюзе
+func (h *PaymentRepository) MarkFailed(payment *entity.Payment) error {
+	payment.Status = entity.PaymentFailed
+
+	return h.db.Model(payment).Where("status = ?", entity.PaymentPending).Update("status", entity.PaymentFailed).Error
+}
+
+// ApplyStatus применяет статус из webhook шлюза. Переход возможен только из pending, поэтому
+// повторный webhook ничего не меняет и changed = false. При completed сумма должна совпасть
+// с платежом, и деньги зачисляются через журнал в той же транзакции.
+func (h *PaymentRepository) ApplyStatus(paymentID int, provider, externalID, status string, amount entity.Kopecks, at time.Time) (*entity.Payment, bool, error) {
+	var operation entity.Payment
+	changed := false
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&operation, paymentID).Error; err != nil {
+			return err
+		}
+
+		if operation.Provider != provider || (operation.ExternalID != nil && *operation.ExternalID != externalID) {
+			return ErrPaymentMismatch
+		}
+		if operation.Status != entity.PaymentPending || status == entity.PaymentPending {
+			return nil
+		}
+
+		updates := map[string]interface{}{
+			"status":      status,
+			"external_id": externalID,
+		}
+
+		if status == entity.PaymentCompleted {
+			if amount != operation.Amount {
+				return ErrPaymentMismatch
+			}
+
+			if _, _, err := postLedger(tx, entity.LedgerPosting{
+				Type:        entity.LedgerTopUp,
+				UserID:      operation.UserID,
+				Amount:      operation.Amount,
+				Counter:     entity.AccountExternal,
+				Reference:   "payment:" + strconv.Itoa(operation.ID),
+				Description: "Пополнение баланса",
+			}, at); err != nil {
+				return err
+			}
+			if err := repayPromises(tx, operation.UserID, operation.Amount, at); err != nil {
+				return err
+			}
+			if err := queueReceipt(tx, &operation, entity.ReceiptIncome, at); err != nil {
+				return err
+			}
+
+			updates["completed_at"] = at
+			operation.CompletedAt = &at
+		}
+
+		if err := tx.Model(&operation).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		operation.Status = status
+		operation.ExternalID = &externalID
+		changed = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &operation, changed, nil
+}
+
+// StartRefund - первый шаг возврата пополнения, amount = 0 - весь остаток. Заводит запись
+// возврата в статусе refund_pending, сразу списывает сумму с баланса и резервирует ее
+// в пополнении, чтобы параллельный возврат не вернул те же деньги. Уже потраченное вернуть
+// нельзя: ErrInsufficientBalance. Шлюз вызывается после коммита, а итог фиксирует
+// CompleteRefund или FailRefund по номеру возврата.
+func (h *PaymentRepository) StartRefund(paymentID int, amount entity.Kopecks, reason string, adminID int64, at time.Time) (*entity.Payment, *entity.Payment, error) {
+	var original, refund entity.Payment
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&original, paymentID).Error; err != nil {
+			return err
+		}
+
+		refundable := original.Refundable()
+		if refundable <= 0 {
+			return ErrNotRefundable
+		}
+		if amount == 0 {
+			amount = refundable
+		}
+		if amount > refundable {
+			return ErrRefundTooLarge
+		}
+
+		refund = entity.Payment{
+			UserID:        original.UserID,
+			Amount:        -amount,
+			Status:        entity.PaymentRefundPending,
+			PaymentMethod: original.PaymentMethod,
+			Provider:      original.Provider,
+			ParentID:      &original.ID,
+			Reason:        reason,
+			AdminID:       &adminID,
+		}
+		description := fmt.Sprintf("Возврат по платежу №%d", original.ID)
+		if err := createOperation(tx, &refund, entity.LedgerRefund, entity.AccountExternal, description, at); err != nil {
+			return err
+		}
+
+		return reserveRefund(tx, &original, amount)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &refund, &original, nil
+}
+
+// CompleteRefund закрывает возврат, который шлюз выполнил: сохраняет номер возврата
+// в шлюзе и ставит в очередь чек возврата
+func (h *PaymentRepository) CompleteRefund(refundID int, externalID string, at time.Time) (*entity.Payment, *entity.Payment, error) {
+	var original, refund entity.Payment
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPendingRefund(tx, refundID, &refund); err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"status":       entity.PaymentRefund,
+			"completed_at": at,
+		}
+		if externalID != "" {
+			updates["external_id"] = externalID
+			refund.ExternalID = &externalID
+		}
+		if err := tx.Model(&refund).Updates(updates).Error; err != nil {
+			return err
+		}
+		refund.Status = entity.PaymentRefund
+		refund.CompletedAt = &at
+
+		// Деньги возвращаются через шлюз только по платежам шлюза, и только на них нужен чек возврата
+		if refund.Provider != "" {
+			if err := queueReceipt(tx, &refund, entity.ReceiptIncomeReturn, at); err != nil {
+				return err
+			}
+		}
+
+		return tx.First(&original, *refund.ParentID).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &refund, &original, nil
+}
+
+// FailRefund отменяет возврат, который шлюз не выполнил: сумма возвращается на баланс
+// отдельной операцией журнала, резерв в пополнении снимается
+func (h *PaymentRepository) FailRefund(refundID int, at time.Time) error {
 	return h.db.Transaction(func(tx *gorm.DB) error {
-		var user entity.User
+		var refund, original entity.Payment
+		if err := tx.First(&refund, refundID).Error; err != nil {
+			return err
+		}
+		if refund.ParentID == nil {
+			return ErrRefundNotPending
+		}
 
-		if err := tx.First(&user, UserID).Error; err != nil {
+		// Пополнение блокируется раньше возврата, в том же порядке, что и в StartRefund
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&original, *refund.ParentID).Error; err != nil {
+			return err
+		}
+		if err := lockPendingRefund(tx, refundID, &refund); err != nil {
 			return err
 		}
 
-		newBalance := user.Balance + amount
-		if err := tx.Model(&user).Update("balance", newBalance).Error; err != nil {
+		if err := tx.Model(&refund).Update("status", entity.PaymentRefundFailed).Error; err != nil {
+			return err
+		}
+		if _, _, err := postLedger(tx, entity.LedgerPosting{
+			Type:        entity.LedgerRefund,
+			UserID:      refund.UserID,
+			Amount:      -refund.Amount,
+			Counter:     entity.AccountExternal,
+			Reference:   "payment:" + strconv.Itoa(refund.ID),
+			Description: fmt.Sprintf("Отмена возврата по платежу №%d: платежный сервис не выполнил возврат", original.ID),
+		}, at); err != nil {
 			return err
 		}
 
-		operation := entity.Payment{
-			UserID:        user.Id,
-			PaymentMethod: paymentMethod,
-			Amount:        amount,
-			Status:        "completed",
+		return reserveRefund(tx, &original, refund.Amount)
+	})
+}
+
+// reserveRefund меняет возвращенную по пополнению сумму на amount (отрицательная - снимает резерв)
+func reserveRefund(tx *gorm.DB, original *entity.Payment, amount entity.Kopecks) error {
+	original.RefundedAmount += amount
+	switch {
+	case original.RefundedAmount == 0:
+		original.Status = entity.PaymentCompleted
+	case original.RefundedAmount == original.Amount:
+		original.Status = entity.PaymentRefunded
+	default:
+		original.Status = entity.PaymentPartiallyRefunded
+	}
+
+	return tx.Model(original).Updates(map[string]interface{}{
+		"refunded_amount": original.RefundedAmount,
+		"status":          original.Status,
+	}).Error
+}
+
+func lockPendingRefund(tx *gorm.DB, refundID int, refund *entity.Payment) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND status = ?", refundID, entity.PaymentRefundPending).
+		First(refund).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRefundNotPending
+	}
+	return err
+}
+
+// ReverseCharge возвращает на баланс списание за тариф. Подписку не меняет: оплаченный
+// период остается, это компенсация. Одно списание можно вернуть только один раз.
+func (h *PaymentRepository) ReverseCharge(chargeID int64, reason string, adminID int64, at time.Time) (*entity.Payment, *entity.SubscriptionCharge, error) {
+	var charge entity.SubscriptionCharge
+	var reversal entity.Payment
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&charge, chargeID).Error; err != nil {
+			return err
+		}
+		if charge.ReversedAt != nil {
+			return ErrChargeReversed
+		}
+
+		reversal = entity.Payment{
+			UserID:        charge.UserID,
+			Amount:        charge.Amount,
+			Status:        entity.PaymentChargeReversal,
+			PaymentMethod: entity.PaymentMethodBalance,
+			ChargeID:      &charge.ID,
+			Reason:        reason,
+			AdminID:       &adminID,
+			CompletedAt:   &at,
+		}
+		description := fmt.Sprintf("Возврат списания за период с %s по %s",
+			charge.PeriodStart.Format("02.01.2006"), charge.PeriodEnd.Format("02.01.2006"))
+		if err := createOperation(tx, &reversal, entity.LedgerRefund, entity.AccountRevenue, description, at); err != nil {
+			return err
 		}
 
-		return tx.Create(&operation).Error
+		charge.ReversedAt = &at
+		return tx.Model(&charge).Update("reversed_at", at).Error
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &reversal, &charge, nil
+}
+
+// Adjust - ручное зачисление (amount > 0) или списание (amount < 0). Списание не может
+// увести баланс в минус.
+func (h *PaymentRepository) Adjust(userID int, amount entity.Kopecks, reason string, adminID int64, at time.Time) (*entity.Payment, error) {
+	adjustment := entity.Payment{
+		UserID:        userID,
+		Amount:        amount,
+		Status:        entity.PaymentAdjustment,
+		PaymentMethod: entity.PaymentMethodManual,
+		Reason:        reason,
+		AdminID:       &adminID,
+		CompletedAt:   &at,
+	}
+
+	description := "Ручное зачисление"
+	if amount < 0 {
+		description = "Ручное списание"
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return createOperation(tx, &adjustment, entity.LedgerAdjustment, entity.AccountAdjustments, description, at)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &adjustment, nil
+}
+
+// createOperation сохраняет операцию администратора и проводит ее по журналу.
+// Списание, после которого баланс стал бы отрицательным, отклоняется.
+func createOperation(tx *gorm.DB, operation *entity.Payment, ledgerType, counter, description string, at time.Time) error {
+	if err := tx.Create(operation).Error; err != nil {
+		return err
+	}
+
+	if operation.Reason != "" {
+		description += ": " + operation.Reason
+	}
+	if len([]rune(description)) > 255 {
+		description = string([]rune(description)[:252]) + "..."
+	}
+
+	_, balance, err := postLedger(tx, entity.LedgerPosting{
+		Type:        ledgerType,
+		UserID:      operation.UserID,
+		Amount:      operation.Amount,
+		Counter:     counter,
+		Reference:   "payment:" + strconv.Itoa(operation.ID),
+		Description: description,
+	}, at)
+	if err != nil {
+		return err
+	}
+	if operation.Amount < 0 && balance < 0 {
+		return ErrInsufficientBalance
+	}
+
+	return nil
+}
+
+// PendingRefunds - возвраты, которые ждут итога шлюза и созданы раньше before
+func (h *PaymentRepository) PendingRefunds(before time.Time) ([]entity.Payment, error) {
+	var refunds []entity.Payment
+	err := h.db.Where("status = ? AND created_at < ?", entity.PaymentRefundPending, before).
+		Order("id").
+		Find(&refunds).Error
+
+	return refunds, err
+}
+
+func (h *PaymentRepository) Find(paymentID int) (*entity.Payment, error) {
+	var operation entity.Payment
+	if err := h.db.First(&operation, paymentID).Error; err != nil {
+		return nil, err
+	}
+
+	return &operation, nil
+}
+
+func (h *PaymentRepository) FindForUser(UserID, paymentID int) (*entity.Payment, error) {
+	var operation entity.Payment
+	if err := h.db.Where("id = ? AND user_id = ?", paymentID, UserID).First(&operation).Error; err != nil {
+		return nil, err
+	}
+
+	return &operation, nil
 }
 
 func (r *PaymentRepository) GetBalanceHistory(UserID int) ([]entity.Payment, error) {
 	var operation []entity.Payment
-	err := r.db.Where("user_id = ?", UserID).Order("created_at DESC").Find(&operation).Error
+	err := r.db.Preload("Receipt").Where("user_id = ?", UserID).Order("created_at DESC").Find(&operation).Error
 
 	return operation, err
 }
@@ -53,8 +444,12 @@ func (r *PaymentRepository) GetAllPayments(search string, filter string, page, l
 
 	query := r.db.Model(&entity.Payment{})
 
-	// Поиск по ID пользователя
-	if search != "" {
+	// Поиск по ID пользователя, #N - по номеру платежа вместе с его возвратами
+	if strings.HasPrefix(search, "#") {
+		if paymentID, err := strconv.Atoi(search[1:]); err == nil {
+			query = query.Where("id = ? OR parent_id = ?", paymentID, paymentID)
+		}
+	} else if search != "" {
 		if userID, err := strconv.Atoi(search); err == nil {
 			query = query.Where("user_id = ?", userID)
 		}
@@ -73,6 +468,7 @@ func (r *PaymentRepository) GetAllPayments(search string, filter string, page, l
 	// Пагинация
 	offset := (page - 1) * limit
 	err := query.
+		Preload("Receipt").
 		Order("created_at DESC").
 		Offset(offset).
 		Limit(limit).
@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"math"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPromiseNoSubscription = errors.New("customer has no tariff to promise against")
+	ErrPromiseOutstanding    = errors.New("previous promised payment is not repaid")
+	ErrPromiseUsed           = errors.New("promised payment already taken this period")
+	ErrPromiseTooLarge       = errors.New("promised payment exceeds the limit")
+)
+
+type PromiseRepository struct {
+	db *gorm.DB
+}
+
+func NewPromiseRepository(db *gorm.DB) *PromiseRepository {
+	return &PromiseRepository{db: db}
+}
+
+// Limit - сколько клиент может взять сейчас, или ошибка, почему нельзя
+func (r *PromiseRepository) Limit(userID, percent int, now time.Time) (entity.Kopecks, error) {
+	return promiseLimit(r.db, userID, percent, now)
+}
+
+// Active - непогашенный обещанный платеж клиента или nil
+func (r *PromiseRepository) Active(userID int) (*entity.PromisedPayment, error) {
+	var promise entity.PromisedPayment
+	err := r.db.Where("user_id = ? AND status = ?", userID, entity.PromiseActive).First(&promise).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &promise, nil
+}
+
+func (r *PromiseRepository) ListForUser(userID int) ([]entity.PromisedPayment, error) {
+	var promises []entity.PromisedPayment
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&promises).Error
+
+	return promises, err
+}
+
+// ListOutstanding - все непогашенные обещанные платежи, просроченные первыми
+func (r *PromiseRepository) ListOutstanding(now time.Time) ([]entity.PromisedPaymentReport, error) {
+	var reports []entity.PromisedPaymentReport
+	err := r.db.Model(&entity.PromisedPayment{}).
+		Select("promised_payments.*, users.name AS user_name, users.email AS user_email, "+
+			"promised_payments.due_at <= ? AS overdue", now).
+		Joins("LEFT JOIN users ON users.id = promised_payments.user_id").
+		Where("promised_payments.status = ?", entity.PromiseActive).
+		Order("promised_payments.due_at ASC").
+		Scan(&reports).Error
+
+	return reports, err
+}
+
+// Take выдает обещанный платеж: сумма сразу зачисляется на баланс через журнал. amount = 0 -
+// весь лимит. Все проверки идут под блокировкой клиента, поэтому два параллельных запроса
+// не получат два кредита.
+func (r *PromiseRepository) Take(userID int, amount entity.Kopecks, percent, days int, now time.Time) (*entity.PromisedPayment, error) {
+	var promise entity.PromisedPayment
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var user entity.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		limit, err := promiseLimit(tx, userID, percent, now)
+		if err != nil {
+			return err
+		}
+		if amount == 0 {
+			amount = limit
+		}
+		if amount > limit {
+			return ErrPromiseTooLarge
+		}
+
+		promise = entity.PromisedPayment{
+			UserID:      userID,
+			Amount:      amount,
+			Outstanding: amount,
+			Status:      entity.PromiseActive,
+			DueAt:       now.AddDate(0, 0, days),
+			CreatedAt:   now,
+		}
+		if err := tx.Create(&promise).Error; err != nil {
+			return err
+		}
+
+		_, _, err = postLedger(tx, entity.LedgerPosting{
+			Type:        entity.LedgerPromise,
+			UserID:      userID,
+			Amount:      amount,
+			Counter:     entity.AccountPromises,
+			Reference:   "promise:" + strconv.FormatInt(promise.ID, 10),
+			Description: "Обещанный платеж до " + promise.DueAt.Format("02.01.2006"),
+		}, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &promise, nil
+}
+
+// promiseLimit - лимит обещанного платежа: percent процентов цены периода тарифа клиента.
+// Новый платеж нельзя взять, пока не погашен прошлый и пока с прошлого не прошел период тарифа.
+func promiseLimit(db *gorm.DB, userID, percent int, now time.Time) (entity.Kopecks, error) {
+	var sub entity.Subscription
+	err := db.Where("user_id = ?", userID).First(&sub).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, ErrPromiseNoSubscription
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Замороженному аккаунту платить не за что
+	if sub.Status == entity.SubscriptionFrozen {
+		return 0, ErrPromiseNoSubscription
+	}
+
+	var tariff entity.Tariff
+	if err := db.First(&tariff, sub.TariffID).Error; err != nil {
+		return 0, err
+	}
+
+	var last entity.PromisedPayment
+	err = db.Where("user_id = ?", userID).Order("created_at DESC").First(&last).Error
+	switch {
+	case err == nil:
+		if last.Status == entity.PromiseActive {
+			return 0, ErrPromiseOutstanding
+		}
+		if tariff.PeriodEnd(last.CreatedAt).After(now) {
+			return 0, ErrPromiseUsed
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return 0, err
+	}
+
+	return entity.Kopecks(math.Round(float64(tariff.PeriodPrice(now)) * float64(percent) / 100)), nil
+}
+
+// repayPromises гасит обещанные платежи клиента из только что зачисленного пополнения amount.
+// Вызывается в транзакции пополнения, поэтому погашение не отстает от зачисления.
+func repayPromises(tx *gorm.DB, userID int, amount entity.Kopecks, at time.Time) error {
+	var promises []entity.PromisedPayment
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND status = ?", userID, entity.PromiseActive).
+		Order("created_at ASC").
+		Find(&promises).Error
+	if err != nil {
+		return err
+	}
+
+	for _, promise := range promises {
+		if amount <= 0 {
+			return nil
+		}
+
+		repaid := promise.Outstanding
+		if repaid > amount {
+			repaid = amount
+		}
+		amount -= repaid
+
+		updates := map[string]interface{}{"outstanding": promise.Outstanding - repaid}
+		if repaid == promise.Outstanding {
+			updates["status"] = entity.PromiseRepaid
+			updates["repaid_at"] = at
+		}
+		if err := tx.Model(&promise).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		_, _, err := postLedger(tx, entity.LedgerPosting{
+			Type:        entity.LedgerPromiseRepay,
+			UserID:      userID,
+			Amount:      -repaid,
+			Counter:     entity.AccountPromises,
+			Reference:   "promise:" + strconv.FormatInt(promise.ID, 10),
+			Description: "Погашение обещанного платежа",
+		}, at)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasOverduePromise - у клиента есть обещанный платеж, не погашенный в срок
+func hasOverduePromise(db *gorm.DB, userID int, now time.Time) (bool, error) {
+	var count int64
+	err := db.Model(&entity.PromisedPayment{}).
+		Where("user_id = ? AND status = ? AND due_at <= ?", userID, entity.PromiseActive, now).
+		Count(&count).Error
+
+	return count > 0, err
+}
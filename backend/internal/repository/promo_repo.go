@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"errors"
+	"internet_provider/internal/app"
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPromoNotFound        = errors.New("promo code not found")
+	ErrPromoUnavailable     = errors.New("promo code is disabled, not started or expired")
+	ErrPromoLimitReached    = errors.New("promo code redemption limit reached")
+	ErrPromoNotEligible     = errors.New("promo code does not apply to the tariff")
+	ErrPromoAlreadyRedeemed = errors.New("promo code already redeemed by the customer")
+	ErrPromoActive          = errors.New("customer already has an active promo code")
+)
+
+type PromoRepository struct {
+	db *gorm.DB
+}
+
+func NewPromoRepository(db *gorm.DB) *PromoRepository {
+	return &PromoRepository{db: db}
+}
+
+func (r *PromoRepository) Create(promo *entity.PromoCode) error {
+	return r.db.Create(promo).Error
+}
+
+func (r *PromoRepository) Update(promo *entity.PromoCode, updates map[string]interface{}) error {
+	return r.db.Model(promo).Updates(updates).Error
+}
+
+func (r *PromoRepository) FindByID(id int64) (*entity.PromoCode, error) {
+	var promo entity.PromoCode
+	if err := r.db.First(&promo, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &promo, nil
+}
+
+func (r *PromoRepository) CodeExists(code string) (bool, error) {
+	var count int64
+	err := r.db.Model(&entity.PromoCode{}).Where("code = ?", code).Count(&count).Error
+	return count > 0, err
+}
+
+// List - промокоды с итогами применений, новые первыми
+func (r *PromoRepository) List() ([]entity.PromoCodeReport, error) {
+	var reports []entity.PromoCodeReport
+	err := r.db.Model(&entity.PromoCode{}).
+		Select("promo_codes.*, "+
+			"COUNT(promo_redemptions.id) FILTER (WHERE promo_redemptions.status = ?) AS active_redemptions, "+
+			"COALESCE(SUM(promo_redemptions.total_discount), 0) AS total_discount", entity.PromoRedemptionActive).
+		Joins("LEFT JOIN promo_redemptions ON promo_redemptions.promo_code_id = promo_codes.id").
+		Group("promo_codes.id").
+		Order("promo_codes.created_at DESC").
+		Scan(&reports).Error
+
+	return reports, err
+}
+
+// Redemptions - кто и когда применил промокод и сколько получил скидки
+func (r *PromoRepository) Redemptions(promoID int64) ([]entity.PromoRedemptionReport, error) {
+	var reports []entity.PromoRedemptionReport
+	err := r.db.Model(&entity.PromoRedemption{}).
+		Select("promo_redemptions.*, users.name AS user_name, users.email AS user_email").
+		Joins("LEFT JOIN users ON users.id = promo_redemptions.user_id").
+		Where("promo_redemptions.promo_code_id = ?", promoID).
+		Order("promo_redemptions.created_at DESC").
+		Scan(&reports).Error
+
+	return reports, err
+}
+
+func (r *PromoRepository) FindByCode(code string) (*entity.PromoCode, error) {
+	var promo entity.PromoCode
+	err := r.db.Where("code = ?", code).First(&promo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPromoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &promo, nil
+}
+
+// Check проверяет, можно ли клиенту применить код к тарифу, ничего не меняя.
+// Для предпросмотра; при подключении то же проверяется под блокировкой в redeemPromo.
+func (r *PromoRepository) Check(code string, userID int, tariffID int64, now time.Time) (*entity.PromoCode, error) {
+	promo, err := r.FindByCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPromo(r.db, promo, userID, tariffID, now); err != nil {
+		return nil, err
+	}
+	return promo, nil
+}
+
+// ActiveFor - промокод, скидка по которому еще действует для клиента, или nil
+func (r *PromoRepository) ActiveFor(userID int) (*entity.PromoCode, error) {
+	var promo entity.PromoCode
+	err := r.db.Joins("JOIN promo_redemptions ON promo_redemptions.promo_code_id = promo_codes.id").
+		Where("promo_redemptions.user_id = ? AND promo_redemptions.status = ?", userID, entity.PromoRedemptionActive).
+		First(&promo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &promo, nil
+}
+
+// ApplicationCode - промокод из последней заявки на подключение с тем же телефоном.
+// Телефоны сравниваются только по цифрам, потому что в заявке и профиле их вводят по-разному.
+func (r *PromoRepository) ApplicationCode(phone string) (*app.Application, error) {
+	var application app.Application
+	err := r.db.Where("promo_code <> '' AND regexp_replace(phone, '\\D', '', 'g') = regexp_replace(?, '\\D', '', 'g')", phone).
+		Order("created_at DESC").
+		First(&application).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &application, nil
+}
+
+// checkPromo - правила применения кода клиентом
+func checkPromo(db *gorm.DB, promo *entity.PromoCode, userID int, tariffID int64, now time.Time) error {
+	if !promo.Redeemable(now) {
+		return ErrPromoUnavailable
+	}
+	if promo.LimitReached() {
+		return ErrPromoLimitReached
+	}
+	if !promo.AppliesTo(tariffID) {
+		return ErrPromoNotEligible
+	}
+
+	var redemptions []entity.PromoRedemption
+	if err := db.Where("user_id = ? AND (promo_code_id = ? OR status = ?)", userID, promo.ID, entity.PromoRedemptionActive).
+		Find(&redemptions).Error; err != nil {
+		return err
+	}
+	for _, redemption := range redemptions {
+		if redemption.PromoCodeID == promo.ID {
+			return ErrPromoAlreadyRedeemed
+		}
+	}
+	if len(redemptions) > 0 {
+		return ErrPromoActive
+	}
+
+	return nil
+}
+
+// redeemPromo применяет код клиентом. Код блокируется, чтобы параллельные подключения
+// не превысили лимит применений. Вызывается внутри транзакции.
+func redeemPromo(tx *gorm.DB, claim *entity.PromoClaim, userID int, tariffID int64, now time.Time) error {
+	var promo entity.PromoCode
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", claim.Code).First(&promo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrPromoNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := checkPromo(tx, &promo, userID, tariffID, now); err != nil {
+		return err
+	}
+
+	if err := tx.Model(&promo).Update("redeemed", gorm.Expr("redeemed + 1")).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&entity.PromoRedemption{
+		PromoCodeID:   promo.ID,
+		UserID:        userID,
+		Status:        entity.PromoRedemptionActive,
+		Source:        claim.Source,
+		ApplicationID: claim.ApplicationID,
+	}).Error
+}
+
+// periodDiscount - скидка клиента на период тарифа по действующему промокоду. Применение
+// блокируется до конца транзакции; засчитывается оно через useDiscount, только если период
+// действительно списан.
+func periodDiscount(tx *gorm.DB, userID int, tariff *entity.Tariff, amount entity.Kopecks) (*entity.PromoRedemption, entity.Kopecks, error) {
+	var redemption entity.PromoRedemption
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND status = ?", userID, entity.PromoRedemptionActive).
+		First(&redemption).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var promo entity.PromoCode
+	if err := tx.First(&promo, redemption.PromoCodeID).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// На тариф вне акции скидки нет, но периоды кода при этом не расходуются
+	if !promo.AppliesTo(tariff.ID) {
+		return nil, 0, nil
+	}
+
+	return &redemption, promo.Discount(amount), nil
+}
+
+// useDiscount засчитывает списанный со скидкой период. Когда периоды кода закончились,
+// применение завершается.
+func useDiscount(tx *gorm.DB, redemption *entity.PromoRedemption, discount entity.Kopecks) error {
+	var promo entity.PromoCode
+	if err := tx.First(&promo, redemption.PromoCodeID).Error; err != nil {
+		return err
+	}
+
+	redemption.PeriodsUsed++
+	redemption.TotalDiscount += discount
+	if promo.Periods > 0 && redemption.PeriodsUsed >= promo.Periods {
+		redemption.Status = entity.PromoRedemptionCompleted
+	}
+
+	return tx.Model(redemption).Updates(map[string]interface{}{
+		"periods_used":   redemption.PeriodsUsed,
+		"total_discount": redemption.TotalDiscount,
+		"status":         redemption.Status,
+	}).Error
+}
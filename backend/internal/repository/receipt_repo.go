@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrReceiptNotFailed = errors.New("only a failed receipt can be resent")
+
+// ReceiptPreparer готовит чек к попытке отправки: заполняет ставку НДС, кассу, счетчик
+// попыток и время следующей попытки. Вызывается под блокировкой чека.
+type ReceiptPreparer func(receipt *entity.Receipt)
+
+type ReceiptRepository struct {
+	db *gorm.DB
+}
+
+func NewReceiptRepository(db *gorm.DB) *ReceiptRepository {
+	return &ReceiptRepository{db: db}
+}
+
+// Due - чеки, которые пора отправить в кассу, в порядке постановки в очередь
+func (r *ReceiptRepository) Due(now time.Time, limit int) ([]int64, error) {
+	var ids []int64
+	err := r.db.Model(&entity.Receipt{}).
+		Where("status = ? AND next_attempt_at <= ?", entity.ReceiptPending, now).
+		Order("id ASC").
+		Limit(limit).
+		Pluck("id", &ids).Error
+
+	return ids, err
+}
+
+// Claim блокирует чек, готовит его через prepare и сохраняет попытку до обращения к кассе.
+// Если после ответа кассы сервер упадет или не сохранит результат, чек вернется в очередь
+// только в NextAttemptAt и уйдет в кассу с тем же ID, по которому касса отбросит повтор.
+// Чек, который уже обрабатывает другой экземпляр сервера или который уже не ждет отправки,
+// пропускается: claimed = false.
+func (r *ReceiptRepository) Claim(id int64, prepare ReceiptPreparer) (*entity.Receipt, bool, error) {
+	var receipt entity.Receipt
+	claimed := false
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ? AND status = ?", id, entity.ReceiptPending).
+			First(&receipt).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		prepare(&receipt)
+		claimed = true
+		return tx.Save(&receipt).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &receipt, claimed, nil
+}
+
+// SaveAttempt сохраняет результат отправки чека, полученного через Claim. Чек, который
+// уже не ждет отправки, не меняется.
+func (r *ReceiptRepository) SaveAttempt(receipt *entity.Receipt) error {
+	return r.db.Model(receipt).Where("status = ?", entity.ReceiptPending).Updates(map[string]interface{}{
+		"status":          receipt.Status,
+		"last_error":      receipt.LastError,
+		"next_attempt_at": receipt.NextAttemptAt,
+		"external_id":     receipt.ExternalID,
+		"fiscal_number":   receipt.FiscalNumber,
+		"fiscal_document": receipt.FiscalDocument,
+		"fiscal_sign":     receipt.FiscalSign,
+		"url":             receipt.URL,
+		"registered_at":   receipt.RegisteredAt,
+	}).Error
+}
+
+// Retry возвращает чек с исчерпанными попытками в очередь
+func (r *ReceiptRepository) Retry(id int64, now time.Time) (*entity.Receipt, error) {
+	var receipt entity.Receipt
+	if err := r.db.First(&receipt, id).Error; err != nil {
+		return nil, err
+	}
+	if receipt.Status != entity.ReceiptFailed {
+		return nil, ErrReceiptNotFailed
+	}
+
+	receipt.Status = entity.ReceiptPending
+	receipt.Attempts = 0
+	receipt.NextAttemptAt = now
+	err := r.db.Model(&receipt).Updates(map[string]interface{}{
+		"status":          receipt.Status,
+		"attempts":        receipt.Attempts,
+		"next_attempt_at": receipt.NextAttemptAt,
+	}).Error
+
+	return &receipt, err
+}
+
+func (r *ReceiptRepository) FindForUser(userID int, id int64) (*entity.Receipt, error) {
+	var receipt entity.Receipt
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&receipt).Error; err != nil {
+		return nil, err
+	}
+
+	return &receipt, nil
+}
+
+// List - чеки для админ-панели, новые первыми. Пустой status - все чеки.
+func (r *ReceiptRepository) List(status string, page, limit int) ([]entity.Receipt, int64, error) {
+	var receipts []entity.Receipt
+	var total int64
+
+	query := r.db.Model(&entity.Receipt{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Order("id DESC").Offset((page - 1) * limit).Limit(limit).Find(&receipts).Error
+	return receipts, total, err
+}
+
+// queueReceipt ставит в очередь чек по платежу. Вызывается в транзакции движения денег.
+// Ставка НДС и фискальные реквизиты заполняются при отправке в кассу.
+func queueReceipt(tx *gorm.DB, payment *entity.Payment, operation string, at time.Time) error {
+	var user entity.User
+	if err := tx.Select("email", "phone").First(&user, payment.UserID).Error; err != nil {
+		return err
+	}
+
+	contact := user.Email
+	if contact == "" {
+		contact = user.Phone
+	}
+
+	amount := payment.Amount
+	if amount < 0 {
+		amount = -amount
+	}
+
+	return tx.Create(&entity.Receipt{
+		PaymentID:     payment.ID,
+		UserID:        payment.UserID,
+		Operation:     operation,
+		Item:          entity.ReceiptItemTopUp,
+		Amount:        amount,
+		Method:        entity.ReceiptMethodAdvance,
+		Subject:       entity.ReceiptSubjectPayment,
+		PaymentType:   entity.ReceiptPaymentElectronic,
+		Contact:       contact,
+		Status:        entity.ReceiptPending,
+		NextAttemptAt: at,
+	}).Error
+}
@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"errors"
+	"internet_provider/internal/app"
+	"internet_provider/internal/entity"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReferralRepository struct {
+	db *gorm.DB
+}
+
+func NewReferralRepository(db *gorm.DB) *ReferralRepository {
+	return &ReferralRepository{db: db}
+}
+
+// FindReferrer - владелец реферального кода или nil
+func (r *ReferralRepository) FindReferrer(code string) (*entity.User, error) {
+	var user entity.User
+	err := r.db.Where("referral_code = ?", code).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Create записывает приглашение. Самоприглашение и повтор по телефону или адресу
+// сохраняются со статусом rejected, чтобы они были видны в отчете, но бонуса не получат.
+func (r *ReferralRepository) Create(referral *entity.Referral, referrer *entity.User) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Приглашения одного телефона проверяются по очереди, иначе два параллельных
+		// запроса не увидят друг друга
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "referral:"+referral.Phone).Error; err != nil {
+			return err
+		}
+
+		reason, err := referralRejectReason(tx, referral, referrer)
+		if err != nil {
+			return err
+		}
+
+		referral.Status = entity.ReferralPending
+		if reason != "" {
+			referral.Status = entity.ReferralRejected
+			referral.RejectReason = reason
+		}
+		if err := tx.Create(referral).Error; err != nil {
+			return err
+		}
+
+		if referral.Status == entity.ReferralPending && referral.ReferredID != nil {
+			return tx.Model(&entity.User{}).Where("id = ?", *referral.ReferredID).Update("referred_by", referral.ReferrerID).Error
+		}
+		return nil
+	})
+}
+
+// LinkApplication привязывает к только что зарегистрированному клиенту приглашение из заявки
+// с тем же телефоном. nil - такого приглашения нет.
+func (r *ReferralRepository) LinkApplication(user *entity.User) (*entity.Referral, error) {
+	var referral entity.Referral
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("phone = ? AND referred_id IS NULL AND status = ?", entity.NormalizePhone(user.Phone), entity.ReferralPending).
+			Order("created_at ASC").
+			First(&referral).Error
+		if err != nil {
+			return err
+		}
+
+		referral.ReferredID = &user.Id
+		if err := tx.Model(&referral).Update("referred_id", user.Id).Error; err != nil {
+			return err
+		}
+		return tx.Model(&entity.User{}).Where("id = ?", user.Id).Update("referred_by", referral.ReferrerID).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &referral, nil
+}
+
+// Stats - сколько клиент пригласил и сколько бонусов получил
+func (r *ReferralRepository) Stats(userID int) (*entity.ReferralStats, error) {
+	var stats entity.ReferralStats
+	err := r.db.Model(&entity.Referral{}).
+		Select("COUNT(*) AS invited, "+
+			"COUNT(*) FILTER (WHERE status = ?) AS rewarded, "+
+			"COALESCE(SUM(referrer_reward), 0) AS earned", entity.ReferralRewarded).
+		Where("referrer_id = ? AND status <> ?", userID, entity.ReferralRejected).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// Report - приглашения для админ-панели, новые первыми. Пустой status - все.
+func (r *ReferralRepository) Report(status string, page, limit int) ([]entity.ReferralReport, int64, error) {
+	var reports []entity.ReferralReport
+	var total int64
+
+	query := r.db.Model(&entity.Referral{})
+	if status != "" {
+		query = query.Where("referrals.status = ?", status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.
+		Select("referrals.*, referrer.name AS referrer_name, referrer.email AS referrer_email, " +
+			"referred.name AS referred_name, referred.email AS referred_email").
+		Joins("LEFT JOIN users AS referrer ON referrer.id = referrals.referrer_id").
+		Joins("LEFT JOIN users AS referred ON referred.id = referrals.referred_id").
+		Order("referrals.id DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Scan(&reports).Error
+
+	return reports, total, err
+}
+
+func (r *ReferralRepository) Summary() (*entity.ReferralSummary, error) {
+	var summary entity.ReferralSummary
+	err := r.db.Model(&entity.Referral{}).
+		Select("COUNT(*) AS total, "+
+			"COUNT(*) FILTER (WHERE status = ?) AS pending, "+
+			"COUNT(*) FILTER (WHERE status = ?) AS rewarded, "+
+			"COUNT(*) FILTER (WHERE status = ?) AS rejected, "+
+			"COALESCE(SUM(referrer_reward + referred_reward), 0) AS paid_out",
+			entity.ReferralPending, entity.ReferralRewarded, entity.ReferralRejected).
+		Scan(&summary).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+// referralRejectReason проверяет приглашение и заодно привязывает приглашение из заявки
+// к уже зарегистрированному клиенту с тем же телефоном. Пустая строка - приглашение честное.
+func referralRejectReason(tx *gorm.DB, referral *entity.Referral, referrer *entity.User) (string, error) {
+	if referral.ReferredID != nil && *referral.ReferredID == referrer.Id {
+		return entity.ReferralSelf, nil
+	}
+	if entity.NormalizePhone(referrer.Phone) == referral.Phone {
+		return entity.ReferralSelf, nil
+	}
+
+	if referral.Address != "" {
+		// Свой адрес клиент указывал в заявках со своим телефоном
+		var addresses []string
+		err := tx.Model(&app.Application{}).
+			Where(phoneSQL("phone")+" = ?", entity.NormalizePhone(referrer.Phone)).
+			Pluck("address", &addresses).Error
+		if err != nil {
+			return "", err
+		}
+		for _, address := range addresses {
+			if entity.NormalizeAddress(address) == referral.Address {
+				return entity.ReferralSelf, nil
+			}
+		}
+	}
+
+	var count int64
+	if err := tx.Model(&entity.Referral{}).
+		Where("phone = ? AND status <> ?", referral.Phone, entity.ReferralRejected).
+		Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return entity.ReferralDuplicatePhone, nil
+	}
+
+	if referral.Address != "" {
+		if err := tx.Model(&entity.Referral{}).
+			Where("address = ? AND status <> ?", referral.Address, entity.ReferralRejected).
+			Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return entity.ReferralDuplicateAddress, nil
+		}
+	}
+
+	var customers []entity.User
+	query := tx.Where(phoneSQL("phone")+" = ?", referral.Phone).Order("id ASC")
+	if referral.ReferredID != nil {
+		query = query.Where("id <> ?", *referral.ReferredID)
+	}
+	if err := query.Find(&customers).Error; err != nil {
+		return "", err
+	}
+	if len(customers) == 0 {
+		return "", nil
+	}
+
+	// Новый аккаунт на телефон, который уже есть у другого клиента
+	if referral.ReferredID != nil {
+		return entity.ReferralDuplicatePhone, nil
+	}
+
+	// Заявку оставил уже зарегистрированный клиент: подключенного пригласить нельзя,
+	// а еще не подключенного приглашение сразу находит
+	customer := customers[0]
+	if err := tx.Model(&entity.Subscription{}).Where("user_id = ?", customer.Id).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return entity.ReferralExistingCustomer, nil
+	}
+	referral.ReferredID = &customer.Id
+
+	return "", nil
+}
+
+// rewardReferral начисляет бонусы обеим сторонам, когда приглашенный клиент впервые оплатил
+// период тарифа. Вызывается в транзакции списания.
+func rewardReferral(tx *gorm.DB, userID int, at time.Time) error {
+	var referral entity.Referral
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("referred_id = ? AND status = ?", userID, entity.ReferralPending).
+		First(&referral).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	referrerReward, err := settingKopecks(tx, entity.SettingReferrerReward, entity.DefaultReferrerReward)
+	if err != nil {
+		return err
+	}
+	referredReward, err := settingKopecks(tx, entity.SettingReferredReward, entity.DefaultReferredReward)
+	if err != nil {
+		return err
+	}
+
+	reference := "referral:" + strconv.FormatInt(referral.ID, 10)
+	rewards := []entity.LedgerPosting{
+		{UserID: referral.ReferrerID, Amount: referrerReward, Description: "Бонус за приглашенного клиента"},
+		{UserID: userID, Amount: referredReward, Description: "Бонус за подключение по приглашению"},
+	}
+	for _, reward := range rewards {
+		if reward.Amount <= 0 {
+			continue
+		}
+		reward.Type = entity.LedgerReferral
+		reward.Counter = entity.AccountReferrals
+		reward.Reference = reference
+		if _, _, err := postLedger(tx, reward, at); err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&referral).Updates(map[string]interface{}{
+		"status":          entity.ReferralRewarded,
+		"referrer_reward": referrerReward,
+		"referred_reward": referredReward,
+		"rewarded_at":     at,
+	}).Error
+}
+
+// settingKopecks читает денежную настройку в транзакции
+func settingKopecks(tx *gorm.DB, key string, def entity.Kopecks) (entity.Kopecks, error) {
+	var setting entity.Setting
+	err := tx.Where("key = ?", key).First(&setting).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return def, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseInt(setting.Value, 10, 64)
+	return entity.Kopecks(value), err
+}
+
+func phoneSQL(column string) string {
+	return "right(regexp_replace(" + column + ", '\\D', '', 'g'), 10)"
+}
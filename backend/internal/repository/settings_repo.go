@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SettingsRepository struct {
+	db *gorm.DB
+}
+
+func NewSettingsRepository(db *gorm.DB) *SettingsRepository {
+	return &SettingsRepository{db: db}
+}
+
+// Get возвращает значение настройки или def, если она еще не задана
+func (r *SettingsRepository) Get(key, def string) (string, error) {
+	var setting entity.Setting
+	err := r.db.Where("key = ?", key).First(&setting).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return def, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return setting.Value, nil
+}
+
+func (r *SettingsRepository) GetBool(key string, def bool) (bool, error) {
+	value, err := r.Get(key, strconv.FormatBool(def))
+	if err != nil {
+		return false, err
+	}
+
+	return strconv.ParseBool(value)
+}
+
+func (r *SettingsRepository) GetInt(key string, def int) (int, error) {
+	value, err := r.Get(key, strconv.Itoa(def))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(value)
+}
+
+func (r *SettingsRepository) Set(key, value string, adminID int64) error {
+	setting := entity.Setting{
+		Key:       key,
+		Value:     value,
+		UpdatedBy: &adminID,
+	}
+
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_by", "updated_at"}),
+	}).Create(&setting).Error
+}
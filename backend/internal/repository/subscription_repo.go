@@ -0,0 +1,579 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"internet_provider/internal/entity"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSubscriptionActive  = errors.New("subscription is already paid for the current period")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrSubscriptionFrozen  = errors.New("subscription is frozen")
+)
+
+// SubscriptionRepository меняет подписки и баланс в транзакциях с блокировкой строк.
+// Все такие транзакции блокируют сначала клиента (users), потом его подписку, иначе
+// биллинг и запросы клиента, взявшие блокировки в разном порядке, ждали бы друг друга.
+type SubscriptionRepository struct {
+	db *gorm.DB
+}
+
+func NewSubscriptionRepository(db *gorm.DB) *SubscriptionRepository {
+	return &SubscriptionRepository{db: db}
+}
+
+// FindByUserID возвращает nil без ошибки, если клиент еще не подключал тариф
+func (r *SubscriptionRepository) FindByUserID(userID int) (*entity.Subscription, error) {
+	var sub entity.Subscription
+	err := r.db.Where("user_id = ?", userID).First(&sub).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &sub, nil
+}
+
+// Activate подключает тариф клиенту без оплаченного периода: проверяет баланс, списывает первый
+// период через журнал, открывает подписку и проставляет тариф клиенту. Все в одной транзакции
+// под блокировкой строки клиента, поэтому параллельные запросы не спишут деньги дважды,
+// а при любой ошибке не останется списания без тарифа. Промокод claim, если передан,
+// применяется в той же транзакции, и скидка по нему действует уже на первый период.
+// Услуги, оставшиеся подключенными с прошлой подписки, оплачиваются вместе с тарифом.
+func (r *SubscriptionRepository) Activate(userID int, tariff *entity.Tariff, start time.Time, claim *entity.PromoClaim) (*entity.TariffActivation, error) {
+	price := tariff.PeriodPrice(start)
+	activation := &entity.TariffActivation{Price: price}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var user entity.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		activation.BalanceBefore = user.Balance
+		activation.PreviousTariffID = user.TariffID
+
+		var previous entity.Subscription
+		var fromTariffID *int64
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&previous).Error
+		switch {
+		case err == nil:
+			// Замороженная подписка сохраняет оплаченные дни, подключение заново их бы потеряло
+			if previous.Status == entity.SubscriptionFrozen {
+				return ErrSubscriptionFrozen
+			}
+			// Пока период оплачен, тариф меняется через ChangeTariff с перерасчетом
+			if previous.Status == entity.SubscriptionActive && previous.PaidUntil.After(start) {
+				return ErrSubscriptionActive
+			}
+			fromTariffID = &previous.TariffID
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+
+		if claim != nil {
+			if err := redeemPromo(tx, claim, userID, tariff.ID, start); err != nil {
+				return err
+			}
+		}
+
+		redemption, discount, err := periodDiscount(tx, userID, tariff, price)
+		if err != nil {
+			return err
+		}
+		activation.Price = price - discount
+		activation.Discount = discount
+
+		addons, err := activeAddons(tx, userID)
+		if err != nil {
+			return err
+		}
+		activation.Addons = addonsPrice(addons, tariff, start)
+
+		if user.Balance < activation.Price+activation.Addons {
+			return ErrInsufficientBalance
+		}
+
+		sub := entity.Subscription{
+			UserID:         userID,
+			TariffID:       tariff.ID,
+			Status:         entity.SubscriptionActive,
+			PeriodStart:    start,
+			PaidUntil:      tariff.PeriodEnd(start),
+			AnchorDay:      start.Day(),
+			PeriodDiscount: discount,
+		}
+		err = tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"tariff_id":       sub.TariffID,
+				"next_tariff_id":  nil,
+				"status":          sub.Status,
+				"period_start":    sub.PeriodStart,
+				"paid_until":      sub.PaidUntil,
+				"anchor_day":      sub.AnchorDay,
+				"period_discount": sub.PeriodDiscount,
+				"suspended_at":    nil,
+				"updated_at":      time.Now(),
+			}),
+		}).Create(&sub).Error
+		if err != nil {
+			return err
+		}
+
+		// При upsert gorm не всегда возвращает ID существующей строки
+		if err := tx.Where("user_id = ?", userID).First(&sub).Error; err != nil {
+			return err
+		}
+
+		charge := entity.SubscriptionCharge{
+			SubscriptionID: sub.ID,
+			UserID:         userID,
+			TariffID:       tariff.ID,
+			Amount:         activation.Price,
+			Discount:       discount,
+			PeriodStart:    sub.PeriodStart,
+			PeriodEnd:      sub.PaidUntil,
+		}
+		if redemption != nil {
+			charge.PromoRedemptionID = &redemption.ID
+		}
+		if err := tx.Create(&charge).Error; err != nil {
+			return err
+		}
+		if redemption != nil {
+			if err := useDiscount(tx, redemption, discount); err != nil {
+				return err
+			}
+		}
+
+		balance, err := postCharge(tx, &charge, tariff)
+		if err != nil {
+			return err
+		}
+		addonsCharged, err := chargeAddons(tx, addons, tariff, &charge)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&entity.User{}).Where("id = ?", userID).Update("tariff_id", tariff.ID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(&entity.TariffHistory{
+			UserID:         userID,
+			SubscriptionID: sub.ID,
+			FromTariffID:   fromTariffID,
+			ToTariffID:     tariff.ID,
+			Kind:           entity.TariffChangeActivation,
+			Charge:         charge.Amount,
+			EffectiveAt:    start,
+		}).Error; err != nil {
+			return err
+		}
+
+		activation.Subscription = &sub
+		activation.Charge = &charge
+		activation.BalanceAfter = balance - addonsCharged
+		return nil
+	})
+	if err != nil {
+		return activation, err
+	}
+
+	return activation, nil
+}
+
+// TariffChangeQuoter считает смену тарифа по заблокированным подписке и балансу.
+// Ошибка отменяет смену, quote при этом все равно возвращается вызывающему.
+type TariffChangeQuoter func(sub *entity.Subscription, current *entity.Tariff, balance entity.Kopecks, now time.Time) (*entity.TariffChangeQuote, error)
+
+// ChangeTariff меняет тариф активной подписки под блокировкой клиента и подписки, чтобы расчет
+// и списание опирались на одни и те же данные. Каждая смена записывается в историю.
+// Если при повышении меняется конец оплаченного периода, подключенные услуги пересчитываются
+// на новый период, и доплата за них входит в AmountDue.
+func (r *SubscriptionRepository) ChangeTariff(userID int, target *entity.Tariff, now time.Time, quoter TariffChangeQuoter) (*entity.TariffChangeQuote, error) {
+	var quote *entity.TariffChangeQuote
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var user entity.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		var sub entity.Subscription
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&sub).Error; err != nil {
+			return err
+		}
+
+		var current entity.Tariff
+		if err := tx.First(&current, sub.TariffID).Error; err != nil {
+			return err
+		}
+
+		var err error
+		quote, err = quoter(&sub, &current, user.Balance, now)
+		if err != nil {
+			return err
+		}
+
+		history := entity.TariffHistory{
+			UserID:         userID,
+			SubscriptionID: sub.ID,
+			FromTariffID:   &sub.TariffID,
+			ToTariffID:     target.ID,
+			Kind:           quote.Kind,
+			Credit:         quote.Credit,
+			Charge:         quote.Charge,
+			EffectiveAt:    quote.EffectiveAt,
+		}
+
+		switch quote.Kind {
+		case entity.TariffChangeUpgrade:
+			if !quote.PaidUntil.Equal(sub.PaidUntil) {
+				addons, err := activeAddons(tx, userID)
+				if err != nil {
+					return err
+				}
+				delta, err := resizeAddons(tx, addons, target, sub.PaidUntil, quote.PeriodStart, quote.PaidUntil, now)
+				if err != nil {
+					return err
+				}
+				quote.AmountDue += delta
+				quote.BalanceAfter -= delta
+				if quote.BalanceAfter < 0 && delta > 0 {
+					quote.EnoughFunds = false
+					return ErrInsufficientBalance
+				}
+			}
+
+			// Новый период, начатый при смене периодичности, отсчитывается от дня смены
+			anchorDay := sub.AnchorDay
+			if !quote.PeriodStart.Equal(sub.PeriodStart) {
+				anchorDay = quote.PeriodStart.Day()
+			}
+			if err := tx.Model(&sub).Updates(map[string]interface{}{
+				"tariff_id":      target.ID,
+				"next_tariff_id": nil,
+				"period_start":   quote.PeriodStart,
+				"paid_until":     quote.PaidUntil,
+				"anchor_day":     anchorDay,
+				// Доплата за новый тариф идет без скидки, поэтому и возвращать при следующем перерасчете нечего
+				"period_discount": 0,
+			}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&entity.User{}).Where("id = ?", userID).Update("tariff_id", target.ID).Error; err != nil {
+				return err
+			}
+
+			// Возврат за остаток периода и новое списание - две операции, чтобы в выписке было видно обе суммы.
+			// Доплата заводится как обычное списание за тариф, поэтому ее видно в списаниях и можно вернуть.
+			if quote.Credit > 0 {
+				if _, _, err := postLedger(tx, entity.LedgerPosting{
+					Type:        entity.LedgerRefund,
+					UserID:      userID,
+					Amount:      quote.Credit,
+					Counter:     entity.AccountRevenue,
+					Reference:   "tariff_change:" + strconv.FormatInt(sub.ID, 10),
+					Description: fmt.Sprintf("Возврат за неиспользованные дни тарифа «%s»", current.Name),
+				}, now); err != nil {
+					return err
+				}
+			}
+			if quote.Charge > 0 {
+				charge := entity.SubscriptionCharge{
+					SubscriptionID: sub.ID,
+					UserID:         userID,
+					TariffID:       target.ID,
+					Amount:         quote.Charge,
+					PeriodStart:    now,
+					PeriodEnd:      quote.PaidUntil,
+				}
+				if err := tx.Create(&charge).Error; err != nil {
+					return err
+				}
+				if _, err := postCharge(tx, &charge, target); err != nil {
+					return err
+				}
+			}
+		case entity.TariffChangeDowngrade:
+			if err := tx.Model(&sub).Update("next_tariff_id", target.ID).Error; err != nil {
+				return err
+			}
+		case entity.TariffChangeDowngradeCancelled:
+			history.FromTariffID = sub.NextTariffID
+			if err := tx.Model(&sub).Update("next_tariff_id", nil).Error; err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected tariff change kind %q", quote.Kind)
+		}
+
+		return tx.Create(&history).Error
+	})
+
+	return quote, err
+}
+
+func (r *SubscriptionRepository) ListHistory(userID int) ([]entity.TariffHistory, error) {
+	var history []entity.TariffHistory
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&history).Error
+
+	return history, err
+}
+
+// ListDue - подписки, которые пора продлить или закрыть за просроченный обещанный платеж,
+// и приостановленные, у которых появились деньги
+func (r *SubscriptionRepository) ListDue(now time.Time, afterID int64, limit int) ([]int64, error) {
+	overdue := r.db.Model(&entity.PromisedPayment{}).Select("1").
+		Where("promised_payments.user_id = subscriptions.user_id AND promised_payments.status = ? AND promised_payments.due_at <= ?",
+			entity.PromiseActive, now)
+
+	var ids []int64
+	err := r.db.Model(&entity.Subscription{}).
+		Joins("JOIN users ON users.id = subscriptions.user_id").
+		Where("subscriptions.id > ?", afterID).
+		Where("(subscriptions.status = ? AND (subscriptions.paid_until <= ? OR EXISTS (?))) OR (subscriptions.status = ? AND users.balance > 0 AND NOT EXISTS (?))",
+			entity.SubscriptionActive, now, overdue, entity.SubscriptionSuspended, overdue).
+		Order("subscriptions.id ASC").
+		Limit(limit).
+		Pluck("subscriptions.id", &ids).Error
+
+	return ids, err
+}
+
+// Bill списывает все наступившие периоды подписки в одной транзакции под блокировкой клиента
+// и подписки. Если денег не хватает, подписка приостанавливается. Повторный вызов для уже
+// оплаченного периода ничего не меняет, поэтому биллинг можно безопасно перезапускать.
+// Запланированное понижение тарифа применяется перед списанием нового периода. Пока обещанный
+// платеж просрочен, доступ закрыт независимо от оплаченного периода. Замороженную подписку
+// биллинг не трогает, пока ее не разморозят.
+func (r *SubscriptionRepository) Bill(subscriptionID int64, now time.Time) (string, *entity.Subscription, []entity.SubscriptionCharge, *entity.TariffHistory, error) {
+	outcome := entity.BillingSkipped
+	var sub entity.Subscription
+	var charges []entity.SubscriptionCharge
+	var change *entity.TariffHistory
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Клиент подписки не меняется, поэтому его можно узнать до блокировок
+		var owner entity.Subscription
+		if err := tx.Select("user_id").First(&owner, subscriptionID).Error; err != nil {
+			return err
+		}
+
+		var user entity.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, owner.UserID).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sub, subscriptionID).Error; err != nil {
+			return err
+		}
+		if sub.Status == entity.SubscriptionFrozen {
+			return nil
+		}
+
+		overdue, err := hasOverduePromise(tx, sub.UserID, now)
+		if err != nil {
+			return err
+		}
+		if overdue {
+			if sub.Status != entity.SubscriptionActive {
+				return nil
+			}
+			sub.Status = entity.SubscriptionSuspended
+			sub.SuspendedAt = &now
+			outcome = entity.BillingSuspended
+			return tx.Save(&sub).Error
+		}
+
+		// Другой запуск уже продлил подписку
+		if sub.Status == entity.SubscriptionActive && sub.PaidUntil.After(now) {
+			return nil
+		}
+
+		wasSuspended := sub.Status == entity.SubscriptionSuspended
+
+		// Пока доступ был приостановлен, услуга не оказывалась: новый период начинается сейчас.
+		// Если доступ закрывали за просроченный обещанный платеж, оплаченный период продолжается.
+		start := sub.PaidUntil
+		if wasSuspended && !sub.PaidUntil.After(now) {
+			start = now
+			sub.AnchorDay = now.Day()
+		}
+
+		if sub.NextTariffID != nil {
+			var previous, next entity.Tariff
+			if err := tx.First(&previous, sub.TariffID).Error; err != nil {
+				return err
+			}
+			if err := tx.First(&next, *sub.NextTariffID).Error; err != nil {
+				return err
+			}
+			// При смене периодичности месяцы отсчитываются заново от начала нового тарифа
+			if previous.BillingPeriod != next.BillingPeriod {
+				sub.AnchorDay = start.Day()
+			}
+
+			change = &entity.TariffHistory{
+				UserID:         sub.UserID,
+				SubscriptionID: sub.ID,
+				FromTariffID:   &sub.TariffID,
+				ToTariffID:     *sub.NextTariffID,
+				Kind:           entity.TariffChangeDowngradeApplied,
+				EffectiveAt:    start,
+			}
+			if err := tx.Create(change).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&entity.User{}).Where("id = ?", user.Id).Update("tariff_id", *sub.NextTariffID).Error; err != nil {
+				return err
+			}
+
+			sub.TariffID = *sub.NextTariffID
+			sub.NextTariffID = nil
+		}
+
+		var tariff entity.Tariff
+		if err := tx.First(&tariff, sub.TariffID).Error; err != nil {
+			return err
+		}
+
+		addons, err := activeAddons(tx, sub.UserID)
+		if err != nil {
+			return err
+		}
+
+		balance := user.Balance
+
+		for !start.After(now) {
+			redemption, discount, err := periodDiscount(tx, sub.UserID, &tariff, tariff.PeriodPrice(start))
+			if err != nil {
+				return err
+			}
+
+			// Услуги оплачиваются вместе с тарифом: не хватает на все - период не продлевается
+			amount := tariff.PeriodPrice(start) - discount
+			if balance < amount+addonsPrice(addons, &tariff, start) {
+				break
+			}
+
+			charge := entity.SubscriptionCharge{
+				SubscriptionID: sub.ID,
+				UserID:         sub.UserID,
+				TariffID:       tariff.ID,
+				Amount:         amount,
+				Discount:       discount,
+				PeriodStart:    start,
+				PeriodEnd:      tariff.PeriodEndOn(start, sub.AnchorDay),
+			}
+			if redemption != nil {
+				charge.PromoRedemptionID = &redemption.ID
+			}
+
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&charge)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 1 {
+				if _, err := postCharge(tx, &charge, &tariff); err != nil {
+					return err
+				}
+				addonsCharged, err := chargeAddons(tx, addons, &tariff, &charge)
+				if err != nil {
+					return err
+				}
+				if redemption != nil {
+					if err := useDiscount(tx, redemption, discount); err != nil {
+						return err
+					}
+				}
+				balance -= amount + addonsCharged
+				charges = append(charges, charge)
+				sub.PeriodDiscount = discount
+			}
+
+			sub.PeriodStart = charge.PeriodStart
+			sub.PaidUntil = charge.PeriodEnd
+			start = charge.PeriodEnd
+		}
+
+		switch {
+		case !sub.PaidUntil.After(now) && !wasSuspended:
+			sub.Status = entity.SubscriptionSuspended
+			sub.SuspendedAt = &now
+			outcome = entity.BillingSuspended
+		case sub.PaidUntil.After(now) && wasSuspended:
+			sub.Status = entity.SubscriptionActive
+			sub.SuspendedAt = nil
+			outcome = entity.BillingResumed
+		case len(charges) > 0:
+			outcome = entity.BillingCharged
+		}
+
+		return tx.Save(&sub).Error
+	})
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+
+	return outcome, &sub, charges, change, nil
+}
+
+// postCharge списывает с баланса оплату периода через журнал и возвращает новый баланс
+func postCharge(tx *gorm.DB, charge *entity.SubscriptionCharge, tariff *entity.Tariff) (entity.Kopecks, error) {
+	description := fmt.Sprintf("Тариф «%s» с %s по %s", tariff.Name,
+		charge.PeriodStart.Format("02.01.2006"), charge.PeriodEnd.Format("02.01.2006"))
+	if charge.Discount > 0 {
+		description += fmt.Sprintf(", скидка %s ₽ по промокоду", charge.Discount)
+	}
+
+	_, balance, err := postLedger(tx, entity.LedgerPosting{
+		Type:        entity.LedgerTariffCharge,
+		UserID:      charge.UserID,
+		Amount:      -charge.Amount,
+		Counter:     entity.AccountRevenue,
+		Reference:   "charge:" + strconv.FormatInt(charge.ID, 10),
+		Description: description,
+	}, charge.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	// Первая оплаченная клиентом услуга засчитывает приглашение. Бонус приходит отдельной
+	// операцией, а вызывающему возвращается баланс сразу после списания.
+	if charge.Amount > 0 {
+		if err := rewardReferral(tx, charge.UserID, charge.CreatedAt); err != nil {
+			return 0, err
+		}
+	}
+	return balance, nil
+}
+
+// BackfillLegacy заводит подписки клиентам, которые подключили тариф до появления биллинга.
+// Они уже заплатили за тариф один раз, поэтому первый период считается оплаченным.
+func (r *SubscriptionRepository) BackfillLegacy(now time.Time) (int64, error) {
+	result := r.db.Exec(`
+		INSERT INTO subscriptions (user_id, tariff_id, status, period_start, paid_until, created_at, updated_at)
+		SELECT users.id, users.tariff_id, ?, ?, ?::timestamptz + INTERVAL '1 month', ?, ?
+		FROM users
+		WHERE users.tariff_id IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM subscriptions WHERE subscriptions.user_id = users.id)`,
+		entity.SubscriptionActive, now, now, now, now)
+
+	return result.RowsAffected, result.Error
+}
+
+func (r *SubscriptionRepository) ListCharges(userID int, limit int) ([]entity.SubscriptionCharge, error) {
+	var charges []entity.SubscriptionCharge
+	err := r.db.Where("user_id = ?", userID).Order("period_start DESC").Limit(limit).Find(&charges).Error
+
+	return charges, err
+}
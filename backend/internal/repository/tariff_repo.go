@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TariffRepository struct {
+	db *gorm.DB
+}
+
+func NewTariffRepository(db *gorm.DB) *TariffRepository {
+	return &TariffRepository{db: db}
+}
+
+// ListPublic - тарифы, которые видят клиенты на сайте и в личном кабинете
+func (r *TariffRepository) ListPublic() ([]entity.Tariff, error) {
+	var tariffs []entity.Tariff
+	err := r.db.Where("is_visible = ? AND archived_at IS NULL", true).
+		Order("sort_order ASC, id ASC").
+		Find(&tariffs).Error
+
+	return tariffs, err
+}
+
+func (r *TariffRepository) ListAll(includeArchived bool) ([]entity.Tariff, error) {
+	var tariffs []entity.Tariff
+
+	query := r.db.Model(&entity.Tariff{})
+	if !includeArchived {
+		query = query.Where("archived_at IS NULL")
+	}
+
+	err := query.Order("sort_order ASC, id ASC").Find(&tariffs).Error
+	return tariffs, err
+}
+
+func (r *TariffRepository) FindByID(id int64) (*entity.Tariff, error) {
+	var tariff entity.Tariff
+	if err := r.db.First(&tariff, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &tariff, nil
+}
+
+// Create ставит новый тариф в конец списка
+func (r *TariffRepository) Create(tariff *entity.Tariff) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var maxOrder int
+		if err := tx.Model(&entity.Tariff{}).Select("COALESCE(MAX(sort_order), 0)").Scan(&maxOrder).Error; err != nil {
+			return err
+		}
+
+		// gorm не пишет false в поле с default:true и подставит true из RETURNING,
+		// поэтому скрытый тариф сохраняем отдельным запросом
+		visible := tariff.IsVisible
+		tariff.SortOrder = maxOrder + 1
+		if err := tx.Create(tariff).Error; err != nil {
+			return err
+		}
+
+		if !visible {
+			return tx.Model(tariff).Update("is_visible", false).Error
+		}
+		return nil
+	})
+}
+
+func (r *TariffRepository) Update(tariff *entity.Tariff) error {
+	return r.db.Save(tariff).Error
+}
+
+func (r *TariffRepository) SetArchived(id int64, archived bool) (bool, error) {
+	var archivedAt *time.Time
+	if archived {
+		now := time.Now()
+		archivedAt = &now
+	}
+
+	result := r.db.Model(&entity.Tariff{}).Where("id = ?", id).Update("archived_at", archivedAt)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Reorder проставляет sort_order по порядку ids. Тарифы, которых нет в списке, остаются после них.
+func (r *TariffRepository) Reorder(ids []int64) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&entity.Tariff{}).Where("id NOT IN ?", ids).
+			Update("sort_order", gorm.Expr("sort_order + ?", len(ids))).Error; err != nil {
+			return err
+		}
+
+		for i, id := range ids {
+			if err := tx.Model(&entity.Tariff{}).Where("id = ?", id).Update("sort_order", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *TariffRepository) CountByIDs(ids []int64) (int64, error) {
+	var count int64
+	err := r.db.Model(&entity.Tariff{}).Where("id IN ?", ids).Count(&count).Error
+	return count, err
+}
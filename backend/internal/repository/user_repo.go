@@ -1,10 +1,9 @@
 package repository
 
 import (
-	"errors"
+	crand "crypto/rand"
 	"fmt"
 	"internet_provider/internal/entity"
-	"log"
 	"math/rand"
 	"time"
 
@@ -21,9 +20,34 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 
 func (r *UserRepository) Create(user *entity.User) error {
 	user.AccountNumber = generateAccountNumber()
+	code, err := generateReferralCode()
+	if err != nil {
+		return err
+	}
+	user.ReferralCode = code
 	return r.db.Create(user).Error
 }
 
+// BackfillReferralCodes выдает реферальные коды клиентам, зарегистрированным до реферальной программы
+func (r *UserRepository) BackfillReferralCodes() (int, error) {
+	var ids []int
+	if err := r.db.Model(&entity.User{}).Where("referral_code IS NULL OR referral_code = ''").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		code, err := generateReferralCode()
+		if err != nil {
+			return 0, err
+		}
+		if err := r.db.Model(&entity.User{}).Where("id = ?", id).Update("referral_code", code).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), nil
+}
+
 func (r *UserRepository) FindByEmail(email string) (*entity.User, error) {
 	var user entity.User
 	err := r.db.Where("email = ?", email).First(&user).Error
@@ -45,50 +69,12 @@ func (r *UserRepository) GetUserByID(UserID int64) (*entity.User, error) {
 	result := r.db.Where("id = ?", UserID).First(&user)
 
 	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, result.Error
-		}
+		return nil, result.Error
 	}
 
 	return &user, nil
 }
 
-func (r *UserRepository) GetTariffByID(TariffID int64) (*entity.Tariff, error) {
-	tariffs := map[int64]entity.Tariff{
-		1: {ID: 1, Name: "Базовый 50 Мбит/с", Price: 300, Speed: 50},
-		2: {ID: 2, Name: "Оптимальный 100 Мбит/с", Price: 500, Speed: 100},
-		3: {ID: 3, Name: "Премиум 200 Мбит/с", Price: 800, Speed: 200},
-	}
-
-	tariff, exists := tariffs[TariffID]
-	if !exists {
-		return nil, fmt.Errorf("tariff not found")
-	}
-
-	return &tariff, nil
-}
-
-func (r *UserRepository) SetTariff(userID, tariffID int64) error {
-	log.Printf("SetTariff: userID=%d, tariffID=%d", userID, tariffID)
-
-	result := r.db.Model(&entity.User{}).
-		Where("id = ?", userID).
-		Update("tariff_id", tariffID)
-
-	if result.Error != nil {
-		log.Printf("Ошибка SetTariff: %v", result.Error)
-		return result.Error
-	}
-
-	if result.RowsAffected == 0 {
-		log.Printf("SetTariff: пользователь не найден")
-		return fmt.Errorf("user not found")
-	}
-
-	log.Printf("SetTariff: успешно обновлено %d записей", result.RowsAffected)
-	return nil
-}
-
 func (r *UserRepository) LoadTariff(user *entity.User, UserID string) error {
 	result := r.db.First(user, UserID)
 
@@ -103,23 +89,48 @@ func (r *UserRepository) LoadTariff(user *entity.User, UserID string) error {
 	return nil
 }
 
-func (r *UserRepository) UpdateBalance(userID int64, amount float64) error {
-	result := r.db.Model(&entity.User{}).
-		Where("id = ?", userID).
-		Update("balance", gorm.Expr("balance + ?", amount))
+func generateAccountNumber() string {
+	rand.Seed(time.Now().UnixNano())
+	return fmt.Sprintf("NL%08d", rand.Intn(100000000))
+}
 
-	if result.Error != nil {
-		return result.Error
-	}
+// referralAlphabet - без похожих друг на друга 0/O и 1/I, чтобы код можно было продиктовать
+const referralAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
 
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("user not found")
+func generateReferralCode() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := crand.Read(buf); err != nil {
+		return "", err
 	}
+	for i := range buf {
+		buf[i] = referralAlphabet[int(buf[i])%len(referralAlphabet)]
+	}
+	return string(buf), nil
+}
 
-	return nil
+func (r *UserRepository) MarkEmailVerified(userID int) error {
+	return r.db.Model(&entity.User{}).
+		Where("id = ?", userID).
+		Update("email_verified", true).Error
 }
 
-func generateAccountNumber() string {
-	rand.Seed(time.Now().UnixNano())
-	return fmt.Sprintf("NL%08d", rand.Intn(100000000))
+// UpdatePassword меняет пароль и в той же транзакции отзывает все сессии клиента:
+// выданные до смены пароля refresh и access токены перестают действовать сразу
+func (r *UserRepository) UpdatePassword(userID int, passwordHash string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&entity.User{}).
+			Where("id = ?", userID).
+			Updates(map[string]interface{}{
+				"password_hash":       passwordHash,
+				"password_changed_at": now,
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&entity.UserSession{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+	})
 }
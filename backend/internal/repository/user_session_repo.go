@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserSessionRepository struct {
+	db *gorm.DB
+}
+
+func NewUserSessionRepository(db *gorm.DB) *UserSessionRepository {
+	return &UserSessionRepository{db: db}
+}
+
+func (r *UserSessionRepository) Create(session *entity.UserSession) error {
+	return r.db.Create(session).Error
+}
+
+func (r *UserSessionRepository) FindByID(sessionID int64) (*entity.UserSession, error) {
+	var session entity.UserSession
+	if err := r.db.First(&session, sessionID).Error; err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *UserSessionRepository) FindActiveByRefreshHash(hash string) (*entity.UserSession, error) {
+	var session entity.UserSession
+	err := r.db.Where("refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hash, time.Now()).
+		First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// Rotate заменяет refresh токен сессии. Замена идет только с oldHash, поэтому из двух
+// запросов с одним токеном проходит один, а второй получает false.
+func (r *UserSessionRepository) Rotate(sessionID int64, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	result := r.db.Model(&entity.UserSession{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, oldHash, time.Now()).
+		Updates(map[string]interface{}{
+			"refresh_token_hash": newHash,
+			"expires_at":         expiresAt,
+			"last_used_at":       time.Now(),
+		})
+
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *UserSessionRepository) Revoke(userID int, sessionID int64) error {
+	return r.db.Model(&entity.UserSession{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now()).Error
+}
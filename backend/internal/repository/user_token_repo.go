@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"internet_provider/internal/entity"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewUserTokenRepository(db *gorm.DB) *UserTokenRepository {
+	return &UserTokenRepository{db: db}
+}
+
+// Create сохраняет новый токен и гасит все прежние неиспользованные токены того же назначения
+func (r *UserTokenRepository) Create(token *entity.UserToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&entity.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(token).Error
+	})
+}
+
+// Consume атомарно помечает токен использованным. Повторное использование и просроченные токены
+// возвращают gorm.ErrRecordNotFound.
+func (r *UserTokenRepository) Consume(hash, purpose string) (*entity.UserToken, error) {
+	var token entity.UserToken
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ? AND purpose = ?", hash, purpose).First(&token).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&entity.UserToken{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", token.ID, time.Now()).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+var ErrTokenInvalid = errors.New("token is invalid, expired or already used")
+
+// AccountService отвечает за подтверждение email и восстановление пароля клиентов
+type AccountService struct {
+	userRepo  *repository.UserRepository
+	tokenRepo *repository.UserTokenRepository
+	mailer    Mailer
+	publicURL string
+}
+
+func NewAccountService(userRepo *repository.UserRepository, tokenRepo *repository.UserTokenRepository, mailer Mailer, publicURL string) *AccountService {
+	return &AccountService{
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+		mailer:    mailer,
+		publicURL: publicURL,
+	}
+}
+
+func (s *AccountService) SendVerification(user *entity.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+
+	token, err := s.issueToken(user.Id, entity.TokenPurposeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(&MailMessage{
+		To:      user.Email,
+		Subject: "NetLink: подтверждение email",
+		Body: fmt.Sprintf("Здравствуйте, %s!\n\nПодтвердите адрес электронной почты по ссылке:\n%s\n\nСсылка действует %d часов.\n",
+			user.Name, s.link("verify_token", token), int(verifyEmailTTL.Hours())),
+	})
+}
+
+// VerifyEmail подтверждает email и возвращает ID клиента
+func (s *AccountService) VerifyEmail(token string) (int, error) {
+	userToken, err := s.tokenRepo.Consume(hashToken(token), entity.TokenPurposeVerifyEmail)
+	if err != nil {
+		return 0, ErrTokenInvalid
+	}
+
+	return userToken.UserID, s.userRepo.MarkEmailVerified(userToken.UserID)
+}
+
+// RequestPasswordReset не сообщает, существует ли такой email, чтобы по ответу нельзя было перебирать адреса
+func (s *AccountService) RequestPasswordReset(email string) error {
+	user, err := s.userRepo.FindByEmail(email)
+	if err != nil {
+		log.Printf("Password reset requested for unknown email")
+		return nil
+	}
+
+	token, err := s.issueToken(user.Id, entity.TokenPurposeResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(&MailMessage{
+		To:      user.Email,
+		Subject: "NetLink: восстановление пароля",
+		Body: fmt.Sprintf("Здравствуйте, %s!\n\nДля смены пароля перейдите по ссылке:\n%s\n\nСсылка одноразовая и действует %d минут. Если вы не запрашивали смену пароля, просто проигнорируйте это письмо.\n",
+			user.Name, s.link("reset_token", token), int(resetPasswordTTL.Minutes())),
+	})
+}
+
+// ResetPassword меняет пароль по одноразовой ссылке и возвращает ID клиента
+func (s *AccountService) ResetPassword(token, newPassword string) (int, error) {
+	userToken, err := s.tokenRepo.Consume(hashToken(token), entity.TokenPurposeResetPassword)
+	if err != nil {
+		return 0, ErrTokenInvalid
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.userRepo.UpdatePassword(userToken.UserID, string(hashedPassword)); err != nil {
+		return 0, err
+	}
+
+	// Письмо пришло на этот адрес, значит он принадлежит клиенту
+	return userToken.UserID, s.userRepo.MarkEmailVerified(userToken.UserID)
+}
+
+func (s *AccountService) issueToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.tokenRepo.Create(&entity.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *AccountService) link(param, token string) string {
+	return s.publicURL + "?" + param + "=" + url.QueryEscape(token)
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAddonNotFound       = errors.New("addon not found")
+	ErrAddonUnavailable    = errors.New("addon is archived or hidden")
+	ErrAddonAttached       = errors.New("addon is already attached")
+	ErrAddonNotAttached    = errors.New("addon is not attached")
+	ErrAddonNoSubscription = errors.New("no paid subscription to attach the addon to")
+	ErrInvalidAddonPrice   = errors.New("addon must have a setup fee or a monthly price")
+)
+
+// AddonService ведет каталог дополнительных услуг и подключает их клиентам.
+// Периоды услуг списывает биллинг вместе с тарифом.
+type AddonService struct {
+	repo *repository.AddonRepository
+}
+
+func NewAddonService(repo *repository.AddonRepository) *AddonService {
+	return &AddonService{repo: repo}
+}
+
+func (s *AddonService) ListPublic() ([]entity.Addon, error) {
+	return s.repo.ListPublic()
+}
+
+func (s *AddonService) ListAll(includeArchived bool) ([]entity.Addon, error) {
+	return s.repo.ListAll(includeArchived)
+}
+
+func (s *AddonService) Get(id int64) (*entity.Addon, error) {
+	addon, err := s.repo.FindByID(id)
+	return addon, addonError(err)
+}
+
+func (s *AddonService) Create(req *entity.CreateAddonRequest) (*entity.Addon, error) {
+	addon := &entity.Addon{
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+		SetupFee:    entity.ToKopecks(req.SetupFee),
+		Price:       entity.ToKopecks(req.Price),
+		IsVisible:   true,
+	}
+	if req.IsVisible != nil {
+		addon.IsVisible = *req.IsVisible
+	}
+	if addon.SetupFee+addon.Price <= 0 {
+		return nil, ErrInvalidAddonPrice
+	}
+
+	if err := s.repo.Create(addon); err != nil {
+		return nil, err
+	}
+
+	return addon, nil
+}
+
+// Update меняет услугу. Новая цена действует для подключенных клиентов со следующего периода.
+func (s *AddonService) Update(id int64, req *entity.UpdateAddonRequest) (*entity.Addon, error) {
+	addon, err := s.repo.FindByID(id)
+	if err != nil {
+		return nil, addonError(err)
+	}
+
+	if req.Name != nil {
+		addon.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		addon.Description = *req.Description
+	}
+	if req.SetupFee != nil {
+		addon.SetupFee = entity.ToKopecks(*req.SetupFee)
+	}
+	if req.Price != nil {
+		addon.Price = entity.ToKopecks(*req.Price)
+	}
+	if req.IsVisible != nil {
+		addon.IsVisible = *req.IsVisible
+	}
+	if addon.SetupFee+addon.Price <= 0 {
+		return nil, ErrInvalidAddonPrice
+	}
+
+	if err := s.repo.Update(addon); err != nil {
+		return nil, err
+	}
+
+	return addon, nil
+}
+
+// SetArchived убирает услугу из продажи или возвращает ее. У подключивших клиентов она остается.
+func (s *AddonService) SetArchived(id int64, archived bool) (*entity.Addon, error) {
+	found, err := s.repo.SetArchived(id, archived)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrAddonNotFound
+	}
+
+	addon, err := s.repo.FindByID(id)
+	return addon, addonError(err)
+}
+
+// ForUser - услуги, подключенные клиенту
+func (s *AddonService) ForUser(userID int) ([]entity.UserAddon, error) {
+	return s.repo.ListForUser(userID)
+}
+
+func (s *AddonService) Charges(userID int) ([]entity.AddonCharge, error) {
+	return s.repo.ListCharges(userID, 50)
+}
+
+// Attach подключает услугу и сразу списывает разовый платеж и остаток текущего периода
+func (s *AddonService) Attach(userID int, addonID int64) (*entity.AddonAttachment, error) {
+	attachment, err := s.repo.Attach(userID, addonID, time.Now())
+	return attachment, addonError(err)
+}
+
+func (s *AddonService) Detach(userID int, userAddonID int64) (*entity.UserAddon, error) {
+	userAddon, err := s.repo.Detach(userID, userAddonID, time.Now())
+	return userAddon, addonError(err)
+}
+
+func addonError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrAddonNotFound):
+		return ErrAddonNotFound
+	case errors.Is(err, repository.ErrAddonUnavailable):
+		return ErrAddonUnavailable
+	case errors.Is(err, repository.ErrAddonAttached):
+		return ErrAddonAttached
+	case errors.Is(err, repository.ErrAddonNotAttached):
+		return ErrAddonNotAttached
+	case errors.Is(err, repository.ErrAddonNoSubscription):
+		return ErrAddonNoSubscription
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		return ErrInsufficientFunds
+	}
+	return err
+}
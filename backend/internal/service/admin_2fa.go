@@ -0,0 +1,313 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"internet_provider/internal/entity"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer         = "NetLink Admin"
+	challengeTTL       = 5 * time.Minute
+	recoveryCodesCount = 10
+)
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotSetUp   = errors.New("two-factor authentication is not set up")
+	ErrTwoFactorMandatory  = errors.New("two-factor authentication is mandatory")
+	ErrInvalidTwoFactor    = errors.New("invalid two-factor code")
+	ErrInvalidChallenge    = errors.New("invalid or expired login challenge")
+	ErrTwoFactorCodeNeeded = errors.New("code or recovery_code is required")
+)
+
+func (s *AdminService) TwoFactorRequired() (bool, error) {
+	return s.settingsRepo.GetBool(entity.SettingAdmin2FARequired, false)
+}
+
+func (s *AdminService) SetTwoFactorRequired(actorID int64, required bool) error {
+	log.Printf("Admin %d set mandatory 2FA to %v", actorID, required)
+	return s.settingsRepo.Set(entity.SettingAdmin2FARequired, strconv.FormatBool(required), actorID)
+}
+
+// TwoFactorSetupRequired - 2FA обязательна, а администратор ее еще не подключил
+func (s *AdminService) TwoFactorSetupRequired(admin *entity.Admin) (bool, error) {
+	if admin.TOTPEnabled {
+		return false, nil
+	}
+
+	return s.TwoFactorRequired()
+}
+
+// SetupTwoFactor выдает новый секрет. 2FA включится только после подтверждения кодом в EnableTwoFactor.
+func (s *AdminService) SetupTwoFactor(adminID int64) (*entity.TwoFactorSetupResponse, error) {
+	admin, err := s.adminRepo.FindByID(adminID)
+	if err != nil {
+		return nil, err
+	}
+	if admin.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	admin.TOTPSecret = secret
+	admin.TOTPLastStep = 0
+	if err := s.adminRepo.UpdateAdmin(admin); err != nil {
+		return nil, err
+	}
+
+	otpURL := totpURL(totpIssuer, admin.Username, secret)
+	png, err := qrcode.Encode(otpURL, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.TwoFactorSetupResponse{
+		Secret:     secret,
+		OTPAuthURL: otpURL,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// EnableTwoFactor включает 2FA и возвращает коды восстановления. Они показываются один раз.
+func (s *AdminService) EnableTwoFactor(adminID int64, code string) ([]string, error) {
+	admin, err := s.adminRepo.FindByID(adminID)
+	if err != nil {
+		return nil, err
+	}
+	if admin.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if admin.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotSetUp
+	}
+
+	if err := s.checkTOTP(admin, code); err != nil {
+		return nil, err
+	}
+
+	admin.TOTPEnabled = true
+	if err := s.adminRepo.UpdateAdmin(admin); err != nil {
+		return nil, err
+	}
+
+	log.Printf("2FA enabled for admin %d", admin.ID)
+	return s.issueRecoveryCodes(admin.ID)
+}
+
+func (s *AdminService) DisableTwoFactor(adminID int64, password, code string) error {
+	required, err := s.TwoFactorRequired()
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrTwoFactorMandatory
+	}
+
+	admin, err := s.adminRepo.FindByID(adminID)
+	if err != nil {
+		return err
+	}
+	if !admin.TOTPEnabled {
+		return ErrTwoFactorNotSetUp
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+	if err := s.checkTOTP(admin, code); err != nil {
+		return err
+	}
+
+	log.Printf("2FA disabled for admin %d", admin.ID)
+	return s.clearTwoFactor(admin)
+}
+
+func (s *AdminService) RegenerateRecoveryCodes(adminID int64, code string) ([]string, error) {
+	admin, err := s.adminRepo.FindByID(adminID)
+	if err != nil {
+		return nil, err
+	}
+	if !admin.TOTPEnabled {
+		return nil, ErrTwoFactorNotSetUp
+	}
+
+	if err := s.checkTOTP(admin, code); err != nil {
+		return nil, err
+	}
+
+	return s.issueRecoveryCodes(admin.ID)
+}
+
+func (s *AdminService) RecoveryCodesLeft(adminID int64) (int64, error) {
+	return s.adminRepo.CountRecoveryCodes(adminID)
+}
+
+// ResetTwoFactor - суперадмин сбрасывает 2FA администратору, потерявшему устройство
+func (s *AdminService) ResetTwoFactor(actorID, adminID int64) error {
+	admin, err := s.adminRepo.FindByID(adminID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.clearTwoFactor(admin); err != nil {
+		return err
+	}
+
+	log.Printf("2FA of admin %d reset by admin %d", adminID, actorID)
+	return s.adminRepo.RevokeAllSessions(admin.ID)
+}
+
+// ChallengeUsername достает имя администратора из challenge токена, чтобы до проверки кода
+// применить к нему ограничения на число попыток
+func (s *AdminService) ChallengeUsername(challengeToken string) (string, error) {
+	_, username, err := s.parseChallenge(challengeToken)
+	if err != nil {
+		return "", ErrInvalidChallenge
+	}
+	return username, nil
+}
+
+// CompleteTwoFactorLogin - второй шаг входа: проверяет TOTP или код восстановления и открывает сессию
+func (s *AdminService) CompleteTwoFactorLogin(req *entity.TwoFactorLoginRequest, userAgent, ip string) (*entity.AdminLoginResponse, error) {
+	adminID, _, err := s.parseChallenge(req.ChallengeToken)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	admin, err := s.adminRepo.FindByID(adminID)
+	if err != nil || !admin.IsActive || !admin.TOTPEnabled {
+		return nil, ErrInvalidChallenge
+	}
+
+	switch {
+	case req.Code != "":
+		err = s.checkTOTP(admin, req.Code)
+	case req.RecoveryCode != "":
+		err = s.useRecoveryCode(admin.ID, req.RecoveryCode)
+	default:
+		err = ErrTwoFactorCodeNeeded
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.startSession(admin, userAgent, ip)
+}
+
+func (s *AdminService) checkTOTP(admin *entity.Admin, code string) error {
+	step, ok := validateTOTP(admin.TOTPSecret, code, time.Now(), admin.TOTPLastStep)
+	if !ok {
+		return ErrInvalidTwoFactor
+	}
+
+	// Один и тот же код нельзя использовать повторно, в том числе параллельными запросами
+	claimed, err := s.adminRepo.ClaimTOTPStep(admin.ID, step)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrInvalidTwoFactor
+	}
+
+	admin.TOTPLastStep = step
+	return nil
+}
+
+func (s *AdminService) useRecoveryCode(adminID int64, code string) error {
+	used, err := s.adminRepo.UseRecoveryCode(adminID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTwoFactor
+	}
+
+	log.Printf("Admin %d logged in with a recovery code", adminID)
+	return nil
+}
+
+func (s *AdminService) issueRecoveryCodes(adminID int64) ([]string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+
+	for i := 0; i < recoveryCodesCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+
+		raw := hex.EncodeToString(buf)
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashToken(raw))
+	}
+
+	if err := s.adminRepo.ReplaceRecoveryCodes(adminID, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *AdminService) clearTwoFactor(admin *entity.Admin) error {
+	admin.TOTPEnabled = false
+	admin.TOTPSecret = ""
+	admin.TOTPLastStep = 0
+
+	if err := s.adminRepo.UpdateAdmin(admin); err != nil {
+		return err
+	}
+
+	return s.adminRepo.DeleteRecoveryCodes(admin.ID)
+}
+
+func (s *AdminService) signChallenge(admin *entity.Admin) (string, error) {
+	claims := jwt.MapClaims{
+		"admin_id": admin.ID,
+		"username": admin.Username,
+		"type":     "2fa_challenge",
+		"exp":      time.Now().Add(challengeTTL).Unix(),
+		"iat":      time.Now().Unix(),
+	}
+
+	return s.keys.Sign(claims)
+}
+
+func (s *AdminService) parseChallenge(tokenString string) (int64, string, error) {
+	token, err := jwt.Parse(tokenString, s.keys.Keyfunc)
+	if err != nil {
+		return 0, "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["type"] != "2fa_challenge" {
+		return 0, "", ErrInvalidChallenge
+	}
+
+	adminID, _ := claims["admin_id"].(float64)
+	username, _ := claims["username"].(string)
+	if adminID == 0 {
+		return 0, "", ErrInvalidChallenge
+	}
+
+	return int64(adminID), username, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
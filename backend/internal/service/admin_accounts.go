@@ -0,0 +1,147 @@
+package service
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrAdminExists      = errors.New("admin with this username already exists")
+	ErrInvalidRole      = errors.New("invalid role")
+	ErrWrongPassword    = errors.New("current password is incorrect")
+	ErrSamePassword     = errors.New("new password must differ from the current one")
+	ErrSelfDeactivation = errors.New("admin cannot deactivate own account")
+	ErrLastSuperadmin   = errors.New("at least one active superadmin is required")
+)
+
+func (s *AdminService) CreateAdmin(req *entity.CreateAdminRequest) (*entity.Admin, error) {
+	if !entity.IsValidRole(req.Role) {
+		return nil, ErrInvalidRole
+	}
+
+	exists, err := s.adminRepo.ExistsByUsername(req.Username)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrAdminExists
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	// Пароль задал суперадмин, поэтому при первом входе его нужно сменить
+	admin := &entity.Admin{
+		Username:           req.Username,
+		Password:           string(hashedPassword),
+		Email:              req.Email,
+		Role:               req.Role,
+		IsActive:           true,
+		MustChangePassword: true,
+	}
+
+	if err := s.adminRepo.CreateAdmin(admin); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Admin created: %s (role: %s)", admin.Username, admin.Role)
+	return admin, nil
+}
+
+func (s *AdminService) ListAdmins() ([]entity.Admin, error) {
+	return s.adminRepo.ListAdmins()
+}
+
+func (s *AdminService) UpdateAdmin(actorID, adminID int64, req *entity.UpdateAdminRequest) (*entity.Admin, error) {
+	if req.Role != nil && !entity.IsValidRole(*req.Role) {
+		return nil, ErrInvalidRole
+	}
+	if req.IsActive != nil && !*req.IsActive && actorID == adminID {
+		return nil, ErrSelfDeactivation
+	}
+
+	var hashedPassword []byte
+	if req.Password != nil {
+		var err error
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	admin, err := s.adminRepo.UpdateAdminKeepingSuperadmin(adminID, func(admin *entity.Admin) {
+		if req.Email != nil {
+			admin.Email = *req.Email
+		}
+		if req.Role != nil {
+			admin.Role = *req.Role
+		}
+		if req.IsActive != nil {
+			admin.IsActive = *req.IsActive
+		}
+		if req.Password != nil {
+			admin.Password = string(hashedPassword)
+			admin.MustChangePassword = true
+		}
+	})
+	if errors.Is(err, repository.ErrLastSuperadmin) {
+		return nil, ErrLastSuperadmin
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Отключенный админ или сброшенный пароль - все текущие сессии больше не действуют
+	if !admin.IsActive || req.Password != nil {
+		if err := s.adminRepo.RevokeAllSessions(admin.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Admin %d updated by admin %d", admin.ID, actorID)
+	return admin, nil
+}
+
+func (s *AdminService) DeactivateAdmin(actorID, adminID int64) (*entity.Admin, error) {
+	inactive := false
+	return s.UpdateAdmin(actorID, adminID, &entity.UpdateAdminRequest{IsActive: &inactive})
+}
+
+// ChangePassword меняет пароль самого администратора и завершает остальные его сессии
+func (s *AdminService) ChangePassword(adminID, sessionID int64, req *entity.ChangePasswordRequest) error {
+	admin, err := s.adminRepo.FindByID(adminID)
+	if err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(req.CurrentPassword)); err != nil {
+		return ErrWrongPassword
+	}
+	if req.CurrentPassword == req.NewPassword {
+		return ErrSamePassword
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	admin.Password = string(hashedPassword)
+	admin.MustChangePassword = false
+
+	if err := s.adminRepo.UpdateAdmin(admin); err != nil {
+		return err
+	}
+
+	return s.adminRepo.RevokeOtherSessions(admin.ID, sessionID)
+}
+
+func (s *AdminService) GetAdmin(adminID int64) (*entity.Admin, error) {
+	return s.adminRepo.FindByID(adminID)
+}
@@ -1,6 +1,9 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"internet_provider/internal/entity"
@@ -13,19 +16,32 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	adminAccessTTL  = 15 * time.Minute
+	adminRefreshTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrSessionRevoked = errors.New("session revoked")
+	ErrAdminInactive  = errors.New("admin is inactive")
+	ErrSessionUnknown = errors.New("session not found")
+)
+
 type AdminService struct {
-	adminRepo *repository.GormAdminRepository
-	jwtSecret string
+	adminRepo    *repository.GormAdminRepository
+	settingsRepo *repository.SettingsRepository
+	keys         *KeyRing
 }
 
-func NewAdminService(admin *repository.GormAdminRepository, jwtSecret string) *AdminService {
+func NewAdminService(admin *repository.GormAdminRepository, settings *repository.SettingsRepository, keys *KeyRing) *AdminService {
 	return &AdminService{
-		adminRepo: admin,
-		jwtSecret: jwtSecret,
+		adminRepo:    admin,
+		settingsRepo: settings,
+		keys:         keys,
 	}
 }
 
-func (s *AdminService) Authenticate(username, password string) (*entity.AdminLoginResponse, error) {
+func (s *AdminService) Authenticate(username, password, userAgent, ip string) (*entity.AdminLoginResponse, error) {
 	admin, err := s.adminRepo.FindByUsername(username)
 	if err != nil {
 		log.Printf("Admin not found: %v", err)
@@ -38,95 +54,199 @@ func (s *AdminService) Authenticate(username, password string) (*entity.AdminLog
 		return nil, errors.New("invalid credentials")
 	}
 
-	token, err := s.GenerateJWT(admin)
+	// Пароль верный, но сессию выдаем только после второго шага
+	if admin.TOTPEnabled {
+		challenge, err := s.signChallenge(admin)
+		if err != nil {
+			return nil, err
+		}
+
+		return &entity.AdminLoginResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		}, nil
+	}
+
+	return s.startSession(admin, userAgent, ip)
+}
+
+func (s *AdminService) startSession(admin *entity.Admin, userAgent, ip string) (*entity.AdminLoginResponse, error) {
+	refreshToken, refreshHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &entity.AdminSession{
+		AdminID:          admin.ID,
+		RefreshTokenHash: refreshHash,
+		UserAgent:        truncate(userAgent, 255),
+		IP:               ip,
+		ExpiresAt:        now.Add(adminRefreshTTL),
+		LastUsedAt:       now,
+	}
+	if err := s.adminRepo.CreateSession(session); err != nil {
+		return nil, err
+	}
+
+	return s.loginResponse(admin, session.ID, refreshToken)
+}
+
+// Refresh выдает новый access токен и заменяет refresh токен сессии
+func (s *AdminService) Refresh(refreshToken string) (*entity.AdminLoginResponse, error) {
+	session, err := s.adminRepo.FindActiveSessionByRefreshHash(hashToken(refreshToken))
+	if err != nil {
+		return nil, ErrSessionUnknown
+	}
+
+	admin, err := s.adminRepo.FindByID(session.AdminID)
+	if err != nil {
+		return nil, err
+	}
+	if !admin.IsActive {
+		return nil, ErrAdminInactive
+	}
+
+	newToken, newHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := s.adminRepo.RotateSessionRefresh(session.ID, session.RefreshTokenHash, newHash, time.Now().Add(adminRefreshTTL))
+	if err != nil {
+		return nil, err
+	}
+	// Токен уже сменил параллельный запрос: старый токен предъявлен повторно, и сессию
+	// безопаснее закрыть, чем выдать две действующие пары
+	if !rotated {
+		if _, err := s.adminRepo.RevokeSession(session.AdminID, session.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionUnknown
+	}
+
+	return s.loginResponse(admin, session.ID, newToken)
+}
+
+func (s *AdminService) Logout(adminID, sessionID int64) error {
+	_, err := s.adminRepo.RevokeSession(adminID, sessionID)
+	return err
+}
+
+func (s *AdminService) ListSessions(adminID int64) ([]entity.AdminSession, error) {
+	return s.adminRepo.ListActiveSessions(adminID)
+}
+
+func (s *AdminService) RevokeSession(adminID, sessionID int64) error {
+	revoked, err := s.adminRepo.RevokeSession(adminID, sessionID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrSessionUnknown
+	}
+
+	return nil
+}
+
+// ValidateSession проверяет, что сессия из токена не отозвана и администратор активен
+func (s *AdminService) ValidateSession(claims jwt.MapClaims) (*entity.Admin, int64, error) {
+	adminID, _ := claims["admin_id"].(float64)
+	sessionID, _ := claims["sid"].(float64)
+	if adminID == 0 || sessionID == 0 {
+		return nil, 0, ErrSessionUnknown
+	}
+
+	session, err := s.adminRepo.FindSessionByID(int64(sessionID))
+	if err != nil || session.AdminID != int64(adminID) {
+		return nil, 0, ErrSessionUnknown
+	}
+	if session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+		return nil, 0, ErrSessionRevoked
+	}
+
+	admin, err := s.adminRepo.FindByID(session.AdminID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !admin.IsActive {
+		return nil, 0, ErrAdminInactive
+	}
+
+	return admin, session.ID, nil
+}
+
+func (s *AdminService) loginResponse(admin *entity.Admin, sessionID int64, refreshToken string) (*entity.AdminLoginResponse, error) {
+	token, err := s.GenerateJWT(admin, sessionID)
 	if err != nil {
 		return nil, err
 	}
 
 	response := &entity.AdminLoginResponse{
-		Token: token,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(adminAccessTTL.Seconds()),
 	}
 
 	response.Admin.ID = admin.ID
 	response.Admin.Username = admin.Username
 	response.Admin.Email = admin.Email
+	response.Admin.Role = admin.Role
+	response.Admin.Permissions = entity.PermissionsForRole(admin.Role)
+	response.Admin.MustChangePassword = admin.MustChangePassword
+
+	setupRequired, err := s.TwoFactorSetupRequired(admin)
+	if err != nil {
+		return nil, err
+	}
+	response.Admin.TwoFactorSetup = setupRequired
 
 	return response, nil
 }
 
-func (s *AdminService) GenerateJWT(admin *entity.Admin) (string, error) {
-	log.Printf("🔄 Generating JWT for admin: %s (ID: %d)", admin.Username, admin.ID)
-
+func (s *AdminService) GenerateJWT(admin *entity.Admin, sessionID int64) (string, error) {
 	claims := jwt.MapClaims{
 		"admin_id": admin.ID,
 		"username": admin.Username,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+		"role":     admin.Role,
+		"sid":      sessionID,
+		"exp":      time.Now().Add(adminAccessTTL).Unix(),
 		"iat":      time.Now().Unix(),
 	}
 
-	log.Printf("📋 JWT Claims: %+v", claims)
-
-	// Создаем токен с правильным методом подписи
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	log.Printf("🔑 Using JWT Secret length: %d", len(s.jwtSecret))
-
-	// Подписываем токен
-	tokenString, err := token.SignedString([]byte(s.jwtSecret))
+	// Подписываем токен активным ключом, kid попадает в заголовок
+	tokenString, err := s.keys.Sign(claims)
 	if err != nil {
-		log.Printf("❌ JWT signing failed: %v", err)
 		return "", err
 	}
 
-	log.Printf("✅ JWT generated successfully")
-	log.Printf("📏 Token length: %d", len(tokenString))
-
 	// Проверим что токен валидный
-	log.Printf("🔍 Validating generated token...")
-	testClaims, err := s.VerifyJWT(tokenString)
-	if err != nil {
-		log.Printf("❌ Generated token is invalid: %v", err)
+	if _, err := s.VerifyJWT(tokenString); err != nil {
 		return "", fmt.Errorf("generated token is invalid: %v", err)
 	}
 
-	log.Printf("✅ Token validation successful: %+v", testClaims)
 	return tokenString, nil
 }
 
 func (s *AdminService) VerifyJWT(tokenString string) (jwt.MapClaims, error) {
-	log.Printf("🔐 Verifying token, length: %d", len(tokenString))
-
 	// Проверим структуру токена перед парсингом
 	parts := strings.Split(tokenString, ".")
 	if len(parts) != 3 {
 		return nil, fmt.Errorf("invalid token structure: expected 3 parts, got %d", len(parts))
 	}
 
-	log.Printf("🔍 Token parts: %d/%d/%d", len(parts[0]), len(parts[1]), len(parts[2]))
-
+	// Ключ выбирается по kid, поэтому во время ротации принимаются токены старого ключа
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		log.Printf("🔐 Token method: %v", token.Method)
-
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Method)
-		}
-
-		log.Printf("✅ Using HMAC signing method")
-		return []byte(s.jwtSecret), nil
+		return s.keys.Keyfunc(token)
 	})
 
 	if err != nil {
-		log.Printf("❌ Token parse error: %v", err)
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		log.Printf("✅ Token verified successfully")
-		log.Printf("📋 Claims: admin_id=%v, username=%v", claims["admin_id"], claims["username"])
 		return claims, nil
 	}
 
-	log.Printf("❌ Token invalid")
 	return nil, fmt.Errorf("invalid token")
 }
 
@@ -145,3 +265,30 @@ func (s *AdminService) GetUsers(search, filter string, page, limit int) ([]entit
 
 	return s.adminRepo.GetUsers(search, filter, page, limit)
 }
+
+func (s *AdminService) GetUser(userID int64) (*entity.AdminUserList, error) {
+	return s.adminRepo.GetUserByID(userID)
+}
+
+// newOpaqueToken генерирует случайный токен для выдачи клиенту и его хеш для хранения в базе
+func newOpaqueToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	token = hex.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncate(value string, max int) string {
+	if len(value) > max {
+		return value[:max]
+	}
+	return value
+}
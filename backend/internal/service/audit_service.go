@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+)
+
+const auditVerifyBatch = 500
+
+// AuditVerifyResult - результат проверки цепочки. BrokenAt - ID первой записи, хеш которой не сходится.
+type AuditVerifyResult struct {
+	Valid    bool   `json:"valid"`
+	Checked  int64  `json:"checked"`
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+	LastHash string `json:"last_hash"`
+}
+
+type AuditService struct {
+	repo *repository.AuditRepository
+}
+
+func NewAuditService(repo *repository.AuditRepository) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+// Record пишет событие в журнал. before и after сериализуются в JSON, nil пропускается.
+func (s *AuditService) Record(meta entity.AuditMeta, action, entityType, entityID string, before, after interface{}) error {
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.Append(&entity.AuditEvent{
+		ActorType:  meta.ActorType,
+		ActorID:    meta.ActorID,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+}
+
+func (s *AuditService) List(filter *entity.AuditFilter, page, limit int) ([]entity.AuditEvent, int64, error) {
+	return s.repo.List(filter, page, limit)
+}
+
+// Verify проходит всю цепочку и пересчитывает хеши. Изменение, удаление или вставка записи
+// задним числом дают расхождение.
+func (s *AuditService) Verify() (*AuditVerifyResult, error) {
+	result := &AuditVerifyResult{Valid: true}
+	var lastID int64
+
+	for {
+		events, err := s.repo.ListAfter(lastID, auditVerifyBatch)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range events {
+			event := &events[i]
+			if event.PrevHash != result.LastHash || event.ComputeHash(result.LastHash) != event.Hash {
+				id := event.ID
+				result.Valid = false
+				result.BrokenAt = &id
+				return result, nil
+			}
+
+			result.LastHash = event.Hash
+			result.Checked++
+			lastID = event.ID
+		}
+
+		if len(events) < auditVerifyBatch {
+			return result, nil
+		}
+	}
+}
+
+func auditJSON(value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrCardNotFound    = errors.New("payment card not found")
+	ErrAutopayNotFound = errors.New("autopay is not configured")
+	ErrInvalidAutopay  = errors.New("invalid autopay settings")
+)
+
+const (
+	// autopayClaim - сколько автоплатеж занят одним экземпляром сервера, пока идет списание
+	autopayClaim = 10 * time.Minute
+	// autopayPendingTimeout - сколько ждать итога списания, прежде чем считать его неудачным
+	autopayPendingTimeout = 24 * time.Hour
+)
+
+// AutopaySettings - RetryDelay - пауза после первой неудачи, дальше она удваивается.
+// После MaxAttempts неудач подряд автоплатеж отключается.
+type AutopaySettings struct {
+	RetryDelay  time.Duration
+	MaxAttempts int
+}
+
+// AutopayService пополняет баланс с сохраненной карты, когда срабатывает условие автоплатежа,
+// и пишет клиенту, если списать деньги не удалось
+type AutopayService struct {
+	repo     *repository.AutopayRepository
+	payments *PaymentService
+	users    *repository.UserRepository
+	mailer   Mailer
+	audit    *AuditService
+	settings AutopaySettings
+}
+
+func NewAutopayService(repo *repository.AutopayRepository, payments *PaymentService, users *repository.UserRepository,
+	mailer Mailer, audit *AuditService, settings AutopaySettings) *AutopayService {
+	return &AutopayService{
+		repo:     repo,
+		payments: payments,
+		users:    users,
+		mailer:   mailer,
+		audit:    audit,
+		settings: settings,
+	}
+}
+
+func (s *AutopayService) Cards(userID int) ([]entity.PaymentCard, error) {
+	return s.repo.ListCards(userID)
+}
+
+// DeleteCard удаляет карту. Автоплатеж с этой карты выключается.
+func (s *AutopayService) DeleteCard(userID int, id int64) error {
+	err := s.repo.DeleteCard(userID, id)
+	if errors.Is(err, repository.ErrCardNotFound) {
+		return ErrCardNotFound
+	}
+	return err
+}
+
+// Get - автоплатеж клиента или nil, если он не настроен
+func (s *AutopayService) Get(userID int) (*entity.Autopay, error) {
+	return s.repo.Get(userID)
+}
+
+// Enable включает автоплатеж с сохраненной карты клиента или меняет его настройки
+func (s *AutopayService) Enable(userID int, req *entity.AutopayRequest) (*entity.Autopay, error) {
+	autopay := &entity.Autopay{
+		UserID:     userID,
+		CardID:     req.CardID,
+		Trigger:    req.Trigger,
+		Threshold:  entity.ToKopecks(req.Threshold),
+		DaysBefore: req.DaysBefore,
+		Amount:     entity.ToKopecks(req.Amount),
+	}
+
+	switch autopay.Trigger {
+	case entity.AutopayThreshold:
+		if autopay.Threshold <= 0 || autopay.Amount < entity.MinAutopayAmount {
+			return nil, ErrInvalidAutopay
+		}
+		autopay.DaysBefore = 0
+	case entity.AutopayBeforeCharge:
+		if autopay.DaysBefore < 1 || (autopay.Amount != 0 && autopay.Amount < entity.MinAutopayAmount) {
+			return nil, ErrInvalidAutopay
+		}
+		autopay.Threshold = 0
+	default:
+		return nil, ErrInvalidAutopay
+	}
+
+	if _, err := s.repo.FindCard(userID, req.CardID); err != nil {
+		if errors.Is(err, repository.ErrCardNotFound) {
+			return nil, ErrCardNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.repo.Enable(autopay); err != nil {
+		return nil, err
+	}
+	return s.repo.Get(userID)
+}
+
+// Disable выключает автоплатеж клиента
+func (s *AutopayService) Disable(userID int) (*entity.Autopay, error) {
+	autopay, err := s.repo.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if autopay == nil {
+		return nil, ErrAutopayNotFound
+	}
+
+	if err := s.repo.Disable(userID); err != nil {
+		return nil, err
+	}
+	autopay.Enabled = false
+	return autopay, nil
+}
+
+// ProcessDue учитывает итоги прошлых списаний и списывает деньги по сработавшим автоплатежам
+func (s *AutopayService) ProcessDue(ctx context.Context, now time.Time) (charged, failed int, err error) {
+	autopays, err := s.repo.Due(now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i := range autopays {
+		autopay := &autopays[i]
+
+		if autopay.PaymentID == nil {
+			if autopay.PaymentID, err = s.charge(ctx, autopay, now); err != nil {
+				log.Printf("Autopay of user %d failed: %v", autopay.UserID, err)
+				continue
+			}
+			if autopay.PaymentID == nil {
+				continue
+			}
+		}
+
+		result, err := s.settle(autopay, now)
+		if err != nil {
+			log.Printf("Failed to settle autopay of user %d: %v", autopay.UserID, err)
+			continue
+		}
+		switch result {
+		case entity.PaymentCompleted:
+			charged++
+		case entity.PaymentFailed:
+			failed++
+		}
+	}
+
+	return charged, failed, nil
+}
+
+func (s *AutopayService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		charged, failed, err := s.ProcessDue(ctx, time.Now())
+		if err != nil {
+			log.Printf("Autopay run failed: %v", err)
+		} else if charged+failed > 0 {
+			log.Printf("Autopay: charged %d, failed %d", charged, failed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// charge списывает деньги, если условие автоплатежа сработало, и возвращает номер платежа.
+// nil - списывать не нужно или автоплатеж занят другим экземпляром сервера.
+func (s *AutopayService) charge(ctx context.Context, autopay *entity.Autopay, now time.Time) (*int, error) {
+	amount, err := s.repo.Need(autopay, now)
+	if err != nil || amount == 0 {
+		return nil, err
+	}
+
+	claimed, err := s.repo.Claim(autopay.ID, now, now.Add(autopayClaim))
+	if err != nil || !claimed {
+		return nil, err
+	}
+
+	card, err := s.repo.FindCard(autopay.UserID, autopay.CardID)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := s.payments.NewCardCharge(card, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	// Платеж привязывается к автоплатежу до запроса в шлюз. Если сервер упадет после
+	// списания, следующий запуск дождется итога этого платежа, а не спишет карту снова.
+	if err := s.repo.Attach(autopay.ID, payment.ID); err != nil {
+		s.payments.markFailed(payment)
+		return nil, err
+	}
+
+	// При отказе шлюза платеж закрыт как failed, это обычная неудача. Если шлюз не ответил,
+	// платеж остается pending и settle ждет его итога, а не списывает карту снова.
+	charged, err := s.payments.ChargeCard(ctx, card, payment)
+	if charged == nil {
+		return nil, err
+	}
+
+	return &charged.ID, nil
+}
+
+// settle учитывает итог списания autopay.PaymentID и возвращает его статус. Пока платеж
+// ждет подтверждения шлюза, ничего не меняется.
+func (s *AutopayService) settle(autopay *entity.Autopay, now time.Time) (string, error) {
+	payment, err := s.payments.Get(autopay.UserID, *autopay.PaymentID)
+	if err != nil {
+		return "", err
+	}
+
+	var reason string
+	switch payment.Status {
+	case entity.PaymentCompleted:
+		if _, err := s.repo.Succeeded(autopay.ID, payment.ID, now); err != nil {
+			return "", err
+		}
+		log.Printf("Autopay charged %s from user %d", payment.Amount, autopay.UserID)
+		return entity.PaymentCompleted, nil
+	case entity.PaymentPending:
+		if now.Sub(payment.CreatedAt) < autopayPendingTimeout {
+			return entity.PaymentPending, nil
+		}
+		reason = "Платежный сервис не подтвердил списание"
+	default:
+		reason = "Банк отклонил списание"
+		if payment.ExternalID == nil {
+			reason = "Платежный сервис недоступен"
+		}
+	}
+
+	next := now.Add(s.retryDelay(autopay.Failures + 1))
+	updated, err := s.repo.Failed(autopay.ID, payment.ID, reason, next, s.settings.MaxAttempts)
+	if err != nil || updated == nil {
+		return "", err
+	}
+
+	if !updated.Enabled {
+		meta := entity.AuditMeta{ActorType: entity.ActorSystem}
+		if err := s.audit.Record(meta, entity.AuditAutopayDisable, entity.AuditEntityUser, strconv.Itoa(updated.UserID), nil,
+			map[string]interface{}{"failures": updated.Failures, "last_error": updated.LastError}); err != nil {
+			log.Printf("Failed to record audit event %s: %v", entity.AuditAutopayDisable, err)
+		}
+	}
+
+	if err := s.notifyFailure(updated, payment.Amount); err != nil {
+		log.Printf("Failed to notify user %d about autopay failure: %v", updated.UserID, err)
+	}
+
+	return entity.PaymentFailed, nil
+}
+
+// retryDelay - пауза перед попыткой после failures неудач подряд
+func (s *AutopayService) retryDelay(failures int) time.Duration {
+	delay := s.settings.RetryDelay
+	for i := 1; i < failures && delay < 24*time.Hour; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+func (s *AutopayService) notifyFailure(autopay *entity.Autopay, amount entity.Kopecks) error {
+	user, err := s.users.GetUserByID(int64(autopay.UserID))
+	if err != nil {
+		return err
+	}
+
+	card := "сохраненной карты"
+	if saved, err := s.repo.FindCard(autopay.UserID, autopay.CardID); err == nil {
+		card = "карты " + saved.Title
+	}
+
+	next := fmt.Sprintf("Следующая попытка - %s.", autopay.NextAttemptAt.Format("02.01.2006 15:04"))
+	if !autopay.Enabled {
+		next = fmt.Sprintf("После %d неудачных попыток подряд автоплатеж отключен. Пополните баланс вручную "+
+			"или включите автоплатеж снова в личном кабинете.", autopay.Failures)
+	}
+
+	return s.mailer.Send(&MailMessage{
+		To:      user.Email,
+		Subject: "NetLink: не удалось пополнить баланс автоплатежом",
+		Body: fmt.Sprintf("Здравствуйте, %s!\n\nНе удалось списать %s ₽ с %s для пополнения баланса.\nПричина: %s.\n%s\n",
+			user.Name, amount, card, autopay.LastError, next),
+	})
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"strconv"
+	"time"
+)
+
+const billingBatch = 100
+
+// BillingService продлевает подписки: списывает очередной период или приостанавливает доступ
+type BillingService struct {
+	subs    *repository.SubscriptionRepository
+	tariffs *repository.TariffRepository
+	promos  *repository.PromoRepository
+	audit   *AuditService
+}
+
+func NewBillingService(subs *repository.SubscriptionRepository, tariffs *repository.TariffRepository,
+	promos *repository.PromoRepository, audit *AuditService) *BillingService {
+	return &BillingService{
+		subs:    subs,
+		tariffs: tariffs,
+		promos:  promos,
+		audit:   audit,
+	}
+}
+
+func (s *BillingService) GetSubscription(userID int) (*entity.Subscription, error) {
+	return s.subs.FindByUserID(userID)
+}
+
+func (s *BillingService) ListCharges(userID int) ([]entity.SubscriptionCharge, error) {
+	return s.subs.ListCharges(userID, 50)
+}
+
+// ActivateTariff подключает тариф и списывает первый период одной транзакцией.
+// Если период уже оплачен, возвращает ErrSubscriptionActive: тогда тариф меняется через ChangeTariff.
+// Промокод берется из запроса, а если его нет - из заявки на подключение с телефоном клиента.
+// Код из заявки, который уже нельзя применить, не мешает подключению.
+func (s *BillingService) ActivateTariff(user *entity.User, tariff *entity.Tariff, promoCode string) (*entity.TariffActivation, error) {
+	claim, err := s.promoClaim(user, promoCode)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	activation, err := s.subs.Activate(user.Id, tariff, now, claim)
+	if claim != nil && claim.Source == entity.PromoSourceApplication && IsPromoError(promoError(err)) {
+		log.Printf("Promo code %s from application %d not applied for user %d: %v", claim.Code, *claim.ApplicationID, user.Id, err)
+		activation, err = s.subs.Activate(user.Id, tariff, now, nil)
+	}
+
+	switch {
+	case errors.Is(err, repository.ErrSubscriptionActive) && claim != nil && claim.Source == entity.PromoSourceActivation:
+		return activation, ErrPromoActivationOnly
+	case errors.Is(err, repository.ErrSubscriptionActive):
+		return activation, ErrSubscriptionActive
+	case errors.Is(err, repository.ErrSubscriptionFrozen):
+		return activation, ErrAccountFrozen
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		return activation, ErrInsufficientFunds
+	}
+
+	return activation, promoError(err)
+}
+
+// promoClaim - промокод, который нужно применить при подключении, или nil
+func (s *BillingService) promoClaim(user *entity.User, promoCode string) (*entity.PromoClaim, error) {
+	if code := entity.NormalizePromoCode(promoCode); code != "" {
+		return &entity.PromoClaim{Code: code, Source: entity.PromoSourceActivation}, nil
+	}
+
+	active, err := s.promos.ActiveFor(user.Id)
+	if err != nil || active != nil || user.Phone == "" {
+		return nil, err
+	}
+
+	application, err := s.promos.ApplicationCode(user.Phone)
+	if err != nil || application == nil {
+		return nil, err
+	}
+	return &entity.PromoClaim{
+		Code:          application.PromoCode,
+		Source:        entity.PromoSourceApplication,
+		ApplicationID: &application.ID,
+	}, nil
+}
+
+// ResumeIfPossible сразу возобновляет приостановленную подписку, не дожидаясь планового запуска.
+// Вызывается после пополнения баланса.
+func (s *BillingService) ResumeIfPossible(userID int) error {
+	sub, err := s.subs.FindByUserID(userID)
+	if err != nil || sub == nil || sub.Status != entity.SubscriptionSuspended {
+		return err
+	}
+
+	_, err = s.bill(sub.ID, time.Now())
+	return err
+}
+
+// RunOnce обрабатывает все подписки, у которых наступил новый период. Ошибка по одной
+// подписке не останавливает остальные, она попадает в лог и в Failed.
+func (s *BillingService) RunOnce(now time.Time) (*entity.BillingRunResult, error) {
+	result := &entity.BillingRunResult{}
+	var lastID int64
+
+	for {
+		ids, err := s.subs.ListDue(now, lastID, billingBatch)
+		if err != nil {
+			return result, err
+		}
+
+		for _, id := range ids {
+			lastID = id
+
+			outcome, err := s.bill(id, now)
+			if err != nil {
+				log.Printf("Billing failed for subscription %d: %v", id, err)
+				result.Failed++
+				continue
+			}
+
+			switch outcome {
+			case entity.BillingCharged:
+				result.Charged++
+			case entity.BillingSuspended:
+				result.Suspended++
+			case entity.BillingResumed:
+				result.Resumed++
+			}
+		}
+
+		if len(ids) < billingBatch {
+			return result, nil
+		}
+	}
+}
+
+// Run запускает биллинг сразу и затем каждые interval, пока не отменен ctx
+func (s *BillingService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := s.RunOnce(time.Now())
+		if err != nil {
+			log.Printf("Billing run failed: %v", err)
+		} else if result.Charged+result.Suspended+result.Resumed+result.Failed > 0 {
+			log.Printf("Billing run: charged %d, suspended %d, resumed %d, failed %d",
+				result.Charged, result.Suspended, result.Resumed, result.Failed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *BillingService) bill(subscriptionID int64, now time.Time) (string, error) {
+	outcome, sub, charges, change, err := s.subs.Bill(subscriptionID, now)
+	if err != nil {
+		return "", err
+	}
+
+	if change != nil {
+		log.Printf("Subscription %d of user %d moved to scheduled tariff %d", sub.ID, sub.UserID, change.ToTariffID)
+		s.record(entity.AuditTariffChange, sub.UserID, change)
+	}
+
+	for _, charge := range charges {
+		s.record(entity.AuditSubscriptionCharge, sub.UserID, charge)
+	}
+
+	switch outcome {
+	case entity.BillingSuspended:
+		log.Printf("Subscription %d of user %d suspended: balance does not cover the next period", sub.ID, sub.UserID)
+		s.record(entity.AuditSubscriptionSuspend, sub.UserID, sub)
+	case entity.BillingResumed:
+		log.Printf("Subscription %d of user %d resumed", sub.ID, sub.UserID)
+		s.record(entity.AuditSubscriptionResume, sub.UserID, sub)
+	}
+
+	return outcome, nil
+}
+
+// record пишет действие биллинга в журнал от имени системы
+func (s *BillingService) record(action string, userID int, after interface{}) {
+	meta := entity.AuditMeta{ActorType: entity.ActorSystem}
+	if err := s.audit.Record(meta, action, entity.AuditEntityUser, strconv.Itoa(userID), nil, after); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"internet_provider/internal/entity"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// CashRegister - онлайн-касса. Касса регистрирует чек, передает его в ФНС через ОФД
+// и возвращает фискальные реквизиты. Чек с уже зарегистрированным FiscalReceipt.ID
+// касса не пробивает повторно, а возвращает реквизиты первой регистрации.
+type CashRegister interface {
+	Name() string
+	Register(ctx context.Context, receipt *FiscalReceipt) (*FiscalResult, error)
+}
+
+// FiscalReceipt - чек в том виде, в каком он уходит в кассу. ID - ключ идемпотентности:
+// повторная отправка того же чека не должна пробивать его второй раз.
+type FiscalReceipt struct {
+	ID          int64          `json:"id"`
+	Operation   string         `json:"operation"`
+	CompanyINN  string         `json:"company_inn"`
+	Taxation    string         `json:"taxation"`
+	Contact     string         `json:"contact"`
+	Items       []FiscalItem   `json:"items"`
+	PaymentType string         `json:"payment_type"`
+	Total       entity.Kopecks `json:"total"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+type FiscalItem struct {
+	Name      string         `json:"name"`
+	Quantity  int            `json:"quantity"`
+	Price     entity.Kopecks `json:"price"`
+	Sum       entity.Kopecks `json:"sum"`
+	VAT       string         `json:"vat"`
+	VATAmount entity.Kopecks `json:"vat_amount"`
+	Method    string         `json:"method"`
+	Subject   string         `json:"subject"`
+}
+
+// FiscalResult - реквизиты зарегистрированного чека: номер фискального накопителя,
+// номер фискального документа, фискальный признак и ссылка на чек
+type FiscalResult struct {
+	ExternalID     string
+	FiscalNumber   string
+	FiscalDocument string
+	FiscalSign     string
+	URL            string
+	RegisteredAt   time.Time
+}
+
+// localFiscalNumber - номер фискального накопителя кассы-заглушки
+const localFiscalNumber = "9999078900000000"
+
+// LocalCashRegister - касса-заглушка для разработки: пишет чеки в каталог и выдает
+// правдоподобные фискальные реквизиты. Если задан checkURL, ссылка на чек строится
+// по нему в формате проверки чека ФНС.
+type LocalCashRegister struct {
+	dir      string
+	checkURL string
+}
+
+func NewLocalCashRegister(dir, checkURL string) (*LocalCashRegister, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &LocalCashRegister{dir: dir, checkURL: checkURL}, nil
+}
+
+func (r *LocalCashRegister) Name() string {
+	return "local"
+}
+
+func (r *LocalCashRegister) Register(ctx context.Context, receipt *FiscalReceipt) (*FiscalResult, error) {
+	body, err := json.MarshalIndent(receipt, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	result := &FiscalResult{
+		ExternalID:     "local-" + strconv.FormatInt(receipt.ID, 10),
+		FiscalNumber:   localFiscalNumber,
+		FiscalDocument: strconv.FormatInt(receipt.ID, 10),
+		FiscalSign:     strconv.FormatUint(uint64(binary.BigEndian.Uint32(sum[:4])), 10),
+		RegisteredAt:   time.Now(),
+	}
+	if r.checkURL != "" {
+		query := url.Values{}
+		query.Set("fn", result.FiscalNumber)
+		query.Set("i", result.FiscalDocument)
+		query.Set("fp", result.FiscalSign)
+		query.Set("s", receipt.Total.String())
+		query.Set("t", result.RegisteredAt.Format("20060102T1504"))
+		query.Set("n", "1")
+		if receipt.Operation == entity.ReceiptIncomeReturn {
+			query.Set("n", "2")
+		}
+		result.URL = r.checkURL + "?" + query.Encode()
+	}
+
+	// Повторная отправка перезаписывает тот же файл, как касса с ключом идемпотентности
+	name := fmt.Sprintf("receipt-%06d.json", receipt.ID)
+	if err := os.WriteFile(filepath.Join(r.dir, name), body, 0600); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPeriodNotClosed  = errors.New("document period is not over yet")
+	ErrDocumentNotFound = errors.New("document not found")
+)
+
+// invoiceCounter - счетчик номеров счетов
+const invoiceCounter = "invoice"
+
+// DocumentService выставляет клиентам счета за месяц и выписки в PDF. Документ строится
+// только за закончившийся период, сохраняется при первой выдаче и дальше не меняется.
+type DocumentService struct {
+	docs   *repository.DocumentRepository
+	ledger *repository.LedgerRepository
+	users  *repository.UserRepository
+	pdf    *PDFService
+}
+
+func NewDocumentService(docs *repository.DocumentRepository, ledger *repository.LedgerRepository,
+	users *repository.UserRepository, pdf *PDFService) *DocumentService {
+	return &DocumentService{
+		docs:   docs,
+		ledger: ledger,
+		users:  users,
+		pdf:    pdf,
+	}
+}
+
+// Invoice - счет за календарный месяц, в который попадает month
+func (s *DocumentService) Invoice(userID int, month time.Time) (*entity.Document, error) {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+
+	return s.issue(entity.DocumentInvoice, userID, from, from.AddDate(0, 1, 0), time.Now())
+}
+
+// Statement - выписка за [from, to)
+func (s *DocumentService) Statement(userID int, from, to time.Time) (*entity.Document, error) {
+	if !from.Before(to) || to.Sub(from) > maxStatementPeriod {
+		return nil, ErrInvalidPeriod
+	}
+
+	return s.issue(entity.DocumentStatement, userID, from, to, time.Now())
+}
+
+func (s *DocumentService) List(userID int) ([]entity.Document, error) {
+	return s.docs.ListForUser(userID)
+}
+
+func (s *DocumentService) Get(userID int, documentID int64) (*entity.Document, error) {
+	doc, err := s.docs.FindForUser(userID, documentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDocumentNotFound
+	}
+	return doc, err
+}
+
+// IssueInvoices выставляет счета за месяц всем клиентам, у которых в этом месяце были операции
+func (s *DocumentService) IssueInvoices(month time.Time) (int, error) {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+	to := from.AddDate(0, 1, 0)
+
+	ids, err := s.docs.UninvoicedCustomers(from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	issued := 0
+	for _, id := range ids {
+		if _, err := s.issue(entity.DocumentInvoice, id, from, to, time.Now()); err != nil {
+			log.Printf("Failed to issue invoice for %s to user %d: %v", from.Format("2006-01"), id, err)
+			continue
+		}
+		issued++
+	}
+
+	return issued, nil
+}
+
+// Run выставляет счета за прошлый месяц, как только он закончился. Уже выставленные
+// счета пропускаются, поэтому частый запуск безопасен.
+func (s *DocumentService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		previous := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0)
+		if issued, err := s.IssueInvoices(previous); err != nil {
+			log.Printf("Invoice run failed: %v", err)
+		} else if issued > 0 {
+			log.Printf("Issued %d invoices for %s", issued, previous.Format("2006-01"))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// issue возвращает сохраненный документ за период или строит, рендерит и сохраняет новый
+func (s *DocumentService) issue(docType string, userID int, from, to, now time.Time) (*entity.Document, error) {
+	if to.After(now) {
+		return nil, ErrPeriodNotClosed
+	}
+
+	existing, err := s.docs.Find(docType, userID, from, to)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	user, err := s.users.GetUserByID(int64(userID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCustomerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := s.ledger.Statement(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &entity.Document{
+		Type:           docType,
+		UserID:         userID,
+		PeriodStart:    from,
+		PeriodEnd:      to,
+		OpeningBalance: statement.OpeningBalance,
+		ClosingBalance: statement.ClosingBalance,
+		CreatedAt:      now,
+	}
+
+	counter := ""
+	var render func() ([]byte, error)
+	switch docType {
+	case entity.DocumentInvoice:
+		counter = invoiceCounter
+		lines := invoiceLines(doc, statement)
+		render = func() ([]byte, error) { return s.pdf.RenderInvoice(doc, user, lines) }
+	default:
+		doc.Charges = statement.Debits
+		doc.Payments = statement.Credits
+		render = func() ([]byte, error) { return s.pdf.RenderStatement(doc, user, statement) }
+	}
+
+	// Номер счета известен только внутри транзакции, поэтому PDF рендерится там же
+	return s.docs.Create(doc, counter, func(number int64) error {
+		if number > 0 {
+			formatted := fmt.Sprintf("%06d", number)
+			doc.Number = &formatted
+		}
+
+		content, err := render()
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		doc.Content = content
+		doc.Checksum = hex.EncodeToString(sum[:])
+		return nil
+	})
+}
+
+// invoiceLines - услуги в счете: списания за тариф, дополнительные услуги и заморозку
+// и возвраты этих списаний. Заодно считает итог начислений и оплат за период.
+func invoiceLines(doc *entity.Document, statement *entity.Statement) []InvoiceLine {
+	var lines []InvoiceLine
+	for _, line := range statement.Lines {
+		switch {
+		case line.Type == entity.LedgerTariffCharge, line.Type == entity.LedgerAddonCharge,
+			line.Type == entity.LedgerFreezeFee, line.Type == entity.LedgerRefund && line.Amount > 0:
+			lines = append(lines, InvoiceLine{Date: line.CreatedAt, Description: line.Description, Amount: -line.Amount})
+			doc.Charges -= line.Amount
+		case line.Type == entity.LedgerTopUp, line.Type == entity.LedgerRefund,
+			line.Type == entity.LedgerPromise, line.Type == entity.LedgerPromiseRepay:
+			doc.Payments += line.Amount
+		}
+	}
+	return lines
+}
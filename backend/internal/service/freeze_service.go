@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrAccountFrozen         = errors.New("account is frozen")
+	ErrFreezeNoSubscription  = errors.New("no paid subscription to freeze")
+	ErrFreezeActive          = errors.New("account is already frozen")
+	ErrFreezeNotActive       = errors.New("account is not frozen")
+	ErrFreezePromise         = errors.New("promised payment is not repaid")
+	ErrFreezeDuration        = errors.New("freeze duration is out of the allowed range")
+	ErrFreezeEnd             = errors.New("freeze end must be after its start and in the future")
+	ErrInvalidFreezeSettings = errors.New("minimum freeze duration exceeds the maximum")
+)
+
+// FreezeService ведет добровольную заморозку аккаунтов: клиент сам ставит услугу на паузу
+// в пределах сроков из настроек, администратор - без ограничений. По окончании срока
+// аккаунт размораживается автоматически.
+type FreezeService struct {
+	repo     *repository.FreezeRepository
+	settings *repository.SettingsRepository
+	audit    *AuditService
+}
+
+func NewFreezeService(repo *repository.FreezeRepository, settings *repository.SettingsRepository, audit *AuditService) *FreezeService {
+	return &FreezeService{
+		repo:     repo,
+		settings: settings,
+		audit:    audit,
+	}
+}
+
+func (s *FreezeService) Settings() (*entity.FreezeSettings, error) {
+	minDays, err := s.settings.GetInt(entity.SettingFreezeMinDays, entity.DefaultFreezeMinDays)
+	if err != nil {
+		return nil, err
+	}
+	maxDays, err := s.settings.GetInt(entity.SettingFreezeMaxDays, entity.DefaultFreezeMaxDays)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := s.settings.GetInt(entity.SettingFreezeDailyFee, int(entity.DefaultFreezeDailyFee))
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.FreezeSettings{MinDays: minDays, MaxDays: maxDays, DailyFee: entity.Kopecks(fee)}, nil
+}
+
+func (s *FreezeService) UpdateSettings(settings *entity.FreezeSettings, adminID int64) error {
+	if settings.MinDays > settings.MaxDays {
+		return ErrInvalidFreezeSettings
+	}
+
+	if err := s.settings.Set(entity.SettingFreezeMinDays, strconv.Itoa(settings.MinDays), adminID); err != nil {
+		return err
+	}
+	if err := s.settings.Set(entity.SettingFreezeMaxDays, strconv.Itoa(settings.MaxDays), adminID); err != nil {
+		return err
+	}
+	return s.settings.Set(entity.SettingFreezeDailyFee, strconv.FormatInt(int64(settings.DailyFee), 10), adminID)
+}
+
+// Offer - текущая заморозка клиента и условия, на которых можно заморозить аккаунт
+func (s *FreezeService) Offer(userID int) (*entity.FreezeOffer, error) {
+	settings, err := s.Settings()
+	if err != nil {
+		return nil, err
+	}
+	active, err := s.repo.Active(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.FreezeOffer{Active: active, Settings: settings}, nil
+}
+
+func (s *FreezeService) History(userID int) ([]entity.AccountFreeze, error) {
+	return s.repo.ListForUser(userID)
+}
+
+func (s *FreezeService) ListActive() ([]entity.FreezeReport, error) {
+	return s.repo.ListActive()
+}
+
+// Freeze замораживает аккаунт по просьбе клиента на срок из настроек с платой за каждый день
+func (s *FreezeService) Freeze(userID, days int) (*entity.AccountFreeze, error) {
+	settings, err := s.Settings()
+	if err != nil {
+		return nil, err
+	}
+	if days < settings.MinDays || days > settings.MaxDays {
+		return nil, ErrFreezeDuration
+	}
+
+	freeze, err := s.repo.Freeze(userID, days, settings.DailyFee, nil, "", time.Now())
+	return freeze, freezeError(err)
+}
+
+// AdminFreeze замораживает аккаунт без ограничений срока и без платы
+func (s *FreezeService) AdminFreeze(userID, days int, reason string, adminID int64) (*entity.AccountFreeze, error) {
+	freeze, err := s.repo.Freeze(userID, days, 0, &adminID, reason, time.Now())
+	return freeze, freezeError(err)
+}
+
+// Unfreeze размораживает аккаунт раньше срока
+func (s *FreezeService) Unfreeze(userID int) (*entity.AccountFreeze, error) {
+	freeze, err := s.repo.Unfreeze(userID, time.Now())
+	return freeze, freezeError(err)
+}
+
+// SetEnd переносит дату автоматической разморозки. Возвращает заморозку до и после изменения.
+func (s *FreezeService) SetEnd(userID int, endAt time.Time) (*entity.AccountFreeze, *entity.AccountFreeze, error) {
+	before, freeze, err := s.repo.SetEnd(userID, endAt, time.Now())
+	return before, freeze, freezeError(err)
+}
+
+// ResumeDue размораживает аккаунты, у которых закончился срок заморозки
+func (s *FreezeService) ResumeDue(now time.Time) (int, error) {
+	ids, err := s.repo.ListDue(now)
+	if err != nil {
+		return 0, err
+	}
+
+	resumed := 0
+	for _, userID := range ids {
+		freeze, err := s.repo.Unfreeze(userID, now)
+		if errors.Is(err, repository.ErrFreezeNotActive) {
+			// Клиент разморозил аккаунт сам, пока шел проход
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to unfreeze account of user %d: %v", userID, err)
+			continue
+		}
+
+		meta := entity.AuditMeta{ActorType: entity.ActorSystem}
+		if err := s.audit.Record(meta, entity.AuditFreezeEnd, entity.AuditEntityUser, strconv.Itoa(userID), nil, freeze); err != nil {
+			log.Printf("Failed to record audit event %s: %v", entity.AuditFreezeEnd, err)
+		}
+		resumed++
+	}
+
+	return resumed, nil
+}
+
+func (s *FreezeService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if resumed, err := s.ResumeDue(time.Now()); err != nil {
+			log.Printf("Freeze run failed: %v", err)
+		} else if resumed > 0 {
+			log.Printf("Unfroze %d accounts", resumed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func freezeError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrFreezeNoSubscription):
+		return ErrFreezeNoSubscription
+	case errors.Is(err, repository.ErrFreezeActive):
+		return ErrFreezeActive
+	case errors.Is(err, repository.ErrFreezeNotActive):
+		return ErrFreezeNotActive
+	case errors.Is(err, repository.ErrFreezePromise):
+		return ErrFreezePromise
+	case errors.Is(err, repository.ErrFreezeEnd):
+		return ErrFreezeEnd
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		return ErrInsufficientFunds
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minSigningKeyLength = 32
+
+var ErrUnknownSigningKey = errors.New("unknown or retired signing key")
+
+// SigningKey - ключ HMAC для JWT. VerifyUntil ограничивает окно ротации: после него
+// токены этого ключа не принимаются. Нулевое значение - без ограничения.
+type SigningKey struct {
+	ID          string
+	Secret      []byte
+	VerifyUntil time.Time
+	Legacy      bool
+}
+
+// KeyRing подписывает токены активным ключом и проверяет их по kid из заголовка,
+// поэтому во время ротации работают токены и нового, и старого ключа.
+type KeyRing struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+	legacy *SigningKey
+}
+
+func NewKeyRing(activeID string, keys []SigningKey) (*KeyRing, error) {
+	ring := &KeyRing{keys: make(map[string]*SigningKey, len(keys))}
+
+	for i := range keys {
+		key := &keys[i]
+		if key.ID == "" {
+			return nil, errors.New("signing key without kid")
+		}
+		if len(key.Secret) < minSigningKeyLength {
+			return nil, fmt.Errorf("signing key %q is shorter than %d bytes", key.ID, minSigningKeyLength)
+		}
+		if _, exists := ring.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate signing key %q", key.ID)
+		}
+		if key.Legacy {
+			if ring.legacy != nil {
+				return nil, errors.New("only one legacy signing key is allowed")
+			}
+			ring.legacy = key
+		}
+		ring.keys[key.ID] = key
+	}
+
+	active, ok := ring.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q is not configured", activeID)
+	}
+	if !active.VerifyUntil.IsZero() {
+		return nil, fmt.Errorf("active signing key %q must not have verify_until", activeID)
+	}
+	ring.active = active
+
+	return ring, nil
+}
+
+// Sign подписывает claims активным ключом и проставляет kid в заголовок
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = k.active.ID
+
+	return token.SignedString(k.active.Secret)
+}
+
+// Keyfunc для jwt.Parse: выбирает ключ по kid и отклоняет ключи, чье окно ротации закончилось
+func (k *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Method)
+	}
+
+	key := k.legacy
+	if kid, ok := token.Header["kid"].(string); ok {
+		key = k.keys[kid]
+	}
+	if key == nil {
+		return nil, ErrUnknownSigningKey
+	}
+
+	if !key.VerifyUntil.IsZero() && time.Now().After(key.VerifyUntil) {
+		return nil, ErrUnknownSigningKey
+	}
+
+	return key.Secret, nil
+}
+
+func (k *KeyRing) ActiveKeyID() string {
+	return k.active.ID
+}
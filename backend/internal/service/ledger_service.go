@@ -0,0 +1,30 @@
+package service
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"time"
+)
+
+var ErrInvalidPeriod = errors.New("invalid statement period")
+
+// maxStatementPeriod - выписку дольше года не строим одним запросом
+const maxStatementPeriod = 366 * 24 * time.Hour
+
+type LedgerService struct {
+	repo *repository.LedgerRepository
+}
+
+func NewLedgerService(repo *repository.LedgerRepository) *LedgerService {
+	return &LedgerService{repo: repo}
+}
+
+// Statement - выписка по счету клиента за [from, to)
+func (s *LedgerService) Statement(userID int, from, to time.Time) (*entity.Statement, error) {
+	if !from.Before(to) || to.Sub(from) > maxStatementPeriod {
+		return nil, ErrInvalidPeriod
+	}
+
+	return s.repo.Statement(userID, from, to)
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"fmt"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"strings"
+	"time"
+)
+
+// Политика защиты от перебора паролей
+const (
+	failureWindow = time.Hour
+
+	// После freeAttempts ошибок каждая следующая попытка возможна только через задержку,
+	// которая удваивается до maxDelay
+	freeAttempts = 3
+	baseDelay    = time.Second
+	maxDelay     = 30 * time.Second
+
+	accountLockThreshold = 10
+	ipLockThreshold      = 50
+	baseLockDuration     = 15 * time.Minute
+	maxLockDuration      = 24 * time.Hour
+)
+
+// LoginBlockedError - вход временно запрещен. Locked=false означает прогрессивную задержку.
+type LoginBlockedError struct {
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+func (e *LoginBlockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("login locked, retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("too many attempts, retry after %s", e.RetryAfter)
+}
+
+type LoginGuard struct {
+	repo *repository.LoginThrottleRepository
+}
+
+func NewLoginGuard(repo *repository.LoginThrottleRepository) *LoginGuard {
+	return &LoginGuard{repo: repo}
+}
+
+func CustomerThrottleKey(email string) string {
+	return entity.ThrottleScopeCustomer + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func AdminThrottleKey(username string) string {
+	return entity.ThrottleScopeAdmin + ":" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func IPThrottleKey(ip string) string {
+	return entity.ThrottleScopeIP + ":" + ip
+}
+
+// Check вызывается до проверки пароля. Возвращает *LoginBlockedError, если входить пока нельзя.
+// Разрешенная попытка сразу засчитывается как неудачная, поэтому параллельные запросы
+// не проверят больше паролей, чем позволяют лимиты. После проверки пароля нужно вызвать
+// RegisterFailure или RegisterSuccess, а если пароль так и не проверялся - Release.
+func (g *LoginGuard) Check(accountKey, scope, ip string) error {
+	now := time.Now()
+	ipKey := IPThrottleKey(ip)
+	expected := 1
+
+	for _, key := range []string{accountKey, ipKey} {
+		throttle, err := g.repo.Find(key)
+		if err != nil {
+			return err
+		}
+		if throttle == nil {
+			continue
+		}
+
+		if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+			return &LoginBlockedError{Locked: true, RetryAfter: throttle.LockedUntil.Sub(now)}
+		}
+
+		// Задержку применяем только к аккаунту: за одним IP может быть много абонентов
+		if key == accountKey {
+			if wait := progressiveDelay(throttle.Failures) - now.Sub(throttle.LastFailureAt); wait > 0 {
+				return &LoginBlockedError{RetryAfter: wait}
+			}
+			if !throttle.LastFailureAt.Before(now.Add(-failureWindow)) {
+				expected = throttle.Failures + 1
+			}
+		}
+	}
+
+	account, err := g.repo.RegisterAttempt(accountKey, scope, now.Add(-failureWindow))
+	if err != nil {
+		return err
+	}
+	// Другой запрос засчитал попытку между чтением и записью: в зоне задержки он уже занял
+	// очередь, а сверх порога проверять пароль нельзя совсем
+	if account.Failures > accountLockThreshold || (account.Failures > freeAttempts && account.Failures != expected) {
+		g.release(accountKey)
+		return &LoginBlockedError{RetryAfter: progressiveDelay(account.Failures)}
+	}
+
+	addr, err := g.repo.RegisterAttempt(ipKey, entity.ThrottleScopeIP, now.Add(-failureWindow))
+	if err != nil {
+		g.release(accountKey)
+		return err
+	}
+	if addr.Failures > ipLockThreshold {
+		g.Release(accountKey, ip)
+		return &LoginBlockedError{RetryAfter: maxDelay}
+	}
+
+	return nil
+}
+
+// RegisterFailure вызывается, если пароль неверный. Попытка уже засчитана в Check, здесь
+// аккаунт или IP блокируются, если счетчик дошел до порога.
+func (g *LoginGuard) RegisterFailure(accountKey, ip string) error {
+	if err := g.lockIfExceeded(accountKey, ip, accountLockThreshold); err != nil {
+		return err
+	}
+
+	return g.lockIfExceeded(IPThrottleKey(ip), ip, ipLockThreshold)
+}
+
+// RegisterSuccess сбрасывает ошибки аккаунта, а попытку с IP снимает: успешный вход
+// не должен приближать блокировку адреса, за которым много абонентов
+func (g *LoginGuard) RegisterSuccess(accountKey, ip string) error {
+	if err := g.repo.Reset(accountKey); err != nil {
+		return err
+	}
+
+	return g.repo.Release(IPThrottleKey(ip))
+}
+
+// Release снимает попытку, засчитанную в Check, если пароль не проверялся или вход
+// продолжится вторым шагом
+func (g *LoginGuard) Release(accountKey, ip string) {
+	g.release(accountKey)
+	g.release(IPThrottleKey(ip))
+}
+
+func (g *LoginGuard) release(key string) {
+	if err := g.repo.Release(key); err != nil {
+		log.Printf("Failed to release login attempt for %s: %v", key, err)
+	}
+}
+
+func (g *LoginGuard) Unlock(key string, adminID int64) (bool, error) {
+	unlocked, err := g.repo.Unlock(key, adminID)
+	if err == nil && unlocked {
+		log.Printf("Login lock for %s removed by admin %d", key, adminID)
+	}
+	return unlocked, err
+}
+
+func (g *LoginGuard) ListLocked() ([]entity.LoginThrottle, error) {
+	return g.repo.ListLocked()
+}
+
+func (g *LoginGuard) ListEvents(scope string, page, limit int) ([]entity.LockoutEvent, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	return g.repo.ListEvents(scope, page, limit)
+}
+
+func (g *LoginGuard) lockIfExceeded(key, ip string, threshold int) error {
+	throttle, err := g.repo.Find(key)
+	if err != nil {
+		return err
+	}
+
+	if throttle == nil || throttle.Failures < threshold {
+		return nil
+	}
+
+	until := time.Now().Add(lockDuration(throttle.LockCount))
+	locked, err := g.repo.Lock(throttle, until, ip)
+	if err != nil {
+		return err
+	}
+	if locked {
+		log.Printf("🔒 Login locked for %s until %s after %d failures (ip: %s)", key, until.Format(time.RFC3339), throttle.Failures, ip)
+	}
+
+	return nil
+}
+
+func progressiveDelay(failures int) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+
+	delay := baseDelay << (failures - freeAttempts)
+	if delay > maxDelay || delay <= 0 {
+		return maxDelay
+	}
+	return delay
+}
+
+// Каждая следующая блокировка вдвое длиннее предыдущей
+func lockDuration(previousLocks int) time.Duration {
+	if previousLocks > 6 {
+		return maxLockDuration
+	}
+
+	duration := baseLockDuration << previousLocks
+	if duration > maxLockDuration {
+		return maxLockDuration
+	}
+	return duration
+}
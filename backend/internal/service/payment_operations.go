@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotRefundable    = errors.New("payment cannot be refunded")
+	ErrRefundTooLarge   = errors.New("refund exceeds the refundable amount")
+	ErrChargeNotFound   = errors.New("charge not found")
+	ErrChargeReversed   = errors.New("charge is already reversed")
+	ErrCustomerNotFound = errors.New("customer not found")
+	ErrRefundPending    = errors.New("refund is waiting for the payment provider")
+)
+
+// refundRetryDelay - более свежий возврат еще может ждать ответа шлюза в RefundPayment,
+// поэтому RetryRefunds его не трогает
+const refundRetryDelay = time.Minute
+
+// RefundPayment возвращает пополнение целиком (amount = 0) или частично. Деньги уходят
+// обратно через шлюз, которым клиент платил. Платежи, принятые до подключения шлюза,
+// возвращаются только по журналу, без обращения к шлюзу. Запрос в шлюз идет вне транзакции,
+// после того как возврат сохранен в статусе refund_pending. Если шлюз не ответил или итог
+// не сохранился, возврат остается в refund_pending, его доводит RetryRefunds, а вызывающий
+// получает ErrRefundPending.
+func (s *PaymentService) RefundPayment(ctx context.Context, adminID int64, paymentID int, amount entity.Kopecks, reason string) (*entity.Payment, *entity.Payment, error) {
+	refund, payment, err := s.repo.StartRefund(paymentID, amount, reason, adminID, time.Now())
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, nil, ErrPaymentNotFound
+	case errors.Is(err, repository.ErrNotRefundable):
+		return nil, nil, ErrNotRefundable
+	case errors.Is(err, repository.ErrRefundTooLarge):
+		return nil, nil, ErrRefundTooLarge
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		return nil, nil, ErrInsufficientFunds
+	case err != nil:
+		return nil, nil, err
+	}
+
+	externalID, err := s.refundAtProvider(ctx, payment, refund)
+	if err != nil {
+		return nil, nil, s.refundNotSent(refund, err)
+	}
+
+	completed, payment, err := s.repo.CompleteRefund(refund.ID, externalID, time.Now())
+	if err != nil {
+		log.Printf("Refund %d of payment %d was sent to the provider as %q but not completed, it will be retried: %v",
+			refund.ID, paymentID, externalID, err)
+		return nil, nil, ErrRefundPending
+	}
+
+	return completed, payment, nil
+}
+
+// RetryRefunds доводит возвраты, которые остались в refund_pending: шлюз не ответил или
+// его ответ не сохранился. Запрос повторяется с тем же ключом, поэтому уже выполненный
+// возврат шлюз не проведет второй раз, а вернет его номер.
+func (s *PaymentService) RetryRefunds(ctx context.Context, now time.Time) (completed, failed int, err error) {
+	refunds, err := s.repo.PendingRefunds(now.Add(-refundRetryDelay))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i := range refunds {
+		refund := &refunds[i]
+		payment, err := s.repo.Find(*refund.ParentID)
+		if err != nil {
+			return completed, failed, err
+		}
+
+		externalID, err := s.refundAtProvider(ctx, payment, refund)
+		if err != nil {
+			if !errors.Is(s.refundNotSent(refund, err), ErrRefundPending) {
+				failed++
+			}
+			continue
+		}
+
+		done, payment, err := s.repo.CompleteRefund(refund.ID, externalID, now)
+		if errors.Is(err, repository.ErrRefundNotPending) {
+			continue
+		}
+		if err != nil {
+			return completed, failed, err
+		}
+		completed++
+
+		meta := entity.AuditMeta{ActorType: entity.ActorSystem}
+		if err := s.audit.Record(meta, entity.AuditPaymentRefund, entity.AuditEntityPayment, strconv.Itoa(payment.ID), nil,
+			map[string]interface{}{"status": payment.Status, "refunded_amount": payment.RefundedAmount, "refund_id": done.ID,
+				"amount": -done.Amount, "reason": done.Reason, "external_id": done.ExternalID}); err != nil {
+			log.Printf("Failed to record audit event %s: %v", entity.AuditPaymentRefund, err)
+		}
+	}
+
+	return completed, failed, nil
+}
+
+// RunRefunds повторяет зависшие возвраты каждые interval, пока не отменен ctx
+func (s *PaymentService) RunRefunds(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		completed, failed, err := s.RetryRefunds(ctx, time.Now())
+		if err != nil {
+			log.Printf("Refund retry run failed: %v", err)
+		} else if completed+failed > 0 {
+			log.Printf("Refunds: completed %d, failed %d", completed, failed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refundAtProvider возвращает деньги через шлюз и отдает номер возврата в шлюзе.
+// Ключом идемпотентности служит номер возврата.
+func (s *PaymentService) refundAtProvider(ctx context.Context, payment, refund *entity.Payment) (string, error) {
+	if payment.Provider == "" {
+		return "", nil
+	}
+	if payment.Provider != s.provider.Name() || payment.ExternalID == nil {
+		return "", ErrUnknownProvider
+	}
+
+	externalID, err := s.provider.Refund(ctx, *payment.ExternalID, -refund.Amount, "refund-"+strconv.Itoa(refund.ID))
+	if err != nil {
+		log.Printf("Payment provider %s failed to refund payment %d: %v", s.provider.Name(), payment.ID, err)
+		return "", err
+	}
+	return externalID, nil
+}
+
+// refundNotSent разбирает ошибку refundAtProvider. Возврат отменяется, только если шлюз
+// точно его не провел: деньги возвращаются на баланс. Если ответа не было, деньги могли
+// уйти, поэтому возврат остается в refund_pending до RetryRefunds.
+func (s *PaymentService) refundNotSent(refund *entity.Payment, err error) error {
+	if !errors.Is(err, ErrProviderDeclined) && !errors.Is(err, ErrUnknownProvider) {
+		return ErrRefundPending
+	}
+
+	if failErr := s.repo.FailRefund(refund.ID, time.Now()); failErr != nil {
+		log.Printf("Failed to cancel refund %d after provider error: %v", refund.ID, failErr)
+	}
+	if errors.Is(err, ErrUnknownProvider) {
+		return ErrUnknownProvider
+	}
+	return ErrProviderUnavailable
+}
+
+// ReverseCharge возвращает на баланс списание за тариф
+func (s *PaymentService) ReverseCharge(adminID int64, chargeID int64, reason string) (*entity.Payment, *entity.SubscriptionCharge, error) {
+	reversal, charge, err := s.repo.ReverseCharge(chargeID, reason, adminID, time.Now())
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, nil, ErrChargeNotFound
+	case errors.Is(err, repository.ErrChargeReversed):
+		return nil, nil, ErrChargeReversed
+	case err != nil:
+		return nil, nil, err
+	}
+
+	s.resume(reversal.UserID)
+	return reversal, charge, nil
+}
+
+// AdjustBalance - ручное зачисление или списание с обязательной причиной
+func (s *PaymentService) AdjustBalance(adminID int64, userID int, amount entity.Kopecks, reason string) (*entity.Payment, error) {
+	adjustment, err := s.repo.Adjust(userID, amount, reason, adminID, time.Now())
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		return nil, ErrCustomerNotFound
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		return nil, ErrInsufficientFunds
+	case err != nil:
+		return nil, err
+	}
+
+	if amount > 0 {
+		s.resume(userID)
+	}
+	return adjustment, nil
+}
+
+// resume возобновляет подписку после зачисления. Деньги уже проведены, поэтому
+// ошибка здесь только пишется в лог.
+func (s *PaymentService) resume(userID int) {
+	if err := s.billing.ResumeIfPossible(userID); err != nil {
+		log.Printf("Failed to resume subscription of user %d: %v", userID, err)
+	}
+}
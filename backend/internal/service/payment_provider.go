@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/mockpay"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrProviderDeclined - шлюз явно отклонил запрос, и операция в нем точно не создана.
+	// Любая другая ошибка (сеть, таймаут, 5xx) означает, что итог неизвестен.
+	ErrProviderDeclined = errors.New("payment provider declined the request")
+)
+
+// PaymentProvider - платежный шлюз. Шлюз создает платеж, покупатель подтверждает его на странице
+// шлюза по ConfirmationURL, а итог приходит подписанным webhook. Платеж по сохраненной карте
+// проходит без покупателя, и шлюз может сразу вернуть его итог в Status.
+type PaymentProvider interface {
+	Name() string
+	CreatePayment(ctx context.Context, req *ProviderPaymentRequest) (*ProviderPayment, error)
+	// ParseWebhook проверяет подпись и возвращает событие. Неподписанные запросы отклоняются с ErrInvalidSignature.
+	ParseWebhook(body []byte, header http.Header) (*ProviderEvent, error)
+	// Refund возвращает часть или всю сумму оплаченного платежа и отдает номер возврата в шлюзе.
+	// Повтор с тем же key не возвращает деньги второй раз, а отдает уже созданный возврат.
+	Refund(ctx context.Context, externalID string, amount entity.Kopecks, key string) (string, error)
+}
+
+// ProviderPaymentRequest - SaveCard просит шлюз сохранить карту после оплаты, CardToken -
+// списать деньги с ранее сохраненной карты
+type ProviderPaymentRequest struct {
+	PaymentID   int
+	Amount      entity.Kopecks
+	Description string
+	ReturnURL   string
+	SaveCard    bool
+	CardToken   string
+}
+
+// ProviderPayment - созданный платеж. Status пустой, пока итог неизвестен.
+type ProviderPayment struct {
+	ExternalID      string
+	ConfirmationURL string
+	Status          string
+}
+
+// ProviderEvent - изменение статуса платежа в шлюзе. Status - один из entity.PaymentX.
+// Card заполнен, если шлюз сохранил карту покупателя.
+type ProviderEvent struct {
+	ExternalID string
+	PaymentID  int
+	Status     string
+	Amount     entity.Kopecks
+	Card       *ProviderCard
+}
+
+// ProviderCard - сохраненная в шлюзе карта. Token - ее ID в шлюзе, Title - маска для клиента.
+type ProviderCard struct {
+	Token string
+	Title string
+}
+
+// MockProvider работает с учебным шлюзом mockpay по HTTP
+type MockProvider struct {
+	baseURL    string
+	secret     string
+	webhookURL string
+	client     *http.Client
+}
+
+func NewMockProvider(baseURL, secret, webhookURL string) *MockProvider {
+	return &MockProvider{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		secret:     secret,
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+func (p *MockProvider) CreatePayment(ctx context.Context, req *ProviderPaymentRequest) (*ProviderPayment, error) {
+	body, err := json.Marshal(mockpay.CreateRequest{
+		Amount:            int64(req.Amount),
+		Description:       req.Description,
+		OrderID:           strconv.Itoa(req.PaymentID),
+		ReturnURL:         req.ReturnURL,
+		WebhookURL:        p.webhookURL,
+		SavePaymentMethod: req.SaveCard,
+		PaymentMethodID:   req.CardToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/api/payments", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+p.secret)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, mockAnswerError(resp)
+	}
+
+	var payment mockpay.Payment
+	if err := json.NewDecoder(resp.Body).Decode(&payment); err != nil {
+		return nil, err
+	}
+
+	created := &ProviderPayment{ExternalID: payment.ID, ConfirmationURL: payment.ConfirmationURL}
+	if payment.Status != mockpay.StatusPending {
+		if created.Status, err = mockStatus(payment.Status); err != nil {
+			return nil, err
+		}
+	}
+
+	return created, nil
+}
+
+func (p *MockProvider) Refund(ctx context.Context, externalID string, amount entity.Kopecks, key string) (string, error) {
+	body, err := json.Marshal(mockpay.RefundRequest{Amount: int64(amount)})
+	if err != nil {
+		return "", err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		p.baseURL+"/api/payments/"+url.PathEscape(externalID)+"/refunds", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+p.secret)
+	httpReq.Header.Set(mockpay.IdempotencyHeader, key)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", mockAnswerError(resp)
+	}
+
+	var refund mockpay.Refund
+	if err := json.NewDecoder(resp.Body).Decode(&refund); err != nil {
+		return "", err
+	}
+
+	return refund.ID, nil
+}
+
+func (p *MockProvider) ParseWebhook(body []byte, header http.Header) (*ProviderEvent, error) {
+	if !mockpay.Verify(p.secret, body, header.Get(mockpay.SignatureHeader)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event mockpay.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+
+	paymentID, err := strconv.Atoi(event.Payment.OrderID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid order id %q", event.Payment.OrderID)
+	}
+
+	status, err := mockStatus(event.Payment.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProviderEvent{
+		ExternalID: event.Payment.ID,
+		PaymentID:  paymentID,
+		Status:     status,
+		Amount:     entity.Kopecks(event.Payment.Amount),
+	}
+	if method := event.Payment.PaymentMethod; method != nil && method.Saved {
+		result.Card = &ProviderCard{Token: method.ID, Title: method.Title}
+	}
+
+	return result, nil
+}
+
+// mockAnswerError - ответ 4xx значит, что шлюз отклонил запрос. После 5xx операция
+// могла пройти, поэтому такая ошибка не считается отказом.
+func mockAnswerError(resp *http.Response) error {
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return fmt.Errorf("%w: mock provider answered %s", ErrProviderDeclined, resp.Status)
+	}
+	return fmt.Errorf("mock provider answered %s", resp.Status)
+}
+
+func mockStatus(status string) (string, error) {
+	switch status {
+	case mockpay.StatusSucceeded:
+		return entity.PaymentCompleted, nil
+	case mockpay.StatusFailed:
+		return entity.PaymentFailed, nil
+	case mockpay.StatusCanceled:
+		return entity.PaymentCancelled, nil
+	case mockpay.StatusPending:
+		return entity.PaymentPending, nil
+	}
+	return "", fmt.Errorf("unknown payment status %q", status)
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownProvider     = errors.New("unknown payment provider")
+	ErrProviderUnavailable = errors.New("payment provider is unavailable")
+	ErrPaymentNotFound     = errors.New("payment not found")
+)
+
+// PaymentService ведет пополнения через платежный шлюз: создает платеж, отправляет клиента
+// на страницу оплаты и зачисляет деньги только после подтверждения шлюзом
+type PaymentService struct {
+	repo      *repository.PaymentRepository
+	cards     *repository.AutopayRepository
+	provider  PaymentProvider
+	returnURL string
+	billing   *BillingService
+	audit     *AuditService
+}
+
+func NewPaymentService(repo *repository.PaymentRepository, cards *repository.AutopayRepository, provider PaymentProvider,
+	returnURL string, billing *BillingService, audit *AuditService) *PaymentService {
+	return &PaymentService{
+		repo:      repo,
+		cards:     cards,
+		provider:  provider,
+		returnURL: returnURL,
+		billing:   billing,
+		audit:     audit,
+	}
+}
+
+// TopUp создает платеж и возвращает его со ссылкой на оплату. Повтор с тем же idempotencyKey
+// возвращает уже созданный платеж, replayed = true. saveCard просит шлюз сохранить карту
+// для автоплатежа.
+func (s *PaymentService) TopUp(ctx context.Context, userID int, amount entity.Kopecks, paymentMethod, idempotencyKey string, saveCard bool) (*entity.Payment, bool, error) {
+	payment, replayed, err := s.repo.CreatePending(userID, amount, paymentMethod, s.provider.Name(), idempotencyKey)
+	if err != nil || replayed {
+		return payment, replayed, err
+	}
+
+	created, err := s.provider.CreatePayment(ctx, &ProviderPaymentRequest{
+		PaymentID:   payment.ID,
+		Amount:      amount,
+		Description: fmt.Sprintf("Пополнение баланса, платеж №%d", payment.ID),
+		ReturnURL:   s.paymentReturnURL(payment.ID),
+		SaveCard:    saveCard,
+	})
+	if err != nil {
+		log.Printf("Payment provider %s failed to create payment %d: %v", s.provider.Name(), payment.ID, err)
+		// После таймаута шлюз мог создать платеж, поэтому он остается pending до webhook
+		if errors.Is(err, ErrProviderDeclined) {
+			s.markFailed(payment)
+		}
+		return payment, false, ErrProviderUnavailable
+	}
+
+	if err := s.repo.AttachProvider(payment, created.ExternalID, created.ConfirmationURL); err != nil {
+		return nil, false, err
+	}
+
+	return payment, false, nil
+}
+
+// NewCardCharge заводит платеж для списания amount с сохраненной карты. В шлюз он уходит
+// через ChargeCard, а до этого вызывающий может запомнить номер платежа у себя.
+func (s *PaymentService) NewCardCharge(card *entity.PaymentCard, amount entity.Kopecks) (*entity.Payment, error) {
+	if card.Provider != s.provider.Name() {
+		return nil, ErrUnknownProvider
+	}
+
+	payment, _, err := s.repo.CreatePending(card.UserID, amount, entity.PaymentMethodAutopay, s.provider.Name(), "")
+	return payment, err
+}
+
+// ChargeCard списывает платеж из NewCardCharge с карты клиента без его участия. Платеж
+// возвращается и при ошибке шлюза: после отказа он failed, а если ответа не было - pending.
+func (s *PaymentService) ChargeCard(ctx context.Context, card *entity.PaymentCard, payment *entity.Payment) (*entity.Payment, error) {
+	amount := payment.Amount
+	created, err := s.provider.CreatePayment(ctx, &ProviderPaymentRequest{
+		PaymentID:   payment.ID,
+		Amount:      amount,
+		Description: fmt.Sprintf("Автоплатеж, платеж №%d", payment.ID),
+		CardToken:   card.Token,
+	})
+	if err != nil {
+		log.Printf("Payment provider %s failed to charge card %d: %v", s.provider.Name(), card.ID, err)
+		// Без явного отказа списание могло пройти: платеж ждет webhook, как и после ответа pending
+		if errors.Is(err, ErrProviderDeclined) {
+			s.markFailed(payment)
+		}
+		return payment, ErrProviderUnavailable
+	}
+
+	if err := s.repo.AttachProvider(payment, created.ExternalID, created.ConfirmationURL); err != nil {
+		return nil, err
+	}
+	if created.Status == "" || created.Status == entity.PaymentPending {
+		return payment, nil
+	}
+
+	// Итог известен сразу, webhook с тем же итогом придет позже и ничего не изменит
+	applied, err := s.applyEvent(&ProviderEvent{
+		ExternalID: created.ExternalID,
+		PaymentID:  payment.ID,
+		Status:     created.Status,
+		Amount:     amount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if applied != nil {
+		return applied, nil
+	}
+
+	return s.repo.FindForUser(card.UserID, payment.ID)
+}
+
+// markFailed закрывает платеж, который шлюз точно не принял. Ошибка только пишется в лог:
+// незакрытый платеж без списания ничего не меняет в балансе.
+func (s *PaymentService) markFailed(payment *entity.Payment) {
+	if err := s.repo.MarkFailed(payment); err != nil {
+		log.Printf("Failed to mark payment %d as failed: %v", payment.ID, err)
+	}
+}
+
+func (s *PaymentService) Get(userID, paymentID int) (*entity.Payment, error) {
+	payment, err := s.repo.FindForUser(userID, paymentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPaymentNotFound
+	}
+	return payment, err
+}
+
+// HandleWebhook проверяет подпись уведомления шлюза и применяет новый статус платежа.
+// Повторные уведомления безопасны: уже завершенный платеж не меняется.
+func (s *PaymentService) HandleWebhook(providerName string, body []byte, header http.Header) error {
+	if providerName != s.provider.Name() {
+		return ErrUnknownProvider
+	}
+
+	event, err := s.provider.ParseWebhook(body, header)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.applyEvent(event)
+	return err
+}
+
+// applyEvent применяет итог платежа из шлюза и возвращает платеж, если статус изменился
+func (s *PaymentService) applyEvent(event *ProviderEvent) (*entity.Payment, error) {
+	payment, changed, err := s.repo.ApplyStatus(event.PaymentID, s.provider.Name(), event.ExternalID, event.Status, event.Amount, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil || !changed {
+		return nil, err
+	}
+
+	log.Printf("Payment %d of user %d is %s", payment.ID, payment.UserID, payment.Status)
+
+	if payment.Status != entity.PaymentCompleted {
+		return payment, nil
+	}
+
+	// Карту из автоплатежа не сохраняем заново: клиент мог удалить ее, пока шел платеж
+	if event.Card != nil && payment.PaymentMethod != entity.PaymentMethodAutopay {
+		err := s.cards.SaveCard(&entity.PaymentCard{
+			UserID:   payment.UserID,
+			Provider: payment.Provider,
+			Token:    event.Card.Token,
+			Title:    event.Card.Title,
+		})
+		if err != nil {
+			log.Printf("Failed to save card of user %d: %v", payment.UserID, err)
+		}
+	}
+
+	meta := entity.AuditMeta{ActorType: entity.ActorSystem}
+	if err := s.audit.Record(meta, entity.AuditBalanceTopUp, entity.AuditEntityUser, strconv.Itoa(payment.UserID), nil,
+		map[string]interface{}{"payment_id": payment.ID, "amount": payment.Amount, "provider": payment.Provider, "external_id": payment.ExternalID}); err != nil {
+		log.Printf("Failed to record audit event %s: %v", entity.AuditBalanceTopUp, err)
+	}
+
+	// Деньги уже зачислены, поэтому ошибка продления не должна превращаться в ошибку webhook
+	s.resume(payment.UserID)
+
+	return payment, nil
+}
+
+// paymentReturnURL - куда шлюз вернет клиента после оплаты
+func (s *PaymentService) paymentReturnURL(paymentID int) string {
+	parsed, err := url.Parse(s.returnURL)
+	if err != nil {
+		return s.returnURL
+	}
+
+	query := parsed.Query()
+	query.Set("payment_id", strconv.Itoa(paymentID))
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"internet_provider/internal/entity"
+	"path/filepath"
+	"time"
+
+	"github.com/signintech/gopdf"
+)
+
+var ErrNoFont = errors.New("no font with cyrillic glyphs found")
+
+// Разметка страницы документов
+const (
+	docLeft      = 40.0
+	docRight     = 555.0
+	docPageLimit = 780.0
+	docRowHeight = 18.0
+)
+
+var (
+	docPrimary = gopdf.RGBColor{R: 59, G: 130, B: 246}
+	docDark    = gopdf.RGBColor{R: 30, G: 41, B: 59}
+	docGray    = gopdf.RGBColor{R: 107, G: 114, B: 128}
+	docLight   = gopdf.RGBColor{R: 243, G: 244, B: 246}
+)
+
+// docColumn - столбец таблицы. Суммы выравниваются по правому краю столбца.
+type docColumn struct {
+	Title string
+	X     float64
+	Width float64
+	Right bool
+}
+
+// InvoiceLine - строка счета: начисление за услугу или возврат начисления (Amount < 0)
+type InvoiceLine struct {
+	Date        time.Time
+	Description string
+	Amount      entity.Kopecks
+}
+
+// RenderInvoice - счет за услуги за месяц
+func (s *PDFService) RenderInvoice(doc *entity.Document, user *entity.User, lines []InvoiceLine) ([]byte, error) {
+	pdf, err := s.startDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	title := "Счет за услуги связи"
+	if doc.Number != nil {
+		title += " № " + *doc.Number
+	}
+	s.documentHeader(pdf, title, doc, user)
+
+	columns := []docColumn{
+		{"Дата", docLeft, 75, false}, {"Услуга", 120, 330, false}, {"Сумма, ₽", 455, 100, true},
+	}
+	s.tableHeader(pdf, columns)
+	if len(lines) == 0 {
+		s.tableRow(pdf, columns, "", "Начислений за период нет", "")
+	}
+	for _, line := range lines {
+		s.tableRow(pdf, columns, line.Date.Format("02.01.2006"), line.Description, line.Amount.String())
+	}
+
+	totals := []docTotal{
+		{"Итого начислено", doc.Charges, true},
+		{"Остаток на начало периода", doc.OpeningBalance, false},
+		{"Оплачено за период", doc.Payments, false},
+	}
+	// Ручные корректировки не относятся ни к услугам, ни к оплатам, но без них остатки не сходятся
+	if adjustments := doc.ClosingBalance - doc.OpeningBalance + doc.Charges - doc.Payments; adjustments != 0 {
+		totals = append(totals, docTotal{"Корректировки", adjustments, false})
+	}
+	totals = append(totals, docTotal{"Остаток на конец периода", doc.ClosingBalance, true})
+	s.totals(pdf, totals)
+
+	return s.finishDocument(pdf)
+}
+
+// RenderStatement - выписка по лицевому счету: все операции за период с остатком после каждой
+func (s *PDFService) RenderStatement(doc *entity.Document, user *entity.User, statement *entity.Statement) ([]byte, error) {
+	pdf, err := s.startDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	s.documentHeader(pdf, "Выписка по лицевому счету", doc, user)
+
+	columns := []docColumn{
+		{"Дата", docLeft, 65, false}, {"Операция", 110, 250, false},
+		{"Сумма, ₽", 365, 90, true}, {"Остаток, ₽", 455, 100, true},
+	}
+	s.tableHeader(pdf, columns)
+	s.tableRow(pdf, columns, "", "Остаток на начало периода", "", statement.OpeningBalance.String())
+	if len(statement.Lines) == 0 {
+		s.tableRow(pdf, columns, "", "Операций за период нет", "", "")
+	}
+	for _, line := range statement.Lines {
+		s.tableRow(pdf, columns, line.CreatedAt.Format("02.01.2006"), line.Description,
+			line.Amount.String(), line.BalanceAfter.String())
+	}
+
+	s.totals(pdf, []docTotal{
+		{"Остаток на начало периода", statement.OpeningBalance, false},
+		{"Начислено и списано", doc.Charges, false},
+		{"Оплачено и зачислено", doc.Payments, false},
+		{"Остаток на конец периода", statement.ClosingBalance, true},
+	})
+
+	return s.finishDocument(pdf)
+}
+
+// loadFont подключает шрифт с кириллицей. Без него документ вышел бы пустым, поэтому
+// вместо упрощенного PDF, как у заявок, возвращается ошибка.
+func (s *PDFService) loadFont(pdf *gopdf.GoPdf) error {
+	candidates := []string{
+		filepath.Join(s.fontsPath, "DejaVuSans.ttf"),
+		"../fonts/DejaVuSans.ttf",
+		filepath.Join(s.fontsPath, "times.ttf"),
+	}
+	for _, path := range candidates {
+		if err := pdf.AddTTFFont("document", path); err == nil {
+			return pdf.SetFont("document", "", 10)
+		}
+	}
+
+	return ErrNoFont
+}
+
+func (s *PDFService) startDocument() (*gopdf.GoPdf, error) {
+	pdf := &gopdf.GoPdf{}
+	pdf.Start(gopdf.Config{PageSize: *gopdf.PageSizeA4})
+	pdf.AddPage()
+
+	if err := s.loadFont(pdf); err != nil {
+		return nil, err
+	}
+
+	return pdf, nil
+}
+
+func (s *PDFService) documentHeader(pdf *gopdf.GoPdf, title string, doc *entity.Document, user *entity.User) {
+	pdf.SetFillColor(docLight.R, docLight.G, docLight.B)
+	pdf.Rectangle(0, 0, 595, 70, "F", 0, 0)
+
+	pdf.SetFontSize(20)
+	pdf.SetTextColor(docDark.R, docDark.G, docDark.B)
+	pdf.SetXY(docLeft, 22)
+	pdf.Cell(nil, "NetLink")
+
+	pdf.SetFontSize(9)
+	pdf.SetTextColor(docGray.R, docGray.G, docGray.B)
+	pdf.SetXY(docLeft, 46)
+	pdf.Cell(nil, "NetLink · +7 (800) 555-35-35 · info@netlink.ru")
+
+	pdf.SetFontSize(16)
+	pdf.SetTextColor(docPrimary.R, docPrimary.G, docPrimary.B)
+	pdf.SetXY(docLeft, 90)
+	pdf.Cell(nil, title)
+
+	// PeriodEnd не входит в период, в документе показываем последний день
+	info := []struct {
+		Label string
+		Value string
+	}{
+		{"Клиент:", user.Name},
+		{"Лицевой счет:", user.AccountNumber},
+		{"Период:", doc.PeriodStart.Format("02.01.2006") + " - " + doc.PeriodEnd.AddDate(0, 0, -1).Format("02.01.2006")},
+		{"Дата формирования:", doc.CreatedAt.Format("02.01.2006")},
+	}
+
+	y := 120.0
+	for _, item := range info {
+		pdf.SetFontSize(10)
+		pdf.SetTextColor(docGray.R, docGray.G, docGray.B)
+		pdf.SetXY(docLeft, y)
+		pdf.Cell(nil, item.Label)
+
+		pdf.SetTextColor(docDark.R, docDark.G, docDark.B)
+		pdf.SetXY(160, y)
+		pdf.Cell(nil, item.Value)
+		y += 16
+	}
+
+	pdf.SetY(y + 14)
+}
+
+func (s *PDFService) tableHeader(pdf *gopdf.GoPdf, columns []docColumn) {
+	s.ensureSpace(pdf, docRowHeight*2)
+
+	y := pdf.GetY()
+	pdf.SetFillColor(docLight.R, docLight.G, docLight.B)
+	pdf.Rectangle(docLeft-4, y-4, docRight+4, y+docRowHeight-4, "F", 0, 0)
+
+	pdf.SetFontSize(10)
+	pdf.SetTextColor(docGray.R, docGray.G, docGray.B)
+	s.cells(pdf, columns, y, func(i int) string { return columns[i].Title })
+	pdf.SetY(y + docRowHeight)
+}
+
+func (s *PDFService) tableRow(pdf *gopdf.GoPdf, columns []docColumn, values ...string) {
+	s.ensureSpace(pdf, docRowHeight)
+
+	y := pdf.GetY()
+	pdf.SetFontSize(10)
+	pdf.SetTextColor(docDark.R, docDark.G, docDark.B)
+	s.cells(pdf, columns, y, func(i int) string { return values[i] })
+
+	pdf.SetLineWidth(0.3)
+	pdf.SetStrokeColor(220, 220, 220)
+	pdf.Line(docLeft, y+docRowHeight-5, docRight, y+docRowHeight-5)
+	pdf.SetY(y + docRowHeight)
+}
+
+// cells выводит значения по столбцам. Текст шире столбца обрезается.
+func (s *PDFService) cells(pdf *gopdf.GoPdf, columns []docColumn, y float64, value func(int) string) {
+	for i, column := range columns {
+		text := value(i)
+		if text == "" {
+			continue
+		}
+
+		width, _ := pdf.MeasureTextWidth(text)
+		for width > column.Width && len([]rune(text)) > 1 {
+			runes := []rune(text)
+			text = string(runes[:len(runes)-2]) + "…"
+			width, _ = pdf.MeasureTextWidth(text)
+		}
+
+		x := column.X
+		if column.Right {
+			x += column.Width - width
+		}
+		pdf.SetXY(x, y)
+		pdf.Cell(nil, text)
+	}
+}
+
+type docTotal struct {
+	Label  string
+	Amount entity.Kopecks
+	Large  bool
+}
+
+func (s *PDFService) totals(pdf *gopdf.GoPdf, totals []docTotal) {
+	s.ensureSpace(pdf, docRowHeight*float64(len(totals)+1))
+	pdf.SetY(pdf.GetY() + 10)
+
+	for _, total := range totals {
+		y := pdf.GetY()
+		size := 10.0
+		if total.Large {
+			size = 12
+		}
+		pdf.SetFontSize(size)
+		pdf.SetTextColor(docDark.R, docDark.G, docDark.B)
+		pdf.SetXY(300, y)
+		pdf.Cell(nil, total.Label)
+
+		amount := total.Amount.String() + " ₽"
+		width, _ := pdf.MeasureTextWidth(amount)
+		pdf.SetXY(docRight-width, y)
+		pdf.Cell(nil, amount)
+		pdf.SetY(y + docRowHeight)
+	}
+}
+
+// ensureSpace переносит вывод на новую страницу, если до нижнего поля не хватает места
+func (s *PDFService) ensureSpace(pdf *gopdf.GoPdf, height float64) {
+	if pdf.GetY()+height <= docPageLimit {
+		return
+	}
+	pdf.AddPage()
+	pdf.SetY(50)
+}
+
+func (s *PDFService) finishDocument(pdf *gopdf.GoPdf) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pdf.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrPromiseDisabled       = errors.New("promised payments are disabled")
+	ErrPromiseNoSubscription = errors.New("customer has no tariff to promise against")
+	ErrPromiseOutstanding    = errors.New("previous promised payment is not repaid")
+	ErrPromiseUsed           = errors.New("promised payment already taken this period")
+	ErrPromiseTooLarge       = errors.New("promised payment exceeds the limit")
+)
+
+// PromiseService выдает клиентам обещанные платежи. Погашаются они из пополнений
+// в PaymentRepository, а просрочку отрабатывает биллинг.
+type PromiseService struct {
+	repo     *repository.PromiseRepository
+	settings *repository.SettingsRepository
+	billing  *BillingService
+}
+
+func NewPromiseService(repo *repository.PromiseRepository, settings *repository.SettingsRepository, billing *BillingService) *PromiseService {
+	return &PromiseService{
+		repo:     repo,
+		settings: settings,
+		billing:  billing,
+	}
+}
+
+func (s *PromiseService) Settings() (*entity.PromiseSettings, error) {
+	percent, err := s.settings.GetInt(entity.SettingPromiseLimitPercent, entity.DefaultPromiseLimitPercent)
+	if err != nil {
+		return nil, err
+	}
+	days, err := s.settings.GetInt(entity.SettingPromiseDays, entity.DefaultPromiseDays)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.PromiseSettings{LimitPercent: percent, Days: days}, nil
+}
+
+func (s *PromiseService) UpdateSettings(settings *entity.PromiseSettings, adminID int64) error {
+	if err := s.settings.Set(entity.SettingPromiseLimitPercent, strconv.Itoa(settings.LimitPercent), adminID); err != nil {
+		return err
+	}
+	return s.settings.Set(entity.SettingPromiseDays, strconv.Itoa(settings.Days), adminID)
+}
+
+// Offer - условия обещанного платежа для клиента прямо сейчас. Если взять его нельзя,
+// reason объясняет почему.
+func (s *PromiseService) Offer(userID int) (offer *entity.PromiseOffer, reason error, err error) {
+	settings, err := s.Settings()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offer = &entity.PromiseOffer{Days: settings.Days}
+	if offer.Active, err = s.repo.Active(userID); err != nil {
+		return nil, nil, err
+	}
+	if settings.LimitPercent == 0 {
+		return offer, ErrPromiseDisabled, nil
+	}
+
+	limit, err := s.repo.Limit(userID, settings.LimitPercent, time.Now())
+	if err = promiseError(err); IsPromiseError(err) {
+		return offer, err, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offer.Available = limit > 0
+	offer.Limit = limit
+	return offer, nil, nil
+}
+
+// Take выдает обещанный платеж и сразу возобновляет приостановленный доступ
+func (s *PromiseService) Take(userID int, amount entity.Kopecks) (*entity.PromisedPayment, error) {
+	settings, err := s.Settings()
+	if err != nil {
+		return nil, err
+	}
+	if settings.LimitPercent == 0 {
+		return nil, ErrPromiseDisabled
+	}
+
+	promise, err := s.repo.Take(userID, amount, settings.LimitPercent, settings.Days, time.Now())
+	if err != nil {
+		return nil, promiseError(err)
+	}
+
+	// Кредит уже зачислен, поэтому ошибка продления не отменяет выдачу
+	if err := s.billing.ResumeIfPossible(userID); err != nil {
+		log.Printf("Failed to resume subscription of user %d after promised payment: %v", userID, err)
+	}
+
+	return promise, nil
+}
+
+func (s *PromiseService) History(userID int) ([]entity.PromisedPayment, error) {
+	return s.repo.ListForUser(userID)
+}
+
+func (s *PromiseService) ListOutstanding() ([]entity.PromisedPaymentReport, error) {
+	return s.repo.ListOutstanding(time.Now())
+}
+
+func promiseError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrPromiseNoSubscription):
+		return ErrPromiseNoSubscription
+	case errors.Is(err, repository.ErrPromiseOutstanding):
+		return ErrPromiseOutstanding
+	case errors.Is(err, repository.ErrPromiseUsed):
+		return ErrPromiseUsed
+	case errors.Is(err, repository.ErrPromiseTooLarge):
+		return ErrPromiseTooLarge
+	}
+	return err
+}
+
+// IsPromiseError - обещанный платеж нельзя взять по правилам, а не из-за сбоя
+func IsPromiseError(err error) bool {
+	for _, target := range []error{ErrPromiseDisabled, ErrPromiseNoSubscription, ErrPromiseOutstanding,
+		ErrPromiseUsed, ErrPromiseTooLarge} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
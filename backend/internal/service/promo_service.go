@@ -0,0 +1,183 @@
+package service
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPromoNotFound        = errors.New("promo code not found")
+	ErrPromoUnavailable     = errors.New("promo code is disabled, not started or expired")
+	ErrPromoLimitReached    = errors.New("promo code redemption limit reached")
+	ErrPromoNotEligible     = errors.New("promo code does not apply to the tariff")
+	ErrPromoAlreadyRedeemed = errors.New("promo code already redeemed by the customer")
+	ErrPromoActive          = errors.New("customer already has an active promo code")
+	ErrPromoActivationOnly  = errors.New("promo code applies only when a tariff is activated")
+	ErrPromoExists          = errors.New("promo code already exists")
+	ErrInvalidPromo         = errors.New("percent is required for a percent discount, amount for a fixed one")
+)
+
+// PromoService ведет промокоды. Применяет их клиент при подключении тарифа через BillingService.
+type PromoService struct {
+	repo *repository.PromoRepository
+}
+
+func NewPromoService(repo *repository.PromoRepository) *PromoService {
+	return &PromoService{repo: repo}
+}
+
+func (s *PromoService) List() ([]entity.PromoCodeReport, error) {
+	return s.repo.List()
+}
+
+func (s *PromoService) Get(id int64) (*entity.PromoCode, error) {
+	promo, err := s.repo.FindByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPromoNotFound
+	}
+	return promo, err
+}
+
+func (s *PromoService) Redemptions(id int64) ([]entity.PromoRedemptionReport, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	return s.repo.Redemptions(id)
+}
+
+func (s *PromoService) Create(req *entity.CreatePromoCodeRequest) (*entity.PromoCode, error) {
+	promo := &entity.PromoCode{
+		Code:           entity.NormalizePromoCode(req.Code),
+		Description:    strings.TrimSpace(req.Description),
+		DiscountType:   req.DiscountType,
+		Periods:        1,
+		TariffIDs:      entity.Int64List(req.TariffIDs),
+		MaxRedemptions: req.MaxRedemptions,
+		StartsAt:       req.StartsAt,
+		ExpiresAt:      req.ExpiresAt,
+	}
+	if req.Periods != nil {
+		promo.Periods = *req.Periods
+	}
+
+	switch promo.DiscountType {
+	case entity.DiscountPercent:
+		promo.Percent = req.Percent
+	case entity.DiscountFixed:
+		promo.Amount = entity.ToKopecks(req.Amount)
+	}
+	if promo.Percent == 0 && promo.Amount == 0 {
+		return nil, ErrInvalidPromo
+	}
+
+	exists, err := s.repo.CodeExists(promo.Code)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrPromoExists
+	}
+
+	if err := s.repo.Create(promo); err != nil {
+		return nil, err
+	}
+
+	return promo, nil
+}
+
+// Update меняет условия выдачи кода. Клиентов, уже применивших код, это касается только
+// через число периодов и список тарифов.
+func (s *PromoService) Update(id int64, req *entity.UpdatePromoCodeRequest, now time.Time) (*entity.PromoCode, error) {
+	promo, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if req.Description != nil {
+		updates["description"] = strings.TrimSpace(*req.Description)
+	}
+	if req.Periods != nil {
+		updates["periods"] = *req.Periods
+	}
+	if req.TariffIDs != nil {
+		updates["tariff_ids"] = entity.Int64List(*req.TariffIDs)
+	}
+	if req.MaxRedemptions != nil {
+		updates["max_redemptions"] = *req.MaxRedemptions
+	}
+	if req.StartsAt != nil {
+		updates["starts_at"] = *req.StartsAt
+	}
+	if req.ExpiresAt != nil {
+		updates["expires_at"] = *req.ExpiresAt
+	}
+	if req.Disabled != nil {
+		switch {
+		case *req.Disabled && promo.DisabledAt == nil:
+			updates["disabled_at"] = now
+		case !*req.Disabled:
+			updates["disabled_at"] = nil
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := s.repo.Update(promo, updates); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.Get(id)
+}
+
+// Validate проверяет код из заявки на подключение. Тариф и клиент еще неизвестны, поэтому
+// остальные правила проверяются при подключении тарифа.
+func (s *PromoService) Validate(code string, now time.Time) error {
+	promo, err := s.repo.FindByCode(code)
+	if err != nil {
+		return promoError(err)
+	}
+
+	switch {
+	case !promo.Redeemable(now):
+		return ErrPromoUnavailable
+	case promo.LimitReached():
+		return ErrPromoLimitReached
+	}
+	return nil
+}
+
+// promoError переводит ошибки промокодов репозитория в ошибки сервиса
+func promoError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrPromoNotFound):
+		return ErrPromoNotFound
+	case errors.Is(err, repository.ErrPromoUnavailable):
+		return ErrPromoUnavailable
+	case errors.Is(err, repository.ErrPromoLimitReached):
+		return ErrPromoLimitReached
+	case errors.Is(err, repository.ErrPromoNotEligible):
+		return ErrPromoNotEligible
+	case errors.Is(err, repository.ErrPromoAlreadyRedeemed):
+		return ErrPromoAlreadyRedeemed
+	case errors.Is(err, repository.ErrPromoActive):
+		return ErrPromoActive
+	}
+	return err
+}
+
+// IsPromoError - ошибка относится к самому промокоду, а не к сбою сервера
+func IsPromoError(err error) bool {
+	for _, target := range []error{ErrPromoNotFound, ErrPromoUnavailable, ErrPromoLimitReached,
+		ErrPromoNotEligible, ErrPromoAlreadyRedeemed, ErrPromoActive, ErrPromoActivationOnly} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
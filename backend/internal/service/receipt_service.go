@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrReceiptNotFound  = errors.New("receipt not found")
+	ErrReceiptNotFailed = errors.New("only a failed receipt can be resent")
+)
+
+const (
+	receiptBatch      = 50
+	receiptTimeout    = 15 * time.Second
+	receiptMaxBackoff = time.Hour
+)
+
+// FiscalSettings - реквизиты продавца в чеке и правила повторной отправки
+type FiscalSettings struct {
+	CompanyINN  string
+	Taxation    string
+	VAT         string
+	MaxAttempts int
+}
+
+// ReceiptService отправляет в кассу чеки из очереди. Чек, который касса не приняла,
+// отправляется повторно с растущей паузой, пока не кончатся попытки.
+type ReceiptService struct {
+	repo     *repository.ReceiptRepository
+	register CashRegister
+	settings FiscalSettings
+}
+
+func NewReceiptService(repo *repository.ReceiptRepository, register CashRegister, settings FiscalSettings) *ReceiptService {
+	return &ReceiptService{
+		repo:     repo,
+		register: register,
+		settings: settings,
+	}
+}
+
+func (s *ReceiptService) Get(userID int, id int64) (*entity.Receipt, error) {
+	receipt, err := s.repo.FindForUser(userID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrReceiptNotFound
+	}
+	return receipt, err
+}
+
+func (s *ReceiptService) List(status string, page, limit int) ([]entity.Receipt, int64, error) {
+	return s.repo.List(status, page, limit)
+}
+
+// Retry снова ставит в очередь чек, по которому попытки исчерпаны
+func (s *ReceiptService) Retry(id int64) (*entity.Receipt, error) {
+	receipt, err := s.repo.Retry(id, time.Now())
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, ErrReceiptNotFound
+	case errors.Is(err, repository.ErrReceiptNotFailed):
+		return nil, ErrReceiptNotFailed
+	}
+	return receipt, err
+}
+
+// ProcessDue отправляет все чеки, которым подошла очередь
+func (s *ReceiptService) ProcessDue(now time.Time) (registered, failed int, err error) {
+	for {
+		ids, err := s.repo.Due(now, receiptBatch)
+		if err != nil {
+			return registered, failed, err
+		}
+
+		for _, id := range ids {
+			receipt, claimed, err := s.repo.Claim(id, func(receipt *entity.Receipt) {
+				s.prepare(receipt, now)
+			})
+			if err != nil {
+				log.Printf("Failed to process receipt %d: %v", id, err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+
+			s.send(receipt)
+			if err := s.repo.SaveAttempt(receipt); err != nil {
+				log.Printf("Failed to save receipt %d after sending it to the cash register: %v", id, err)
+				continue
+			}
+
+			switch receipt.Status {
+			case entity.ReceiptRegistered:
+				registered++
+			case entity.ReceiptFailed:
+				failed++
+				log.Printf("Receipt %d for payment %d failed after %d attempts: %s",
+					receipt.ID, receipt.PaymentID, receipt.Attempts, receipt.LastError)
+			}
+		}
+
+		// Неотправленные чеки получили следующую попытку позже now, поэтому цикл конечен
+		if len(ids) < receiptBatch {
+			return registered, failed, nil
+		}
+	}
+}
+
+// Run отправляет чеки из очереди каждые interval, пока не отменен ctx
+func (s *ReceiptService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		registered, failed, err := s.ProcessDue(time.Now())
+		if err != nil {
+			log.Printf("Receipt queue run failed: %v", err)
+		} else if registered+failed > 0 {
+			log.Printf("Receipts: registered %d, failed %d", registered, failed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prepare заполняет ставку НДС и засчитывает попытку до обращения к кассе. Следующая
+// попытка назначается заранее: если результат не сохранится, чек уйдет повторно только
+// после паузы и с теми же реквизитами.
+func (s *ReceiptService) prepare(receipt *entity.Receipt, now time.Time) {
+	if receipt.VAT == "" {
+		receipt.VAT = entity.ReceiptVAT(s.settings.VAT, receipt.Method)
+		receipt.VATAmount = entity.IncludedVAT(receipt.Amount, receipt.VAT)
+	}
+
+	receipt.Attempts++
+	receipt.CashRegister = s.register.Name()
+	receipt.NextAttemptAt = now.Add(receiptBackoff(receipt.Attempts))
+}
+
+// send отправляет чек в кассу и записывает в receipt результат попытки
+func (s *ReceiptService) send(receipt *entity.Receipt) {
+	ctx, cancel := context.WithTimeout(context.Background(), receiptTimeout)
+	defer cancel()
+
+	result, err := s.register.Register(ctx, s.document(receipt))
+	if err != nil {
+		receipt.LastError = truncate(err.Error(), 500)
+		if receipt.Attempts >= s.settings.MaxAttempts {
+			receipt.Status = entity.ReceiptFailed
+		}
+		return
+	}
+
+	receipt.Status = entity.ReceiptRegistered
+	receipt.LastError = ""
+	receipt.ExternalID = result.ExternalID
+	receipt.FiscalNumber = result.FiscalNumber
+	receipt.FiscalDocument = result.FiscalDocument
+	receipt.FiscalSign = result.FiscalSign
+	receipt.URL = result.URL
+	receipt.RegisteredAt = &result.RegisteredAt
+}
+
+func (s *ReceiptService) document(receipt *entity.Receipt) *FiscalReceipt {
+	return &FiscalReceipt{
+		ID:         receipt.ID,
+		Operation:  receipt.Operation,
+		CompanyINN: s.settings.CompanyINN,
+		Taxation:   s.settings.Taxation,
+		Contact:    receipt.Contact,
+		Items: []FiscalItem{{
+			Name:      receipt.Item,
+			Quantity:  1,
+			Price:     receipt.Amount,
+			Sum:       receipt.Amount,
+			VAT:       receipt.VAT,
+			VATAmount: receipt.VATAmount,
+			Method:    receipt.Method,
+			Subject:   receipt.Subject,
+		}},
+		PaymentType: receipt.PaymentType,
+		Total:       receipt.Amount,
+		CreatedAt:   receipt.CreatedAt,
+	}
+}
+
+// receiptBackoff - пауза перед следующей попыткой: минута, две, четыре и так далее до часа
+func receiptBackoff(attempts int) time.Duration {
+	backoff := time.Minute
+	for i := 1; i < attempts && backoff < receiptMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > receiptMaxBackoff {
+		return receiptMaxBackoff
+	}
+	return backoff
+}
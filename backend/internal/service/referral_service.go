@@ -0,0 +1,140 @@
+package service
+
+import (
+	"errors"
+	"internet_provider/internal/app"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"log"
+	"strconv"
+)
+
+var ErrReferralCodeNotFound = errors.New("referral code not found")
+
+// ReferralService ведет реферальную программу: проверяет коды при регистрации и в заявках
+// и записывает приглашения. Бонусы начисляет биллинг при первой оплате тарифа.
+type ReferralService struct {
+	repo     *repository.ReferralRepository
+	settings *repository.SettingsRepository
+}
+
+func NewReferralService(repo *repository.ReferralRepository, settings *repository.SettingsRepository) *ReferralService {
+	return &ReferralService{
+		repo:     repo,
+		settings: settings,
+	}
+}
+
+func (s *ReferralService) Settings() (*entity.ReferralSettings, error) {
+	referrer, err := s.settings.GetInt(entity.SettingReferrerReward, int(entity.DefaultReferrerReward))
+	if err != nil {
+		return nil, err
+	}
+	referred, err := s.settings.GetInt(entity.SettingReferredReward, int(entity.DefaultReferredReward))
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.ReferralSettings{
+		ReferrerReward: entity.Kopecks(referrer),
+		ReferredReward: entity.Kopecks(referred),
+	}, nil
+}
+
+func (s *ReferralService) UpdateSettings(settings *entity.ReferralSettings, adminID int64) error {
+	if err := s.settings.Set(entity.SettingReferrerReward, strconv.FormatInt(int64(settings.ReferrerReward), 10), adminID); err != nil {
+		return err
+	}
+	return s.settings.Set(entity.SettingReferredReward, strconv.FormatInt(int64(settings.ReferredReward), 10), adminID)
+}
+
+// Referrer - владелец кода. Пустой код - клиент пришел без приглашения, nil без ошибки.
+func (s *ReferralService) Referrer(code string) (*entity.User, error) {
+	code = entity.NormalizeReferralCode(code)
+	if code == "" {
+		return nil, nil
+	}
+
+	referrer, err := s.repo.FindReferrer(code)
+	if err != nil {
+		return nil, err
+	}
+	if referrer == nil {
+		return nil, ErrReferralCodeNotFound
+	}
+	return referrer, nil
+}
+
+// AttachRegistration записывает приглашение нового клиента. Приглашение из заявки с тем же
+// телефоном находит клиента и без кода.
+func (s *ReferralService) AttachRegistration(user *entity.User, referrer *entity.User) error {
+	linked, err := s.repo.LinkApplication(user)
+	if err != nil {
+		return err
+	}
+	if referrer == nil || (linked != nil && linked.ReferrerID == referrer.Id) {
+		return nil
+	}
+
+	referral := &entity.Referral{
+		ReferrerID: referrer.Id,
+		ReferredID: &user.Id,
+		Source:     entity.ReferralSourceRegister,
+		Code:       referrer.ReferralCode,
+		Phone:      entity.NormalizePhone(user.Phone),
+	}
+	if err := s.repo.Create(referral, referrer); err != nil {
+		return err
+	}
+	s.logRejected(referral)
+	return nil
+}
+
+// AttachApplication записывает приглашение из заявки на подключение
+func (s *ReferralService) AttachApplication(application *app.Application, referrer *entity.User) error {
+	applicationID := application.ID
+	referral := &entity.Referral{
+		ReferrerID:    referrer.Id,
+		ApplicationID: &applicationID,
+		Source:        entity.ReferralSourceApplication,
+		Code:          referrer.ReferralCode,
+		Phone:         entity.NormalizePhone(application.Phone),
+		Address:       entity.NormalizeAddress(application.Address),
+	}
+	if err := s.repo.Create(referral, referrer); err != nil {
+		return err
+	}
+	s.logRejected(referral)
+	return nil
+}
+
+// Stats - код клиента, его приглашения и текущие бонусы
+func (s *ReferralService) Stats(user *entity.User) (*entity.ReferralStats, error) {
+	stats, err := s.repo.Stats(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := s.Settings()
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Code = user.ReferralCode
+	stats.ReferrerReward = settings.ReferrerReward
+	stats.ReferredReward = settings.ReferredReward
+	return stats, nil
+}
+
+func (s *ReferralService) Report(status string, page, limit int) ([]entity.ReferralReport, int64, error) {
+	return s.repo.Report(status, page, limit)
+}
+
+func (s *ReferralService) Summary() (*entity.ReferralSummary, error) {
+	return s.repo.Summary()
+}
+
+func (s *ReferralService) logRejected(referral *entity.Referral) {
+	if referral.Status == entity.ReferralRejected {
+		log.Printf("Referral %d by user %d rejected: %s", referral.ID, referral.ReferrerID, referral.RejectReason)
+	}
+}
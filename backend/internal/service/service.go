@@ -4,23 +4,49 @@ import (
 	"bytes"
 	"fmt"
 	"internet_provider/internal/app"
+	"internet_provider/internal/entity"
+	"log"
 	"time"
 
 	"github.com/signintech/gopdf"
 )
 
 type ApplicationService struct {
-	repo app.ApplicationRepository
+	repo      app.ApplicationRepository
+	promos    *PromoService
+	referrals *ReferralService
 }
 
-func NewApplicationService(repo app.ApplicationRepository) *ApplicationService {
-	return &ApplicationService{repo: repo}
+func NewApplicationService(repo app.ApplicationRepository, promos *PromoService, referrals *ReferralService) *ApplicationService {
+	return &ApplicationService{repo: repo, promos: promos, referrals: referrals}
 }
 
+// CreateApplication сохраняет заявку. Промокод из заявки проверяется сразу, а применяется,
+// когда клиент с тем же телефоном подключит тариф. Реферальный код записывает приглашение.
 func (s *ApplicationService) CreateApplication(app *app.Application) error {
+	app.PromoCode = entity.NormalizePromoCode(app.PromoCode)
+	if app.PromoCode != "" {
+		if err := s.promos.Validate(app.PromoCode, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	app.ReferralCode = entity.NormalizeReferralCode(app.ReferralCode)
+	referrer, err := s.referrals.Referrer(app.ReferralCode)
+	if err != nil {
+		return err
+	}
+
 	if err := s.repo.Create(app); err != nil {
 		return err
 	}
+
+	// Заявка уже принята, поэтому сбой записи приглашения ее не отменяет
+	if referrer != nil {
+		if err := s.referrals.AttachApplication(app, referrer); err != nil {
+			log.Printf("Failed to record referral for application %d: %v", app.ID, err)
+		}
+	}
 	return nil
 }
 
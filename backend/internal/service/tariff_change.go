@@ -0,0 +1,197 @@
+package service
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSameTariff           = errors.New("tariff is already active")
+	ErrInsufficientFunds    = errors.New("insufficient funds")
+	ErrNoActiveSubscription = errors.New("no active subscription")
+	ErrSubscriptionActive   = errors.New("subscription is already active")
+)
+
+// PreviewTariffChange считает суммы смены тарифа, ничего не меняя. Если передан промокод,
+// он проверяется и его скидка показывается в расчете, иначе учитывается уже примененный код.
+func (s *BillingService) PreviewTariffChange(user *entity.User, target *entity.Tariff, promoCode string) (*entity.TariffChangeQuote, error) {
+	now := time.Now()
+
+	var promo *entity.PromoCode
+	var err error
+	if code := entity.NormalizePromoCode(promoCode); code != "" {
+		promo, err = s.promos.Check(code, user.Id, target.ID, now)
+	} else {
+		promo, err = s.promos.ActiveFor(user.Id)
+	}
+	if err != nil {
+		return nil, promoError(err)
+	}
+
+	sub, err := s.subs.FindByUserID(user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var current *entity.Tariff
+	if sub != nil {
+		if current, err = s.tariffs.FindByID(sub.TariffID); err != nil {
+			return nil, err
+		}
+	}
+
+	quote, err := quoteTariffChange(sub, current, target, promo, user.Balance, now)
+	if err == nil && promoCode != "" && quote.Kind != entity.TariffChangeActivation {
+		return nil, ErrPromoActivationOnly
+	}
+	return quote, err
+}
+
+// ChangeTariff меняет тариф активной подписки. Повышение применяется сразу с перерасчетом
+// остатка периода, понижение - с начала следующего периода.
+func (s *BillingService) ChangeTariff(userID int, target *entity.Tariff) (*entity.TariffChangeQuote, error) {
+	promo, err := s.promos.ActiveFor(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	quote, err := s.subs.ChangeTariff(userID, target, time.Now(), func(sub *entity.Subscription, current *entity.Tariff, balance entity.Kopecks, now time.Time) (*entity.TariffChangeQuote, error) {
+		quote, err := quoteTariffChange(sub, current, target, promo, balance, now)
+		if err != nil {
+			return nil, err
+		}
+		if quote.Kind == entity.TariffChangeActivation {
+			return nil, ErrNoActiveSubscription
+		}
+		if !quote.EnoughFunds {
+			return quote, ErrInsufficientFunds
+		}
+		return quote, nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoActiveSubscription
+	}
+	if errors.Is(err, repository.ErrInsufficientBalance) {
+		// Не хватило на доплату за подключенные услуги
+		return quote, ErrInsufficientFunds
+	}
+
+	return quote, err
+}
+
+// TariffHistory - все подключения и смены тарифа клиента, новые первыми
+func (s *BillingService) TariffHistory(userID int) ([]entity.TariffHistory, error) {
+	return s.subs.ListHistory(userID)
+}
+
+// quoteTariffChange - расчет без побочных эффектов. current - тариф подписки sub, promo -
+// промокод клиента или nil. Скидка по коду действует на полные периоды: на подключение и на
+// период после понижения, но не на доплату при повышении.
+func quoteTariffChange(sub *entity.Subscription, current, target *entity.Tariff, promo *entity.PromoCode, balance entity.Kopecks, now time.Time) (*entity.TariffChangeQuote, error) {
+	quote := &entity.TariffChangeQuote{
+		NewTariffID: target.ID,
+		EffectiveAt: now,
+		Balance:     balance,
+	}
+	if promo != nil && !promo.AppliesTo(target.ID) {
+		promo = nil
+	}
+	if promo != nil {
+		quote.PromoCode = promo.Code
+	}
+
+	// Замороженную подписку нельзя ни подключить заново, ни сменить, пока клиент ее не разморозит
+	if sub != nil && sub.Status == entity.SubscriptionFrozen {
+		return nil, ErrAccountFrozen
+	}
+
+	switch {
+	case sub == nil || current == nil || sub.Status != entity.SubscriptionActive:
+		// Оплаченного периода нет, тариф подключается заново на полный период
+		quote.Kind = entity.TariffChangeActivation
+		quote.Charge = target.PeriodPrice(now)
+		if promo != nil {
+			quote.Discount = promo.Discount(quote.Charge)
+			quote.Charge -= quote.Discount
+		}
+		quote.AmountDue = quote.Charge
+		quote.PeriodStart = now
+		quote.PaidUntil = target.PeriodEnd(now)
+
+	case target.ID == sub.TariffID:
+		if sub.NextTariffID == nil {
+			return nil, ErrSameTariff
+		}
+		// Возврат к текущему тарифу отменяет запланированное понижение
+		quote.Kind = entity.TariffChangeDowngradeCancelled
+		quote.CurrentTariffID = &sub.TariffID
+		quote.PeriodStart = sub.PeriodStart
+		quote.PaidUntil = sub.PaidUntil
+
+	case target.Price > current.Price:
+		quote.Kind = entity.TariffChangeUpgrade
+		quote.CurrentTariffID = &sub.TariffID
+
+		// Возвращается только то, что клиент заплатил за период, то есть без скидки
+		left := remainingShare(sub, now)
+		quote.Credit = prorate(current.PeriodPrice(sub.PeriodStart)-sub.PeriodDiscount, left)
+
+		if target.BillingPeriod == current.BillingPeriod {
+			// Период не меняется, доплачивается разница за оставшиеся дни
+			quote.Charge = prorate(target.PeriodPrice(sub.PeriodStart), left)
+			quote.PeriodStart = sub.PeriodStart
+			quote.PaidUntil = sub.PaidUntil
+		} else {
+			// Другая периодичность: остаток возвращается, новый период начинается сейчас
+			quote.Charge = target.PeriodPrice(now)
+			quote.PeriodStart = now
+			quote.PaidUntil = target.PeriodEnd(now)
+		}
+		quote.AmountDue = quote.Charge - quote.Credit
+
+	default:
+		// Понижение: текущий период уже оплачен по старому тарифу, новый начнется со следующего
+		quote.Kind = entity.TariffChangeDowngrade
+		quote.CurrentTariffID = &sub.TariffID
+		quote.EffectiveAt = sub.PaidUntil
+		quote.NextPeriodCharge = target.PeriodPrice(sub.PaidUntil)
+		if promo != nil {
+			quote.Discount = promo.Discount(quote.NextPeriodCharge)
+			quote.NextPeriodCharge -= quote.Discount
+		}
+		quote.PeriodStart = sub.PaidUntil
+		if target.BillingPeriod == current.BillingPeriod {
+			quote.PaidUntil = target.PeriodEndOn(sub.PaidUntil, sub.AnchorDay)
+		} else {
+			quote.PaidUntil = target.PeriodEnd(sub.PaidUntil)
+		}
+	}
+
+	quote.BalanceAfter = balance - quote.AmountDue
+	quote.EnoughFunds = quote.BalanceAfter >= 0
+
+	return quote, nil
+}
+
+// remainingShare - доля оплаченного периода, которая еще не прошла
+func remainingShare(sub *entity.Subscription, now time.Time) float64 {
+	total := sub.PaidUntil.Sub(sub.PeriodStart)
+	left := sub.PaidUntil.Sub(now)
+	if total <= 0 || left <= 0 {
+		return 0
+	}
+	if left > total {
+		return 1
+	}
+	return float64(left) / float64(total)
+}
+
+// prorate - часть суммы, округленная до копейки
+func prorate(amount entity.Kopecks, share float64) entity.Kopecks {
+	return entity.Kopecks(math.Round(float64(amount) * share))
+}
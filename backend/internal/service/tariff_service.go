@@ -0,0 +1,160 @@
+package service
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTariffNotFound    = errors.New("tariff not found")
+	ErrTariffUnavailable = errors.New("tariff is archived or hidden")
+	ErrDuplicateTariffs  = errors.New("tariff ids must be unique")
+)
+
+type TariffService struct {
+	repo *repository.TariffRepository
+}
+
+func NewTariffService(repo *repository.TariffRepository) *TariffService {
+	return &TariffService{repo: repo}
+}
+
+func (s *TariffService) ListPublic() ([]entity.Tariff, error) {
+	return s.repo.ListPublic()
+}
+
+func (s *TariffService) ListAll(includeArchived bool) ([]entity.Tariff, error) {
+	return s.repo.ListAll(includeArchived)
+}
+
+func (s *TariffService) Get(id int64) (*entity.Tariff, error) {
+	tariff, err := s.repo.FindByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTariffNotFound
+	}
+	return tariff, err
+}
+
+// GetAvailable возвращает тариф, который клиент может подключить прямо сейчас
+func (s *TariffService) GetAvailable(id int64) (*entity.Tariff, error) {
+	tariff, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !tariff.Available() {
+		return nil, ErrTariffUnavailable
+	}
+
+	return tariff, nil
+}
+
+func (s *TariffService) Create(req *entity.CreateTariffRequest) (*entity.Tariff, error) {
+	tariff := &entity.Tariff{
+		Name:          strings.TrimSpace(req.Name),
+		Description:   req.Description,
+		Price:         entity.ToKopecks(req.Price),
+		Speed:         req.Speed,
+		Features:      cleanFeatures(req.Features),
+		IsVisible:     true,
+		BillingPeriod: entity.BillingMonthly,
+	}
+	if req.IsVisible != nil {
+		tariff.IsVisible = *req.IsVisible
+	}
+	if req.BillingPeriod != "" {
+		tariff.BillingPeriod = req.BillingPeriod
+	}
+
+	if err := s.repo.Create(tariff); err != nil {
+		return nil, err
+	}
+
+	return tariff, nil
+}
+
+// Update меняет тариф. Цена меняется и для уже подключенных клиентов со следующего списания.
+func (s *TariffService) Update(id int64, req *entity.UpdateTariffRequest) (*entity.Tariff, error) {
+	tariff, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		tariff.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		tariff.Description = *req.Description
+	}
+	if req.Price != nil {
+		tariff.Price = entity.ToKopecks(*req.Price)
+	}
+	if req.Speed != nil {
+		tariff.Speed = *req.Speed
+	}
+	if req.Features != nil {
+		tariff.Features = cleanFeatures(*req.Features)
+	}
+	if req.IsVisible != nil {
+		tariff.IsVisible = *req.IsVisible
+	}
+	if req.BillingPeriod != nil {
+		tariff.BillingPeriod = *req.BillingPeriod
+	}
+
+	if err := s.repo.Update(tariff); err != nil {
+		return nil, err
+	}
+
+	return tariff, nil
+}
+
+// SetArchived убирает тариф из продажи или возвращает его. Клиенты на архивном тарифе остаются на нем.
+func (s *TariffService) SetArchived(id int64, archived bool) (*entity.Tariff, error) {
+	found, err := s.repo.SetArchived(id, archived)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrTariffNotFound
+	}
+
+	return s.Get(id)
+}
+
+func (s *TariffService) Reorder(ids []int64) ([]entity.Tariff, error) {
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return nil, ErrDuplicateTariffs
+		}
+		seen[id] = true
+	}
+
+	count, err := s.repo.CountByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	if count != int64(len(ids)) {
+		return nil, ErrTariffNotFound
+	}
+
+	if err := s.repo.Reorder(ids); err != nil {
+		return nil, err
+	}
+
+	return s.repo.ListAll(true)
+}
+
+func cleanFeatures(features []string) entity.StringList {
+	result := make(entity.StringList, 0, len(features))
+	for _, feature := range features {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			result = append(result, feature)
+		}
+	}
+	return result
+}
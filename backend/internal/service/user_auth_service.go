@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"internet_provider/internal/entity"
+	"internet_provider/internal/repository"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const tokenTypeAccess = "access"
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type UserAuthService struct {
+	userRepo    *repository.UserRepository
+	sessionRepo *repository.UserSessionRepository
+	keys        *KeyRing
+	accessTTL   time.Duration
+	refreshTTL  time.Duration
+}
+
+func NewUserAuthService(userRepo *repository.UserRepository, sessionRepo *repository.UserSessionRepository, keys *KeyRing, accessTTL, refreshTTL time.Duration) *UserAuthService {
+	return &UserAuthService{
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
+		keys:        keys,
+		accessTTL:   accessTTL,
+		refreshTTL:  refreshTTL,
+	}
+}
+
+// IssueTokens открывает клиенту новую сессию после успешного входа и выдает пару токенов.
+// Refresh токен случайный, в базе хранится только его хеш.
+func (s *UserAuthService) IssueTokens(user *entity.User, userAgent, ip string) (*entity.TokenPair, error) {
+	refreshToken, refreshHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &entity.UserSession{
+		UserID:           user.Id,
+		RefreshTokenHash: refreshHash,
+		UserAgent:        truncate(userAgent, 255),
+		IP:               truncate(ip, 45),
+		ExpiresAt:        now.Add(s.refreshTTL),
+		LastUsedAt:       now,
+	}
+	if err := s.sessionRepo.Create(session); err != nil {
+		return nil, err
+	}
+
+	return s.tokenPair(session, refreshToken)
+}
+
+// Refresh обменивает refresh токен на новую пару токенов в той же сессии. Старый refresh токен
+// после этого недействителен; если его предъявили дважды одновременно, сессия отзывается.
+func (s *UserAuthService) Refresh(refreshToken string) (*entity.TokenPair, error) {
+	oldHash := hashToken(refreshToken)
+	session, err := s.sessionRepo.FindActiveByRefreshHash(oldHash)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if _, err := s.userRepo.GetUserByID(int64(session.UserID)); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	newToken, newHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	rotated, err := s.sessionRepo.Rotate(session.ID, oldHash, newHash, time.Now().Add(s.refreshTTL))
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// Токен уже обменяли в параллельном запросе: им пользуется кто-то еще
+		if err := s.sessionRepo.Revoke(session.UserID, session.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidToken
+	}
+
+	return s.tokenPair(session, newToken)
+}
+
+// Logout отзывает сессию, из которой пришел запрос
+func (s *UserAuthService) Logout(userID int, sessionID int64) error {
+	return s.sessionRepo.Revoke(userID, sessionID)
+}
+
+// VerifyAccessToken возвращает ID клиента и его сессии из access токена.
+// Токен отозванной или просроченной сессии не принимается.
+func (s *UserAuthService) VerifyAccessToken(tokenString string) (int, int64, error) {
+	userID, sessionID, err := s.parse(tokenString)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	session, err := s.sessionRepo.FindByID(sessionID)
+	if err != nil || session.UserID != userID {
+		return 0, 0, ErrInvalidToken
+	}
+	if session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+		return 0, 0, ErrInvalidToken
+	}
+
+	return userID, sessionID, nil
+}
+
+func (s *UserAuthService) tokenPair(session *entity.UserSession, refreshToken string) (*entity.TokenPair, error) {
+	now := time.Now()
+	accessToken, err := s.keys.Sign(jwt.MapClaims{
+		"user_id": session.UserID,
+		"sid":     session.ID,
+		"type":    tokenTypeAccess,
+		"exp":     now.Add(s.accessTTL).Unix(),
+		"iat":     now.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTTL.Seconds()),
+	}, nil
+}
+
+func (s *UserAuthService) parse(tokenString string) (int, int64, error) {
+	token, err := jwt.Parse(tokenString, s.keys.Keyfunc)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return 0, 0, ErrInvalidToken
+	}
+
+	if tokenType, _ := claims["type"].(string); tokenType != tokenTypeAccess {
+		return 0, 0, ErrInvalidToken
+	}
+
+	userID, _ := claims["user_id"].(float64)
+	sessionID, _ := claims["sid"].(float64)
+	if userID <= 0 || sessionID <= 0 {
+		return 0, 0, ErrInvalidToken
+	}
+
+	return int(userID), int64(sessionID), nil
+}
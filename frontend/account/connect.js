@@ -23,7 +23,8 @@ document.getElementById('registerForm').addEventListener('submit', async functio
             name: document.getElementById('reg_name').value,
             email: document.getElementById('reg_email').value,
             phone: document.getElementById('reg_phone').value,
-            password: document.getElementById('reg_password').value
+            password: document.getElementById('reg_password').value,
+            referral_code: document.getElementById('reg_referral_code').value.trim()
         };
 
         
@@ -92,6 +93,8 @@ document.getElementById('registerForm').addEventListener('submit', async functio
 
             if (response.ok) {
                 localStorage.setItem('netlinkUser', JSON.stringify(result.user));
+                localStorage.setItem('netlinkAccessToken', result.tokens.access_token);
+                localStorage.setItem('netlinkRefreshToken', result.tokens.refresh_token);
                 localStorage.setItem('netlinkLoggedIn', 'true');
                 
                 alert('Вход выполнен успешно!');
@@ -106,9 +109,62 @@ document.getElementById('registerForm').addEventListener('submit', async functio
         }
     });
 
-    
-    
-    
-    
-    
-    
\ No newline at end of file
+    // Восстановление пароля
+    document.getElementById('forgotPasswordLink').addEventListener('click', async function(e) {
+        e.preventDefault();
+
+        const email = prompt('Введите email, указанный при регистрации:', document.getElementById('login_email').value);
+        if (!email) return;
+
+        try {
+            const response = await fetch(`${API_BASE}/auth/forgot-password`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ email })
+            });
+            const result = await response.json();
+            alert(response.ok ? result.message : 'Ошибка: ' + result.error);
+        } catch (error) {
+            console.error('Ошибка:', error);
+            alert('Ошибка соединения с сервером');
+        }
+    });
+
+    // Ссылки из писем: подтверждение email и смена пароля
+    (async function handleEmailLinks() {
+        const params = new URLSearchParams(window.location.search);
+        const verifyToken = params.get('verify_token');
+        const resetToken = params.get('reset_token');
+
+        try {
+            if (verifyToken) {
+                const response = await fetch(`${API_BASE}/auth/verify-email`, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ token: verifyToken })
+                });
+                const result = await response.json();
+                alert(response.ok ? result.message : 'Ошибка: ' + result.error);
+            }
+
+            if (resetToken) {
+                const password = prompt('Введите новый пароль (не короче 6 символов):');
+                if (password) {
+                    const response = await fetch(`${API_BASE}/auth/reset-password`, {
+                        method: 'POST',
+                        headers: { 'Content-Type': 'application/json' },
+                        body: JSON.stringify({ token: resetToken, password })
+                    });
+                    const result = await response.json();
+                    alert(response.ok ? result.message + '. Теперь вы можете войти.' : 'Ошибка: ' + result.error);
+                }
+            }
+        } catch (error) {
+            console.error('Ошибка:', error);
+            alert('Ошибка соединения с сервером');
+        }
+
+        if (verifyToken || resetToken) {
+            window.history.replaceState({}, '', window.location.pathname);
+        }
+    })();
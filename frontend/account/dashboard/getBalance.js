@@ -4,6 +4,42 @@ document.addEventListener('DOMContentLoaded', function() {
     initBalanceFunctionality();
 });
 
+// checkReturnedPayment показывает итог оплаты, когда шлюз вернул клиента с payment_id в адресе.
+// Webhook может прийти чуть позже редиректа, поэтому статус опрашивается несколько раз.
+async function checkReturnedPayment() {
+    const params = new URLSearchParams(window.location.search);
+    const paymentId = params.get('payment_id');
+    if (!paymentId) return;
+
+    window.history.replaceState(null, '', window.location.pathname);
+
+    for (let attempt = 0; attempt < 10; attempt++) {
+        try {
+            const response = await authFetch(`${API_BASE}/pay/${encodeURIComponent(paymentId)}`);
+            if (!response.ok) return;
+
+            const { payment } = await response.json();
+            if (payment.status === 'completed') {
+                const user = await loadUserData();
+                if (user) updateUserBalanceFromServer(user.balance);
+                showPaymentSuccess(payment.amount, payment.payment_method);
+                return;
+            }
+            if (payment.status === 'failed' || payment.status === 'cancelled') {
+                alert(payment.status === 'failed' ? 'Платеж не прошел' : 'Платеж отменен');
+                return;
+            }
+        } catch (error) {
+            console.error('Ошибка проверки платежа:', error);
+            return;
+        }
+
+        await new Promise(resolve => setTimeout(resolve, 1500));
+    }
+
+    alert('Платеж еще обрабатывается. Баланс обновится после подтверждения оплаты.');
+}
+
 function initBalanceFunctionality() {
     console.log('Инициализация функционала пополнения баланса');
     
@@ -14,6 +50,8 @@ function initBalanceFunctionality() {
         return;
     }
     
+    checkReturnedPayment();
+
     // Кнопка пополнения баланса
     const topUpBtn = document.querySelector('.btn--white');
     if (topUpBtn) {
@@ -122,6 +160,17 @@ function processPayment() {
     simulatePayment(amount, paymentMethod.value);
 }
 
+// Ключ идемпотентности живет, пока платеж не прошел: повтор после ошибки или двойной клик
+// отправляют тот же ключ, и сервер не зачислит деньги дважды
+let pendingPayment = null;
+
+function paymentIdempotencyKey(amount, paymentMethod) {
+    if (!pendingPayment || pendingPayment.amount !== amount || pendingPayment.paymentMethod !== paymentMethod) {
+        pendingPayment = { key: crypto.randomUUID(), amount, paymentMethod };
+    }
+    return pendingPayment.key;
+}
+
 async function simulatePayment(amount, paymentMethod) {
     console.log('Начало процесса оплаты:', { amount, paymentMethod });
     
@@ -137,23 +186,23 @@ async function simulatePayment(amount, paymentMethod) {
         if (!userData) throw new Error('Пользователь не найден');
 
         const user = JSON.parse(userData);
-        const userId = user.id;
         
         console.log('Данные для запроса:', {
-            userId,
             amount,
             paymentMethod
         });
 
         // Отправляем запрос на бекенд
-        const response = await fetch(`http://localhost:8080/api/v1/pay/${userId}`, {
+        const response = await authFetch(`${API_BASE}/pay`, {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
+                'Idempotency-Key': paymentIdempotencyKey(amount, paymentMethod),
             },
             body: JSON.stringify({
                 amount: amount,
-                payment_method: paymentMethod
+                payment_method: paymentMethod,
+                save_card: paymentMethod === 'card' && document.getElementById('saveCard')?.checked === true
             })
         });
 
@@ -174,20 +223,15 @@ async function simulatePayment(amount, paymentMethod) {
         // Парсим успешный ответ
         const result = await response.json();
         console.log('Успешный ответ от сервера:', result);
-        
-        // Обновляем баланс на фронтенде
-        const newBalance = (user.balance || 0) + amount;
-        updateUserBalanceFromServer(newBalance);
-        
-        // Восстанавливаем кнопку
-        confirmBtn.innerHTML = originalText;
-        confirmBtn.disabled = false;
-        
-        // Закрываем модальное окно
-        closeBalanceModal();
-        
-        // Показываем уведомление об успехе
-        showPaymentSuccess(amount, paymentMethod);
+        pendingPayment = null;
+
+        // Баланс пополнится после подтверждения оплаты на странице платежного шлюза
+        if (result.status === 'pending' && result.confirmation_url) {
+            window.location.href = result.confirmation_url;
+            return;
+        }
+
+        throw new Error(result.message || 'Платеж не создан');
         
     } catch (error) {
         console.error('Полная ошибка оплаты:', error);
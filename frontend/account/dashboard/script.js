@@ -6,16 +6,18 @@ document.addEventListener('DOMContentLoaded', async () => {
     }
 
     try {
-        const user = await loadUserData();
+        const [user] = await Promise.all([loadUserData(), loadTariffs()]);
         
         document.getElementById('userName').textContent = user.name;
         document.getElementById('userAccount').textContent = user.accountn;
         document.getElementById('userBalance').textContent = user.balance;
 
-        const tariffData = getTariffData(user.tariff_id);
+        const tariffData = await getTariffData(user.tariff_id);
         updateTariffDisplay(tariffData);
         updateTariffFeatures(tariffData);
         updateBalanceStatus(user.balance);
+        await updateSubscriptionStatus();
+        await loadAddons();
         setupEventListeners();
         
     } catch (error) {
@@ -24,13 +26,46 @@ document.addEventListener('DOMContentLoaded', async () => {
     }
 });
 
+const API_BASE = 'http://localhost:8080/api/v1';
+
+// Запрос с access токеном; при 401 один раз пробуем обновить токены
+async function authFetch(url, options = {}) {
+    const withToken = () => ({
+        ...options,
+        headers: {
+            ...(options.headers || {}),
+            'Authorization': `Bearer ${localStorage.getItem('netlinkAccessToken')}`
+        }
+    });
+
+    let response = await fetch(url, withToken());
+    if (response.status === 401 && await refreshTokens()) {
+        response = await fetch(url, withToken());
+    }
+    return response;
+}
+
+async function refreshTokens() {
+    const refreshToken = localStorage.getItem('netlinkRefreshToken');
+    if (!refreshToken) return false;
+
+    const response = await fetch(`${API_BASE}/auth/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken })
+    });
+    if (!response.ok) return false;
+
+    const result = await response.json();
+    localStorage.setItem('netlinkAccessToken', result.tokens.access_token);
+    localStorage.setItem('netlinkRefreshToken', result.tokens.refresh_token);
+    return true;
+}
+
 async function loadUserData() {
-    const userData = localStorage.getItem('netlinkUser');
-    const user = JSON.parse(userData);
-    
-    console.log('Загружаем данные пользователя ID:', user.id);
+    console.log('Загружаем данные пользователя');
     
-    const response = await fetch(`http://localhost:8080/api/v1/auth/${user.id}`);
+    const response = await authFetch(`${API_BASE}/auth/me`);
     
     console.log('Response status:', response.status);
     
@@ -46,40 +81,55 @@ async function loadUserData() {
     return result.user;
 }
 
-function getTariffData(tariffId) {
-    const tariffs = {
-        1: {
-            id: 1,
-            name: "Базовый 50 Мбит/с",
-            price: 300,
-            speed: "50 Мбит/с",
-            traffic: "Безлимитный",
-            devices: "до 3 устройств",
-            features: ["Безлимитный интернет", "Техподдержка 24/7", "Базовая защита"],
-            isActive: true
-        },
-        2: {
-            id: 2,
-            name: "Оптимальный 100 Мбит/с",
-            price: 500,
-            speed: "100 Мбит/с",
-            traffic: "Безлимитный",
-            devices: "до 5 устройств",
-            features: ["Безлимитный интернет", "Приоритетная поддержка", "Расширенная защита", "Статический IP"],
-            isActive: true
-        },
-        3: {
-            id: 3,
-            name: "Премиум 200 Мбит/с",
-            price: 800,
-            speed: "200 Мбит/с",
-            traffic: "Безлимитный",
-            devices: "до 10 устройств",
-            features: ["Безлимитный интернет", "Персональный менеджер", "Максимальная защита", "Статический IP", "Резервный канал"],
-            isActive: true
-        }
-    };
-    
+// Каталог тарифов с сервера, заполняется в loadTariffs
+let tariffCatalog = [];
+
+function escapeHtml(value) {
+    const div = document.createElement('div');
+    div.textContent = value;
+    return div.innerHTML;
+}
+
+function formatSpeed(speed) {
+    return speed >= 1000 ? `${speed / 1000} Гбит/с` : `${speed} Мбит/с`;
+}
+
+async function loadTariffs() {
+    const response = await fetch(`${API_BASE}/tariffs`);
+    if (!response.ok) {
+        throw new Error(`Ошибка сервера: ${response.status}`);
+    }
+
+    const result = await response.json();
+    tariffCatalog = result.tariffs;
+    renderTariffOptions();
+}
+
+function renderTariffOptions() {
+    const container = document.querySelector('#tariffModal .tariff-options');
+    if (!container) return;
+
+    if (tariffCatalog.length === 0) {
+        container.innerHTML = '<p>Сейчас нет тарифов для подключения</p>';
+        return;
+    }
+
+    container.innerHTML = tariffCatalog.map(tariff => `
+        <div class="tariff-option" data-tariff-id="${tariff.id}">
+            <h4>${escapeHtml(tariff.name)}</h4>
+            <div class="tariff-price">${tariff.price} руб./мес.</div>
+            ${tariff.description ? `<p>${escapeHtml(tariff.description)}</p>` : ''}
+            <ul>
+                <li>Скорость: ${formatSpeed(tariff.speed)}</li>
+                ${tariff.features.map(feature => `<li>${escapeHtml(feature)}</li>`).join('')}
+            </ul>
+            <button class="btn btn--outline select-tariff">Выбрать</button>
+        </div>
+    `).join('');
+}
+
+// Подключенный тариф может быть уже снят с продажи, тогда его нет в каталоге и он запрашивается отдельно
+async function getTariffData(tariffId) {
     if (!tariffId) {
         return {
             id: null,
@@ -87,13 +137,28 @@ function getTariffData(tariffId) {
             price: 0,
             speed: "0 Мбит/с",
             traffic: "Нет доступа",
-            devices: "нет устройств",
             features: ["Интернет недоступен", "Подключите тариф для начала использования"],
             isActive: false
         };
     }
-    
-    return tariffs[tariffId];
+
+    let tariff = tariffCatalog.find(t => t.id === tariffId);
+    if (!tariff) {
+        const response = await fetch(`${API_BASE}/tariffs/${tariffId}`);
+        if (!response.ok) {
+            throw new Error(`Тариф ${tariffId} не найден`);
+        }
+        tariff = (await response.json()).tariff;
+    }
+
+    return {
+        id: tariff.id,
+        name: tariff.name,
+        price: tariff.price,
+        speed: formatSpeed(tariff.speed),
+        features: tariff.features,
+        isActive: true
+    };
 }
 
 function updateTariffFeatures(tariffData) {
@@ -136,21 +201,13 @@ function updateTariffFeatures(tariffData) {
                 <i class="fas fa-bolt"></i>
                 <span>Скорость: ${tariffData.speed}</span>
             </div>
-            <div class="feature">
-                <i class="fas fa-wifi"></i>
-                <span>Трафик: ${tariffData.traffic}</span>
-            </div>
-            <div class="feature">
-                <i class="fas fa-desktop"></i>
-                <span>Устройства: ${tariffData.devices}</span>
-            </div>
         `;
         
         tariffData.features.forEach(feature => {
             featuresContainer.innerHTML += `
                 <div class="feature">
                     <i class="fas fa-check"></i>
-                    <span>${feature}</span>
+                    <span>${escapeHtml(feature)}</span>
                 </div>
             `;
         });
@@ -177,6 +234,153 @@ function updateTariffDisplay(tariffData) {
     }
 }
 
+// Срок оплаты и приостановка доступа приходят из биллинга
+async function updateSubscriptionStatus() {
+    const response = await authFetch(`${API_BASE}/auth/subscription`);
+    if (!response.ok) return;
+
+    const { subscription } = await response.json();
+    if (!subscription) return;
+
+    const featuresContainer = document.getElementById('tariffFeatures');
+    const badgeElement = document.getElementById('tariffBadge');
+    const statusElement = document.querySelector('.balance-status');
+
+    if (subscription.status === 'suspended') {
+        badgeElement.textContent = "ПРИОСТАНОВЛЕН";
+        badgeElement.style.background = '#e74c3c';
+        statusElement.innerHTML = `
+            <i class="fas fa-exclamation-triangle status-warning"></i>
+            <span>Доступ приостановлен</span>
+            <small>Пополните баланс, чтобы возобновить услуги</small>
+        `;
+        statusElement.style.background = '#fdecea';
+        return;
+    }
+
+    const freezeButton = document.getElementById('freezeBtn');
+
+    if (subscription.status === 'frozen') {
+        const freezeResponse = await authFetch(`${API_BASE}/auth/freeze`);
+        const { freeze } = freezeResponse.ok ? await freezeResponse.json() : {};
+        const endAt = freeze?.active ? new Date(freeze.active.end_at).toLocaleDateString('ru-RU') : '';
+
+        badgeElement.textContent = "ЗАМОРОЖЕН";
+        badgeElement.style.background = '#3498db';
+        statusElement.innerHTML = `
+            <i class="fas fa-snowflake"></i>
+            <span>Аккаунт заморожен${endAt ? ` до ${endAt}` : ''}</span>
+            <small>Оплаченные дни сохранятся до разморозки</small>
+        `;
+        freezeButton.innerHTML = '<i class="fas fa-sun"></i> Разморозить';
+        freezeButton.onclick = unfreezeAccount;
+        freezeButton.style.display = '';
+        return;
+    }
+
+    const paidUntil = new Date(subscription.paid_until).toLocaleDateString('ru-RU');
+    featuresContainer.innerHTML += `
+        <div class="feature">
+            <i class="fas fa-calendar-check"></i>
+            <span>Оплачено до ${paidUntil}</span>
+        </div>
+    `;
+    freezeButton.onclick = freezeAccount;
+    freezeButton.style.display = '';
+}
+
+// Заморозка на время отпуска: срок и плата за день берутся из условий сервера
+async function freezeAccount() {
+    const offerResponse = await authFetch(`${API_BASE}/auth/freeze`);
+    if (!offerResponse.ok) return;
+    const { freeze } = await offerResponse.json();
+    const { min_days, max_days, daily_fee } = freeze.settings;
+
+    const feeNote = daily_fee > 0 ? ` Плата ${daily_fee} руб. в день списывается сразу.` : '';
+    const days = parseInt(prompt(`На сколько дней заморозить аккаунт (от ${min_days} до ${max_days})?${feeNote}`), 10);
+    if (!days) return;
+
+    const response = await authFetch(`${API_BASE}/auth/freeze`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ days })
+    });
+    const result = await response.json();
+    alert(response.ok ? result.message : result.error);
+    if (response.ok) window.location.reload();
+}
+
+async function unfreezeAccount() {
+    if (!confirm('Разморозить аккаунт сейчас?')) return;
+
+    const response = await authFetch(`${API_BASE}/auth/freeze`, { method: 'DELETE' });
+    const result = await response.json();
+    alert(response.ok ? result.message : result.error);
+    if (response.ok) window.location.reload();
+}
+
+// Подключенные услуги и каталог тех, что еще можно подключить
+async function loadAddons() {
+    const [userResponse, catalogResponse] = await Promise.all([
+        authFetch(`${API_BASE}/auth/addons`),
+        fetch(`${API_BASE}/addons`)
+    ]);
+    if (!userResponse.ok || !catalogResponse.ok) return;
+
+    const { addons: attached } = await userResponse.json();
+    const { addons: catalog } = await catalogResponse.json();
+    const attachedIds = new Set(attached.map(item => item.addon_id));
+
+    document.getElementById('userAddons').innerHTML = attached.length === 0
+        ? '<p>Услуги не подключены</p>'
+        : attached.map(item => `
+            <div class="feature">
+                <i class="fas fa-check-circle"></i>
+                <span>${escapeHtml(item.addon.name)} — ${item.addon.price} руб./мес.</span>
+                <button class="btn btn--outline" onclick="detachAddon(${item.id})">Отключить</button>
+            </div>
+        `).join('');
+
+    document.getElementById('addonCatalog').innerHTML = catalog
+        .filter(addon => !attachedIds.has(addon.id))
+        .map(addon => `
+            <div class="feature">
+                <i class="fas fa-plus-circle"></i>
+                <span>${escapeHtml(addon.name)} — ${addon.price} руб./мес.${addon.setup_fee > 0 ? `, подключение ${addon.setup_fee} руб.` : ''}</span>
+                <button class="btn btn--outline" onclick="attachAddon(${addon.id})">Подключить</button>
+            </div>
+        `).join('');
+}
+
+async function attachAddon(addonId) {
+    const response = await authFetch(`${API_BASE}/auth/addons`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ addon_id: addonId })
+    });
+    const result = await response.json();
+    if (!response.ok) {
+        alert(result.missing ? `${result.error}. Не хватает ${result.missing} руб.` : result.error);
+        return;
+    }
+
+    alert(`${result.message}. Списано ${result.charged} руб.`);
+    document.getElementById('userBalance').textContent = result.new_balance;
+    await loadAddons();
+}
+
+async function detachAddon(userAddonId) {
+    if (!confirm('Отключить услугу? Оплаченный период не возвращается.')) return;
+
+    const response = await authFetch(`${API_BASE}/auth/addons/${userAddonId}`, { method: 'DELETE' });
+    const result = await response.json();
+    if (!response.ok) {
+        alert(result.error);
+        return;
+    }
+    await loadAddons();
+}
+
 function updateBalanceStatus(balance) {
     const balanceElement = document.getElementById('userBalance');
     const statusElement = document.querySelector('.balance-status');
@@ -219,10 +423,18 @@ function setupEventListeners() {
     
     const logoutBtn = document.getElementById('logoutBtn');
     if (logoutBtn) {
-        logoutBtn.addEventListener('click', function(e) {
+        logoutBtn.addEventListener('click', async function(e) {
             e.preventDefault();
             if (confirm('Вы уверены, что хотите выйти?')) {
+                // Отзываем сессию на сервере; локальные токены удаляем в любом случае
+                try {
+                    await authFetch(`${API_BASE}/auth/logout`, { method: 'POST' });
+                } catch (error) {
+                    console.error('Ошибка выхода:', error);
+                }
                 localStorage.removeItem('netlinkUser');
+                localStorage.removeItem('netlinkAccessToken');
+                localStorage.removeItem('netlinkRefreshToken');
                 window.location.href = '../account.html';
             }
         });
@@ -306,9 +518,14 @@ async function activateSelectedTariff() {
     if (!userData) return;
 
     const user = JSON.parse(userData);
-    const tariffData = getTariffData(parseInt(selectedTariffId));
+    const tariffData = await getTariffData(parseInt(selectedTariffId));
     
-    const confirmMessage = `Вы уверены, что хотите активировать тариф "${tariffData.name}"?`;
+    const quote = await previewTariffChange(parseInt(selectedTariffId));
+    if (!quote) {
+        return;
+    }
+
+    const confirmMessage = tariffChangeMessage(tariffData, quote);
     
     if (!confirm(confirmMessage)) {
         return;
@@ -320,13 +537,12 @@ async function activateSelectedTariff() {
         activateBtn.innerHTML = '<i class="fas fa-spinner fa-spin"></i> Активация...';
         activateBtn.disabled = true;
 
-        const response = await fetch('http://localhost:8080/api/v1/auth/activate-tariff', {
+        const response = await authFetch(`${API_BASE}/auth/activate-tariff`, {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
             },
             body: JSON.stringify({
-                user_id: user.id,
                 tariff_id: parseInt(selectedTariffId)
             })
         });
@@ -337,15 +553,21 @@ async function activateSelectedTariff() {
 
         const result = await response.json();
         
+        closeTariffModal();
+
+        // Понижение вступит в силу только со следующего периода
+        if (result.quote && result.quote.kind === 'downgrade') {
+            alert(`Тариф "${tariffData.name}" будет подключен с ${formatDate(result.quote.effective_at)}`);
+            return;
+        }
+
         user.tariff_id = parseInt(selectedTariffId);
         localStorage.setItem('netlinkUser', JSON.stringify(user));
         
         updateTariffDisplay(tariffData);
         updateTariffFeatures(tariffData);
         
-        closeTariffModal();
-        
-        alert(`Тариф "${tariffData.name}" успешно активирован!`);
+        alert(result.message || `Тариф "${tariffData.name}" успешно активирован!`);
         
     } catch (error) {
         console.error('Ошибка активации тарифа:', error);
@@ -359,6 +581,64 @@ async function activateSelectedTariff() {
     }
 }
 
+// previewTariffChange получает точные суммы смены тарифа до подтверждения
+async function previewTariffChange(tariffId) {
+    try {
+        const response = await authFetch(`${API_BASE}/auth/activate-tariff/preview`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ tariff_id: tariffId })
+        });
+
+        const result = await response.json();
+        if (!response.ok) {
+            alert(result.error || 'Не удалось рассчитать стоимость смены тарифа');
+            return null;
+        }
+
+        return result.quote;
+    } catch (error) {
+        console.error('Ошибка расчета смены тарифа:', error);
+        alert('Не удалось рассчитать стоимость смены тарифа. Попробуйте позже.');
+        return null;
+    }
+}
+
+function formatDate(value) {
+    return new Date(value).toLocaleDateString('ru-RU');
+}
+
+function tariffChangeMessage(tariffData, quote) {
+    const money = value => `${Number(value).toFixed(2)} ₽`;
+    let message;
+
+    switch (quote.kind) {
+        case 'upgrade':
+            message = `Перейти на тариф "${tariffData.name}" сейчас?\n\n` +
+                `Стоимость до ${formatDate(quote.paid_until)}: ${money(quote.charge)}\n` +
+                `Возврат за неиспользованные дни: ${money(quote.credit)}\n` +
+                `К списанию: ${money(quote.amount_due)}`;
+            break;
+        case 'downgrade':
+            message = `Тариф "${tariffData.name}" будет подключен с ${formatDate(quote.effective_at)}.\n` +
+                `До этого дня действует текущий тариф.\n\n` +
+                `Стоимость следующего периода: ${money(quote.next_period_charge)}`;
+            break;
+        case 'downgrade_cancelled':
+            return `Отменить запланированную смену тарифа и остаться на "${tariffData.name}"?`;
+        default:
+            message = `Активировать тариф "${tariffData.name}"?\n\nК списанию: ${money(quote.amount_due)}`;
+    }
+
+    message += `\nБаланс после списания: ${money(quote.balance_after)}`;
+    if (!quote.enough_funds) {
+        message += '\n\nНа балансе недостаточно средств, пополните счет перед подтверждением.';
+    }
+    return message;
+}
+
 function showTariffModal() {
     console.log('Открытие модального окна');
     const modal = document.getElementById('tariffModal');
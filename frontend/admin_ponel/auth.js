@@ -4,6 +4,10 @@ const API_CONFIG = {
     ENDPOINTS: {
         ADMIN: {
             LOGIN: '/admin/login',
+            LOGIN_2FA: '/admin/login/2fa',
+            REFRESH: '/admin/refresh',
+            LOGOUT: '/admin/logout',
+            CHANGE_PASSWORD: '/admin/me/password',
             VERIFY: '/admin/verify',
             DASHBOARD: '/admin/dashboard',
             USERS: '/admin/users'
@@ -31,12 +35,35 @@ class AuthService {
                 throw new Error('Login failed');
             }
 
-            const data = await response.json();
+            let data = await response.json();
+
+            // Второй шаг входа: код из приложения-аутентификатора или код восстановления
+            if (data.two_factor_required) {
+                const code = prompt('Введите код из приложения-аутентификатора или код восстановления:');
+                if (!code) {
+                    throw new Error('Login cancelled');
+                }
+
+                const isRecovery = code.trim().length !== 6;
+                const secondStep = await fetch(`${API_CONFIG.BASE_URL}${API_CONFIG.ENDPOINTS.ADMIN.LOGIN_2FA}`, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({
+                        challenge_token: data.challenge_token,
+                        [isRecovery ? 'recovery_code' : 'code']: code.trim()
+                    })
+                });
+                if (!secondStep.ok) {
+                    throw new Error('Invalid two-factor code');
+                }
+                data = await secondStep.json();
+            }
             
             this.token = data.token;
             this.adminData = data.admin;
             
             localStorage.setItem('adminToken', data.token);
+            localStorage.setItem('adminRefreshToken', data.refresh_token);
             localStorage.setItem('adminData', JSON.stringify(data.admin));
             
             return data;
@@ -46,10 +73,63 @@ class AuthService {
         }
     }
 
+    async refresh() {
+        const refreshToken = localStorage.getItem('adminRefreshToken');
+        if (!refreshToken) {
+            return false;
+        }
+
+        try {
+            const response = await fetch(`${API_CONFIG.BASE_URL}${API_CONFIG.ENDPOINTS.ADMIN.REFRESH}`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ refresh_token: refreshToken })
+            });
+            if (!response.ok) {
+                return false;
+            }
+
+            const data = await response.json();
+            this.token = data.token;
+            localStorage.setItem('adminToken', data.token);
+            localStorage.setItem('adminRefreshToken', data.refresh_token);
+            return true;
+        } catch (error) {
+            console.error('Token refresh failed:', error);
+            return false;
+        }
+    }
+
+    async changePassword(currentPassword) {
+        const newPassword = prompt('Задайте новый пароль (не короче 8 символов):');
+        if (!newPassword) {
+            throw new Error('Необходимо сменить временный пароль');
+        }
+
+        const response = await fetch(`${API_CONFIG.BASE_URL}${API_CONFIG.ENDPOINTS.ADMIN.CHANGE_PASSWORD}`, {
+            method: 'PUT',
+            headers: this.getAuthHeaders(),
+            body: JSON.stringify({ current_password: currentPassword, new_password: newPassword })
+        });
+
+        if (!response.ok) {
+            const data = await response.json().catch(() => ({}));
+            throw new Error(data.error || 'Не удалось сменить пароль');
+        }
+    }
+
     logout() {
+        if (this.token) {
+            fetch(`${API_CONFIG.BASE_URL}${API_CONFIG.ENDPOINTS.ADMIN.LOGOUT}`, {
+                method: 'POST',
+                headers: { 'Authorization': `Bearer ${this.token}` }
+            }).catch(error => console.error('Logout request failed:', error));
+        }
+
         this.token = null;
         this.adminData = null;
         localStorage.removeItem('adminToken');
+        localStorage.removeItem('adminRefreshToken');
         localStorage.removeItem('adminData');
         this.showLoginModal();
     }
@@ -68,7 +148,12 @@ class AuthService {
                 }
             });
 
-            return response.ok;
+            if (response.ok) {
+                return true;
+            }
+
+            // Access токен живет недолго, пробуем продлить сессию
+            return await this.refresh();
             
         } catch (error) {
             console.error('Token verification failed:', error);
@@ -108,6 +193,13 @@ class AuthService {
 
 window.authService = new AuthService();
 
+// Обновляем access токен заранее, пока он не истек
+setInterval(() => {
+    if (window.authService.isAuthenticated()) {
+        window.authService.refresh();
+    }
+}, 10 * 60 * 1000);
+
 document.addEventListener('DOMContentLoaded', function() {
     const loginForm = document.getElementById('adminLoginForm');
     if (loginForm) {
@@ -125,7 +217,12 @@ document.addEventListener('DOMContentLoaded', function() {
                 submitBtn.innerHTML = '<i class="fas fa-spinner fa-spin"></i> Вход...';
                 submitBtn.disabled = true;
                 
-                await window.authService.login(username, password);
+                const data = await window.authService.login(username, password);
+
+                // Временный пароль нужно сменить до работы с панелью
+                if (data.admin.must_change_password) {
+                    await window.authService.changePassword(password);
+                }
                 
                 location.reload();
                 
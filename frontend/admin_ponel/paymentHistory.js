@@ -278,6 +278,41 @@ class PaymentsUI {
             } else if (payment.status === 'pending') {
                 statusText = 'В обработке';
                 statusClass = 'status-warning';
+            } else if (payment.status === 'partially_refunded') {
+                statusText = 'Частично возвращен';
+                statusClass = 'status-warning';
+            } else if (payment.status === 'refunded') {
+                statusText = 'Возвращен';
+                statusClass = 'status-inactive';
+            } else if (payment.status === 'refund') {
+                statusText = `Возврат по №${payment.parent_id}`;
+                statusClass = 'status-inactive';
+            } else if (payment.status === 'refund_pending') {
+                statusText = `Возврат по №${payment.parent_id} в обработке`;
+                statusClass = 'status-warning';
+            } else if (payment.status === 'refund_failed') {
+                statusText = `Возврат по №${payment.parent_id} не выполнен`;
+                statusClass = 'status-inactive';
+            } else if (payment.status === 'charge_reversal') {
+                statusText = `Возврат списания №${payment.charge_id}`;
+                statusClass = 'status-active';
+            } else if (payment.status === 'adjustment') {
+                statusText = 'Корректировка';
+                statusClass = 'status-warning';
+            }
+            const reason = payment.reason ? `<br><small style="color: #666;">${payment.reason}</small>` : '';
+
+            // Фискальный чек
+            let receipt = '';
+            if (payment.receipt_status) {
+                const receiptText = {
+                    registered: 'Чек пробит',
+                    pending: 'Чек в очереди',
+                    failed: 'Чек не отправлен'
+                }[payment.receipt_status] || payment.receipt_status;
+                receipt = payment.receipt_url
+                    ? `<br><small><a href="${payment.receipt_url}" target="_blank">${receiptText}</a></small>`
+                    : `<br><small style="color: ${payment.receipt_status === 'failed' ? '#c00' : '#666'};">${receiptText}</small>`;
             }
             
             // Дата
@@ -312,14 +347,14 @@ class PaymentsUI {
                         </div>
                     </td>
                     <td>
-                        <span class="amount positive">
+                        <span class="amount ${payment.amount < 0 ? 'negative' : 'positive'}">
                             ${payment.amount || 0} ₽
                         </span>
                     </td>
                     <td>
                         <span class="status-badge ${statusClass}">
                             ${statusText}
-                        </span>
+                        </span>${reason}${receipt}
                     </td>
                     <td>${paymentDate}</td>
                 </tr>
@@ -595,7 +595,7 @@ class ReportGenerator {
 
     async getUserDetails(userId, token) {
         try {
-            const response = await fetch(`http://localhost:8080/api/v1/auth/${userId}`, {
+            const response = await fetch(`${this.baseUrl}/users/${userId}`, {
                 method: 'GET',
                 headers: {
                     'Authorization': `Bearer ${token}`,
@@ -5,7 +5,62 @@ function showNotification(message, type = 'info') {
     alert(`${icon} ${message}`);
 }
 
+function escapeHtml(value) {
+    const div = document.createElement('div');
+    div.textContent = value;
+    return div.innerHTML;
+}
+
+function formatSpeed(speed) {
+    return speed >= 1000 ? `${speed / 1000} Гбит/с` : `${speed} Мбит/с`;
+}
+
+// Тарифы берутся из каталога на сервере, а не из разметки
+async function loadTariffs() {
+    const grid = document.getElementById('tariffsGrid');
+    const select = document.getElementById('plan');
+
+    try {
+        const response = await fetch(`${URL_API}/tariffs`);
+        if (!response.ok) {
+            throw new Error(`Ошибка сервера: ${response.status}`);
+        }
+
+        const { tariffs } = await response.json();
+
+        if (grid) {
+            grid.innerHTML = tariffs.map(tariff => `
+                <div class="tariff__card">
+                    <h3>${escapeHtml(tariff.name)}</h3>
+                    <div class="tariff__speed">${formatSpeed(tariff.speed)}</div>
+                    <div class="tariff__price">${tariff.price}₽/мес</div>
+                    ${tariff.description ? `<p>${escapeHtml(tariff.description)}</p>` : ''}
+                    <ul class="tariff__features">
+                        ${tariff.features.map(feature => `<li>${escapeHtml(feature)}</li>`).join('')}
+                    </ul>
+                </div>
+            `).join('');
+        }
+
+        if (select) {
+            tariffs.forEach(tariff => {
+                const option = document.createElement('option');
+                option.value = tariff.name;
+                option.textContent = `${tariff.name} - ${tariff.price}₽/мес`;
+                select.appendChild(option);
+            });
+        }
+    } catch (error) {
+        console.error('Ошибка загрузки тарифов:', error);
+        if (grid) {
+            grid.innerHTML = '<p>Не удалось загрузить тарифы. Попробуйте обновить страницу.</p>';
+        }
+    }
+}
+
 document.addEventListener('DOMContentLoaded', function() {
+    loadTariffs();
+
     const form = document.querySelector("#applicationForm");
     
     if (!form) {